@@ -5,13 +5,13 @@ package ape
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var imageGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Apev2,
 		&decode.Format{
 			Description: "APEv2 metadata tag",
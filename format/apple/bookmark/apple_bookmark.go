@@ -7,7 +7,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/apple"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -15,7 +15,7 @@ import (
 var bookmarkFS embed.FS
 
 func init() {
-	interp.RegisterFormat(format.Apple_Bookmark,
+	registry.RegisterFormat(format.Apple_Bookmark,
 		&decode.Format{
 			ProbeOrder:  format.ProbeOrderBinUnique,
 			Description: "Apple BookmarkData",
@@ -23,7 +23,7 @@ func init() {
 			DecodeFn:    bookmarkDecode,
 			Functions:   []string{"torepr"},
 		})
-	interp.RegisterFS(bookmarkFS)
+	registry.RegisterFS(bookmarkFS)
 }
 
 const (
@@ -9,7 +9,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/apple"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -17,7 +17,7 @@ import (
 var bplistFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bplist,
 		&decode.Format{
 			ProbeOrder:  format.ProbeOrderBinUnique,
@@ -26,7 +26,7 @@ func init() {
 			DecodeFn:    bplistDecode,
 			Functions:   []string{"torepr"},
 		})
-	interp.RegisterFS(bplistFS)
+	registry.RegisterFS(bplistFS)
 }
 
 const (
@@ -10,7 +10,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -18,14 +18,14 @@ import (
 var machoFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MachO,
 		&decode.Format{
 			Description: "Mach-O macOS executable",
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    machoDecode,
 		})
-	interp.RegisterFS(machoFS)
+	registry.RegisterFS(machoFS)
 }
 
 func strIndexNull(idx int, s string) string {
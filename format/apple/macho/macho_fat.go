@@ -5,14 +5,14 @@ package macho
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var machoFormat decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MachO_Fat,
 		&decode.Format{
 			Description: "Fat Mach-O macOS executable (multi-architecture)",
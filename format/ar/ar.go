@@ -3,14 +3,14 @@ package ar
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var probeGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.AR,
 		&decode.Format{
 			Description: "Unix archive",
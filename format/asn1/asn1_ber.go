@@ -24,7 +24,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -33,14 +33,14 @@ import (
 var asn1FS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.ASN1_BER,
 		&decode.Format{
 			Description: "ASN1 BER (basic encoding rules, also CER and DER)",
 			DecodeFn:    decodeASN1BER,
 			Functions:   []string{"torepr"},
 		})
-	interp.RegisterFS(asn1FS)
+	registry.RegisterFS(asn1FS)
 }
 
 const (
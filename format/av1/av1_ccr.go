@@ -7,12 +7,12 @@ package av1
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.AV1_CCR,
 		&decode.Format{
 			Description: "AV1 Codec Configuration Record",
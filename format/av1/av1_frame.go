@@ -7,13 +7,13 @@ package av1
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var av1FrameObuGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.AV1_Frame,
 		&decode.Format{
 			Description: "AV1 frame",
@@ -3,12 +3,12 @@ package av1
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.AV1_OBU,
 		&decode.Format{
 			Description: "AV1 Open Bitstream Unit",
@@ -12,7 +12,7 @@ import (
 	"github.com/wader/fq/format/avro/schema"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -20,14 +20,14 @@ import (
 var avroOcfFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Avro_Ocf,
 		&decode.Format{
 			Description: "Avro object container file",
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    decodeAvroOCF,
 		})
-	interp.RegisterFS(avroOcfFS)
+	registry.RegisterFS(avroOcfFS)
 }
 
 type HeaderData struct {
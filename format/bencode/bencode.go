@@ -8,7 +8,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -17,14 +17,14 @@ import (
 var bencodeFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bencode,
 		&decode.Format{
 			Description: "BitTorrent bencoding",
 			DecodeFn:    decodeBencode,
 			Functions:   []string{"torepr"},
 		})
-	interp.RegisterFS(bencodeFS)
+	registry.RegisterFS(bencodeFS)
 }
 
 var typeToNames = scalar.StrMapSymStr{
@@ -3,13 +3,13 @@ package bitcoin
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var bitcoinBlockGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bitcoin_Blkdat,
 		&decode.Format{
 			Description: "Bitcoin blk.dat",
@@ -8,14 +8,14 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var bitcoinTranscationGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bitcoin_Block,
 		&decode.Format{
 			Description: "Bitcoin block",
@@ -3,7 +3,7 @@ package bitcoin
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -34,7 +34,7 @@ func (ops opcodeEntries) MapUint(s scalar.Uint) (scalar.Uint, error) {
 }
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bitcoin_Script,
 		&decode.Format{
 			Description: "Bitcoin script",
@@ -7,14 +7,14 @@ package bitcoin
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var bitcoinScriptGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bitcoin_Transaction,
 		&decode.Format{
 			Description: "Bitcoin transaction",
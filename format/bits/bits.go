@@ -6,6 +6,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -25,19 +26,19 @@ func decodeBits(unit int) func(d *decode.D) any {
 }
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bits,
 		&decode.Format{
 			Description:        "Raw bits",
 			DecodeFn:           decodeBits(1),
 			SkipDecodeFunction: true, // skip add bits and frombits function
 		})
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Bytes,
 		&decode.Format{
 			Description:        "Raw bytes",
 			DecodeFn:           decodeBits(8),
 			SkipDecodeFunction: true, // skip add bytes and frombytes function
 		})
-	interp.RegisterFS(bitsFS)
+	registry.RegisterFS(bitsFS)
 }
@@ -7,7 +7,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -16,14 +16,14 @@ import (
 var bsonFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.BSON,
 		&decode.Format{
 			Description: "Binary JSON",
 			DecodeFn:    decodeBSON,
 			Functions:   []string{"torepr"},
 		})
-	interp.RegisterFS(bsonFS)
+	registry.RegisterFS(bsonFS)
 }
 
 const (
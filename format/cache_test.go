@@ -0,0 +1,168 @@
+package format_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+// countingIndexCache is a in memory index cache that counts hits and sets
+type countingIndexCache struct {
+	m    map[string][]byte
+	hits int
+	sets int
+}
+
+func (c *countingIndexCache) Keys() ([]string, error) {
+	var keys []string
+	for k := range c.m {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (c *countingIndexCache) Get(key string) ([]byte, error) {
+	b, ok := c.m[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	c.hits++
+	return b, nil
+}
+
+func (c *countingIndexCache) Set(key string, b []byte) error {
+	c.sets++
+	c.m[key] = b
+	return nil
+}
+
+func (c *countingIndexCache) Delete(key string) error {
+	delete(c.m, key)
+	return nil
+}
+
+type cacheTest struct {
+	fuzzTest
+	args   []string
+	stdout *bytes.Buffer
+	cache  *countingIndexCache
+}
+
+func (ct *cacheTest) Args() []string { return ct.args }
+func (ct *cacheTest) Stdin() interp.Input {
+	return fuzzTestInput{FileReader: interp.FileReader{R: &bytes.Buffer{}}}
+}
+func (ct *cacheTest) Stdout() interp.Output         { return fuzzTestOutput{ct.stdout} }
+func (ct *cacheTest) FS() fs.FS                     { return osFS{} }
+func (ct *cacheTest) IndexCache() interp.IndexCache { return ct.cache }
+func (ct *cacheTest) AbsPath(name string) (string, error) {
+	return filepath.Abs(name)
+}
+
+func (ct *cacheTest) run(t *testing.T, args ...string) string {
+	t.Helper()
+	ct.args = append([]string{"fq"}, args...)
+	ct.stdout = &bytes.Buffer{}
+	q, err := interp.New(ct, interp.DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Main(context.Background(), ct.Stdout(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	return ct.stdout.String()
+}
+
+func TestIndexCache(t *testing.T) {
+	ct := &cacheTest{cache: &countingIndexCache{m: map[string][]byte{}}}
+	args := []string{"--cache", "-o", "cache_min_size=0", ".packets[1].packet | ._start, ._len", "pcap/testdata/ipv4frags.pcap"}
+
+	if out := ct.run(t, args...); out != "8528\n3728\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if ct.cache.hits != 0 || ct.cache.sets != 1 {
+		t.Fatalf("expected miss and set got hits=%d sets=%d", ct.cache.hits, ct.cache.sets)
+	}
+
+	// entry filename should be absolute so that it can be found from other directories
+	for _, b := range ct.cache.m {
+		var e struct {
+			Filename string `json:"filename"`
+		}
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatal(err)
+		}
+		if !filepath.IsAbs(e.Filename) {
+			t.Fatalf("expected absolute filename got %q", e.Filename)
+		}
+	}
+
+	// cached index is used and not set again
+	if out := ct.run(t, args...); out != "8528\n3728\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if ct.cache.hits != 1 || ct.cache.sets != 1 {
+		t.Fatalf("expected hit and no set got hits=%d sets=%d", ct.cache.hits, ct.cache.sets)
+	}
+
+	// prune from another directory should keep the entry
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	if out := ct.run(t, "-nc", "index_cache_prune"); out != `{"kept":1,"removed":0}`+"\n" {
+		t.Fatalf("unexpected prune output %q", out)
+	}
+}
+
+// records from a cached index are validated when decoded
+func TestIndexCacheChangedRecord(t *testing.T) {
+	ct := &cacheTest{cache: &countingIndexCache{m: map[string][]byte{}}}
+
+	// pcap header and one record with incl_len 4 and orig_len 4
+	b := []byte{
+		0xd4, 0xc3, 0xb2, 0xa1, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0, 0, 1, 0, 0, 0,
+		1, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 'a', 'b', 'c', 'd',
+	}
+	p := filepath.Join(t.TempDir(), "test.pcap")
+	if err := os.WriteFile(p, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--cache", "-o", "cache_min_size=0", "-c", "errors | map(.error)", p}
+	if out := ct.run(t, args...); out != "[]\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	// change orig_len to 2 but keep size and modification time
+	b[36] = 2
+	if err := os.WriteFile(p, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if out := ct.run(t, args...); out != `["error at position 0x28: incl_len 4 > orig_len 2"]`+"\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if ct.cache.hits != 1 {
+		t.Fatalf("expected cached index to be used got hits=%d", ct.cache.hits)
+	}
+}
@@ -16,7 +16,7 @@ import (
 	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -25,14 +25,14 @@ import (
 var cborFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.CBOR,
 		&decode.Format{
 			Description: "Concise Binary Object Representation",
 			DecodeFn:    decodeCBOR,
 			Functions:   []string{"torepr"},
 		})
-	interp.RegisterFS(cborFS)
+	registry.RegisterFS(cborFS)
 }
 
 type majorTypeEntry struct {
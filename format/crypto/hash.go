@@ -13,6 +13,7 @@ import (
 
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 
 	//nolint: staticcheck
 	"golang.org/x/crypto/md4"
@@ -24,7 +25,7 @@ var hashFS embed.FS
 
 func init() {
 	interp.RegisterFunc1("_to_hash", toHash)
-	interp.RegisterFS(hashFS)
+	registry.RegisterFS(hashFS)
 }
 
 func hashFn(s string) hash.Hash {
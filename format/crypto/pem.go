@@ -3,12 +3,12 @@ package crypto
 import (
 	"embed"
 
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 //go:embed pem.jq
 var pemFS embed.FS
 
 func init() {
-	interp.RegisterFS(pemFS)
+	registry.RegisterFS(pemFS)
 }
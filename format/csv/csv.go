@@ -13,6 +13,7 @@ import (
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -21,7 +22,7 @@ import (
 var csvFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.CSV,
 		&decode.Format{
 			Description: "Comma separated values",
@@ -33,7 +34,7 @@ func init() {
 			},
 			Functions: []string{"_todisplay"},
 		})
-	interp.RegisterFS(csvFS)
+	registry.RegisterFS(csvFS)
 	interp.RegisterFunc1("_to_csv", toCSV)
 }
 
@@ -9,12 +9,12 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.DNS,
 		&decode.Format{
 			Description: "DNS packet",
@@ -27,6 +27,8 @@ const (
 	classIN = 1
 )
 
+const dnsHeaderLen = 12
+
 var classNames = scalar.UintRangeToScalar{
 	{Range: [2]uint64{0x0000, 0x0000}, S: scalar.Uint{Sym: "reserved", Description: "Reserved"}},
 	{Range: [2]uint64{classIN, classIN}, S: scalar.Uint{Sym: "in", Description: "Internet"}},
@@ -224,10 +226,11 @@ func dnsDecodeRR(d *decode.D, pointerOffset int64, resp bool, count uint64, name
 }
 
 func dnsDecode(d *decode.D, hasLengthHeader bool) any {
-	pointerOffset := int64(0)
+	// compression pointers are relative to start of message
+	pointerOffset := d.Pos()
 	d.FieldStruct("header", func(d *decode.D) {
 		if hasLengthHeader {
-			pointerOffset = 16
+			pointerOffset += 16
 			d.FieldU16("length")
 		}
 		d.FieldU16("id")
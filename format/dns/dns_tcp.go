@@ -3,11 +3,11 @@ package dns
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.DNS_TCP,
 		&decode.Format{
 			Description: "DNS packet (TCP)",
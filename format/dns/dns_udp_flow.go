@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+)
+
+func init() {
+	registry.RegisterFormat(
+		format.DNS_UDP_Flow,
+		&decode.Format{
+			Description: "DNS packets (UDP flow)",
+			Groups:      []*decode.Group{format.UDP_Flow},
+			DecodeFn:    dnsUDPFlowDecode,
+		})
+}
+
+type dnsFlowPayloadKey struct {
+	isClient bool
+	payload  string
+}
+
+// dnsUDPFlowDecode decodes all datagrams in a flow and links retransmissions
+// and responses to their query
+func dnsUDPFlowDecode(d *decode.D) any {
+	var ufi format.UDP_Flow_In
+	if !d.ArgAs(&ufi) || len(ufi.Datagrams) == 0 {
+		d.Fatalf("dns_udp_flow requires udp flow datagrams")
+	}
+	ufi.MustIsPort(d.Fatalf, format.UDPPortDomain, format.UDPPortMDNS)
+
+	// index of first datagram with same direction and payload
+	seenPayloads := map[dnsFlowPayloadKey]int{}
+	// index of last query by id
+	queries := map[uint64]int{}
+
+	d.FieldArray("messages", func(d *decode.D) {
+		for i, dg := range ufi.Datagrams {
+			if dg.Length < dnsHeaderLen {
+				d.Fatalf("datagram %d too short for dns header", i)
+			}
+			d.FieldStruct("message", func(d *decode.D) {
+				d.FieldValueUint("packet_index", uint64(dg.PacketIndex))
+				d.FieldValueBool("is_client", dg.IsClient)
+
+				key := dnsFlowPayloadKey{isClient: dg.IsClient, payload: string(d.PeekBytes(int(dg.Length)))}
+				first, isRetransmission := seenPayloads[key]
+				if isRetransmission {
+					d.FieldValueUint("retransmission_of", uint64(first))
+				} else {
+					seenPayloads[key] = i
+				}
+
+				header := d.PeekBytes(3)
+				id := uint64(header[0])<<8 | uint64(header[1])
+				isResponse := header[2]&0x80 != 0
+				switch {
+				case isResponse:
+					if qi, ok := queries[id]; ok {
+						d.FieldValueUint("query_index", uint64(qi))
+						d.FieldValueFlt("response_time", dg.Timestamp.Sub(ufi.Datagrams[qi].Timestamp).Seconds())
+					}
+				case !isRetransmission:
+					queries[id] = i
+				}
+
+				d.FramedFn(dg.Length*8, func(d *decode.D) {
+					dnsDecode(d, false)
+				})
+			})
+		}
+	})
+
+	return nil
+}
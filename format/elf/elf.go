@@ -12,12 +12,12 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.ELF,
 		&decode.Format{
 			Description: "Executable and Linkable Format",
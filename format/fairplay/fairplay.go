@@ -5,11 +5,11 @@ package fairplay
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Fairplay_SPC,
 		&decode.Format{
 			Description: "FairPlay Server Playback Context",
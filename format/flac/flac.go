@@ -12,7 +12,7 @@ import (
 	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -20,7 +20,7 @@ var flacMetadatablocksGroup decode.Group
 var flacFrameGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.FLAC,
 		&decode.Format{
 			Description: "Free Lossless Audio Codec file",
@@ -8,12 +8,12 @@ import (
 	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/checksum"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.FLAC_Frame,
 		&decode.Format{
 			Description: "FLAC frame",
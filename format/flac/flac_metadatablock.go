@@ -8,7 +8,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -17,7 +17,7 @@ var flacPicture decode.Group
 var vorbisCommentFormat decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.FLAC_Metadatablock,
 		&decode.Format{
 			Description: "FLAC metadatablock",
@@ -5,13 +5,13 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var flacMetadatablockGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.FLAC_Metadatablocks,
 		&decode.Format{
 			Description: "FLAC metadatablocks",
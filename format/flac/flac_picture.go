@@ -3,7 +3,7 @@ package flac
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -34,7 +34,7 @@ var pictureTypeNames = scalar.UintMapSymStr{
 }
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.FLAC_Picture,
 		&decode.Format{
 			Description: "FLAC metadatablock picture",
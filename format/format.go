@@ -3,6 +3,7 @@ package format
 import (
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/wader/fq/pkg/decode"
 )
@@ -52,6 +53,8 @@ var (
 	IP_Packet      = &decode.Group{Name: "ip_packet", DefaultInArg: INET_Packet_In{}}   // ex: tcp
 	TCP_Stream     = &decode.Group{Name: "tcp_stream", DefaultInArg: TCP_Stream_In{}}   // ex: http
 	UDP_Payload    = &decode.Group{Name: "udp_payload", DefaultInArg: UDP_Payload_In{}} // ex: dns
+	UDP_Flow       = &decode.Group{Name: "udp_flow", DefaultInArg: UDP_Flow_In{}}       // ex: quic
+	QUIC_Streams   = &decode.Group{Name: "quic_streams", DefaultInArg: QUIC_Streams_In{}}
 	MP3_Frame_Tags = &decode.Group{Name: "mp3_frame_tags"}
 
 	Bytes = &decode.Group{Name: "bytes"}
@@ -65,6 +68,7 @@ var (
 	Apev2               = &decode.Group{Name: "apev2"}
 	Apple_Bookmark      = &decode.Group{Name: "apple_bookmark"}
 	AR                  = &decode.Group{Name: "ar"}
+	ARP                 = &decode.Group{Name: "arp"}
 	ASN1_BER            = &decode.Group{Name: "asn1_ber"}
 	AV1_CCR             = &decode.Group{Name: "av1_ccr"}
 	AV1_Frame           = &decode.Group{Name: "av1_frame"}
@@ -89,8 +93,11 @@ var (
 	Bzip2               = &decode.Group{Name: "bzip2"}
 	CBOR                = &decode.Group{Name: "cbor"}
 	CSV                 = &decode.Group{Name: "csv"}
+	DHCPv4              = &decode.Group{Name: "dhcpv4"}
+	DHCPv6              = &decode.Group{Name: "dhcpv6"}
 	DNS                 = &decode.Group{Name: "dns"}
 	DNS_TCP             = &decode.Group{Name: "dns_tcp"}
+	DNS_UDP_Flow        = &decode.Group{Name: "dns_udp_flow"}
 	ELF                 = &decode.Group{Name: "elf"}
 	Ether_8023_Frame    = &decode.Group{Name: "ether8023_frame"}
 	Exif                = &decode.Group{Name: "exif"}
@@ -102,7 +109,10 @@ var (
 	FLAC_Picture        = &decode.Group{Name: "flac_picture"}
 	FLAC_Streaminfo     = &decode.Group{Name: "flac_streaminfo"}
 	FLV                 = &decode.Group{Name: "flv"}
+	GENEVE              = &decode.Group{Name: "geneve"}
 	GIF                 = &decode.Group{Name: "gif"}
+	GRPC                = &decode.Group{Name: "grpc"}
+	GRE                 = &decode.Group{Name: "gre"}
 	Gzip                = &decode.Group{Name: "gzip"}
 	HEVC_Annexb         = &decode.Group{Name: "hevc_annexb"}
 	HEVC_AU             = &decode.Group{Name: "hevc_au"}
@@ -112,6 +122,9 @@ var (
 	HEVC_SPS            = &decode.Group{Name: "hevc_sps"}
 	HEVC_VPS            = &decode.Group{Name: "hevc_vps"}
 	HTML                = &decode.Group{Name: "html"}
+	HTTP                = &decode.Group{Name: "http"}
+	HTTP2               = &decode.Group{Name: "http2"}
+	HTTP3               = &decode.Group{Name: "http3"}
 	ICC_Profile         = &decode.Group{Name: "icc_profile"}
 	ICMP                = &decode.Group{Name: "icmp"}
 	ICMPv6              = &decode.Group{Name: "icmpv6"}
@@ -123,6 +136,7 @@ var (
 	JPEG                = &decode.Group{Name: "jpeg"}
 	JSON                = &decode.Group{Name: "json"}
 	JSONL               = &decode.Group{Name: "jsonl"}
+	LLDP                = &decode.Group{Name: "lldp"}
 	MachO               = &decode.Group{Name: "macho"}
 	MachO_Fat           = &decode.Group{Name: "macho_fat"}
 	Markdown            = &decode.Group{Name: "markdown"}
@@ -138,7 +152,9 @@ var (
 	MPEG_PES_Packet     = &decode.Group{Name: "mpeg_pes_packet"}
 	MPEG_SPU            = &decode.Group{Name: "mpeg_spu"}
 	MPEG_TS             = &decode.Group{Name: "mpeg_ts"}
+	MPLS                = &decode.Group{Name: "mpls"}
 	MsgPack             = &decode.Group{Name: "msgpack"}
+	NTP                 = &decode.Group{Name: "ntp"}
 	Ogg                 = &decode.Group{Name: "ogg"}
 	Ogg_Page            = &decode.Group{Name: "ogg_page"}
 	Opus_Packet         = &decode.Group{Name: "opus_packet"}
@@ -152,6 +168,7 @@ var (
 	Protobuf            = &decode.Group{Name: "protobuf"}
 	ProtobufWidevine    = &decode.Group{Name: "protobuf_widevine"}
 	PSSH_Playready      = &decode.Group{Name: "pssh_playready"}
+	QUIC                = &decode.Group{Name: "quic"}
 	RTMP                = &decode.Group{Name: "rtmp"}
 	SLL_Packet          = &decode.Group{Name: "sll_packet"}
 	SLL2_Packet         = &decode.Group{Name: "sll2_packet"}
@@ -162,12 +179,14 @@ var (
 	TOML                = &decode.Group{Name: "toml"}
 	Tzif                = &decode.Group{Name: "tzif"}
 	UDP_Datagram        = &decode.Group{Name: "udp_datagram"}
+	VLAN                = &decode.Group{Name: "vlan"}
 	Vorbis_Comment      = &decode.Group{Name: "vorbis_comment"}
 	Vorbis_Packet       = &decode.Group{Name: "vorbis_packet"}
 	VP8_Frame           = &decode.Group{Name: "vp8_frame"}
 	VP9_CFM             = &decode.Group{Name: "vp9_cfm"}
 	VP9_Frame           = &decode.Group{Name: "vp9_frame"}
 	VPX_CCR             = &decode.Group{Name: "vpx_ccr"}
+	VXLAN               = &decode.Group{Name: "vxlan"}
 	WASM                = &decode.Group{Name: "wasm"}
 	WAV                 = &decode.Group{Name: "wav"}
 	WebP                = &decode.Group{Name: "webp"}
@@ -242,6 +261,10 @@ type Protobuf_In struct {
 	Message ProtoBufMessage
 }
 
+type GRPC_In struct {
+	Encoding string // grpc-encoding header, ex: gzip
+}
+
 type Matroska_In struct {
 	DecodeSamples bool `doc:"Decode samples"`
 }
@@ -307,6 +330,52 @@ func (u UDP_Payload_In) MustIsPort(fn func(format string, a ...any), ports ...in
 	}
 }
 
+type UDP_Flow_Datagram struct {
+	IsClient    bool
+	PacketIndex int
+	Timestamp   time.Time
+	Length      int64 // in bytes
+}
+
+// UDP_Flow_In is datagrams in both directions between a client and server,
+// client sent the first datagram. Decode buffer is datagram payloads concatenated
+// in capture order.
+type UDP_Flow_In struct {
+	ClientPort int
+	ServerPort int
+	Datagrams  []UDP_Flow_Datagram
+}
+
+func (u UDP_Flow_In) IsPort(ports ...int) bool {
+	for _, p := range ports {
+		if u.ServerPort == p || u.ClientPort == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (u UDP_Flow_In) MustIsPort(fn func(format string, a ...any), ports ...int) {
+	if !u.IsPort(ports...) {
+		fn("incorrect udp flow port client:%d server:%d", u.ClientPort, u.ServerPort)
+	}
+}
+
+type QUIC_Stream struct {
+	ID       uint64
+	IsClient bool  // sent by client
+	HasEnd   bool  // has all data up to final size
+	Length   int64 // in bytes
+}
+
+// QUIC_Streams_In is reassembled stream data of a QUIC connection, one entry per
+// stream and direction in stream id order. Decode buffer is stream data concatenated
+// in the same order.
+type QUIC_Streams_In struct {
+	ALPN    string
+	Streams []QUIC_Stream
+}
+
 type TCP_Stream_In struct {
 	IsClient        bool
 	HasStart        bool
@@ -314,6 +383,8 @@ type TCP_Stream_In struct {
 	SkippedBytes    uint64
 	SourcePort      int
 	DestinationPort int
+	// InArg from client TCP_Stream_Out when decoding server side, ex: http request methods
+	ClientOut any
 }
 
 type TCP_Stream_Out struct {
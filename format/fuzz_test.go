@@ -8,11 +8,14 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"testing"
 
 	_ "github.com/wader/fq/format/all"
+	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/mutate"
 )
 
 type fuzzFS struct{}
@@ -64,13 +67,9 @@ func (ft *fuzzTest) Readline(opts interp.ReadlineOpts) (string, error) {
 	return "", io.EOF
 }
 
-func FuzzFormats(f *testing.F) {
-	if os.Getenv("FUZZTEST") == "" {
-		f.Skip("run with FUZZTEST=1 to fuzz")
-	}
-
-	i := 0
-
+// testdataFiles returns content of all non-fqtest files in testdata directories
+func testdataFiles(f *testing.F) [][]byte {
+	var bs [][]byte
 	if err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
 		if filepath.Base(path) != "testdata" {
 			return nil
@@ -89,9 +88,8 @@ func FuzzFormats(f *testing.F) {
 				f.Fatal(err)
 			}
 
-			f.Logf("seed#%d %s", i, path)
-			f.Add(b)
-			i++
+			f.Logf("seed#%d %s", len(bs), path)
+			bs = append(bs, b)
 
 			return nil
 		}); err != nil {
@@ -101,20 +99,32 @@ func FuzzFormats(f *testing.F) {
 	}); err != nil {
 		f.Fatal(f)
 	}
+	return bs
+}
 
-	fi := 0
-	var g *decode.Group
-
+func fuzzGroup(f *testing.F) *decode.Group {
 	if n := os.Getenv("GROUP"); n != "" {
-		var err error
-		g, err = interp.DefaultRegistry.Group(n)
+		g, err := interp.DefaultRegistry.Group(n)
 		if err != nil {
 			f.Fatal(err)
 		}
 		f.Logf("GROUP=%s", n)
-	} else {
-		g = interp.DefaultRegistry.MustAll()
+		return g
 	}
+	return interp.DefaultRegistry.MustAll()
+}
+
+func FuzzFormats(f *testing.F) {
+	if os.Getenv("FUZZTEST") == "" {
+		f.Skip("run with FUZZTEST=1 to fuzz")
+	}
+
+	for _, b := range testdataFiles(f) {
+		f.Add(b)
+	}
+
+	fi := 0
+	g := fuzzGroup(f)
 
 	f.Fuzz(func(t *testing.T, b []byte) {
 		fz := &fuzzTest{b: b, f: g.Formats[fi]}
@@ -132,3 +142,53 @@ func FuzzFormats(f *testing.F) {
 		fi = (fi + 1) % len(g.Formats)
 	})
 }
+
+// fuzzDecodeResolve decodes and walks the result so that lazy values are
+// resolved, a panic is a failure
+func fuzzDecodeResolve(t *testing.T, what string, b []byte, g *decode.Group, opts decode.Options) *decode.Value {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("%s: panic: %v\n%s", what, r, debug.Stack())
+		}
+	}()
+	dv, _, _ := decode.Decode(context.Background(), bitio.NewBitReader(b, -1), g, opts)
+	if dv == nil {
+		return nil
+	}
+	_ = dv.WalkPreOrder(func(*decode.Value, *decode.Value, int, int) error { return nil })
+	return dv
+}
+
+// FuzzStructural decodes testdata files, mutates them using the decode tree and
+// decodes the mutated input again
+func FuzzStructural(f *testing.F) {
+	if os.Getenv("FUZZTEST") == "" {
+		f.Skip("run with FUZZTEST=1 to fuzz")
+	}
+
+	bs := testdataFiles(f)
+	for i := range bs {
+		f.Add(uint(i), int64(0))
+	}
+
+	g := fuzzGroup(f)
+	if len(g.Formats) > 1 {
+		// probe instead of trying all formats
+		g = interp.DefaultRegistry.MustGroup("probe")
+	}
+	opts := decode.Options{IsRoot: true, FillGaps: true}
+
+	f.Fuzz(func(t *testing.T, fileIndex uint, seed int64) {
+		b := bs[fileIndex%uint(len(bs))]
+		dv := fuzzDecodeResolve(t, "original", b, g, opts)
+		if dv == nil {
+			return
+		}
+		mb, _, err := mutate.Mutate(dv, mutate.Options{Seed: seed, Count: 1 + int(uint64(seed)%4)})
+		if err != nil {
+			return
+		}
+		fuzzDecodeResolve(t, "mutated", mb, g, opts)
+	})
+}
@@ -0,0 +1,65 @@
+package http
+
+// https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md
+
+import (
+	"embed"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed grpc.md
+var grpcFS embed.FS
+
+var grpcProtobufGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.GRPC,
+		&decode.Format{
+			Description: "gRPC messages",
+			DecodeFn:    grpcDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Protobuf}, Out: &grpcProtobufGroup},
+			},
+		})
+	registry.RegisterFS(grpcFS)
+}
+
+var compressedFlagNames = scalar.UintMapSymBool{
+	0: false,
+	1: true,
+}
+
+func grpcDecode(d *decode.D) any {
+	var gi format.GRPC_In
+	d.ArgAs(&gi)
+
+	d.FieldArray("messages", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("message", func(d *decode.D) {
+				compressed := d.FieldU8("compressed", compressedFlagNames, d.UintValidate(0, 1))
+				length := d.FieldU32("length")
+				if int64(length)*8 > d.BitsLeft() {
+					d.Fatalf("message length %d outside data", length)
+				}
+				if length == 0 {
+					return
+				}
+				if compressed == 0 {
+					d.FieldFormatOrRawLen("data", int64(length)*8, &grpcProtobufGroup, nil)
+					return
+				}
+				// message is compressed using grpc-encoding
+				bs := d.PeekBytes(int(length))
+				d.FieldRawLen("data", int64(length)*8)
+				fieldDecodedContent(d, bs, gi.Encoding, &grpcProtobufGroup, nil)
+			})
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,520 @@
+package http
+
+// https://www.rfc-editor.org/rfc/rfc9112 HTTP/1.1
+// https://www.rfc-editor.org/rfc/rfc9110 HTTP Semantics
+
+// TODO: multipart bodies
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"embed"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed http.md
+var httpFS embed.FS
+
+var httpGIFGroup decode.Group
+var httpHTMLGroup decode.Group
+var httpJPEGGroup decode.Group
+var httpJSONGroup decode.Group
+var httpMP4Group decode.Group
+var httpPNGGroup decode.Group
+var httpProbeGroup decode.Group
+var httpWebPGroup decode.Group
+var httpXMLGroup decode.Group
+var httpHTTP2Group decode.Group
+
+// contentDependencies are groups used to decode bodies based on content type,
+// shared with http2
+func contentDependencies() []decode.Dependency {
+	return []decode.Dependency{
+		{Groups: []*decode.Group{format.GIF}, Out: &httpGIFGroup},
+		{Groups: []*decode.Group{format.HTML}, Out: &httpHTMLGroup},
+		{Groups: []*decode.Group{format.JPEG}, Out: &httpJPEGGroup},
+		{Groups: []*decode.Group{format.JSON}, Out: &httpJSONGroup},
+		{Groups: []*decode.Group{format.MP4}, Out: &httpMP4Group},
+		{Groups: []*decode.Group{format.PNG}, Out: &httpPNGGroup},
+		{Groups: []*decode.Group{format.Probe}, Out: &httpProbeGroup},
+		{Groups: []*decode.Group{format.WebP}, Out: &httpWebPGroup},
+		{Groups: []*decode.Group{format.XML}, Out: &httpXMLGroup},
+	}
+}
+
+func init() {
+	registry.RegisterFormat(
+		format.HTTP,
+		&decode.Format{
+			Description: "Hypertext Transfer Protocol 1.x",
+			Groups:      []*decode.Group{format.TCP_Stream},
+			DecodeFn:    httpDecode,
+			Dependencies: append(
+				contentDependencies(),
+				decode.Dependency{Groups: []*decode.Group{format.HTTP2}, Out: &httpHTTP2Group},
+			),
+		})
+	registry.RegisterFS(httpFS)
+}
+
+const maxLineLen = 64 * 1024
+
+var contentTypeGroups = map[string]*decode.Group{
+	"application/json": &httpJSONGroup,
+	"application/xml":  &httpXMLGroup,
+	"image/gif":        &httpGIFGroup,
+	"image/jpeg":       &httpJPEGGroup,
+	"image/png":        &httpPNGGroup,
+	"image/webp":       &httpWebPGroup,
+	"text/html":        &httpHTMLGroup,
+	"text/xml":         &httpXMLGroup,
+	"video/mp4":        &httpMP4Group,
+}
+
+var statusCodeNames = scalar.StrMapDescription{
+	"100": "Continue",
+	"101": "Switching Protocols",
+	"200": "OK",
+	"201": "Created",
+	"202": "Accepted",
+	"203": "Non-Authoritative Information",
+	"204": "No Content",
+	"205": "Reset Content",
+	"206": "Partial Content",
+	"300": "Multiple Choices",
+	"301": "Moved Permanently",
+	"302": "Found",
+	"303": "See Other",
+	"304": "Not Modified",
+	"305": "Use Proxy",
+	"307": "Temporary Redirect",
+	"308": "Permanent Redirect",
+	"400": "Bad Request",
+	"401": "Unauthorized",
+	"402": "Payment Required",
+	"403": "Forbidden",
+	"404": "Not Found",
+	"405": "Method Not Allowed",
+	"406": "Not Acceptable",
+	"407": "Proxy Authentication Required",
+	"408": "Request Timeout",
+	"409": "Conflict",
+	"410": "Gone",
+	"411": "Length Required",
+	"412": "Precondition Failed",
+	"413": "Content Too Large",
+	"414": "URI Too Long",
+	"415": "Unsupported Media Type",
+	"416": "Range Not Satisfiable",
+	"417": "Expectation Failed",
+	"421": "Misdirected Request",
+	"422": "Unprocessable Content",
+	"426": "Upgrade Required",
+	"429": "Too Many Requests",
+	"500": "Internal Server Error",
+	"501": "Not Implemented",
+	"502": "Bad Gateway",
+	"503": "Service Unavailable",
+	"504": "Gateway Timeout",
+	"505": "HTTP Version Not Supported",
+}
+
+// contentTypeGroup returns group to decode body with based on media type,
+// ex: "text/html; charset=UTF-8" is text/html. Unknown non-text types are probed.
+func contentTypeGroup(contentType string) *decode.Group {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	if g, ok := contentTypeGroups[mediaType]; ok {
+		return g
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		return &httpJSONGroup
+	case strings.HasSuffix(mediaType, "+xml"):
+		return &httpXMLGroup
+	case mediaType == "",
+		strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/x-www-form-urlencoded":
+		return nil
+	}
+	return &httpProbeGroup
+}
+
+// lineLen returns length of line in bytes including line ending or -1 if
+// there is no line ending
+func lineLen(d *decode.D) int64 {
+	n, _, err := d.TryPeekFind(8, 8, maxLineLen*8, func(v uint64) bool { return v == '\n' })
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n/8 + 1
+}
+
+func peekLine(d *decode.D) (string, bool) {
+	n := lineLen(d)
+	if n < 0 {
+		return "", false
+	}
+	return string(d.PeekBytes(int(n))), true
+}
+
+func isVersion(s string) bool {
+	return s == "HTTP/1.0" || s == "HTTP/1.1"
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// startLine checks if line is a request or status line
+func startLine(line string) (isRequest bool, ok bool) {
+	parts := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 3)
+	if len(parts) < 2 {
+		return false, false
+	}
+	if isVersion(parts[0]) {
+		code := parts[1]
+		_, err := strconv.ParseUint(code, 10, 16)
+		return false, len(code) == 3 && err == nil
+	}
+	return true, len(parts) == 3 && isToken(parts[0]) && isVersion(parts[2])
+}
+
+type headers map[string]string
+
+func (h headers) get(name string) string { return h[strings.ToLower(name)] }
+
+func (h headers) has(name string, token string) bool {
+	for _, v := range strings.Split(h.get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldHeaders(d *decode.D, name string, elemName string, h headers) {
+	d.FieldArray(name, func(d *decode.D) {
+		for {
+			line, ok := peekLine(d)
+			if !ok {
+				d.Fatalf("header line not terminated")
+			}
+			if line == "\r\n" || line == "\n" {
+				return
+			}
+			colon := strings.IndexByte(line, ':')
+			if colon < 1 {
+				d.Fatalf("invalid header line")
+			}
+			d.FieldStruct(elemName, func(d *decode.D) {
+				n := d.FieldUTF8("name", colon+1, scalar.StrActualTrim(":"))
+				v := d.FieldUTF8("value", len(line)-(colon+1), scalar.ActualTrimSpace)
+				// repeated headers are same as comma separated list
+				k := strings.ToLower(n)
+				if pv, ok := h[k]; ok {
+					v = pv + ", " + v
+				}
+				h[k] = v
+			})
+		}
+	})
+	line, _ := peekLine(d)
+	d.FieldUTF8(name+"_end", len(line))
+}
+
+// maxContentSize limits size of decoded content, ex: a compression bomb
+const maxContentSize = 64 * 1024 * 1024
+
+// decodeContent undoes content-encoding, encodings are listed in order they
+// were applied. Content larger than maxContentSize is truncated.
+func decodeContent(bs []byte, contentEncoding string) (content []byte, truncated bool, ok bool) {
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var r io.Reader
+		var err error
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(bs))
+		case "deflate":
+			// should be zlib but raw deflate is also used
+			r, err = zlib.NewReader(bytes.NewReader(bs))
+			if err != nil {
+				r, err = flate.NewReader(bytes.NewReader(bs)), nil
+			}
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(bs))
+		default:
+			return nil, false, false
+		}
+		if err != nil {
+			return nil, false, false
+		}
+		bs, err = io.ReadAll(io.LimitReader(r, int64(maxContentSize)+1))
+		if len(bs) > maxContentSize {
+			bs = bs[0:maxContentSize]
+			truncated = true
+		}
+		// truncated input to next encoding will fail at the end
+		if err != nil && !truncated {
+			return nil, false, false
+		}
+	}
+	return bs, truncated, true
+}
+
+// fieldDecodedContent adds content with content-encoding undone, if truncated
+// content is not decoded and content_truncated is added
+func fieldDecodedContent(d *decode.D, bs []byte, contentEncoding string, group *decode.Group, inArg any) {
+	content, truncated, ok := decodeContent(bs, contentEncoding)
+	if !ok {
+		return
+	}
+	if truncated {
+		d.FieldRootBitBuf("content", bitio.NewBitReader(content, -1))
+		d.FieldValueBool("content_truncated", true)
+		return
+	}
+	fieldContent(d, "content", content, group, inArg)
+}
+
+func fieldContent(d *decode.D, name string, bs []byte, group *decode.Group, inArg any) {
+	br := bitio.NewBitReader(bs, -1)
+	if group != nil {
+		if dv, _, _ := d.TryFieldFormatBitBuf(name, br, group, inArg); dv != nil {
+			return
+		}
+	}
+	d.FieldRootBitBuf(name, br)
+}
+
+func decodeChunked(d *decode.D, h headers) []byte {
+	content := &bytes.Buffer{}
+	d.FieldArray("chunks", func(d *decode.D) {
+		for {
+			line, ok := peekLine(d)
+			if !ok {
+				d.Fatalf("chunk size line not terminated")
+			}
+			// chunk-size [ chunk-ext ] CRLF
+			sizeStr := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+			size, err := strconv.ParseUint(sizeStr, 16, 63)
+			if err != nil {
+				d.Fatalf("invalid chunk size %q", sizeStr)
+			}
+			d.FieldStruct("chunk", func(d *decode.D) {
+				d.FieldUTF8("size", len(line), scalar.ActualTrimSpace, scalar.StrSym(size))
+				if size == 0 {
+					return
+				}
+				// compare in bytes as size in bits can overflow
+				if size > uint64(d.BitsLeft()/8) {
+					d.Fatalf("chunk size %d outside buffer", size)
+				}
+				content.Write(d.PeekBytes(int(size)))
+				d.FieldRawLen("data", int64(size)*8)
+				end, ok := peekLine(d)
+				if !ok || (end != "\r\n" && end != "\n") {
+					d.Fatalf("chunk data not terminated")
+				}
+				d.FieldUTF8("end", len(end))
+			})
+			if size == 0 {
+				return
+			}
+		}
+	})
+	fieldHeaders(d, "trailers", "trailer", h)
+
+	return content.Bytes()
+}
+
+// httpRequests is output from decoding client side and is used when decoding server
+// side to pair responses with requests
+type httpRequests struct {
+	methods []string
+}
+
+// decodeMessage decodes a request or response, returns true and upgrade header
+// if it's a protocol upgrade request or switching protocols response. requestMethod
+// is method of the request a response is for if known.
+func decodeMessage(d *decode.D, isRequest bool, requestMethod string) (bool, string) {
+	line, _ := peekLine(d)
+	parts := strings.SplitN(line, " ", 3)
+
+	hasBody := true
+	statusCode := ""
+	if isRequest {
+		d.FieldUTF8("method", len(parts[0])+1, scalar.ActualTrimSpace)
+		d.FieldUTF8("uri", len(parts[1])+1, scalar.ActualTrimSpace)
+		d.FieldUTF8("version", len(parts[2]), scalar.ActualTrimSpace)
+	} else {
+		d.FieldUTF8("version", len(parts[0])+1, scalar.ActualTrimSpace)
+		if len(parts) == 3 {
+			statusCode = d.FieldUTF8("status_code", len(parts[1])+1, scalar.ActualTrimSpace, statusCodeNames)
+			d.FieldUTF8("reason", len(parts[2]), scalar.ActualTrimSpace)
+		} else {
+			// no reason phrase
+			statusCode = d.FieldUTF8("status_code", len(parts[1]), scalar.ActualTrimSpace, statusCodeNames)
+		}
+		// https://www.rfc-editor.org/rfc/rfc9112#section-6.3
+		hasBody = !(statusCode[0] == '1' || statusCode == "204" || statusCode == "304" || requestMethod == "HEAD")
+	}
+
+	h := headers{}
+	fieldHeaders(d, "headers", "header", h)
+
+	upgrade := h.get("upgrade")
+	// successful CONNECT response is followed by a tunnel
+	isConnect := requestMethod == "CONNECT" && statusCode[0] == '2'
+	isUpgrade := statusCode == "101" || (isRequest && upgrade != "") || isConnect
+	if isConnect {
+		return isUpgrade, upgrade
+	}
+	if !hasBody {
+		return isUpgrade, upgrade
+	}
+
+	contentEncoding := h.get("content-encoding")
+	group := contentTypeGroup(h.get("content-type"))
+	isEncoded := contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity")
+
+	var body []byte
+	switch {
+	case h.has("transfer-encoding", "chunked"):
+		body = decodeChunked(d, h)
+	default:
+		var length int64
+		if cl := h.get("content-length"); cl != "" {
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.Split(cl, ",")[0]), 10, 63)
+			if err != nil || n < 0 {
+				d.Fatalf("invalid content-length %q", cl)
+			}
+			length = n
+		} else if !isRequest {
+			// read until connection close
+			length = d.BitsLeft() / 8
+		}
+		if length == 0 {
+			return isUpgrade, upgrade
+		}
+		if length*8 > d.BitsLeft() {
+			// truncated, ex: end of stream is missing
+			length = d.BitsLeft() / 8
+			group = nil
+			isEncoded = false
+		}
+		if !isEncoded {
+			if group != nil {
+				d.FieldFormatOrRawLen("body", length*8, group, nil)
+			} else {
+				d.FieldRawLen("body", length*8)
+			}
+			return isUpgrade, upgrade
+		}
+		body = d.PeekBytes(int(length))
+		d.FieldRawLen("body", length*8)
+	}
+
+	if isEncoded {
+		fieldDecodedContent(d, body, contentEncoding, group, nil)
+	} else {
+		fieldContent(d, "content", body, group, nil)
+	}
+
+	return isUpgrade, upgrade
+}
+
+func httpDecode(d *decode.D) any {
+	var tsi format.TCP_Stream_In
+	if d.ArgAs(&tsi) && !tsi.HasStart {
+		d.Fatalf("http requires start of byte stream")
+	}
+
+	line, ok := peekLine(d)
+	if !ok {
+		d.Fatalf("no start line found")
+	}
+	if _, ok := startLine(line); !ok {
+		d.Fatalf("not a http start line")
+	}
+
+	// methods of requests on client side, used by server side to know if a
+	// response has a body, ex: response to HEAD has no body
+	requests := &httpRequests{}
+	var clientRequests *httpRequests
+	if cr, ok := tsi.ClientOut.(*httpRequests); ok && !tsi.IsClient {
+		clientRequests = cr
+	}
+	responses := 0
+
+	// rest of stream after a protocol upgrade is some other protocol, ex: websocket
+	isUpgrade := false
+	upgrade := ""
+	d.FieldArray("messages", func(d *decode.D) {
+		for !d.End() {
+			line, ok := peekLine(d)
+			if !ok {
+				return
+			}
+			isRequest, ok := startLine(line)
+			if !ok {
+				return
+			}
+			requestMethod := ""
+			if isRequest {
+				requests.methods = append(requests.methods, strings.SplitN(line, " ", 2)[0])
+			} else if clientRequests != nil {
+				// interim 1xx responses except 101 are followed by a final response
+				// status line can have no reason phrase, ex: "HTTP/1.1 101\r\n"
+				parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+				if len(parts) < 2 || parts[1] == "" || parts[1][0] != '1' || parts[1] == "101" {
+					if responses < len(clientRequests.methods) {
+						requestMethod = clientRequests.methods[responses]
+					}
+					responses++
+				}
+			}
+			d.FieldStruct("message", func(d *decode.D) {
+				isUpgrade, upgrade = decodeMessage(d, isRequest, requestMethod)
+			})
+		}
+	})
+	if d.BitsLeft() > 0 {
+		switch {
+		case isUpgrade && strings.EqualFold(strings.TrimSpace(upgrade), "h2c"):
+			// http2 without tls, client preface or server settings follows
+			tsi.HasStart = true
+			d.FieldFormatOrRawLen("upgraded", d.BitsLeft(), &httpHTTP2Group, tsi)
+		case isUpgrade:
+			d.FieldRawLen("upgraded", d.BitsLeft())
+		default:
+			d.FieldRawLen("unknown", d.BitsLeft())
+		}
+	}
+
+	if tsi.IsClient {
+		return format.TCP_Stream_Out{InArg: requests}
+	}
+	return nil
+}
@@ -0,0 +1,679 @@
+package http
+
+// https://www.rfc-editor.org/rfc/rfc9113 HTTP/2
+// https://www.rfc-editor.org/rfc/rfc7541 HPACK
+
+// TODO: pair client and server streams, push promise request headers are
+// currently part of the promised stream headers
+// TODO: priority update and other extension frames
+
+import (
+	"bytes"
+	"embed"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+	"golang.org/x/net/http2/hpack"
+)
+
+//go:embed http2.md
+var http2FS embed.FS
+
+var http2GRPCGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.HTTP2,
+		&decode.Format{
+			Description: "Hypertext Transfer Protocol 2",
+			Groups:      []*decode.Group{format.TCP_Stream},
+			DecodeFn:    http2Decode,
+			Dependencies: append(
+				contentDependencies(),
+				decode.Dependency{Groups: []*decode.Group{format.GRPC}, Out: &http2GRPCGroup},
+			),
+		})
+	registry.RegisterFS(http2FS)
+}
+
+const http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const http2FrameHeaderLen = 9
+
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FramePriority     = 0x2
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+)
+
+var http2FrameTypeNames = scalar.UintMapSymStr{
+	http2FrameData:         "data",
+	http2FrameHeaders:      "headers",
+	http2FramePriority:     "priority",
+	http2FrameRSTStream:    "rst_stream",
+	http2FrameSettings:     "settings",
+	http2FramePushPromise:  "push_promise",
+	http2FramePing:         "ping",
+	http2FrameGoAway:       "goaway",
+	http2FrameWindowUpdate: "window_update",
+	http2FrameContinuation: "continuation",
+}
+
+const (
+	http2FlagEndStream  = 0x01
+	http2FlagAck        = 0x01
+	http2FlagEndHeaders = 0x04
+	http2FlagPadded     = 0x08
+	http2FlagPriority   = 0x20
+)
+
+type http2FlagBits struct {
+	name string
+	n    int
+}
+
+// flag bits from most significant bit, unknown frame types have all bits unused
+var http2FrameFlags = map[uint64][]http2FlagBits{
+	http2FrameData: {
+		{"unused0", 4}, {"padded", 1}, {"unused1", 2}, {"end_stream", 1},
+	},
+	http2FrameHeaders: {
+		{"unused0", 2}, {"priority", 1}, {"unused1", 1}, {"padded", 1}, {"end_headers", 1}, {"unused2", 1}, {"end_stream", 1},
+	},
+	http2FrameSettings: {
+		{"unused0", 7}, {"ack", 1},
+	},
+	http2FramePushPromise: {
+		{"unused0", 4}, {"padded", 1}, {"end_headers", 1}, {"unused1", 2},
+	},
+	http2FramePing: {
+		{"unused0", 7}, {"ack", 1},
+	},
+	http2FrameContinuation: {
+		{"unused0", 5}, {"end_headers", 1}, {"unused1", 2},
+	},
+}
+
+var http2SettingNames = scalar.UintMapSymStr{
+	0x1: "header_table_size",
+	0x2: "enable_push",
+	0x3: "max_concurrent_streams",
+	0x4: "initial_window_size",
+	0x5: "max_frame_size",
+	0x6: "max_header_list_size",
+	0x8: "enable_connect_protocol",
+	0x9: "no_rfc7540_priorities",
+}
+
+var http2ErrorCodeNames = scalar.UintMapSymStr{
+	0x0: "no_error",
+	0x1: "protocol_error",
+	0x2: "internal_error",
+	0x3: "flow_control_error",
+	0x4: "settings_timeout",
+	0x5: "stream_closed",
+	0x6: "frame_size_error",
+	0x7: "refused_stream",
+	0x8: "cancel",
+	0x9: "compression_error",
+	0xa: "connect_error",
+	0xb: "enhance_your_calm",
+	0xc: "inadequate_security",
+	0xd: "http_1_1_required",
+}
+
+type hpackField struct {
+	name  string
+	value string
+}
+
+// https://www.rfc-editor.org/rfc/rfc7541#appendix-A
+var hpackStaticTable = []hpackField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// hpackTable is the dynamic table, it's state is kept across header blocks
+// for one direction of a connection
+type hpackTable struct {
+	entries []hpackField // newest first
+	size    int
+	maxSize int
+}
+
+func hpackEntrySize(f hpackField) int { return len(f.name) + len(f.value) + 32 }
+
+func (t *hpackTable) evict() {
+	for t.size > t.maxSize && len(t.entries) > 0 {
+		t.size -= hpackEntrySize(t.entries[len(t.entries)-1])
+		t.entries = t.entries[:len(t.entries)-1]
+	}
+}
+
+func (t *hpackTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *hpackTable) add(f hpackField) {
+	t.entries = append([]hpackField{f}, t.entries...)
+	t.size += hpackEntrySize(f)
+	t.evict()
+}
+
+// lookup index where 1 is first static table entry and dynamic table entries
+// follow static table
+func (t *hpackTable) lookup(i uint64) (hpackField, bool) {
+	if i == 0 {
+		return hpackField{}, false
+	}
+	if i <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[i-1], true
+	}
+	i -= uint64(len(hpackStaticTable)) + 1
+	if i < uint64(len(t.entries)) {
+		return t.entries[i], true
+	}
+	return hpackField{}, false
+}
+
+// https://www.rfc-editor.org/rfc/rfc7541#section-5.1
+func fieldHPACKInt(d *decode.D, name string, prefixBits int) uint64 {
+	return d.FieldUintFn(name, func(d *decode.D) uint64 {
+		prefixMax := uint64(1)<<prefixBits - 1
+		v := d.U(prefixBits)
+		if v < prefixMax {
+			return v
+		}
+		for m := 0; ; m += 7 {
+			if m > 56 {
+				d.Fatalf("integer overflow")
+			}
+			b := d.U8()
+			v += (b & 0x7f) << m
+			if b&0x80 == 0 {
+				return v
+			}
+		}
+	})
+}
+
+// https://www.rfc-editor.org/rfc/rfc7541#section-5.2
+func fieldHPACKString(d *decode.D, name string) string {
+	return fieldHPACKPrefixString(d, name, 7)
+}
+
+// fieldHPACKPrefixString decodes a huffman flag followed by a length with
+// prefixBits bits, QPACK uses shorter prefixes for some names
+// https://www.rfc-editor.org/rfc/rfc9204#section-4.1.2
+func fieldHPACKPrefixString(d *decode.D, name string, prefixBits int) string {
+	huffman := d.FieldBool(name + "_huffman")
+	length := fieldHPACKInt(d, name+"_length", prefixBits)
+	if int64(length)*8 > d.BitsLeft() {
+		d.Fatalf("%s length %d outside header block", name, length)
+	}
+	return d.FieldStrFn(name, func(d *decode.D) string {
+		bs := d.BytesLen(int(length))
+		if !huffman {
+			return string(bs)
+		}
+		s, err := hpack.HuffmanDecodeToString(bs)
+		if err != nil {
+			d.Fatalf("%s: %s", name, err)
+		}
+		return s
+	})
+}
+
+// fieldHeaderBlock decodes a complete header block and returns header fields
+// https://www.rfc-editor.org/rfc/rfc7541#section-6
+func fieldHeaderBlock(d *decode.D, t *hpackTable) []hpackField {
+	var fields []hpackField
+
+	for !d.End() {
+		d.FieldStruct("field", func(d *decode.D) {
+			b := d.PeekUintBits(8)
+
+			var indexBits int
+			var indexing bool
+			switch {
+			case b&0x80 == 0x80:
+				d.FieldU1("representation", scalar.UintSym("indexed"))
+				index := fieldHPACKInt(d, "index", 7)
+				f, ok := t.lookup(index)
+				if !ok {
+					d.Fatalf("invalid index %d", index)
+				}
+				d.FieldValueStr("name", f.name)
+				d.FieldValueStr("value", f.value)
+				fields = append(fields, f)
+				return
+			case b&0xe0 == 0x20:
+				d.FieldU3("representation", scalar.UintSym("size_update"))
+				t.setMaxSize(int(fieldHPACKInt(d, "max_size", 5)))
+				return
+			case b&0xc0 == 0x40:
+				d.FieldU2("representation", scalar.UintSym("literal_incremental_indexing"))
+				indexBits = 6
+				indexing = true
+			case b&0xf0 == 0x10:
+				d.FieldU4("representation", scalar.UintSym("literal_never_indexed"))
+				indexBits = 4
+			default:
+				d.FieldU4("representation", scalar.UintSym("literal_without_indexing"))
+				indexBits = 4
+			}
+
+			var f hpackField
+			index := fieldHPACKInt(d, "index", indexBits)
+			if index == 0 {
+				f.name = fieldHPACKString(d, "name")
+			} else {
+				nf, ok := t.lookup(index)
+				if !ok {
+					d.Fatalf("invalid name index %d", index)
+				}
+				f.name = nf.name
+				d.FieldValueStr("name", f.name)
+			}
+			f.value = fieldHPACKString(d, "value")
+			if indexing {
+				t.add(f)
+			}
+			fields = append(fields, f)
+		})
+	}
+
+	return fields
+}
+
+type http2Stream struct {
+	id          uint64
+	headers     []hpackField
+	trailers    []hpackField
+	hasHeaders  bool
+	data        bytes.Buffer
+	isReset     bool
+	resetCode   uint64
+	isEndStream bool
+}
+
+type http2Conn struct {
+	table   hpackTable
+	streams map[uint64]*http2Stream
+	order   []uint64
+
+	// header block split into continuation frames
+	block       bytes.Buffer
+	blockStream uint64
+	inBlock     bool
+}
+
+func (c *http2Conn) stream(id uint64) *http2Stream {
+	if s, ok := c.streams[id]; ok {
+		return s
+	}
+	s := &http2Stream{id: id}
+	c.streams[id] = s
+	c.order = append(c.order, id)
+	return s
+}
+
+// first header block on a stream are headers, later ones are trailers
+func (c *http2Conn) addHeaders(id uint64, fields []hpackField) {
+	s := c.stream(id)
+	if !s.hasHeaders {
+		s.hasHeaders = true
+		s.headers = fields
+		return
+	}
+	// informational 1xx responses are followed by final headers
+	if len(s.headers) > 0 && s.headers[0].name == ":status" && strings.HasPrefix(s.headers[0].value, "1") {
+		s.headers = fields
+		return
+	}
+	s.trailers = append(s.trailers, fields...)
+}
+
+// fieldHeaderBlockFragment decodes header block in place if complete,
+// otherwise it's collected until a continuation frame with end headers
+func (c *http2Conn) fieldHeaderBlockFragment(d *decode.D, streamID uint64, nBytes int64, endHeaders bool) {
+	if endHeaders && !c.inBlock {
+		d.FramedFn(nBytes*8, func(d *decode.D) {
+			d.FieldArray("header_block", func(d *decode.D) {
+				c.addHeaders(streamID, fieldHeaderBlock(d, &c.table))
+			})
+		})
+		return
+	}
+
+	if !c.inBlock {
+		c.inBlock = true
+		c.blockStream = streamID
+		c.block.Reset()
+	}
+	c.block.Write(d.PeekBytes(int(nBytes)))
+	d.FieldRawLen("header_block_fragment", nBytes*8)
+	if endHeaders {
+		c.inBlock = false
+		br := bitio.NewBitReader(append([]byte(nil), c.block.Bytes()...), -1)
+		d.FieldArrayRootBitBufFn("header_block", br, func(d *decode.D) {
+			c.addHeaders(c.blockStream, fieldHeaderBlock(d, &c.table))
+		})
+	}
+}
+
+// fieldPadded decodes optional pad length and padding around fn, fn gets
+// length of payload without padding
+func fieldPadded(d *decode.D, padded bool, fn func(d *decode.D, nBytes int64)) {
+	var padLength int64
+	if padded {
+		padLength = int64(d.FieldU8("pad_length"))
+	}
+	nBytes := d.BitsLeft()/8 - padLength
+	if nBytes < 0 {
+		d.Fatalf("pad length %d larger than payload", padLength)
+	}
+	fn(d, nBytes)
+	if padded {
+		d.FieldRawLen("padding", padLength*8)
+	}
+}
+
+func fieldPriority(d *decode.D) {
+	d.FieldBool("exclusive")
+	d.FieldU31("stream_dependency")
+	d.FieldU8("weight", scalar.UintActualAdd(1))
+}
+
+func (c *http2Conn) fieldFrame(d *decode.D) {
+	length := d.FieldU24("length")
+	typ := d.FieldU8("type", http2FrameTypeNames)
+	flags := d.PeekUintBits(8)
+	d.FieldStruct("flags", func(d *decode.D) {
+		bits, ok := http2FrameFlags[typ]
+		if !ok {
+			d.FieldU8("unused0")
+			return
+		}
+		for _, b := range bits {
+			if strings.HasPrefix(b.name, "unused") {
+				d.FieldU(b.name, b.n)
+			} else {
+				d.FieldBool(b.name)
+			}
+		}
+	})
+	d.FieldU1("reserved")
+	streamID := d.FieldU31("stream_id")
+
+	if c.inBlock && typ != http2FrameContinuation {
+		d.Fatalf("expected continuation frame")
+	}
+
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		if _, ok := http2FrameTypeNames[typ]; !ok {
+			if d.BitsLeft() > 0 {
+				d.FieldRawLen("payload", d.BitsLeft())
+			}
+			return
+		}
+		d.FieldStruct("payload", func(d *decode.D) { c.fieldFramePayload(d, typ, flags, streamID) })
+	})
+}
+
+func (c *http2Conn) fieldFramePayload(d *decode.D, typ uint64, flags uint64, streamID uint64) {
+	switch typ {
+	case http2FrameData:
+		fieldPadded(d, flags&http2FlagPadded != 0, func(d *decode.D, nBytes int64) {
+			s := c.stream(streamID)
+			s.data.Write(d.PeekBytes(int(nBytes)))
+			s.isEndStream = flags&http2FlagEndStream != 0
+			d.FieldRawLen("data", nBytes*8)
+		})
+	case http2FrameHeaders:
+		fieldPadded(d, flags&http2FlagPadded != 0, func(d *decode.D, nBytes int64) {
+			if flags&http2FlagPriority != 0 {
+				fieldPriority(d)
+				nBytes -= 5
+			}
+			if flags&http2FlagEndStream != 0 {
+				c.stream(streamID).isEndStream = true
+			}
+			c.fieldHeaderBlockFragment(d, streamID, nBytes, flags&http2FlagEndHeaders != 0)
+		})
+	case http2FramePriority:
+		fieldPriority(d)
+	case http2FrameRSTStream:
+		s := c.stream(streamID)
+		s.isReset = true
+		s.resetCode = d.FieldU32("error_code", http2ErrorCodeNames)
+	case http2FrameSettings:
+		d.FieldStructArrayLoop("settings", "setting", func() bool { return d.BitsLeft() >= 6*8 }, func(d *decode.D) {
+			d.FieldU16("identifier", http2SettingNames)
+			d.FieldU32("value")
+		})
+	case http2FramePushPromise:
+		fieldPadded(d, flags&http2FlagPadded != 0, func(d *decode.D, nBytes int64) {
+			d.FieldU1("reserved")
+			promisedID := d.FieldU31("promised_stream_id")
+			c.fieldHeaderBlockFragment(d, promisedID, nBytes-4, flags&http2FlagEndHeaders != 0)
+		})
+	case http2FramePing:
+		d.FieldU64("opaque_data")
+	case http2FrameGoAway:
+		d.FieldU1("reserved")
+		d.FieldU31("last_stream_id")
+		d.FieldU32("error_code", http2ErrorCodeNames)
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("additional_debug_data", d.BitsLeft())
+		}
+	case http2FrameWindowUpdate:
+		d.FieldU1("reserved")
+		d.FieldU31("window_size_increment")
+	case http2FrameContinuation:
+		if !c.inBlock {
+			d.Fatalf("unexpected continuation frame")
+		}
+		c.fieldHeaderBlockFragment(d, streamID, d.BitsLeft()/8, flags&http2FlagEndHeaders != 0)
+	}
+}
+
+func fieldHeaderFields(d *decode.D, name string, elemName string, fields []hpackField) headers {
+	h := headers{}
+	d.FieldArray(name, func(d *decode.D) {
+		for _, f := range fields {
+			d.FieldStruct(elemName, func(d *decode.D) {
+				d.FieldValueStr("name", f.name)
+				d.FieldValueStr("value", f.value)
+			})
+			// repeated headers are same as comma separated list, cookie can
+			// be split into multiple fields
+			// https://www.rfc-editor.org/rfc/rfc9113#section-8.2.3
+			if pv, ok := h[f.name]; ok {
+				sep := ", "
+				if f.name == "cookie" {
+					sep = "; "
+				}
+				f.value = pv + sep + f.value
+			}
+			h[f.name] = f.value
+		}
+	})
+	return h
+}
+
+func isGRPCContentType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+func fieldStreams(d *decode.D, c *http2Conn) {
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, id := range c.order {
+			// connection control frames are on stream 0
+			if id == 0 {
+				continue
+			}
+			s := c.streams[id]
+			d.FieldStruct("stream", func(d *decode.D) {
+				d.FieldValueUint("stream_id", s.id)
+				h := fieldHeaderFields(d, "headers", "header", s.headers)
+				if len(s.trailers) > 0 {
+					fieldHeaderFields(d, "trailers", "trailer", s.trailers)
+				}
+				d.FieldValueBool("end_stream", s.isEndStream)
+				if s.isReset {
+					d.FieldValueUint("reset_error_code", s.resetCode, http2ErrorCodeNames)
+				}
+				if s.data.Len() == 0 {
+					return
+				}
+				fieldMessageData(d, h, s.data.Bytes(), &http2GRPCGroup)
+			})
+		}
+	})
+}
+
+// fieldMessageData adds data and decoded content based on content type and encoding
+func fieldMessageData(d *decode.D, h headers, data []byte, grpcGroup *decode.Group) {
+	contentType := h.get("content-type")
+	if isGRPCContentType(contentType) {
+		fieldContent(d, "data", data, grpcGroup, format.GRPC_In{Encoding: h.get("grpc-encoding")})
+		return
+	}
+
+	group := contentTypeGroup(contentType)
+	contentEncoding := h.get("content-encoding")
+	if contentEncoding == "" || strings.EqualFold(contentEncoding, "identity") {
+		fieldContent(d, "data", data, group, nil)
+		return
+	}
+	d.FieldRootBitBuf("data", bitio.NewBitReader(data, -1))
+	fieldDecodedContent(d, data, contentEncoding, group, nil)
+}
+
+// isHTTP2Settings checks if there is a non-ack settings frame on stream 0
+// which is the first frame sent by both client and server
+func isHTTP2Settings(d *decode.D) bool {
+	if d.BitsLeft() < http2FrameHeaderLen*8 {
+		return false
+	}
+	bs := d.PeekBytes(http2FrameHeaderLen)
+	length := int(bs[0])<<16 | int(bs[1])<<8 | int(bs[2])
+	streamID := (int(bs[5])<<24 | int(bs[6])<<16 | int(bs[7])<<8 | int(bs[8])) & 0x7fff_ffff
+	return bs[3] == http2FrameSettings &&
+		bs[4]&http2FlagAck == 0 &&
+		length%6 == 0 &&
+		streamID == 0
+}
+
+func http2Decode(d *decode.D) any {
+	var tsi format.TCP_Stream_In
+	if d.ArgAs(&tsi) && !tsi.HasStart {
+		d.Fatalf("http2 requires start of byte stream")
+	}
+
+	// client starts with a preface followed by settings, server starts with settings
+	hasPreface := d.BitsLeft() >= int64(len(http2ClientPreface))*8 &&
+		string(d.PeekBytes(len(http2ClientPreface))) == http2ClientPreface
+	if hasPreface {
+		d.FieldUTF8("preface", len(http2ClientPreface))
+	}
+	if !isHTTP2Settings(d) {
+		d.Fatalf("no initial settings frame found")
+	}
+
+	c := &http2Conn{
+		table:   hpackTable{maxSize: 4096},
+		streams: map[uint64]*http2Stream{},
+	}
+
+	d.FieldArray("frames", func(d *decode.D) {
+		for d.BitsLeft() >= http2FrameHeaderLen*8 {
+			length := d.PeekUintBits(24)
+			if int64(http2FrameHeaderLen+length)*8 > d.BitsLeft() {
+				// truncated, ex: end of stream is missing
+				return
+			}
+			d.FieldStruct("frame", c.fieldFrame)
+		}
+	})
+	fieldStreams(d, c)
+
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -0,0 +1,623 @@
+package http
+
+// https://www.rfc-editor.org/rfc/rfc9114 HTTP/3
+// https://www.rfc-editor.org/rfc/rfc9204 QPACK
+// https://www.rfc-editor.org/rfc/rfc9218#section-7.2 priority update
+
+// TODO: required insert count is decoded using total number of inserts at end of
+// encoder stream, can be wrong if dynamic table wrapped around
+// TODO: server push streams are not paired with push promises
+
+import (
+	"embed"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed http3.md
+var http3FS embed.FS
+
+var http3GRPCGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.HTTP3,
+		&decode.Format{
+			Description: "Hypertext Transfer Protocol 3",
+			Groups:      []*decode.Group{format.QUIC_Streams},
+			DecodeFn:    http3Decode,
+			Dependencies: append(
+				contentDependencies(),
+				decode.Dependency{Groups: []*decode.Group{format.GRPC}, Out: &http3GRPCGroup},
+			),
+		})
+	registry.RegisterFS(http3FS)
+}
+
+const (
+	http3StreamControl      = 0x00
+	http3StreamPush         = 0x01
+	http3StreamQPACKEncoder = 0x02
+	http3StreamQPACKDecoder = 0x03
+)
+
+var http3StreamTypeNames = scalar.UintMapSymStr{
+	http3StreamControl:      "control",
+	http3StreamPush:         "push",
+	http3StreamQPACKEncoder: "qpack_encoder",
+	http3StreamQPACKDecoder: "qpack_decoder",
+}
+
+const (
+	http3FrameData                  = 0x00
+	http3FrameHeaders               = 0x01
+	http3FrameCancelPush            = 0x03
+	http3FrameSettings              = 0x04
+	http3FramePushPromise           = 0x05
+	http3FrameGoAway                = 0x07
+	http3FrameMaxPushID             = 0x0d
+	http3FramePriorityUpdateRequest = 0xf0700
+	http3FramePriorityUpdatePush    = 0xf0701
+)
+
+var http3FrameTypeNames = scalar.UintMapSymStr{
+	http3FrameData:                  "data",
+	http3FrameHeaders:               "headers",
+	http3FrameCancelPush:            "cancel_push",
+	http3FrameSettings:              "settings",
+	http3FramePushPromise:           "push_promise",
+	http3FrameGoAway:                "goaway",
+	http3FrameMaxPushID:             "max_push_id",
+	http3FramePriorityUpdateRequest: "priority_update_request",
+	http3FramePriorityUpdatePush:    "priority_update_push",
+}
+
+const http3SettingQPACKMaxTableCapacity = 0x01
+
+var http3SettingNames = scalar.UintMapSymStr{
+	http3SettingQPACKMaxTableCapacity: "qpack_max_table_capacity",
+	0x06:                              "max_field_section_size",
+	0x07:                              "qpack_blocked_streams",
+	0x08:                              "enable_connect_protocol",
+	0x33:                              "h3_datagram",
+}
+
+// http3Reserved maps names and reserved 0x1f * N + 0x21 values used for stream types,
+// frame types and settings to exercise that unknown values are ignored
+// https://www.rfc-editor.org/rfc/rfc9114#section-7.2.8
+func http3Reserved(names scalar.UintMapSymStr) scalar.UintMapper {
+	return scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		if s.Actual >= 0x21 && (s.Actual-0x21)%0x1f == 0 {
+			s.Sym = "reserved"
+			return s, nil
+		}
+		return names.MapUint(s)
+	})
+}
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-16
+func fieldQUICVarint(d *decode.D, name string, sms ...scalar.UintMapper) uint64 {
+	return d.FieldUintFn(name, func(d *decode.D) uint64 {
+		lengthBits := d.U2()
+		return d.U(int(8<<lengthBits) - 2)
+	}, sms...)
+}
+
+// https://www.rfc-editor.org/rfc/rfc9204#appendix-A
+var qpackStaticTable = []hpackField{
+	{":authority", ""},
+	{":path", "/"},
+	{"age", "0"},
+	{"content-disposition", ""},
+	{"content-length", "0"},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"referer", ""},
+	{"set-cookie", ""},
+	{":method", "CONNECT"},
+	{":method", "DELETE"},
+	{":method", "GET"},
+	{":method", "HEAD"},
+	{":method", "OPTIONS"},
+	{":method", "POST"},
+	{":method", "PUT"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "103"},
+	{":status", "200"},
+	{":status", "304"},
+	{":status", "404"},
+	{":status", "503"},
+	{"accept", "*/*"},
+	{"accept", "application/dns-message"},
+	{"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"},
+	{"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"},
+	{"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"},
+	{"cache-control", "no-cache"},
+	{"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"},
+	{"content-encoding", "br"},
+	{"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"},
+	{"content-type", "application/javascript"},
+	{"content-type", "application/json"},
+	{"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"},
+	{"content-type", "image/jpeg"},
+	{"content-type", "image/png"},
+	{"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"},
+	{"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"},
+	{"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"},
+	{"vary", "accept-encoding"},
+	{"vary", "origin"},
+	{"x-content-type-options", "nosniff"},
+	{"x-xss-protection", "1; mode=block"},
+	{":status", "100"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "302"},
+	{":status", "400"},
+	{":status", "403"},
+	{":status", "421"},
+	{":status", "425"},
+	{":status", "500"},
+	{"accept-language", ""},
+	{"access-control-allow-credentials", "FALSE"},
+	{"access-control-allow-credentials", "TRUE"},
+	{"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"},
+	{"access-control-allow-methods", "get, post, options"},
+	{"access-control-allow-methods", "options"},
+	{"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"},
+	{"access-control-request-method", "get"},
+	{"access-control-request-method", "post"},
+	{"alt-svc", "clear"},
+	{"authorization", ""},
+	{"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{"early-data", "1"},
+	{"expect-ct", ""},
+	{"forwarded", ""},
+	{"if-range", ""},
+	{"origin", ""},
+	{"purpose", "prefetch"},
+	{"server", ""},
+	{"timing-allow-origin", "*"},
+	{"upgrade-insecure-requests", "1"},
+	{"user-agent", ""},
+	{"x-forwarded-for", ""},
+	{"x-frame-options", "deny"},
+	{"x-frame-options", "sameorigin"},
+}
+
+// qpackTable is the dynamic table for field sections encoded by one endpoint. All
+// inserted entries are kept and indexed by absolute index as eviction only depends
+// on what the encoder references.
+type qpackTable struct {
+	entries []hpackField
+	// capacity from peer decoders settings, used to decode required insert count
+	maxTableCapacity uint64
+}
+
+func (t *qpackTable) lookup(d *decode.D, static bool, index uint64) hpackField {
+	if static {
+		if index >= uint64(len(qpackStaticTable)) {
+			d.Fatalf("invalid static table index %d", index)
+		}
+		return qpackStaticTable[index]
+	}
+	if index >= uint64(len(t.entries)) {
+		d.Fatalf("invalid dynamic table index %d", index)
+	}
+	return t.entries[index]
+}
+
+// relative index on encoder stream is relative to last inserted entry
+func (t *qpackTable) relative(d *decode.D, index uint64) uint64 {
+	if index >= uint64(len(t.entries)) {
+		d.Fatalf("invalid relative index %d", index)
+	}
+	return uint64(len(t.entries)) - 1 - index
+}
+
+// https://www.rfc-editor.org/rfc/rfc9204#section-4.3
+func (t *qpackTable) fieldEncoderInstruction(d *decode.D) {
+	b := d.PeekUintBits(8)
+	switch {
+	case b&0x80 == 0x80:
+		d.FieldU1("type", scalar.UintSym("insert_with_name_reference"))
+		static := d.FieldBool("static")
+		index := fieldHPACKInt(d, "name_index", 6)
+		if !static {
+			index = t.relative(d, index)
+		}
+		name := t.lookup(d, static, index).name
+		d.FieldValueStr("name", name)
+		value := fieldHPACKString(d, "value")
+		t.entries = append(t.entries, hpackField{name: name, value: value})
+	case b&0xc0 == 0x40:
+		d.FieldU2("type", scalar.UintSym("insert_with_literal_name"))
+		name := fieldHPACKPrefixString(d, "name", 5)
+		value := fieldHPACKString(d, "value")
+		t.entries = append(t.entries, hpackField{name: name, value: value})
+	case b&0xe0 == 0x20:
+		d.FieldU3("type", scalar.UintSym("set_dynamic_table_capacity"))
+		fieldHPACKInt(d, "capacity", 5)
+	default:
+		d.FieldU3("type", scalar.UintSym("duplicate"))
+		index := t.relative(d, fieldHPACKInt(d, "index", 5))
+		f := t.lookup(d, false, index)
+		d.FieldValueStr("name", f.name)
+		d.FieldValueStr("value", f.value)
+		t.entries = append(t.entries, f)
+	}
+}
+
+// https://www.rfc-editor.org/rfc/rfc9204#section-4.4
+func fieldQPACKDecoderInstruction(d *decode.D) {
+	b := d.PeekUintBits(8)
+	switch {
+	case b&0x80 == 0x80:
+		d.FieldU1("type", scalar.UintSym("section_acknowledgment"))
+		fieldHPACKInt(d, "stream_id", 7)
+	case b&0xc0 == 0x40:
+		d.FieldU2("type", scalar.UintSym("stream_cancellation"))
+		fieldHPACKInt(d, "stream_id", 6)
+	default:
+		d.FieldU2("type", scalar.UintSym("insert_count_increment"))
+		fieldHPACKInt(d, "increment", 6)
+	}
+}
+
+// https://www.rfc-editor.org/rfc/rfc9204#section-4.5.1.1
+func (t *qpackTable) requiredInsertCount(d *decode.D, encoded uint64) uint64 {
+	if encoded == 0 {
+		return 0
+	}
+	maxEntries := t.maxTableCapacity / 32
+	fullRange := 2 * maxEntries
+	if encoded > fullRange {
+		d.Fatalf("invalid encoded required insert count %d", encoded)
+	}
+	maxValue := uint64(len(t.entries)) + maxEntries
+	maxWrapped := (maxValue / fullRange) * fullRange
+	ric := maxWrapped + encoded - 1
+	if ric > maxValue {
+		if ric <= fullRange {
+			d.Fatalf("invalid encoded required insert count %d", encoded)
+		}
+		ric -= fullRange
+	}
+	if ric == 0 {
+		d.Fatalf("invalid encoded required insert count %d", encoded)
+	}
+	return ric
+}
+
+// fieldFieldSection decodes a field section and returns field lines
+// https://www.rfc-editor.org/rfc/rfc9204#section-4.5
+func (t *qpackTable) fieldFieldSection(d *decode.D) []hpackField {
+	var fields []hpackField
+
+	encodedRIC := fieldHPACKInt(d, "encoded_required_insert_count", 8)
+	ric := t.requiredInsertCount(d, encodedRIC)
+	d.FieldValueUint("required_insert_count", ric)
+	sign := d.FieldBool("sign")
+	deltaBase := fieldHPACKInt(d, "delta_base", 7)
+	base := ric + deltaBase
+	if sign {
+		if deltaBase >= ric {
+			d.Fatalf("invalid delta base %d", deltaBase)
+		}
+		base = ric - deltaBase - 1
+	}
+	d.FieldValueUint("base", base)
+
+	// relative index is relative to base, post-base index counts from base
+	relative := func(index uint64) uint64 {
+		if index >= base {
+			d.Fatalf("invalid relative index %d", index)
+		}
+		return base - 1 - index
+	}
+
+	d.FieldArray("field_lines", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("field_line", func(d *decode.D) {
+				b := d.PeekUintBits(8)
+
+				var f hpackField
+				switch {
+				case b&0x80 == 0x80:
+					d.FieldU1("representation", scalar.UintSym("indexed"))
+					static := d.FieldBool("static")
+					index := fieldHPACKInt(d, "index", 6)
+					if !static {
+						index = relative(index)
+					}
+					f = t.lookup(d, static, index)
+					d.FieldValueStr("name", f.name)
+					d.FieldValueStr("value", f.value)
+				case b&0xf0 == 0x10:
+					d.FieldU4("representation", scalar.UintSym("indexed_post_base"))
+					f = t.lookup(d, false, base+fieldHPACKInt(d, "index", 4))
+					d.FieldValueStr("name", f.name)
+					d.FieldValueStr("value", f.value)
+				case b&0xc0 == 0x40:
+					d.FieldU2("representation", scalar.UintSym("literal_with_name_reference"))
+					d.FieldBool("never_indexed")
+					static := d.FieldBool("static")
+					index := fieldHPACKInt(d, "name_index", 4)
+					if !static {
+						index = relative(index)
+					}
+					f.name = t.lookup(d, static, index).name
+					d.FieldValueStr("name", f.name)
+					f.value = fieldHPACKString(d, "value")
+				case b&0xf0 == 0x00:
+					d.FieldU4("representation", scalar.UintSym("literal_with_post_base_name_reference"))
+					d.FieldBool("never_indexed")
+					f.name = t.lookup(d, false, base+fieldHPACKInt(d, "name_index", 3)).name
+					d.FieldValueStr("name", f.name)
+					f.value = fieldHPACKString(d, "value")
+				default:
+					d.FieldU3("representation", scalar.UintSym("literal_with_literal_name"))
+					d.FieldBool("never_indexed")
+					f.name = fieldHPACKPrefixString(d, "name", 3)
+					f.value = fieldHPACKString(d, "value")
+				}
+				fields = append(fields, f)
+			})
+		}
+	})
+
+	return fields
+}
+
+type http3Message struct {
+	headers    []hpackField
+	trailers   []hpackField
+	hasHeaders bool
+	data       []byte
+}
+
+// first field section on a stream are headers, later ones are trailers
+func (m *http3Message) addFields(fields []hpackField) {
+	// informational 1xx responses are followed by final headers
+	isInformational := len(m.headers) > 0 && m.headers[0].name == ":status" && strings.HasPrefix(m.headers[0].value, "1")
+	if !m.hasHeaders || isInformational {
+		m.hasHeaders = true
+		m.headers = fields
+		return
+	}
+	m.trailers = append(m.trailers, fields...)
+}
+
+type http3Request struct {
+	streamID uint64
+	request  http3Message
+	response http3Message
+}
+
+type http3Conn struct {
+	// dynamic tables for field sections sent by client and server
+	clientTable qpackTable
+	serverTable qpackTable
+	requests    map[uint64]*http3Request
+	order       []uint64
+}
+
+func (c *http3Conn) table(isClient bool) *qpackTable {
+	if isClient {
+		return &c.clientTable
+	}
+	return &c.serverTable
+}
+
+func (c *http3Conn) message(streamID uint64, isClient bool) *http3Message {
+	r, ok := c.requests[streamID]
+	if !ok {
+		r = &http3Request{streamID: streamID}
+		c.requests[streamID] = r
+		c.order = append(c.order, streamID)
+	}
+	if isClient {
+		return &r.request
+	}
+	return &r.response
+}
+
+// fieldFrame decodes a frame, msg is nil for frames not on a request stream
+// https://www.rfc-editor.org/rfc/rfc9114#section-7.2
+func (c *http3Conn) fieldFrame(d *decode.D, isClient bool, msg *http3Message) {
+	typ := fieldQUICVarint(d, "type", http3Reserved(http3FrameTypeNames), scalar.UintHex)
+	length := fieldQUICVarint(d, "length")
+	if int64(length)*8 > d.BitsLeft() {
+		// end of stream is missing
+		d.FieldRawLen("incomplete", d.BitsLeft())
+		return
+	}
+
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		switch typ {
+		case http3FrameData:
+			if msg != nil {
+				msg.data = append(msg.data, d.PeekBytes(int(length))...)
+			}
+			d.FieldRawLen("data", d.BitsLeft())
+		case http3FrameHeaders:
+			d.FieldStruct("field_section", func(d *decode.D) {
+				fields := c.table(isClient).fieldFieldSection(d)
+				if msg != nil {
+					msg.addFields(fields)
+				}
+			})
+		case http3FrameCancelPush,
+			http3FrameMaxPushID:
+			fieldQUICVarint(d, "push_id")
+		case http3FrameSettings:
+			d.FieldStructArrayLoop("settings", "setting", func() bool { return !d.End() }, func(d *decode.D) {
+				identifier := fieldQUICVarint(d, "identifier", http3Reserved(http3SettingNames), scalar.UintHex)
+				value := fieldQUICVarint(d, "value")
+				// settings constrains what the peer encoder can use
+				if identifier == http3SettingQPACKMaxTableCapacity {
+					c.table(!isClient).maxTableCapacity = value
+				}
+			})
+		case http3FramePushPromise:
+			fieldQUICVarint(d, "push_id")
+			d.FieldStruct("field_section", func(d *decode.D) { c.table(isClient).fieldFieldSection(d) })
+		case http3FrameGoAway:
+			fieldQUICVarint(d, "id")
+		case http3FramePriorityUpdateRequest,
+			http3FramePriorityUpdatePush:
+			fieldQUICVarint(d, "prioritized_element_id")
+			d.FieldUTF8("priority_field_value", int(d.BitsLeft()/8))
+		default:
+			if d.BitsLeft() > 0 {
+				d.FieldRawLen("payload", d.BitsLeft())
+			}
+		}
+	})
+}
+
+func (c *http3Conn) fieldFrames(d *decode.D, isClient bool, msg *http3Message) {
+	d.FieldArray("frames", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("frame", func(d *decode.D) { c.fieldFrame(d, isClient, msg) })
+		}
+	})
+}
+
+// https://www.rfc-editor.org/rfc/rfc9114#section-6.2
+func (c *http3Conn) fieldUnidirectionalStream(d *decode.D, isClient bool) {
+	if d.End() {
+		return
+	}
+	typ := fieldQUICVarint(d, "stream_type", http3Reserved(http3StreamTypeNames), scalar.UintHex)
+	switch typ {
+	case http3StreamControl:
+		c.fieldFrames(d, isClient, nil)
+	case http3StreamPush:
+		fieldQUICVarint(d, "push_id")
+		c.fieldFrames(d, isClient, nil)
+	case http3StreamQPACKEncoder:
+		d.FieldStructArrayLoop("instructions", "instruction", func() bool { return !d.End() }, c.table(isClient).fieldEncoderInstruction)
+	case http3StreamQPACKDecoder:
+		d.FieldStructArrayLoop("instructions", "instruction", func() bool { return !d.End() }, fieldQPACKDecoderInstruction)
+	default:
+		if !d.End() {
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	}
+}
+
+func isHTTP3UnidirectionalStream(id uint64) bool { return id&0x2 != 0 }
+
+func http3Decode(d *decode.D) any {
+	var qsi format.QUIC_Streams_In
+	if !d.ArgAs(&qsi) || len(qsi.Streams) == 0 {
+		d.Fatalf("http3 requires quic streams")
+	}
+
+	// stream bit offsets in decode buffer
+	offsets := make([]int64, len(qsi.Streams))
+	var offset int64
+	hasControl := false
+	for i, s := range qsi.Streams {
+		offsets[i] = offset
+		if isHTTP3UnidirectionalStream(s.ID) && s.Length > 0 && d.BytesRange(offset, 1)[0] == http3StreamControl {
+			hasControl = true
+		}
+		offset += s.Length * 8
+	}
+	if qsi.ALPN != "" && !strings.HasPrefix(qsi.ALPN, "h3") {
+		d.Fatalf("alpn %q is not http3", qsi.ALPN)
+	}
+	if qsi.ALPN == "" && !hasControl {
+		d.Fatalf("no alpn and no control stream found")
+	}
+
+	c := &http3Conn{
+		requests: map[uint64]*http3Request{},
+	}
+
+	fieldStream := func(d *decode.D, i int) {
+		s := qsi.Streams[i]
+		d.FieldStruct("stream", func(d *decode.D) {
+			d.FieldValueUint("stream_id", s.ID)
+			d.FieldValueBool("is_client", s.IsClient)
+			d.FieldValueBool("has_end", s.HasEnd)
+			d.RangeFn(offsets[i], s.Length*8, func(d *decode.D) {
+				if isHTTP3UnidirectionalStream(s.ID) {
+					c.fieldUnidirectionalStream(d, s.IsClient)
+					return
+				}
+				c.fieldFrames(d, s.IsClient, c.message(s.ID, s.IsClient))
+			})
+		})
+	}
+
+	// unidirectional streams first as request streams depend on settings and
+	// dynamic table inserts on encoder streams
+	d.FieldArray("streams", func(d *decode.D) {
+		for i, s := range qsi.Streams {
+			if isHTTP3UnidirectionalStream(s.ID) {
+				fieldStream(d, i)
+			}
+		}
+		for i, s := range qsi.Streams {
+			if !isHTTP3UnidirectionalStream(s.ID) {
+				fieldStream(d, i)
+			}
+		}
+	})
+
+	fieldMessage := func(d *decode.D, name string, m *http3Message) {
+		if !m.hasHeaders && len(m.data) == 0 {
+			return
+		}
+		d.FieldStruct(name, func(d *decode.D) {
+			h := fieldHeaderFields(d, "headers", "header", m.headers)
+			if len(m.trailers) > 0 {
+				fieldHeaderFields(d, "trailers", "trailer", m.trailers)
+			}
+			if len(m.data) > 0 {
+				fieldMessageData(d, h, m.data, &http3GRPCGroup)
+			}
+		})
+	}
+
+	d.FieldArray("requests", func(d *decode.D) {
+		for _, id := range c.order {
+			r := c.requests[id]
+			d.FieldStruct("request", func(d *decode.D) {
+				d.FieldValueUint("stream_id", r.streamID)
+				fieldMessage(d, "request", &r.request)
+				fieldMessage(d, "response", &r.response)
+			})
+		}
+	})
+
+	return nil
+}
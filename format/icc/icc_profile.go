@@ -7,11 +7,11 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.ICC_Profile,
 		&decode.Format{
 			Description: "International Color Consortium profile",
@@ -3,14 +3,14 @@ package id3
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 // TODO: comment 28 long, zero byte, track number
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.ID3v1,
 		&decode.Format{
 			Description: "ID3v1 metadata",
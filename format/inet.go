@@ -273,8 +273,16 @@ var LinkTypeMap = scalar.UintMap{
 }
 
 const (
-	EtherTypeIPv4 = 0x0800
-	EtherTypeIPv6 = 0x86dd
+	EtherTypeIPv4                      = 0x0800
+	EtherTypeARP                       = 0x0806
+	EtherTypeTransparentEthernetBridge = 0x6558
+	EtherTypeRARP                      = 0x8035
+	EtherTypeVLAN                      = 0x8100
+	EtherTypeIPv6                      = 0x86dd
+	EtherTypeMPLSUnicast               = 0x8847
+	EtherTypeMPLSMulticast             = 0x8848
+	EtherTypeServiceVLAN               = 0x88a8
+	EtherTypeLLDP                      = 0x88cc
 )
 
 // from https://en.wikipedia.org/wiki/EtherType
@@ -286,6 +294,7 @@ var EtherTypeMap = scalar.UintMap{
 	0x22f0:        {Sym: "audio", Description: `Audio Video Transport Protocol`},
 	0x22f3:        {Sym: "trill", Description: `IETF TRILL Protocol`},
 	0x22ea:        {Sym: "srp", Description: `Stream Reservation Protocol`},
+	0x6558:        {Sym: "teb", Description: `Transparent Ethernet Bridging`},
 	0x6002:        {Sym: "dec", Description: `DEC MOP RC`},
 	0x6003:        {Sym: "decnet", Description: `DECnet Phase IV, DNA Routing`},
 	0x6004:        {Sym: "declat", Description: `DEC LAT`},
@@ -345,7 +354,9 @@ const (
 	IPv4ProtocolIGMP   = 2
 	IPv4ProtocolTCP    = 6
 	IPv4ProtocolUDP    = 17
+	IPv4ProtocolGRE    = 47
 	IPv4ProtocolICMPv6 = 58
+	IPv4ProtocolMPLS   = 137
 )
 
 var IPv4ProtocolMap = scalar.UintMap{
@@ -395,7 +406,7 @@ var IPv4ProtocolMap = scalar.UintMap{
 	44:                 {Sym: "ipv6-frag", Description: "fragment header for ipv6"},
 	45:                 {Sym: "idrp", Description: "Inter-Domain Routing Protocol"},
 	46:                 {Sym: "rsvp", Description: "Resource ReSerVation Protocol"},
-	47:                 {Sym: "gre", Description: "Generic Routing Encapsulation"},
+	IPv4ProtocolGRE:    {Sym: "gre", Description: "Generic Routing Encapsulation"},
 	48:                 {Sym: "dsr", Description: "Dynamic Source Routing Protocol"},
 	49:                 {Sym: "bna", Description: "BNA"},
 	50:                 {Sym: "esp", Description: "encapsulating security payload"},
@@ -477,7 +488,7 @@ var IPv4ProtocolMap = scalar.UintMap{
 	134:                {Sym: "rsvp-e2e-ignore", Description: "Aggregation of RSVP for IP reservations"},
 	135:                {Sym: "mobility-header", Description: "Mobility Support in IPv6"},
 	136:                {Sym: "udplite", Description: "The UDP-Lite Protocol"},
-	137:                {Sym: "mpls-in-ip", Description: "Encapsulating MPLS in IP"},
+	IPv4ProtocolMPLS:   {Sym: "mpls-in-ip", Description: "Encapsulating MPLS in IP"},
 	138:                {Sym: "manet", Description: "MANET Protocols (RFC5498)"},
 	139:                {Sym: "hip", Description: "Host Identity Protocol (RFC5201)"},
 	140:                {Sym: "shim6", Description: "Shim6 Protocol (RFC5533)"},
@@ -492,8 +503,15 @@ var IPv4ProtocolMap = scalar.UintMap{
 // current truncated to < 1024
 
 const (
-	UDPPortDomain = 53
-	UDPPortMDNS   = 5353
+	UDPPortDomain       = 53
+	UDPPortBOOTPS       = 67
+	UDPPortBOOTPC       = 68
+	UDPPortNTP          = 123
+	UDPPortDHCPv6Client = 546
+	UDPPortDHCPv6Server = 547
+	UDPPortVXLAN        = 4789
+	UDPPortMDNS         = 5353
+	UDPPortGENEVE       = 6081
 )
 
 var UDPPortMap = scalar.UintMap{
@@ -1176,7 +1194,9 @@ var UDPPortMap = scalar.UintMap{
 	1000:          {Sym: "cadlock2"},
 	1010:          {Sym: "surf", Description: "surf"},
 
-	UDPPortMDNS: {Sym: "mdns", Description: "Multicast DNS"},
+	UDPPortVXLAN:  {Sym: "vxlan", Description: "Virtual eXtensible Local Area Network"},
+	UDPPortMDNS:   {Sym: "mdns", Description: "Multicast DNS"},
+	UDPPortGENEVE: {Sym: "geneve", Description: "Generic Network Virtualization Encapsulation"},
 }
 
 const (
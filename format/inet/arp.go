@@ -0,0 +1,85 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc826
+// https://www.iana.org/assignments/arp-parameters/arp-parameters.xhtml
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	registry.RegisterFormat(
+		format.ARP,
+		&decode.Format{
+			Description: "Address Resolution Protocol",
+			Groups:      []*decode.Group{format.INET_Packet},
+			DecodeFn:    decodeARP,
+		})
+}
+
+const (
+	arpHardwareTypeEthernet = 1
+)
+
+var arpHardwareTypeMap = scalar.UintMap{
+	arpHardwareTypeEthernet: {Sym: "ethernet", Description: "Ethernet (10Mb)"},
+	6:                       {Sym: "ieee802", Description: "IEEE 802 Networks"},
+	15:                      {Sym: "frame_relay", Description: "Frame Relay"},
+	16:                      {Sym: "atm", Description: "Asynchronous Transmission Mode (ATM)"},
+	18:                      {Sym: "fibre_channel", Description: "Fibre Channel"},
+	20:                      {Sym: "serial", Description: "Serial Line"},
+	32:                      {Sym: "infiniband", Description: "InfiniBand"},
+}
+
+var arpOperationMap = scalar.UintMapSymStr{
+	1: "request",
+	2: "reply",
+	3: "reverse_request",
+	4: "reverse_reply",
+	8: "inverse_request",
+	9: "inverse_reply",
+}
+
+// fieldHardwareAddress adds address with symbolic value for known lengths
+func fieldHardwareAddress(d *decode.D, name string, length int) {
+	switch length {
+	case 6:
+		d.FieldU48(name, mapUToEtherSym, scalar.UintHex)
+	default:
+		d.FieldRawLen(name, int64(length)*8)
+	}
+}
+
+// fieldProtocolAddress adds address with symbolic value for ipv4 and ipv6 lengths
+func fieldProtocolAddress(d *decode.D, name string, length int) {
+	switch length {
+	case 4:
+		d.FieldU32(name, mapUToIPv4Sym, scalar.UintHex)
+	case 16:
+		d.FieldRawLen(name, 128, mapUToIPv6Sym)
+	default:
+		d.FieldRawLen(name, int64(length)*8)
+	}
+}
+
+func decodeARP(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	if d.ArgAs(&ipi) && ipi.EtherType != format.EtherTypeARP && ipi.EtherType != format.EtherTypeRARP {
+		d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+	}
+
+	d.FieldU16("hardware_type", arpHardwareTypeMap)
+	d.FieldU16("protocol_type", format.EtherTypeMap, scalar.UintHex)
+	hardwareLen := int(d.FieldU8("hardware_length"))
+	protocolLen := int(d.FieldU8("protocol_length"))
+	d.FieldU16("operation", arpOperationMap)
+	fieldHardwareAddress(d, "sender_hardware_address", hardwareLen)
+	fieldProtocolAddress(d, "sender_protocol_address", protocolLen)
+	fieldHardwareAddress(d, "target_hardware_address", hardwareLen)
+	fieldProtocolAddress(d, "target_protocol_address", protocolLen)
+
+	return nil
+}
@@ -5,14 +5,14 @@ package inet
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var bsdLoopbackFrameInetPacketGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.BSD_Loopback_Frame, &decode.Format{
 			Description: "BSD loopback frame",
 			Groups:      []*decode.Group{format.Link_Frame},
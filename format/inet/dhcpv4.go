@@ -0,0 +1,283 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc2131
+// https://www.rfc-editor.org/rfc/rfc2132 options
+// https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	registry.RegisterFormat(
+		format.DHCPv4,
+		&decode.Format{
+			Description: "Dynamic Host Configuration Protocol",
+			Groups:      []*decode.Group{format.UDP_Payload},
+			DecodeFn:    decodeDHCPv4,
+		})
+}
+
+const dhcpv4MagicCookie = 0x63825363
+
+var dhcpv4OpMap = scalar.UintMapSymStr{
+	1: "request",
+	2: "reply",
+}
+
+const (
+	dhcpv4OptionPad                   = 0
+	dhcpv4OptionSubnetMask            = 1
+	dhcpv4OptionTimeOffset            = 2
+	dhcpv4OptionRouter                = 3
+	dhcpv4OptionTimeServer            = 4
+	dhcpv4OptionNameServer            = 5
+	dhcpv4OptionDomainNameServer      = 6
+	dhcpv4OptionLogServer             = 7
+	dhcpv4OptionHostName              = 12
+	dhcpv4OptionDomainName            = 15
+	dhcpv4OptionInterfaceMTU          = 26
+	dhcpv4OptionBroadcastAddress      = 28
+	dhcpv4OptionNTPServers            = 42
+	dhcpv4OptionNetBIOSNameServer     = 44
+	dhcpv4OptionRequestedIPAddress    = 50
+	dhcpv4OptionLeaseTime             = 51
+	dhcpv4OptionOverload              = 52
+	dhcpv4OptionMessageType           = 53
+	dhcpv4OptionServerIdentifier      = 54
+	dhcpv4OptionParameterRequestList  = 55
+	dhcpv4OptionMessage               = 56
+	dhcpv4OptionMaximumMessageSize    = 57
+	dhcpv4OptionRenewalTime           = 58
+	dhcpv4OptionRebindingTime         = 59
+	dhcpv4OptionVendorClassIdentifier = 60
+	dhcpv4OptionClientIdentifier      = 61
+	dhcpv4OptionTFTPServerName        = 66
+	dhcpv4OptionBootfileName          = 67
+	dhcpv4OptionUserClass             = 77
+	dhcpv4OptionClientFQDN            = 81
+	dhcpv4OptionRelayAgentInformation = 82
+	dhcpv4OptionDomainSearch          = 119
+	dhcpv4OptionClasslessStaticRoute  = 121
+	dhcpv4OptionEnd                   = 255
+)
+
+var dhcpv4OptionMap = scalar.UintMap{
+	dhcpv4OptionPad:                   {Sym: "pad", Description: "Padding"},
+	dhcpv4OptionSubnetMask:            {Sym: "subnet_mask", Description: "Subnet mask"},
+	dhcpv4OptionTimeOffset:            {Sym: "time_offset", Description: "Time offset in seconds from UTC"},
+	dhcpv4OptionRouter:                {Sym: "router", Description: "Routers"},
+	dhcpv4OptionTimeServer:            {Sym: "time_server", Description: "Time servers"},
+	dhcpv4OptionNameServer:            {Sym: "name_server", Description: "IEN 116 name servers"},
+	dhcpv4OptionDomainNameServer:      {Sym: "domain_name_server", Description: "DNS servers"},
+	dhcpv4OptionLogServer:             {Sym: "log_server", Description: "Log servers"},
+	dhcpv4OptionHostName:              {Sym: "host_name", Description: "Host name"},
+	dhcpv4OptionDomainName:            {Sym: "domain_name", Description: "Domain name"},
+	dhcpv4OptionInterfaceMTU:          {Sym: "interface_mtu", Description: "Interface MTU"},
+	dhcpv4OptionBroadcastAddress:      {Sym: "broadcast_address", Description: "Broadcast address"},
+	dhcpv4OptionNTPServers:            {Sym: "ntp_servers", Description: "NTP servers"},
+	dhcpv4OptionNetBIOSNameServer:     {Sym: "netbios_name_server", Description: "NetBIOS name servers"},
+	dhcpv4OptionRequestedIPAddress:    {Sym: "requested_ip_address", Description: "Requested IP address"},
+	dhcpv4OptionLeaseTime:             {Sym: "lease_time", Description: "IP address lease time"},
+	dhcpv4OptionOverload:              {Sym: "overload", Description: "Overload sname or file"},
+	dhcpv4OptionMessageType:           {Sym: "message_type", Description: "DHCP message type"},
+	dhcpv4OptionServerIdentifier:      {Sym: "server_identifier", Description: "DHCP server identification"},
+	dhcpv4OptionParameterRequestList:  {Sym: "parameter_request_list", Description: "Parameter request list"},
+	dhcpv4OptionMessage:               {Sym: "message", Description: "DHCP error message"},
+	dhcpv4OptionMaximumMessageSize:    {Sym: "maximum_message_size", Description: "DHCP maximum message size"},
+	dhcpv4OptionRenewalTime:           {Sym: "renewal_time", Description: "DHCP renewal (T1) time"},
+	dhcpv4OptionRebindingTime:         {Sym: "rebinding_time", Description: "DHCP rebinding (T2) time"},
+	dhcpv4OptionVendorClassIdentifier: {Sym: "vendor_class_identifier", Description: "Class identifier"},
+	dhcpv4OptionClientIdentifier:      {Sym: "client_identifier", Description: "Client identifier"},
+	dhcpv4OptionTFTPServerName:        {Sym: "tftp_server_name", Description: "TFTP server name"},
+	dhcpv4OptionBootfileName:          {Sym: "bootfile_name", Description: "Boot file name"},
+	dhcpv4OptionUserClass:             {Sym: "user_class", Description: "User class information"},
+	dhcpv4OptionClientFQDN:            {Sym: "client_fqdn", Description: "Fully qualified domain name"},
+	dhcpv4OptionRelayAgentInformation: {Sym: "relay_agent_information", Description: "Relay agent information"},
+	dhcpv4OptionDomainSearch:          {Sym: "domain_search", Description: "DNS domain search list"},
+	dhcpv4OptionClasslessStaticRoute:  {Sym: "classless_static_route", Description: "Classless static route"},
+	dhcpv4OptionEnd:                   {Sym: "end", Description: "End of options"},
+}
+
+var dhcpv4MessageTypeMap = scalar.UintMapSymStr{
+	1:  "discover",
+	2:  "offer",
+	3:  "request",
+	4:  "decline",
+	5:  "ack",
+	6:  "nak",
+	7:  "release",
+	8:  "inform",
+	9:  "force_renew",
+	10: "lease_query",
+	11: "lease_unassigned",
+	12: "lease_unknown",
+	13: "lease_active",
+}
+
+var dhcpv4RelayAgentSubOptionMap = scalar.UintMapSymStr{
+	1: "circuit_id",
+	2: "remote_id",
+	5: "link_selection",
+	9: "vendor_specific",
+}
+
+var dhcpv4OverloadMap = scalar.UintMapSymStr{
+	1: "file",
+	2: "sname",
+	3: "both",
+}
+
+func fieldDHCPv4Addresses(d *decode.D) {
+	d.FieldArray("addresses", func(d *decode.D) {
+		for !d.End() {
+			d.FieldU32("address", mapUToIPv4Sym, scalar.UintHex)
+		}
+	})
+}
+
+func decodeDHCPv4Option(d *decode.D, code uint64, length int) {
+	switch code {
+	case dhcpv4OptionSubnetMask,
+		dhcpv4OptionBroadcastAddress,
+		dhcpv4OptionRequestedIPAddress,
+		dhcpv4OptionServerIdentifier:
+		d.FieldU32("address", mapUToIPv4Sym, scalar.UintHex)
+	case dhcpv4OptionRouter,
+		dhcpv4OptionTimeServer,
+		dhcpv4OptionNameServer,
+		dhcpv4OptionDomainNameServer,
+		dhcpv4OptionLogServer,
+		dhcpv4OptionNTPServers,
+		dhcpv4OptionNetBIOSNameServer:
+		fieldDHCPv4Addresses(d)
+	case dhcpv4OptionTimeOffset:
+		d.FieldS32("offset")
+	case dhcpv4OptionLeaseTime,
+		dhcpv4OptionRenewalTime,
+		dhcpv4OptionRebindingTime:
+		d.FieldU32("seconds")
+	case dhcpv4OptionInterfaceMTU,
+		dhcpv4OptionMaximumMessageSize:
+		d.FieldU16("size")
+	case dhcpv4OptionOverload:
+		d.FieldU8("overload", dhcpv4OverloadMap)
+	case dhcpv4OptionMessageType:
+		d.FieldU8("message_type", dhcpv4MessageTypeMap)
+	case dhcpv4OptionHostName,
+		dhcpv4OptionDomainName,
+		dhcpv4OptionMessage,
+		dhcpv4OptionVendorClassIdentifier,
+		dhcpv4OptionTFTPServerName,
+		dhcpv4OptionBootfileName:
+		d.FieldUTF8NullFixedLen("value", length)
+	case dhcpv4OptionParameterRequestList:
+		d.FieldArray("parameters", func(d *decode.D) {
+			for !d.End() {
+				d.FieldU8("parameter", dhcpv4OptionMap)
+			}
+		})
+	case dhcpv4OptionClientIdentifier:
+		typ := d.FieldU8("type", arpHardwareTypeMap)
+		if typ == arpHardwareTypeEthernet && d.BitsLeft() == 48 {
+			d.FieldU48("hardware_address", mapUToEtherSym, scalar.UintHex)
+		} else {
+			d.FieldRawLen("identifier", d.BitsLeft())
+		}
+	case dhcpv4OptionClientFQDN:
+		d.FieldStruct("flags", func(d *decode.D) {
+			d.FieldU4("unused0")
+			d.FieldBool("no_server_update")
+			d.FieldBool("encoded")
+			d.FieldBool("server_override")
+			d.FieldBool("server_update")
+		})
+		d.FieldU8("rcode1")
+		d.FieldU8("rcode2")
+		d.FieldUTF8("domain_name", int(d.BitsLeft()/8))
+	case dhcpv4OptionRelayAgentInformation:
+		d.FieldArray("sub_options", func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("sub_option", func(d *decode.D) {
+					d.FieldU8("code", dhcpv4RelayAgentSubOptionMap)
+					subLength := d.FieldU8("length")
+					d.FieldRawLen("data", int64(subLength)*8)
+				})
+			}
+		})
+	case dhcpv4OptionClasslessStaticRoute:
+		d.FieldArray("routes", func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("route", func(d *decode.D) {
+					prefixLen := d.FieldU8("prefix_length", d.UintValidateRange(0, 32))
+					// only significant octets of destination are present
+					d.FieldRawLen("destination", int64((prefixLen+7)/8)*8)
+					d.FieldU32("router", mapUToIPv4Sym, scalar.UintHex)
+				})
+			}
+		})
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func decodeDHCPv4(d *decode.D) any {
+	var upi format.UDP_Payload_In
+	if d.ArgAs(&upi) {
+		upi.MustIsPort(d.Fatalf, format.UDPPortBOOTPS, format.UDPPortBOOTPC)
+	}
+
+	d.FieldU8("op", dhcpv4OpMap)
+	hardwareType := d.FieldU8("hardware_type", arpHardwareTypeMap)
+	hardwareLen := d.FieldU8("hardware_length")
+	d.FieldU8("hops")
+	d.FieldU32("transaction_id", scalar.UintHex)
+	d.FieldU16("seconds")
+	d.FieldStruct("flags", func(d *decode.D) {
+		d.FieldBool("broadcast")
+		d.FieldU15("unused0")
+	})
+	d.FieldU32("client_ip", mapUToIPv4Sym, scalar.UintHex)
+	d.FieldU32("your_ip", mapUToIPv4Sym, scalar.UintHex)
+	d.FieldU32("server_ip", mapUToIPv4Sym, scalar.UintHex)
+	d.FieldU32("gateway_ip", mapUToIPv4Sym, scalar.UintHex)
+	d.FramedFn(16*8, func(d *decode.D) {
+		if hardwareType == arpHardwareTypeEthernet && hardwareLen == 6 {
+			d.FieldU48("client_hardware_address", mapUToEtherSym, scalar.UintHex)
+			d.FieldRawLen("client_hardware_address_padding", d.BitsLeft())
+		} else {
+			d.FieldRawLen("client_hardware_address", d.BitsLeft())
+		}
+	})
+	d.FieldUTF8NullFixedLen("server_name", 64)
+	d.FieldUTF8NullFixedLen("boot_file_name", 128)
+	d.FieldU32("magic_cookie", d.UintAssert(dhcpv4MagicCookie), scalar.UintHex)
+
+	d.FieldArray("options", func(d *decode.D) {
+		seenEnd := false
+		for !seenEnd && !d.End() {
+			d.FieldStruct("option", func(d *decode.D) {
+				code := d.FieldU8("code", dhcpv4OptionMap)
+				switch code {
+				case dhcpv4OptionPad:
+					return
+				case dhcpv4OptionEnd:
+					seenEnd = true
+					return
+				}
+				length := d.FieldU8("length")
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					decodeDHCPv4Option(d, code, int(length))
+				})
+			})
+		}
+	})
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("padding", d.BitsLeft())
+	}
+
+	return nil
+}
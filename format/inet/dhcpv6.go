@@ -0,0 +1,283 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc8415
+// https://www.iana.org/assignments/dhcpv6-parameters/dhcpv6-parameters.xhtml
+
+import (
+	"strings"
+	"time"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	registry.RegisterFormat(
+		format.DHCPv6,
+		&decode.Format{
+			Description: "Dynamic Host Configuration Protocol for IPv6",
+			Groups:      []*decode.Group{format.UDP_Payload},
+			DecodeFn:    decodeDHCPv6,
+		})
+}
+
+const (
+	dhcpv6MessageRelayForward = 12
+	dhcpv6MessageRelayReply   = 13
+)
+
+var dhcpv6MessageTypeMap = scalar.UintMapSymStr{
+	1:                         "solicit",
+	2:                         "advertise",
+	3:                         "request",
+	4:                         "confirm",
+	5:                         "renew",
+	6:                         "rebind",
+	7:                         "reply",
+	8:                         "release",
+	9:                         "decline",
+	10:                        "reconfigure",
+	11:                        "information_request",
+	dhcpv6MessageRelayForward: "relay_forward",
+	dhcpv6MessageRelayReply:   "relay_reply",
+}
+
+const (
+	dhcpv6OptionClientID          = 1
+	dhcpv6OptionServerID          = 2
+	dhcpv6OptionIANA              = 3
+	dhcpv6OptionIATA              = 4
+	dhcpv6OptionIAAddress         = 5
+	dhcpv6OptionOptionRequest     = 6
+	dhcpv6OptionPreference        = 7
+	dhcpv6OptionElapsedTime       = 8
+	dhcpv6OptionRelayMessage      = 9
+	dhcpv6OptionServerUnicast     = 12
+	dhcpv6OptionStatusCode        = 13
+	dhcpv6OptionRapidCommit       = 14
+	dhcpv6OptionUserClass         = 15
+	dhcpv6OptionVendorClass       = 16
+	dhcpv6OptionVendorOptions     = 17
+	dhcpv6OptionInterfaceID       = 18
+	dhcpv6OptionReconfigureAccept = 20
+	dhcpv6OptionDNSServers        = 23
+	dhcpv6OptionDomainList        = 24
+	dhcpv6OptionIAPD              = 25
+	dhcpv6OptionIAPrefix          = 26
+	dhcpv6OptionSNTPServers       = 31
+	dhcpv6OptionRefreshTime       = 32
+	dhcpv6OptionClientFQDN        = 39
+	dhcpv6OptionNTPServer         = 56
+	dhcpv6OptionSolMaxRT          = 82
+)
+
+var dhcpv6OptionMap = scalar.UintMapSymStr{
+	dhcpv6OptionClientID:          "client_id",
+	dhcpv6OptionServerID:          "server_id",
+	dhcpv6OptionIANA:              "ia_na",
+	dhcpv6OptionIATA:              "ia_ta",
+	dhcpv6OptionIAAddress:         "ia_address",
+	dhcpv6OptionOptionRequest:     "option_request",
+	dhcpv6OptionPreference:        "preference",
+	dhcpv6OptionElapsedTime:       "elapsed_time",
+	dhcpv6OptionRelayMessage:      "relay_message",
+	dhcpv6OptionServerUnicast:     "server_unicast",
+	dhcpv6OptionStatusCode:        "status_code",
+	dhcpv6OptionRapidCommit:       "rapid_commit",
+	dhcpv6OptionUserClass:         "user_class",
+	dhcpv6OptionVendorClass:       "vendor_class",
+	dhcpv6OptionVendorOptions:     "vendor_options",
+	dhcpv6OptionInterfaceID:       "interface_id",
+	dhcpv6OptionReconfigureAccept: "reconfigure_accept",
+	dhcpv6OptionDNSServers:        "dns_servers",
+	dhcpv6OptionDomainList:        "domain_list",
+	dhcpv6OptionIAPD:              "ia_pd",
+	dhcpv6OptionIAPrefix:          "ia_prefix",
+	dhcpv6OptionSNTPServers:       "sntp_servers",
+	dhcpv6OptionRefreshTime:       "information_refresh_time",
+	dhcpv6OptionClientFQDN:        "client_fqdn",
+	dhcpv6OptionNTPServer:         "ntp_server",
+	dhcpv6OptionSolMaxRT:          "sol_max_rt",
+}
+
+const (
+	dhcpv6DUIDLinkLayerTime = 1
+	dhcpv6DUIDEnterprise    = 2
+	dhcpv6DUIDLinkLayer     = 3
+	dhcpv6DUIDUUID          = 4
+)
+
+var dhcpv6DUIDTypeMap = scalar.UintMapSymStr{
+	dhcpv6DUIDLinkLayerTime: "link_layer_time",
+	dhcpv6DUIDEnterprise:    "enterprise",
+	dhcpv6DUIDLinkLayer:     "link_layer",
+	dhcpv6DUIDUUID:          "uuid",
+}
+
+var dhcpv6StatusCodeMap = scalar.UintMapSymStr{
+	0: "success",
+	1: "unspec_fail",
+	2: "no_addrs_avail",
+	3: "no_binding",
+	4: "not_on_link",
+	5: "use_multicast",
+	6: "no_prefix_avail",
+}
+
+// DUID time is seconds since 2000-01-01 UTC
+var dhcpv6DUIDEpochDate = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func fieldDHCPv6DUID(d *decode.D) {
+	typ := d.FieldU16("duid_type", dhcpv6DUIDTypeMap)
+	switch typ {
+	case dhcpv6DUIDLinkLayerTime, dhcpv6DUIDLinkLayer:
+		hardwareType := d.FieldU16("hardware_type", arpHardwareTypeMap)
+		if typ == dhcpv6DUIDLinkLayerTime {
+			d.FieldU32("time", scalar.UintActualDate(dhcpv6DUIDEpochDate, time.RFC3339))
+		}
+		if hardwareType == arpHardwareTypeEthernet && d.BitsLeft() == 48 {
+			d.FieldU48("link_layer_address", mapUToEtherSym, scalar.UintHex)
+		} else {
+			d.FieldRawLen("link_layer_address", d.BitsLeft())
+		}
+	case dhcpv6DUIDEnterprise:
+		d.FieldU32("enterprise_number")
+		d.FieldRawLen("identifier", d.BitsLeft())
+	case dhcpv6DUIDUUID:
+		d.FieldRawLen("uuid", d.BitsLeft())
+	default:
+		d.FieldRawLen("identifier", d.BitsLeft())
+	}
+}
+
+// domain names in uncompressed DNS wire format
+func fieldDHCPv6DomainName(d *decode.D, name string) {
+	d.FieldStrFn(name, func(d *decode.D) string {
+		var labels []string
+		for !d.End() {
+			l := d.U8()
+			if l == 0 {
+				break
+			}
+			labels = append(labels, d.UTF8(int(l)))
+		}
+		return strings.Join(labels, ".")
+	})
+}
+
+func fieldDHCPv6Addresses(d *decode.D) {
+	d.FieldArray("addresses", func(d *decode.D) {
+		for !d.End() {
+			d.FieldRawLen("address", 128, mapUToIPv6Sym)
+		}
+	})
+}
+
+func fieldDHCPv6Options(d *decode.D) {
+	d.FieldArray("options", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("option", func(d *decode.D) {
+				code := d.FieldU16("code", dhcpv6OptionMap)
+				length := d.FieldU16("length")
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					decodeDHCPv6Option(d, code)
+				})
+			})
+		}
+	})
+}
+
+func decodeDHCPv6Option(d *decode.D, code uint64) {
+	switch code {
+	case dhcpv6OptionClientID, dhcpv6OptionServerID:
+		fieldDHCPv6DUID(d)
+	case dhcpv6OptionIANA, dhcpv6OptionIAPD:
+		d.FieldU32("iaid", scalar.UintHex)
+		d.FieldU32("t1")
+		d.FieldU32("t2")
+		fieldDHCPv6Options(d)
+	case dhcpv6OptionIATA:
+		d.FieldU32("iaid", scalar.UintHex)
+		fieldDHCPv6Options(d)
+	case dhcpv6OptionIAAddress:
+		d.FieldRawLen("address", 128, mapUToIPv6Sym)
+		d.FieldU32("preferred_lifetime")
+		d.FieldU32("valid_lifetime")
+		fieldDHCPv6Options(d)
+	case dhcpv6OptionIAPrefix:
+		d.FieldU32("preferred_lifetime")
+		d.FieldU32("valid_lifetime")
+		d.FieldU8("prefix_length")
+		d.FieldRawLen("prefix", 128, mapUToIPv6Sym)
+		fieldDHCPv6Options(d)
+	case dhcpv6OptionOptionRequest:
+		d.FieldArray("options", func(d *decode.D) {
+			for !d.End() {
+				d.FieldU16("option", dhcpv6OptionMap)
+			}
+		})
+	case dhcpv6OptionPreference:
+		d.FieldU8("preference")
+	case dhcpv6OptionElapsedTime:
+		// hundredths of a second
+		d.FieldU16("elapsed_time")
+	case dhcpv6OptionRelayMessage:
+		d.FieldStruct("message", decodeDHCPv6Message)
+	case dhcpv6OptionServerUnicast:
+		d.FieldRawLen("address", 128, mapUToIPv6Sym)
+	case dhcpv6OptionStatusCode:
+		d.FieldU16("status_code", dhcpv6StatusCodeMap)
+		d.FieldUTF8("message", int(d.BitsLeft()/8))
+	case dhcpv6OptionRapidCommit, dhcpv6OptionReconfigureAccept:
+		// no data
+	case dhcpv6OptionVendorClass, dhcpv6OptionVendorOptions:
+		d.FieldU32("enterprise_number")
+		d.FieldRawLen("data", d.BitsLeft())
+	case dhcpv6OptionDNSServers, dhcpv6OptionSNTPServers:
+		fieldDHCPv6Addresses(d)
+	case dhcpv6OptionDomainList:
+		d.FieldArray("domains", func(d *decode.D) {
+			for !d.End() {
+				fieldDHCPv6DomainName(d, "domain")
+			}
+		})
+	case dhcpv6OptionRefreshTime, dhcpv6OptionSolMaxRT:
+		d.FieldU32("seconds")
+	case dhcpv6OptionClientFQDN:
+		d.FieldStruct("flags", func(d *decode.D) {
+			d.FieldU5("unused0")
+			d.FieldBool("no_server_update")
+			d.FieldBool("server_override")
+			d.FieldBool("server_update")
+		})
+		fieldDHCPv6DomainName(d, "domain_name")
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func decodeDHCPv6Message(d *decode.D) {
+	msgType := d.FieldU8("message_type", dhcpv6MessageTypeMap)
+	switch msgType {
+	case dhcpv6MessageRelayForward, dhcpv6MessageRelayReply:
+		d.FieldU8("hop_count")
+		d.FieldRawLen("link_address", 128, mapUToIPv6Sym)
+		d.FieldRawLen("peer_address", 128, mapUToIPv6Sym)
+	default:
+		d.FieldU24("transaction_id", scalar.UintHex)
+	}
+	fieldDHCPv6Options(d)
+}
+
+func decodeDHCPv6(d *decode.D) any {
+	var upi format.UDP_Payload_In
+	if d.ArgAs(&upi) {
+		upi.MustIsPort(d.Fatalf, format.UDPPortDHCPv6Client, format.UDPPortDHCPv6Server)
+	}
+
+	decodeDHCPv6Message(d)
+
+	return nil
+}
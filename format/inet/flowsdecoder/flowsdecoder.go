@@ -7,9 +7,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/gopacket/gopacket"
-	"github.com/gopacket/gopacket/ip4defrag"
 	"github.com/gopacket/gopacket/layers"
 	"github.com/gopacket/gopacket/reassembly"
 )
@@ -91,10 +91,36 @@ func (t *TCPConnection) ReassemblyComplete(ac reassembly.AssemblerContext) bool
 	return false
 }
 
-type IPV4Reassembled struct {
-	SourceIP      net.IP
-	DestinationIP net.IP
-	Datagram      []byte
+// CaptureInfo is information about a captured packet from the capture format
+type CaptureInfo struct {
+	Index     int // packet index in capture
+	Timestamp time.Time
+}
+
+type UDPEndpoint struct {
+	IP   net.IP
+	Port int
+}
+
+type UDPDatagram struct {
+	IsClient    bool
+	CaptureInfo CaptureInfo
+	Payload     []byte
+}
+
+// UDPFlow is datagrams with the same addresses and ports in both directions,
+// client is the source of the first datagram
+type UDPFlow struct {
+	Client    UDPEndpoint
+	Server    UDPEndpoint
+	Datagrams []UDPDatagram
+}
+
+type udpFlowKey struct {
+	srcIP   string
+	srcPort int
+	dstIP   string
+	dstPort int
 }
 
 func (fd *Decoder) New(net, transport gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
@@ -149,9 +175,14 @@ type Decoder struct {
 	Options DecoderOptions
 
 	TCPConnections  []*TCPConnection
-	IPV4Reassembled []IPV4Reassembled
+	UDPFlows        []*UDPFlow
+	IPV4Reassembled []IPReassembled
+	IPV6Reassembled []IPReassembled
 
-	ipv4Defrag   *ip4defrag.IPv4Defragmenter
+	udpFlows map[udpFlowKey]*UDPFlow
+
+	ipv4Defrag   *ipDefragmenter
+	ipv6Defrag   *ipDefragmenter
 	tcpAssembler *reassembly.Assembler
 }
 
@@ -161,105 +192,161 @@ type DecoderOptions struct {
 
 func New(options DecoderOptions) *Decoder {
 	flowDecoder := &Decoder{
-		Options: options,
+		Options:  options,
+		udpFlows: map[udpFlowKey]*UDPFlow{},
 	}
 	streamPool := reassembly.NewStreamPool(flowDecoder)
 	tcpAssembler := reassembly.NewAssembler(streamPool)
 	flowDecoder.tcpAssembler = tcpAssembler
-	flowDecoder.ipv4Defrag = ip4defrag.NewIPv4Defragmenter()
+	flowDecoder.ipv4Defrag = newIPDefragmenter(func(r IPReassembled) {
+		flowDecoder.IPV4Reassembled = append(flowDecoder.IPV4Reassembled, r)
+	})
+	flowDecoder.ipv6Defrag = newIPDefragmenter(func(r IPReassembled) {
+		flowDecoder.IPV6Reassembled = append(flowDecoder.IPV6Reassembled, r)
+	})
 
 	return flowDecoder
 }
 
-func (fd *Decoder) EthernetFrame(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeEthernet, gopacket.Lazy))
+func (fd *Decoder) EthernetFrame(bs []byte, ci CaptureInfo) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeEthernet, gopacket.Lazy), ci)
 }
 
-func (fd *Decoder) IPv4Packet(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv4, gopacket.Lazy))
+func (fd *Decoder) IPv4Packet(bs []byte, ci CaptureInfo) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv4, gopacket.Lazy), ci)
 }
 
-func (fd *Decoder) IPv6Packet(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv6, gopacket.Lazy))
+func (fd *Decoder) IPv6Packet(bs []byte, ci CaptureInfo) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv6, gopacket.Lazy), ci)
 }
 
-func (fd *Decoder) SLLPacket(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL, gopacket.Lazy))
+func (fd *Decoder) SLLPacket(bs []byte, ci CaptureInfo) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL, gopacket.Lazy), ci)
 }
 
-func (fd *Decoder) SLL2Packet(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL2, gopacket.Lazy))
+func (fd *Decoder) SLL2Packet(bs []byte, ci CaptureInfo) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL2, gopacket.Lazy), ci)
 }
 
-func (fd *Decoder) LoopbackFrame(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLoopback, gopacket.Lazy))
+func (fd *Decoder) LoopbackFrame(bs []byte, ci CaptureInfo) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLoopback, gopacket.Lazy), ci)
 }
 
 // LinkTypeRAW IPv4 or Ipv6
-func (fd *Decoder) RAWIPFrame(bs []byte) error {
+func (fd *Decoder) RAWIPFrame(bs []byte, ci CaptureInfo) error {
 	version := bs[0] >> 4
 	switch version {
 	case 4:
-		return fd.IPv4Packet(bs)
+		return fd.IPv4Packet(bs, ci)
 	case 6:
-		return fd.IPv6Packet(bs)
+		return fd.IPv6Packet(bs, ci)
 	}
 	return fmt.Errorf("invalid ip version %v", version)
 }
 
-func (fd *Decoder) packet(p gopacket.Packet) error {
+func (fd *Decoder) packet(p gopacket.Packet, ci CaptureInfo) error {
 	// TODO: linkType
-	ip4Layer := p.Layer(layers.LayerTypeIPv4)
-	if ip4Layer != nil {
-		ip4, _ := ip4Layer.(*layers.IPv4)
-		l := ip4.Length
-		newIPv4, err := fd.ipv4Defrag.DefragIPv4(ip4)
-		if err != nil {
+	// use innermost ip layer for tunneled packets (vlan, mpls, gre, vxlan, geneve etc),
+	// a fragment is always the last ip layer as decoding stops at fragment payload
+	var ip4 *layers.IPv4
+	var ip6 *layers.IPv6
+	var frag *layers.IPv6Fragment
+	for _, l := range p.Layers() {
+		switch l := l.(type) {
+		case *layers.IPv4:
+			ip4, ip6, frag = l, nil, nil
+		case *layers.IPv6:
+			ip4, ip6, frag = nil, l, nil
+		case *layers.IPv6Fragment:
+			frag = l
+		}
+	}
+
+	var payload []byte
+	var nextLayer gopacket.LayerType
+	if ip4 != nil && (ip4.Flags&layers.IPv4MoreFragments != 0 || ip4.FragOffset != 0) {
+		var ok bool
+		var err error
+		if payload, ok, err = fd.ipv4Defrag.IPv4(ip4, ci); err != nil {
 			return err
-		} else if newIPv4 != nil {
-			// TODO: correct way to detect finished reassemble?
-			if newIPv4.Length != l {
-				// TODO: better way to reconstruct package?
-				sb := gopacket.NewSerializeBuffer()
-				b, _ := sb.PrependBytes(len(newIPv4.Payload))
-				copy(b, newIPv4.Payload)
-				if err := newIPv4.SerializeTo(sb, gopacket.SerializeOptions{
-					FixLengths:       true,
-					ComputeChecksums: true,
-				}); err != nil {
-					return err
-				}
-
-				fd.IPV4Reassembled = append(fd.IPV4Reassembled, IPV4Reassembled{
-					SourceIP:      ip4.SrcIP,
-					DestinationIP: ip4.DstIP,
-					Datagram:      sb.Bytes(),
-				})
-
-				// i think this replaces p with the newly defragmented ip packet and is
-				// used below when reassembling tcp streams
-				// see gopacket reassemblydump example
-				pb, ok := p.(gopacket.PacketBuilder)
-				if !ok {
-					panic("not a PacketBuilder")
-				}
-				nextDecoder := newIPv4.NextLayerType()
-				if err := nextDecoder.Decode(newIPv4.Payload, pb); err != nil {
-					return err
-				}
+		} else if ok {
+			nextLayer = ip4.Protocol.LayerType()
+		}
+	} else if ip6 != nil && frag != nil {
+		var ok bool
+		var err error
+		if payload, ok, err = fd.ipv6Defrag.IPv6(ip6, frag, ci); err != nil {
+			return err
+		} else if ok {
+			nextLayer = frag.NextHeader.LayerType()
+		}
+	}
+	if payload != nil {
+		// decode reassembled payload as layers of p, used below when reassembling
+		// tcp streams and udp flows, see gopacket reassemblydump example
+		pb, ok := p.(gopacket.PacketBuilder)
+		if !ok {
+			panic("not a PacketBuilder")
+		}
+		if err := nextLayer.Decode(payload, pb); err != nil {
+			return err
+		}
+	}
+
+	// innermost transport layer and the network layer it belongs to
+	var network gopacket.NetworkLayer
+	var transportNetwork gopacket.NetworkLayer
+	var transport gopacket.Layer
+	for _, l := range p.Layers() {
+		switch l := l.(type) {
+		case gopacket.NetworkLayer:
+			network = l
+		case *layers.TCP, *layers.UDP:
+			if network != nil {
+				transportNetwork = network
+				transport = l
 			}
 		}
 	}
 
-	tcp := p.Layer(layers.LayerTypeTCP)
-	if tcp != nil {
-		tcp, _ := tcp.(*layers.TCP)
-		fd.tcpAssembler.Assemble(p.NetworkLayer().NetworkFlow(), tcp)
+	switch transport := transport.(type) {
+	case *layers.TCP:
+		fd.tcpAssembler.Assemble(transportNetwork.NetworkFlow(), transport)
+	case *layers.UDP:
+		fd.udpDatagram(transportNetwork.NetworkFlow(), transport, ci)
 	}
 
 	return nil
 }
 
+func (fd *Decoder) udpDatagram(net gopacket.Flow, udp *layers.UDP, ci CaptureInfo) {
+	src := UDPEndpoint{IP: append([]byte(nil), net.Src().Raw()...), Port: int(udp.SrcPort)}
+	dst := UDPEndpoint{IP: append([]byte(nil), net.Dst().Raw()...), Port: int(udp.DstPort)}
+	key := udpFlowKey{srcIP: string(src.IP), srcPort: src.Port, dstIP: string(dst.IP), dstPort: dst.Port}
+	reverseKey := udpFlowKey{srcIP: key.dstIP, srcPort: key.dstPort, dstIP: key.srcIP, dstPort: key.srcPort}
+
+	isClient := true
+	flow, ok := fd.udpFlows[key]
+	if !ok {
+		if flow, ok = fd.udpFlows[reverseKey]; ok {
+			isClient = false
+		}
+	}
+	if !ok {
+		flow = &UDPFlow{Client: src, Server: dst}
+		fd.udpFlows[key] = flow
+		fd.UDPFlows = append(fd.UDPFlows, flow)
+	}
+
+	flow.Datagrams = append(flow.Datagrams, UDPDatagram{
+		IsClient:    isClient,
+		CaptureInfo: ci,
+		Payload:     append([]byte(nil), udp.Payload...),
+	})
+}
+
 func (fd *Decoder) Flush() {
 	fd.tcpAssembler.FlushAll()
+	fd.ipv4Defrag.Flush()
+	fd.ipv6Defrag.Flush()
 }
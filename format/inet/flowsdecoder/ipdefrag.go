@@ -0,0 +1,269 @@
+package flowsdecoder
+
+// IPv4 and IPv6 fragment reassembly that keeps track of which packets a
+// datagram was reassembled from and anomalies seen while reassembling
+// https://www.rfc-editor.org/rfc/rfc791
+// https://www.rfc-editor.org/rfc/rfc8200#section-4.5
+// https://www.rfc-editor.org/rfc/rfc5722 overlapping fragments
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/wader/fq/internal/mathex"
+)
+
+// fragments not completed within timeout after first fragment are dropped.
+// RFC 791 suggests at least 15 seconds for IPv4, 30 seconds is what Linux uses
+// (ipfrag_time). RFC 8200 says 60 seconds for IPv6
+const (
+	fragmentTimeoutIPv4 = 30 * time.Second
+	fragmentTimeoutIPv6 = 60 * time.Second
+)
+
+// max IP payload length
+const fragmentMaxLen = 65535
+
+const (
+	AnomalyOverlap         = "overlap"          // fragments overlap with same data
+	AnomalyOverlapConflict = "overlap_conflict" // fragments overlap with different data, first data is used
+	AnomalyTimeout         = "timeout"          // not completed within timeout
+	AnomalyIncomplete      = "incomplete"       // not completed at end of capture
+	AnomalyTooLarge        = "too_large"        // fragments end after max length
+)
+
+// IPReassembled is a datagram reassembled from fragments. Datagram is nil if
+// reassembly did not complete.
+type IPReassembled struct {
+	SourceIP      net.IP
+	DestinationIP net.IP
+	Datagram      []byte
+	PacketIndexes []int
+	Anomalies     []string
+}
+
+type fragmentKey struct {
+	src      string
+	dst      string
+	id       uint32
+	protocol uint8 // only used for ipv4
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+type fragmentList struct {
+	srcIP          net.IP
+	dstIP          net.IP
+	firstTimestamp time.Time
+	fragments      []fragment // in arrival order
+	packetIndexes  []int
+	anomalies      []string
+	totalLen       int // -1 until last fragment is seen
+	// header fields from first fragment, used to build reassembled packet
+	header gopacket.SerializableLayer
+}
+
+func (fl *fragmentList) anomaly(a string) {
+	for _, e := range fl.anomalies {
+		if e == a {
+			return
+		}
+	}
+	fl.anomalies = append(fl.anomalies, a)
+}
+
+func (fl *fragmentList) add(ci CaptureInfo, offset int, more bool, data []byte) {
+	fl.packetIndexes = append(fl.packetIndexes, ci.Index)
+
+	end := offset + len(data)
+	if end > fragmentMaxLen {
+		fl.anomaly(AnomalyTooLarge)
+		return
+	}
+	for _, f := range fl.fragments {
+		start := mathex.Max(offset, f.offset)
+		stop := mathex.Min(end, f.offset+len(f.data))
+		if start >= stop {
+			continue
+		}
+		if bytes.Equal(data[start-offset:stop-offset], f.data[start-f.offset:stop-f.offset]) {
+			fl.anomaly(AnomalyOverlap)
+		} else {
+			fl.anomaly(AnomalyOverlapConflict)
+		}
+	}
+	if !more {
+		fl.totalLen = end
+	}
+	fl.fragments = append(fl.fragments, fragment{offset: offset, data: append([]byte(nil), data...)})
+}
+
+// reassemble returns payload if all fragments has been seen
+func (fl *fragmentList) reassemble() ([]byte, bool) {
+	if fl.totalLen < 0 || fl.header == nil {
+		return nil, false
+	}
+
+	sorted := append([]fragment(nil), fl.fragments...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+	covered := 0
+	for _, f := range sorted {
+		if f.offset > covered {
+			return nil, false
+		}
+		covered = mathex.Max(covered, f.offset+len(f.data))
+	}
+	if covered < fl.totalLen {
+		return nil, false
+	}
+
+	// copy in reverse arrival order so that first seen data is used for overlaps
+	payload := make([]byte, fl.totalLen)
+	for i := len(fl.fragments) - 1; i >= 0; i-- {
+		f := fl.fragments[i]
+		if f.offset < len(payload) {
+			copy(payload[f.offset:], f.data)
+		}
+	}
+
+	return payload, true
+}
+
+func (fl *fragmentList) serialize(payload []byte) ([]byte, error) {
+	sb := gopacket.NewSerializeBuffer()
+	b, err := sb.PrependBytes(len(payload))
+	if err != nil {
+		return nil, err
+	}
+	copy(b, payload)
+	if err := fl.header.SerializeTo(sb, gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}); err != nil {
+		return nil, err
+	}
+	return sb.Bytes(), nil
+}
+
+func (fl *fragmentList) result(datagram []byte) IPReassembled {
+	return IPReassembled{
+		SourceIP:      fl.srcIP,
+		DestinationIP: fl.dstIP,
+		Datagram:      datagram,
+		PacketIndexes: fl.packetIndexes,
+		Anomalies:     fl.anomalies,
+	}
+}
+
+type ipDefragmenter struct {
+	lists map[fragmentKey]*fragmentList
+	order []fragmentKey // to flush incomplete in first seen order
+	done  func(r IPReassembled)
+}
+
+func newIPDefragmenter(done func(r IPReassembled)) *ipDefragmenter {
+	return &ipDefragmenter{
+		lists: map[fragmentKey]*fragmentList{},
+		done:  done,
+	}
+}
+
+func (df *ipDefragmenter) list(key fragmentKey, src net.IP, dst net.IP, timeout time.Duration, ci CaptureInfo) *fragmentList {
+	fl, ok := df.lists[key]
+	if ok && ci.Timestamp.Sub(fl.firstTimestamp) > timeout {
+		fl.anomaly(AnomalyTimeout)
+		df.done(fl.result(nil))
+		df.remove(key)
+		ok = false
+	}
+	if !ok {
+		fl = &fragmentList{
+			srcIP:          append(net.IP(nil), src...),
+			dstIP:          append(net.IP(nil), dst...),
+			firstTimestamp: ci.Timestamp,
+			totalLen:       -1,
+		}
+		df.lists[key] = fl
+		df.order = append(df.order, key)
+	}
+	return fl
+}
+
+func (df *ipDefragmenter) remove(key fragmentKey) {
+	delete(df.lists, key)
+	for i, k := range df.order {
+		if k == key {
+			df.order = append(df.order[:i], df.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// complete returns reassembled packet and payload if key is complete
+func (df *ipDefragmenter) complete(key fragmentKey, fl *fragmentList) ([]byte, bool, error) {
+	payload, ok := fl.reassemble()
+	if !ok {
+		return nil, false, nil
+	}
+	df.remove(key)
+	datagram, err := fl.serialize(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	df.done(fl.result(datagram))
+	return payload, true, nil
+}
+
+// IPv4 adds fragment and returns reassembled payload if complete
+func (df *ipDefragmenter) IPv4(ip4 *layers.IPv4, ci CaptureInfo) ([]byte, bool, error) {
+	key := fragmentKey{src: string(ip4.SrcIP), dst: string(ip4.DstIP), id: uint32(ip4.Id), protocol: uint8(ip4.Protocol)}
+	fl := df.list(key, ip4.SrcIP, ip4.DstIP, fragmentTimeoutIPv4, ci)
+	offset := int(ip4.FragOffset) * 8
+	fl.add(ci, offset, ip4.Flags&layers.IPv4MoreFragments != 0, ip4.Payload)
+	if offset == 0 {
+		h := *ip4
+		h.Flags &^= layers.IPv4MoreFragments
+		h.FragOffset = 0
+		fl.header = &h
+	}
+	return df.complete(key, fl)
+}
+
+// IPv6 adds fragment and returns reassembled payload if complete
+func (df *ipDefragmenter) IPv6(ip6 *layers.IPv6, frag *layers.IPv6Fragment, ci CaptureInfo) ([]byte, bool, error) {
+	key := fragmentKey{src: string(ip6.SrcIP), dst: string(ip6.DstIP), id: frag.Identification}
+	fl := df.list(key, ip6.SrcIP, ip6.DstIP, fragmentTimeoutIPv6, ci)
+	offset := int(frag.FragmentOffset) * 8
+	fl.add(ci, offset, frag.MoreFragments, frag.Payload)
+	if offset == 0 {
+		// TODO: keep unfragmentable extension headers
+		fl.header = &layers.IPv6{
+			Version:      6,
+			TrafficClass: ip6.TrafficClass,
+			FlowLabel:    ip6.FlowLabel,
+			NextHeader:   frag.NextHeader,
+			HopLimit:     ip6.HopLimit,
+			SrcIP:        ip6.SrcIP,
+			DstIP:        ip6.DstIP,
+		}
+	}
+	return df.complete(key, fl)
+}
+
+// Flush reports incomplete datagrams
+func (df *ipDefragmenter) Flush() {
+	for _, key := range df.order {
+		fl := df.lists[key]
+		fl.anomaly(AnomalyIncomplete)
+		df.done(fl.result(nil))
+	}
+	df.lists = map[fragmentKey]*fragmentList{}
+	df.order = nil
+}
@@ -0,0 +1,63 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc8926
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var geneveInetPacketGroup decode.Group
+var geneveLinkFrameGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.GENEVE,
+		&decode.Format{
+			Description: "Generic Network Virtualization Encapsulation",
+			Groups:      []*decode.Group{format.UDP_Payload},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.INET_Packet}, Out: &geneveInetPacketGroup},
+				{Groups: []*decode.Group{format.Link_Frame}, Out: &geneveLinkFrameGroup},
+			},
+			DecodeFn: decodeGENEVE,
+		})
+}
+
+func decodeGENEVE(d *decode.D) any {
+	var upi format.UDP_Payload_In
+	if d.ArgAs(&upi) {
+		upi.MustIsPort(d.Fatalf, format.UDPPortGENEVE)
+	}
+
+	d.FieldU2("version", d.UintAssert(0))
+	// in 4 byte multiples
+	optionsLen := d.FieldU6("options_length")
+	d.FieldBool("control")
+	d.FieldBool("critical")
+	d.FieldU6("reserved0")
+	protocolType := d.FieldU16("protocol_type", format.EtherTypeMap, scalar.UintHex)
+	d.FieldU24("vni")
+	d.FieldU8("reserved1")
+
+	d.FramedFn(int64(optionsLen)*4*8, func(d *decode.D) {
+		d.FieldArray("options", func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("option", func(d *decode.D) {
+					d.FieldU16("class", scalar.UintHex)
+					d.FieldBool("critical")
+					d.FieldU7("type")
+					d.FieldU3("reserved")
+					length := d.FieldU5("length")
+					d.FieldRawLen("data", int64(length)*4*8)
+				})
+			}
+		})
+	})
+
+	fieldTunnelPayload(d, int(protocolType), &geneveInetPacketGroup, &geneveLinkFrameGroup)
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc2784
+// https://www.rfc-editor.org/rfc/rfc2890 key and sequence number
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var greInetPacketGroup decode.Group
+var greLinkFrameGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.GRE,
+		&decode.Format{
+			Description: "Generic Routing Encapsulation",
+			Groups:      []*decode.Group{format.IP_Packet},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.INET_Packet}, Out: &greInetPacketGroup},
+				{Groups: []*decode.Group{format.Link_Frame}, Out: &greLinkFrameGroup},
+			},
+			DecodeFn: decodeGRE,
+		})
+}
+
+func decodeGRE(d *decode.D) any {
+	var ipi format.IP_Packet_In
+	if d.ArgAs(&ipi) && ipi.Protocol != format.IPv4ProtocolGRE {
+		d.Fatalf("incorrect protocol %d", ipi.Protocol)
+	}
+
+	checksumPresent := d.FieldBool("checksum_present")
+	d.FieldBool("routing_present")
+	keyPresent := d.FieldBool("key_present")
+	sequencePresent := d.FieldBool("sequence_number_present")
+	d.FieldU9("reserved0")
+	d.FieldU3("version", d.UintAssert(0))
+	protocolType := d.FieldU16("protocol_type", format.EtherTypeMap, scalar.UintHex)
+	if checksumPresent {
+		d.FieldU16("checksum", scalar.UintHex)
+		d.FieldU16("reserved1")
+	}
+	if keyPresent {
+		d.FieldU32("key", scalar.UintHex)
+	}
+	if sequencePresent {
+		d.FieldU32("sequence_number")
+	}
+
+	fieldTunnelPayload(d, int(protocolType), &greInetPacketGroup, &greLinkFrameGroup)
+
+	return nil
+}
+
+// fieldTunnelPayload decodes payload with ether type as ethernet frame or inet packet
+func fieldTunnelPayload(d *decode.D, etherType int, inetPacketGroup *decode.Group, linkFrameGroup *decode.Group) {
+	if etherType == format.EtherTypeTransparentEthernetBridge {
+		d.FieldFormatOrRawLen(
+			"payload",
+			d.BitsLeft(),
+			linkFrameGroup,
+			format.Link_Frame_In{Type: format.LinkTypeETHERNET},
+		)
+		return
+	}
+	d.FieldFormatOrRawLen(
+		"payload",
+		d.BitsLeft(),
+		inetPacketGroup,
+		format.INET_Packet_In{EtherType: etherType},
+	)
+}
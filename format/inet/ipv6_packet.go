@@ -7,14 +7,14 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/internal/bitioex"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var ipv6IpPacketGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.IPv6Packet,
 		&decode.Format{
 			Description: "Internet protocol v6 packet",
@@ -131,6 +131,8 @@ func decodeIPv6(d *decode.D) any {
 	d.FieldRawLen("source_address", 128, mapUToIPv6Sym)
 	d.FieldRawLen("destination_address", 128, mapUToIPv6Sym)
 
+	var moreFragments bool
+	var fragmentOffset uint64
 	extStart := d.Pos()
 	if isIpv6Option(nextHeader) {
 		// TODO: own format?
@@ -139,6 +141,15 @@ func decodeIPv6(d *decode.D) any {
 				d.FieldStruct("extension", func(d *decode.D) {
 					currentHeader := nextHeader
 					nextHeader = d.FieldU8("next_header", nextHeaderMap)
+					if currentHeader == nextHeaderFragment {
+						// fixed length, https://www.rfc-editor.org/rfc/rfc8200#section-4.5
+						d.FieldU8("reserved")
+						fragmentOffset = d.FieldU13("fragment_offset")
+						d.FieldU2("unused0")
+						moreFragments = d.FieldBool("more_fragments")
+						d.FieldU32("identification")
+						return
+					}
 					extLen := d.FieldU8("length")
 					// whole header not including the first 8 octets
 					extLen += 6
@@ -170,6 +181,10 @@ func decodeIPv6(d *decode.D) any {
 	// TODO: nextHeader 59 skip
 
 	payloadLen := int64(dataLength)*8 - extLen
+	if moreFragments || fragmentOffset > 0 {
+		d.FieldRawLen("payload", payloadLen)
+		return nil
+	}
 	d.FieldFormatOrRawLen(
 		"payload",
 		payloadLen,
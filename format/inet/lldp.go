@@ -0,0 +1,198 @@
+package inet
+
+// IEEE 802.1AB Link Layer Discovery Protocol
+// https://en.wikipedia.org/wiki/Link_Layer_Discovery_Protocol
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	registry.RegisterFormat(
+		format.LLDP,
+		&decode.Format{
+			Description: "Link Layer Discovery Protocol",
+			Groups:      []*decode.Group{format.INET_Packet},
+			DecodeFn:    decodeLLDP,
+		})
+}
+
+const (
+	lldpTLVEnd                = 0
+	lldpTLVChassisID          = 1
+	lldpTLVPortID             = 2
+	lldpTLVTTL                = 3
+	lldpTLVPortDescription    = 4
+	lldpTLVSystemName         = 5
+	lldpTLVSystemDescription  = 6
+	lldpTLVSystemCapabilities = 7
+	lldpTLVManagementAddress  = 8
+	lldpTLVOrganization       = 127
+)
+
+var lldpTLVTypeMap = scalar.UintMapSymStr{
+	lldpTLVEnd:                "end",
+	lldpTLVChassisID:          "chassis_id",
+	lldpTLVPortID:             "port_id",
+	lldpTLVTTL:                "ttl",
+	lldpTLVPortDescription:    "port_description",
+	lldpTLVSystemName:         "system_name",
+	lldpTLVSystemDescription:  "system_description",
+	lldpTLVSystemCapabilities: "system_capabilities",
+	lldpTLVManagementAddress:  "management_address",
+	lldpTLVOrganization:       "organization_specific",
+}
+
+const (
+	lldpChassisIDMACAddress     = 4
+	lldpChassisIDNetworkAddress = 5
+)
+
+var lldpChassisIDSubtypeMap = scalar.UintMapSymStr{
+	1:                           "chassis_component",
+	2:                           "interface_alias",
+	3:                           "port_component",
+	lldpChassisIDMACAddress:     "mac_address",
+	lldpChassisIDNetworkAddress: "network_address",
+	6:                           "interface_name",
+	7:                           "local",
+}
+
+const (
+	lldpPortIDMACAddress     = 3
+	lldpPortIDNetworkAddress = 4
+)
+
+var lldpPortIDSubtypeMap = scalar.UintMapSymStr{
+	1:                        "interface_alias",
+	2:                        "port_component",
+	lldpPortIDMACAddress:     "mac_address",
+	lldpPortIDNetworkAddress: "network_address",
+	5:                        "interface_name",
+	6:                        "agent_circuit_id",
+	7:                        "local",
+}
+
+// https://www.iana.org/assignments/address-family-numbers/address-family-numbers.xhtml
+const (
+	addressFamilyIPv4 = 1
+	addressFamilyIPv6 = 2
+	addressFamily802  = 6
+)
+
+var addressFamilyMap = scalar.UintMapSymStr{
+	addressFamilyIPv4: "ipv4",
+	addressFamilyIPv6: "ipv6",
+	addressFamily802:  "ieee802",
+}
+
+var lldpInterfaceSubtypeMap = scalar.UintMapSymStr{
+	1: "unknown",
+	2: "if_index",
+	3: "system_port_number",
+}
+
+var lldpOrganizationMap = scalar.UintMap{
+	0x0080c2: {Sym: "ieee_802_1", Description: "IEEE 802.1"},
+	0x00120f: {Sym: "ieee_802_3", Description: "IEEE 802.3"},
+	0x0012bb: {Sym: "tia", Description: "TIA TR-41 Committee, Media Endpoint Discovery"},
+}
+
+func fieldLLDPAddress(d *decode.D, family uint64, length int) {
+	switch {
+	case family == addressFamilyIPv4 && length == 4,
+		family == addressFamilyIPv6 && length == 16:
+		fieldProtocolAddress(d, "address", length)
+	case family == addressFamily802 && length == 6:
+		fieldHardwareAddress(d, "address", length)
+	default:
+		d.FieldRawLen("address", int64(length)*8)
+	}
+}
+
+// fieldLLDPID decodes chassis or port id
+func fieldLLDPID(d *decode.D, subtypeMap scalar.UintMapSymStr, macSubtype uint64, networkSubtype uint64) {
+	subtype := d.FieldU8("subtype", subtypeMap)
+	switch subtype {
+	case macSubtype:
+		fieldHardwareAddress(d, "id", int(d.BitsLeft()/8))
+	case networkSubtype:
+		family := d.FieldU8("address_family", addressFamilyMap)
+		fieldLLDPAddress(d, family, int(d.BitsLeft()/8))
+	default:
+		d.FieldUTF8("id", int(d.BitsLeft()/8))
+	}
+}
+
+func fieldLLDPCapabilities(d *decode.D, name string) {
+	d.FieldStruct(name, func(d *decode.D) {
+		d.FieldU5("unused0")
+		d.FieldBool("two_port_mac_relay")
+		d.FieldBool("s_vlan")
+		d.FieldBool("c_vlan")
+		d.FieldBool("station_only")
+		d.FieldBool("docsis_cable_device")
+		d.FieldBool("telephone")
+		d.FieldBool("router")
+		d.FieldBool("wlan_access_point")
+		d.FieldBool("bridge")
+		d.FieldBool("repeater")
+		d.FieldBool("other")
+	})
+}
+
+func decodeLLDP(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	if d.ArgAs(&ipi) && ipi.EtherType != format.EtherTypeLLDP {
+		d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+	}
+
+	d.FieldArray("tlvs", func(d *decode.D) {
+		seenEnd := false
+		for !seenEnd && !d.End() {
+			d.FieldStruct("tlv", func(d *decode.D) {
+				typ := d.FieldU7("type", lldpTLVTypeMap)
+				length := d.FieldU9("length")
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					switch typ {
+					case lldpTLVEnd:
+						seenEnd = true
+					case lldpTLVChassisID:
+						fieldLLDPID(d, lldpChassisIDSubtypeMap, lldpChassisIDMACAddress, lldpChassisIDNetworkAddress)
+					case lldpTLVPortID:
+						fieldLLDPID(d, lldpPortIDSubtypeMap, lldpPortIDMACAddress, lldpPortIDNetworkAddress)
+					case lldpTLVTTL:
+						d.FieldU16("ttl")
+					case lldpTLVPortDescription,
+						lldpTLVSystemName,
+						lldpTLVSystemDescription:
+						d.FieldUTF8("value", int(length))
+					case lldpTLVSystemCapabilities:
+						fieldLLDPCapabilities(d, "capabilities")
+						fieldLLDPCapabilities(d, "enabled_capabilities")
+					case lldpTLVManagementAddress:
+						addressLen := d.FieldU8("address_length")
+						family := d.FieldU8("address_family", addressFamilyMap)
+						// address length includes family
+						fieldLLDPAddress(d, family, int(addressLen)-1)
+						d.FieldU8("interface_subtype", lldpInterfaceSubtypeMap)
+						d.FieldU32("interface_number")
+						oidLen := d.FieldU8("oid_length")
+						d.FieldRawLen("oid", int64(oidLen)*8)
+					case lldpTLVOrganization:
+						d.FieldU24("oui", lldpOrganizationMap, scalar.UintHex)
+						d.FieldU8("subtype")
+						d.FieldRawLen("value", d.BitsLeft())
+					default:
+						d.FieldRawLen("value", d.BitsLeft())
+					}
+				})
+			})
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc3032
+// https://www.rfc-editor.org/rfc/rfc4023 MPLS in IP and GRE
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var mplsInetPacketGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.MPLS,
+		&decode.Format{
+			Description: "Multiprotocol Label Switching label stack",
+			Groups: []*decode.Group{
+				format.INET_Packet,
+				format.IP_Packet,
+			},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.INET_Packet}, Out: &mplsInetPacketGroup},
+			},
+			DecodeFn: decodeMPLS,
+		})
+}
+
+// reserved labels
+// https://www.iana.org/assignments/mpls-label-values/mpls-label-values.xhtml
+var mplsLabelMap = scalar.UintMapSymStr{
+	0:  "ipv4_explicit_null",
+	1:  "router_alert",
+	2:  "ipv6_explicit_null",
+	3:  "implicit_null",
+	7:  "entropy_label_indicator",
+	13: "gal",
+	14: "oam_alert",
+	15: "extension",
+}
+
+func decodeMPLS(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	var ippi format.IP_Packet_In
+	if d.ArgAs(&ipi) && ipi.EtherType != format.EtherTypeMPLSUnicast && ipi.EtherType != format.EtherTypeMPLSMulticast {
+		d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+	} else if d.ArgAs(&ippi) && ippi.Protocol != format.IPv4ProtocolMPLS {
+		d.Fatalf("incorrect protocol %d", ippi.Protocol)
+	}
+
+	d.FieldArray("labels", func(d *decode.D) {
+		bottomOfStack := false
+		for !bottomOfStack {
+			d.FieldStruct("label", func(d *decode.D) {
+				d.FieldU20("label", mplsLabelMap)
+				d.FieldU3("traffic_class")
+				bottomOfStack = d.FieldBool("bottom_of_stack")
+				d.FieldU8("ttl")
+			})
+		}
+	})
+
+	// payload protocol is not known, guess based on ip version like most implementations
+	// TODO: ethernet pseudowire
+	var etherType int
+	if d.BitsLeft() >= 4 {
+		switch d.PeekUintBits(4) {
+		case 4:
+			etherType = format.EtherTypeIPv4
+		case 6:
+			etherType = format.EtherTypeIPv6
+		}
+	}
+	if etherType == 0 {
+		d.FieldRawLen("payload", d.BitsLeft())
+		return nil
+	}
+	d.FieldFormatOrRawLen(
+		"payload",
+		d.BitsLeft(),
+		&mplsInetPacketGroup,
+		format.INET_Packet_In{EtherType: etherType},
+	)
+
+	return nil
+}
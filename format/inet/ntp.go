@@ -0,0 +1,166 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc5905
+// https://www.rfc-editor.org/rfc/rfc7822 extension fields
+// https://www.rfc-editor.org/rfc/rfc9327 control messages
+
+import (
+	"time"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	registry.RegisterFormat(
+		format.NTP,
+		&decode.Format{
+			Description: "Network Time Protocol",
+			Groups:      []*decode.Group{format.UDP_Payload},
+			DecodeFn:    decodeNTP,
+		})
+}
+
+var ntpLeapIndicatorMap = scalar.UintMapSymStr{
+	0: "no_warning",
+	1: "last_minute_61_seconds",
+	2: "last_minute_59_seconds",
+	3: "unsynchronized",
+}
+
+const (
+	ntpModeControl = 6
+	ntpModePrivate = 7
+)
+
+var ntpModeMap = scalar.UintMapSymStr{
+	0:              "reserved",
+	1:              "symmetric_active",
+	2:              "symmetric_passive",
+	3:              "client",
+	4:              "server",
+	5:              "broadcast",
+	ntpModeControl: "control",
+	ntpModePrivate: "private",
+}
+
+var ntpStratumMap = scalar.UintMap{
+	0:  {Sym: "unspecified", Description: "Unspecified or invalid, reference id is kiss code"},
+	1:  {Sym: "primary", Description: "Primary server, reference id is clock source"},
+	16: {Sym: "unsynchronized", Description: "Unsynchronized"},
+}
+
+var ntpControlOpcodeMap = scalar.UintMapSymStr{
+	1: "read_status",
+	2: "read_variables",
+	3: "write_variables",
+	4: "read_clock_variables",
+	5: "write_clock_variables",
+	6: "set_trap",
+	7: "async_message",
+	8: "unset_trap",
+}
+
+// NTP era 0 starts at 1900-01-01 UTC
+var ntpEpochDate = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// 32.32 fixed point seconds since ntp epoch
+var ntpTimestampDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	if s.Actual == 0 {
+		return s, nil
+	}
+	seconds := s.Actual >> 32
+	nanos := (s.Actual & 0xffff_ffff) * uint64(time.Second) >> 32
+	s.Description = ntpEpochDate.
+		Add(time.Duration(seconds) * time.Second).
+		Add(time.Duration(nanos)).
+		Format(time.RFC3339Nano)
+	return s, nil
+})
+
+// 16.16 fixed point seconds
+func fieldNTPShort(d *decode.D, name string) {
+	d.FieldFltFn(name, func(d *decode.D) float64 { return float64(d.U32()) / (1 << 16) })
+}
+
+// key identifier and digest, only key identifier for crypto-NAK
+func fieldNTPMAC(d *decode.D) {
+	d.FieldStruct("mac", func(d *decode.D) {
+		d.FieldU32("key_identifier")
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("digest", d.BitsLeft())
+		}
+	})
+}
+
+func decodeNTP(d *decode.D) any {
+	var upi format.UDP_Payload_In
+	if d.ArgAs(&upi) {
+		upi.MustIsPort(d.Fatalf, format.UDPPortNTP)
+	}
+
+	d.FieldU2("leap_indicator", ntpLeapIndicatorMap)
+	d.FieldU3("version", d.UintValidateRange(1, 4))
+	mode := d.FieldU3("mode", ntpModeMap)
+
+	switch mode {
+	case ntpModeControl:
+		d.FieldBool("response")
+		d.FieldBool("error")
+		d.FieldBool("more")
+		d.FieldU5("opcode", ntpControlOpcodeMap)
+		d.FieldU16("sequence")
+		d.FieldU16("status", scalar.UintHex)
+		d.FieldU16("association_id")
+		d.FieldU16("offset")
+		count := d.FieldU16("count")
+		d.FieldUTF8("data", int(count))
+		// data is padded to 32 bit boundary
+		if padding := (4 - count%4) % 4; padding > 0 && d.BitsLeft() >= int64(padding)*8 {
+			d.FieldRawLen("padding", int64(padding)*8)
+		}
+		if d.BitsLeft() > 0 {
+			fieldNTPMAC(d)
+		}
+		return nil
+	case ntpModePrivate:
+		d.FieldRawLen("data", d.BitsLeft())
+		return nil
+	}
+
+	stratum := d.FieldU8("stratum", ntpStratumMap)
+	d.FieldS8("poll")
+	d.FieldS8("precision")
+	fieldNTPShort(d, "root_delay")
+	fieldNTPShort(d, "root_dispersion")
+	if stratum <= 1 {
+		d.FieldUTF8NullFixedLen("reference_id", 4)
+	} else {
+		d.FieldU32("reference_id", mapUToIPv4Sym, scalar.UintHex)
+	}
+	d.FieldU64("reference_timestamp", ntpTimestampDescription, scalar.UintHex)
+	d.FieldU64("origin_timestamp", ntpTimestampDescription, scalar.UintHex)
+	d.FieldU64("receive_timestamp", ntpTimestampDescription, scalar.UintHex)
+	d.FieldU64("transmit_timestamp", ntpTimestampDescription, scalar.UintHex)
+
+	// anything more than the largest MAC (key id and 160 bit digest) is extension fields
+	const maxMACBits = (4 + 20) * 8
+	if d.BitsLeft() > maxMACBits {
+		d.FieldArray("extension_fields", func(d *decode.D) {
+			for d.BitsLeft() > maxMACBits {
+				d.FieldStruct("extension_field", func(d *decode.D) {
+					d.FieldU16("type", scalar.UintHex)
+					length := d.FieldU16("length", d.UintAssertRange(4, 0xffff))
+					d.FieldRawLen("value", int64(length-4)*8)
+				})
+			}
+		})
+	}
+	if d.BitsLeft() > 0 {
+		fieldNTPMAC(d)
+	}
+
+	return nil
+}
@@ -6,14 +6,14 @@ package inet
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var sllPacket2InetPacketGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.SLL2_Packet,
 		&decode.Format{
 			Description: "Linux cooked capture encapsulation v2",
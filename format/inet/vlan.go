@@ -0,0 +1,59 @@
+package inet
+
+// https://en.wikipedia.org/wiki/IEEE_802.1Q
+// https://en.wikipedia.org/wiki/IEEE_802.1ad
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var vlanInetPacketGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.VLAN,
+		&decode.Format{
+			Description: "IEEE 802.1Q/802.1ad VLAN tag",
+			Groups:      []*decode.Group{format.INET_Packet},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.INET_Packet}, Out: &vlanInetPacketGroup},
+			},
+			DecodeFn: decodeVLAN,
+		})
+}
+
+var vlanPriorityNames = scalar.UintMapSymStr{
+	0: "best_effort",
+	1: "background",
+	2: "excellent_effort",
+	3: "critical_applications",
+	4: "video",
+	5: "voice",
+	6: "internetwork_control",
+	7: "network_control",
+}
+
+func decodeVLAN(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	if d.ArgAs(&ipi) && ipi.EtherType != format.EtherTypeVLAN && ipi.EtherType != format.EtherTypeServiceVLAN {
+		d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+	}
+
+	d.FieldU3("priority", vlanPriorityNames)
+	d.FieldBool("drop_eligible")
+	d.FieldU12("vlan_id")
+	etherType := d.FieldU16("ether_type", format.EtherTypeMap, scalar.UintHex)
+
+	// inner ether type can be another tag for 802.1ad (QinQ)
+	d.FieldFormatOrRawLen(
+		"payload",
+		d.BitsLeft(),
+		&vlanInetPacketGroup,
+		format.INET_Packet_In{EtherType: int(etherType)},
+	)
+
+	return nil
+}
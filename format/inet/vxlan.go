@@ -0,0 +1,47 @@
+package inet
+
+// https://www.rfc-editor.org/rfc/rfc7348
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+)
+
+var vxlanLinkFrameGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.VXLAN,
+		&decode.Format{
+			Description: "Virtual eXtensible Local Area Network",
+			Groups:      []*decode.Group{format.UDP_Payload},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Link_Frame}, Out: &vxlanLinkFrameGroup},
+			},
+			DecodeFn: decodeVXLAN,
+		})
+}
+
+func decodeVXLAN(d *decode.D) any {
+	var upi format.UDP_Payload_In
+	if d.ArgAs(&upi) {
+		upi.MustIsPort(d.Fatalf, format.UDPPortVXLAN)
+	}
+
+	d.FieldU4("reserved0")
+	d.FieldBool("vni_present", d.BoolAssert(true))
+	d.FieldU3("reserved1")
+	d.FieldU24("reserved2")
+	d.FieldU24("vni")
+	d.FieldU8("reserved3")
+
+	d.FieldFormatOrRawLen(
+		"payload",
+		d.BitsLeft(),
+		&vxlanLinkFrameGroup,
+		format.Link_Frame_In{Type: format.LinkTypeETHERNET},
+	)
+
+	return nil
+}
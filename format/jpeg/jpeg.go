@@ -10,7 +10,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -18,7 +18,7 @@ var exifFormat decode.Group
 var iccProfileFormat decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.JPEG,
 		&decode.Format{
 			Description: "Joint Photographic Experts Group file",
@@ -3,12 +3,12 @@ package json
 import (
 	"embed"
 
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 //go:embed jq.jq
 var jqFS embed.FS
 
 func init() {
-	interp.RegisterFS(jqFS)
+	registry.RegisterFS(jqFS)
 }
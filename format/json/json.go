@@ -12,6 +12,7 @@ import (
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 	"github.com/wader/gojq"
 )
@@ -20,7 +21,7 @@ import (
 var jsonFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.JSON,
 		&decode.Format{
 			Description: "JavaScript Object Notation",
@@ -29,7 +30,7 @@ func init() {
 			DecodeFn:    decodeJSON,
 			Functions:   []string{"_todisplay"},
 		})
-	interp.RegisterFS(jsonFS)
+	registry.RegisterFS(jsonFS)
 	interp.RegisterFunc1("_to_json", toJSON)
 }
 
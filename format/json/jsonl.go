@@ -1,12 +1,16 @@
 package json
 
 import (
+	"bufio"
 	"bytes"
 	"embed"
+	"errors"
+	"io"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 //go:embed jsonl.jq
@@ -15,16 +19,17 @@ var jsonlFS embed.FS
 // TODO: not strictly JSONL, allows any whitespace between
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.JSONL,
 		&decode.Format{
 			Description: "JavaScript Object Notation Lines",
 			ProbeOrder:  format.ProbeOrderTextFuzzy,
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    decodeJSONL,
+			StreamFn:    streamJSONL,
 			Functions:   []string{"_todisplay"},
 		})
-	interp.RegisterFS(jsonlFS)
+	registry.RegisterFS(jsonlFS)
 	interp.RegisterFunc0("to_jsonl", toJSONL)
 }
 
@@ -32,6 +37,35 @@ func decodeJSONL(d *decode.D) any {
 	return decodeJSONEx(d, true)
 }
 
+// streamJSONL chunks are one non-empty line including line ending
+func streamJSONL(r *bufio.Reader) decode.StreamChunkFn {
+	return func() ([]byte, error) {
+		for {
+			var line []byte
+			for {
+				b, err := r.ReadSlice('\n')
+				line = append(line, b...)
+				if len(line) > decode.StreamMaxChunkSize {
+					return nil, errors.New("line too long")
+				}
+				if errors.Is(err, bufio.ErrBufferFull) {
+					continue
+				}
+				if err != nil {
+					if errors.Is(err, io.EOF) && len(line) > 0 {
+						break
+					}
+					return nil, err
+				}
+				break
+			}
+			if len(bytes.TrimSpace(line)) > 0 {
+				return line, nil
+			}
+		}
+	}
+}
+
 func toJSONL(i *interp.Interp, c []any) any {
 	cj := makeEncoder(ToJSONOpts{})
 	bb := &bytes.Buffer{}
@@ -10,7 +10,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -19,14 +19,14 @@ import (
 var markdownFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Markdown,
 		&decode.Format{
 			Description: "Markdown",
 			DecodeFn:    decodeMarkdown,
 			Functions:   []string{"_todisplay"},
 		})
-	interp.RegisterFS(markdownFS)
+	registry.RegisterFS(markdownFS)
 }
 
 func decodeMarkdown(d *decode.D) any {
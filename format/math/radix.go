@@ -3,12 +3,12 @@ package math
 import (
 	"embed"
 
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 //go:embed radix.jq
 var radixFS embed.FS
 
 func init() {
-	interp.RegisterFS(radixFS)
+	registry.RegisterFS(radixFS)
 }
@@ -19,8 +19,8 @@ import (
 	"github.com/wader/fq/format/matroska/ebml"
 	"github.com/wader/fq/format/matroska/ebml_matroska"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -51,7 +51,7 @@ var vp9FrameGroup decode.Group
 var codecToGroup map[string]*decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Matroska,
 		&decode.Format{
 			Description: "Matroska file",
@@ -82,7 +82,7 @@ func init() {
 				{Groups: []*decode.Group{format.VP9_Frame}, Out: &vp9FrameGroup},
 			},
 		})
-	interp.RegisterFS(matroskaFS)
+	registry.RegisterFS(matroskaFS)
 
 	codecToGroup = map[string]*decode.Group{
 		"A_VORBIS":         &vorbisPacketGroup,
@@ -250,9 +250,10 @@ type block struct {
 }
 
 type decodeContext struct {
-	currentTrack *track
-	tracks       []*track
-	blocks       []block
+	currentTrack  *track
+	tracks        []*track
+	blocks        []block
+	decodeSamples bool
 }
 
 func decodeMaster(d *decode.D, bitsLimit int64, elm *ebml.Master, unknownSize bool, dc *decodeContext) {
@@ -434,7 +435,18 @@ func decodeMaster(d *decode.D, bitsLimit int64, elm *ebml.Master, unknownSize bo
 					}
 
 				case *ebml.Master:
-					decodeMaster(d, int64(tagSize)*8, childElm, unknownSize, dc)
+					if tagID == ebml_matroska.ClusterID && !unknownSize {
+						// clusters are decoded on first access as there can be lots of them.
+						// blocks are decoded after all tracks are known so the cluster uses
+						// its own block list
+						d.FieldLazy("elements", int64(tagSize)*8, func(d *decode.D) {
+							cdc := &decodeContext{decodeSamples: dc.decodeSamples}
+							decodeMaster(d, d.BitsLeft(), childElm, false, cdc)
+							decodeBlocks(dc, cdc.blocks)
+						})
+					} else {
+						decodeMaster(d, int64(tagSize)*8, childElm, unknownSize, dc)
+					}
 				}
 			})
 		}
@@ -449,14 +461,9 @@ func matroskaDecode(d *decode.D) any {
 	if d.PeekUintBits(32) != ebmlHeaderID {
 		d.Errorf("no EBML header found")
 	}
-	dc := &decodeContext{tracks: []*track{}}
+	dc := &decodeContext{tracks: []*track{}, decodeSamples: mi.DecodeSamples}
 	decodeMaster(d, d.BitsLeft(), ebml_matroska.RootElement, false, dc)
 
-	trackNumberToTrack := map[int]*track{}
-	for _, t := range dc.tracks {
-		trackNumberToTrack[t.number] = t
-	}
-
 	for _, t := range dc.tracks {
 		// no CodecPrivate found
 		if t.parentD == nil {
@@ -524,7 +531,19 @@ func matroskaDecode(d *decode.D) any {
 		}
 	}
 
-	for _, b := range dc.blocks {
+	decodeBlocks(dc, dc.blocks)
+
+	return nil
+}
+
+// decodeBlocks decodes blocks using codec information from tracks in dc
+func decodeBlocks(dc *decodeContext, blocks []block) {
+	trackNumberToTrack := map[int]*track{}
+	for _, t := range dc.tracks {
+		trackNumberToTrack[t.number] = t
+	}
+
+	for _, b := range blocks {
 		b.d.RangeFn(b.r.Start, b.r.Len, func(d *decode.D) {
 			var lacing uint64
 			trackNumber := d.FieldUintFn("track_number", decodeVint)
@@ -554,7 +573,7 @@ func matroskaDecode(d *decode.D) any {
 			}
 
 			decodeLacingFn(d, int(lacing), func(d *decode.D) {
-				if mi.DecodeSamples && f != nil {
+				if dc.decodeSamples && f != nil {
 					d.FieldFormat("packet", f, track.formatInArg)
 				} else {
 					d.FieldRawLen("packet", d.BitsLeft())
@@ -562,6 +581,4 @@ func matroskaDecode(d *decode.D) any {
 			})
 		})
 	}
-
-	return nil
 }
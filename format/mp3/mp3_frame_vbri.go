@@ -5,12 +5,12 @@ package mp3
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MP3_Frame_VBRI,
 		&decode.Format{
 			Description: "MP3 frame Fraunhofer encoder variable bitrate tag",
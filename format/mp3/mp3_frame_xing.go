@@ -7,11 +7,11 @@ package mp3
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MP3_Frame_XING,
 		&decode.Format{
 			Description: "MP3 frame Xing/Info tag",
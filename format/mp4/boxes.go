@@ -272,6 +272,14 @@ func decodeBoxWithParentData(ctx *decodeContext, d *decode.D, parentData any, ex
 
 	}
 
+	// with recover option continue with next box if this box fails
+	pathLen := len(ctx.path)
+	boxEnd := d.Pos() + int64(dataSize*8)
+	d.ResyncFn(func(d *decode.D) {
+		ctx.path = ctx.path[0:pathLen]
+		d.SeekAbs(boxEnd)
+	})
+
 	if parentData != nil {
 		ctx.path[len(ctx.path)-1].data = parentData
 	}
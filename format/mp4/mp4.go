@@ -21,7 +21,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"golang.org/x/exp/slices"
 )
 
@@ -55,7 +55,7 @@ var vp9FrameGroup decode.Group
 var vpxCCRGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MP4,
 		&decode.Format{
 			Description: "ISOBMFF, QuickTime and similar",
@@ -94,8 +94,59 @@ func init() {
 				{Groups: []*decode.Group{format.VP9_Frame}, Out: &vp9FrameGroup},
 				{Groups: []*decode.Group{format.VPX_CCR}, Out: &vpxCCRGroup},
 			},
+			Schema: mp4Schema(),
 		})
-	interp.RegisterFS(mp4FS)
+	registry.RegisterFS(mp4FS)
+}
+
+// box fields depend on box type so only common fields are described
+func mp4Schema() *decode.Schema {
+	boxesFields := func() []*decode.Schema {
+		return []*decode.Schema{
+			decode.SchemaUint("zero_terminator").Opt(),
+			decode.SchemaBitBuf("padding").Opt(),
+		}
+	}
+
+	box := decode.SchemaStruct("box",
+		decode.SchemaUint("size", boxSizeNames),
+		decode.SchemaStr("type", boxDescriptions),
+		decode.SchemaUint("size64").Opt(),
+	).Open()
+	box.Fields = append(box.Fields, decode.SchemaArray("boxes", box).Opt())
+	box.Fields = append(box.Fields, boxesFields()...)
+
+	var sampleAlts []*decode.Schema
+	for _, g := range []*decode.Group{
+		&aacFrameGroup,
+		&av1FrameGroup,
+		&avcAUGroup,
+		&flacFrameGroup,
+		&hevcAUGroup,
+		&jpegGroup,
+		&mp3FrameGroup,
+		&mpegPESPacketSampleGroup,
+		&opusPacketFrameGroup,
+		&pngGroup,
+		&proResFrameGroup,
+		&vorbisPacketGroup,
+		&vp9FrameGroup,
+	} {
+		sampleAlts = append(sampleAlts, decode.SchemaFormat("", g))
+	}
+	sampleAlts = append(sampleAlts, decode.SchemaBitBuf(""))
+
+	return decode.SchemaStruct("",
+		append([]*decode.Schema{
+			decode.SchemaArray("boxes", box),
+			decode.SchemaArray("tracks", decode.SchemaStruct("track",
+				decode.SchemaUint("id"),
+				decode.SchemaStr("data_format", dataFormatNames),
+				// not for raw sample formats
+				decode.SchemaArray("samples", decode.SchemaAnyOf("sample", sampleAlts...)).Opt(),
+			)).Opt(),
+		}, boxesFields()...)...,
+	)
 }
 
 type stsc struct {
@@ -224,12 +275,15 @@ func mp4Tracks(d *decode.D, ctx *decodeContext) {
 	d.FieldArray("tracks", func(d *decode.D) {
 		for _, t := range sortedTracks {
 			decodeSampleRange := func(d *decode.D, t *track, decodeSample bool, dataFormat string, name string, firstBit int64, nBits int64, inArg any) {
-				d.RangeFn(firstBit, nBits, func(d *decode.D) {
-					if !decodeSample {
+				if !decodeSample {
+					d.RangeFn(firstBit, nBits, func(d *decode.D) {
 						d.FieldRawLen(name, d.BitsLeft())
-						return
-					}
+					})
+					return
+				}
 
+				// decoded on first access as there can be lots of samples
+				d.FieldLazyRange(name, firstBit, nBits, func(d *decode.D) {
 					switch {
 					case dataFormat == "fLaC":
 						d.FieldFormatLen(name, nBits, &flacFrameGroup, inArg)
@@ -3,13 +3,13 @@ package mpeg
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var adtsFrameGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.ADTS,
 		&decode.Format{
 			Description: "Audio Data Transport Stream",
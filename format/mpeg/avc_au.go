@@ -5,13 +5,13 @@ package mpeg
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var avcNALUFormat decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.AVC_AU,
 		&decode.Format{
 			Description: "H.264/AVC Access Unit",
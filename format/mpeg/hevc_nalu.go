@@ -4,7 +4,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -13,7 +13,7 @@ var hevcPPSGroup decode.Group
 var hevcSPSGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.HEVC_NALU,
 		&decode.Format{
 			Description: "H.265/HEVC Network Access Layer Unit",
@@ -5,7 +5,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -13,7 +13,7 @@ var mpegASCGroup decode.Group
 var vorbisPacketGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MPEG_ES,
 		&decode.Format{
 			Description: "MPEG Elementary Stream",
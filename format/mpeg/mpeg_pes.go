@@ -8,14 +8,14 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var pesPacketGroup decode.Group
 var mpegSpuGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MPES_PES,
 		&decode.Format{
 			Description: "MPEG Packetized elementary stream",
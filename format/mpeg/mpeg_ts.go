@@ -1,20 +1,24 @@
 package mpeg
 
 import (
+	"bufio"
+	"fmt"
+
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MPEG_TS,
 		&decode.Format{
 			ProbeOrder:  format.ProbeOrderBinFuzzy, // make sure to be after gif, both start with 0x47
 			Description: "MPEG Transport Stream",
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    tsDecode,
+			StreamFn:    tsStream,
 		})
 }
 
@@ -32,3 +36,19 @@ func tsDecode(d *decode.D) any {
 
 	return nil
 }
+
+const tsPacketSize = 188
+
+// tsStream chunks are one packet
+func tsStream(r *bufio.Reader) decode.StreamChunkFn {
+	return func() ([]byte, error) {
+		b, err := decode.StreamRead(r, tsPacketSize)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != 0x47 {
+			return nil, fmt.Errorf("invalid sync byte 0x%.2x", b[0])
+		}
+		return b, nil
+	}
+}
@@ -9,7 +9,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -18,14 +18,14 @@ import (
 var msgPackFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.MsgPack,
 		&decode.Format{
 			Description: "MessagePack",
 			DecodeFn:    decodeMsgPack,
 			Functions:   []string{"torepr"},
 		})
-	interp.RegisterFS(msgPackFS)
+	registry.RegisterFS(msgPackFS)
 }
 
 type formatEntry struct {
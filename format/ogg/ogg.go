@@ -3,13 +3,14 @@ package ogg
 // https://xiph.org/ogg/doc/framing.html
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 var oggPageGroup decode.Group
@@ -19,12 +20,13 @@ var flacMetadatablockGroup decode.Group
 var flacFrameGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Ogg,
 		&decode.Format{
 			Description: "OGG file",
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    decodeOgg,
+			StreamFn:    streamOgg,
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.Ogg_Page}, Out: &oggPageGroup},
 				{Groups: []*decode.Group{format.Vorbis_Packet}, Out: &vorbisPacketGroup},
@@ -178,3 +180,25 @@ func decodeOgg(d *decode.D) any {
 
 	return nil
 }
+
+// streamOgg chunks are one page, packets spanning pages will be incomplete
+func streamOgg(r *bufio.Reader) decode.StreamChunkFn {
+	return func() ([]byte, error) {
+		b, err := decode.StreamRead(r, 27)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(b[0:4], []byte("OggS")) {
+			return nil, fmt.Errorf("invalid capture pattern")
+		}
+		segments := int(b[26])
+		if b, err = decode.StreamReadAppend(r, b, segments); err != nil {
+			return nil, err
+		}
+		dataLen := 0
+		for _, l := range b[27:] {
+			dataLen += int(l)
+		}
+		return decode.StreamReadAppend(r, b, dataLen)
+	}
+}
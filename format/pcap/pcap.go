@@ -4,21 +4,30 @@ package pcap
 // TODO: tshark seems to not support sll2 in pcap, confusing
 
 import (
+	"bufio"
 	"embed"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/inet/flowsdecoder"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
+//go:embed pcap.jq
 //go:embed pcap.md
 var pcapFS embed.FS
 
 var pcapLinkFrameGroup decode.Group
 var pcapTCPStreamGroup decode.Group
+var pcapUDPFlowGroup decode.Group
 var pcapIPv4PacketGroup decode.Group
+var pcapIPv6PacketGroup decode.Group
 
 // writing application writes 0xa1b2c3d4 in native endian
 const (
@@ -39,7 +48,7 @@ var endianMap = scalar.UintMapSymStr{
 }
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.PCAP,
 		&decode.Format{
 			Description: "PCAP packet capture",
@@ -47,17 +56,21 @@ func init() {
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.Link_Frame}, Out: &pcapLinkFrameGroup},
 				{Groups: []*decode.Group{format.TCP_Stream}, Out: &pcapTCPStreamGroup},
+				{Groups: []*decode.Group{format.UDP_Flow}, Out: &pcapUDPFlowGroup},
 				{Groups: []*decode.Group{format.IPv4Packet}, Out: &pcapIPv4PacketGroup},
+				{Groups: []*decode.Group{format.IPv6Packet}, Out: &pcapIPv6PacketGroup},
 			},
 			DecodeFn: decodePcap,
+			StreamFn: streamPcap,
 		})
-	interp.RegisterFS(pcapFS)
+	registry.RegisterFS(pcapFS)
 }
 
 func decodePcap(d *decode.D) any {
 	var endian decode.Endian
 	linkType := 0
 	timestampUNSStr := "ts_usec"
+	timestampUnit := time.Microsecond
 
 	d.FieldStruct("header", func(d *decode.D) {
 		magic := d.FieldU32("magic", d.UintAssert(
@@ -75,9 +88,11 @@ func decodePcap(d *decode.D) any {
 		case bigEndianNS:
 			endian = decode.BigEndian
 			timestampUNSStr = "ts_nsec"
+			timestampUnit = time.Nanosecond
 		case littleEndianNS:
 			endian = decode.LittleEndian
 			timestampUNSStr = "ts_nsec"
+			timestampUnit = time.Nanosecond
 		}
 
 		d.Endian = endian
@@ -91,50 +106,133 @@ func decodePcap(d *decode.D) any {
 	})
 
 	d.Endian = endian
-	fd := flowsdecoder.New(flowsdecoder.DecoderOptions{CheckTCPOptions: false})
 
+	const recordHeaderLen = 16 * 8
+	decodeRecord := func(d *decode.D) {
+		d.FieldStruct("packet", func(d *decode.D) {
+			d.FieldU32("ts_sec")
+			d.FieldU32(timestampUNSStr)
+			inclLen := d.FieldU32("incl_len")
+			origLen := d.FieldU32("orig_len")
+			// also checked when scanning but records can be from a cached index
+			if inclLen > origLen {
+				d.Errorf("incl_len %d > orig_len %d", inclLen, origLen)
+			}
+			d.FieldFormatOrRawLen(
+				"packet",
+				int64(inclLen)*8,
+				&pcapLinkFrameGroup,
+				format.Link_Frame_In{
+					Type:           linkType,
+					IsLittleEndian: d.Endian == decode.LittleEndian,
+				},
+			)
+		})
+	}
+
+	// packets are decoded on first access as there can be lots of them. Ranges
+	// of records are added to the index so that a cached index can be used
+	// instead of scanning all record headers
+	var records []ranges.Range
 	d.FieldArray("packets", func(d *decode.D) {
-		for !d.End() {
-			d.FieldStruct("packet", func(d *decode.D) {
-				d.FieldU32("ts_sec")
-				d.FieldU32(timestampUNSStr)
-				inclLen := d.FieldU32("incl_len")
-				origLen := d.FieldU32("orig_len")
-
-				// "incl_len: the number of bytes of packet data actually captured and saved in the file. This value should never become larger than orig_len or the snaplen value of the global header"
-				// "orig_len: the length of the packet as it appeared on the network when it was captured. If incl_len and orig_len differ, the actually saved packet size was limited by snaplen."
-
-				// TODO: incl_len seems to be larger than snaplen in real pcap files
-				// if inclLen > snapLen {
-				// 	d.Errorf("incl_len %d > snaplen %d", inclLen, snapLen)
-				// }
-
-				if inclLen > origLen {
-					d.Errorf("incl_len %d > orig_len %d", inclLen, origLen)
-				}
+		if rs, ok := d.IndexRecords("packets"); ok {
+			records = rs
+			for _, r := range rs {
+				d.FieldLazyRange("packet", r.Start, r.Len, decodeRecord)
+			}
+			if len(rs) > 0 {
+				d.SeekAbs(rs[len(rs)-1].Stop())
+			}
+			return
+		}
 
-				bs := d.ReadAllBits(d.BitBufRange(d.Pos(), int64(inclLen)*8))
+		for !d.End() {
+			start := d.Pos()
+			d.SeekRel(8 * 8)
+			inclLen := d.U32()
+			origLen := d.U32()
+			d.SeekAbs(start)
+
+			// "incl_len: the number of bytes of packet data actually captured and saved in the file. This value should never become larger than orig_len or the snaplen value of the global header"
+			// "orig_len: the length of the packet as it appeared on the network when it was captured. If incl_len and orig_len differ, the actually saved packet size was limited by snaplen."
+
+			// TODO: incl_len seems to be larger than snaplen in real pcap files
+			// if inclLen > snapLen {
+			// 	d.Errorf("incl_len %d > snaplen %d", inclLen, snapLen)
+			// }
+
+			if inclLen > origLen {
+				d.Errorf("incl_len %d > orig_len %d", inclLen, origLen)
+			}
+
+			r := ranges.Range{Start: start, Len: recordHeaderLen + int64(inclLen)*8}
+			d.FieldLazy("packet", r.Len, decodeRecord)
+			d.IndexAddRecord("packets", r)
+			records = append(records, r)
+		}
+	})
 
-				if fn, ok := linkToDecodeFn[linkType]; ok {
+	// flows are decoded on first access as all packets has to be read
+	var fdOnce sync.Once
+	fd := flowsdecoder.New(flowsdecoder.DecoderOptions{CheckTCPOptions: false})
+	flowsFn := func(d *decode.D) *flowsdecoder.Decoder {
+		fdOnce.Do(func() {
+			if fn, ok := linkToDecodeFn[linkType]; ok {
+				for i, r := range records {
+					hbs := d.ReadAllBits(d.BitBufRange(r.Start, 8*8))
+					byteOrder := binary.ByteOrder(binary.BigEndian)
+					if endian == decode.LittleEndian {
+						byteOrder = binary.LittleEndian
+					}
+					ts := time.Unix(int64(byteOrder.Uint32(hbs[0:4])), int64(byteOrder.Uint32(hbs[4:8]))*int64(timestampUnit))
+
+					bs := d.ReadAllBits(d.BitBufRange(r.Start+recordHeaderLen, r.Len-recordHeaderLen))
 					// TODO: report decode errors
-					_ = fn(fd, bs)
+					_ = fn(fd, bs, flowsdecoder.CaptureInfo{Index: i, Timestamp: ts})
 				}
-
-				d.FieldFormatOrRawLen(
-					"packet",
-					int64(inclLen)*8,
-					&pcapLinkFrameGroup,
-					format.Link_Frame_In{
-						Type:           linkType,
-						IsLittleEndian: d.Endian == decode.LittleEndian,
-					},
-				)
-			})
-		}
+			}
+			fd.Flush()
+		})
+		return fd
+	}
+	fieldLazyFlows(d, flowsFn, flowFormats{
+		tcpStream:  pcapTCPStreamGroup,
+		udpFlow:    pcapUDPFlowGroup,
+		ipv4Packet: pcapIPv4PacketGroup,
+		ipv6Packet: pcapIPv6PacketGroup,
 	})
-	fd.Flush()
-
-	fieldFlows(d, fd, pcapTCPStreamGroup, pcapIPv4PacketGroup)
 
 	return nil
 }
+
+// streamPcap chunks are the header followed by one packet
+func streamPcap(r *bufio.Reader) decode.StreamChunkFn {
+	var header []byte
+	var byteOrder binary.ByteOrder
+
+	return func() ([]byte, error) {
+		if header == nil {
+			h, err := decode.StreamRead(r, 24)
+			if err != nil {
+				return nil, err
+			}
+			switch binary.BigEndian.Uint32(h) {
+			case bigEndian, bigEndianNS:
+				byteOrder = binary.BigEndian
+			case littleEndian, littleEndianNS:
+				byteOrder = binary.LittleEndian
+			default:
+				return nil, fmt.Errorf("invalid magic")
+			}
+			header = h
+		}
+
+		ph, err := decode.StreamRead(r, 16)
+		if err != nil {
+			return nil, err
+		}
+		inclLen := byteOrder.Uint32(ph[8:12])
+		b := append(append([]byte{}, header...), ph...)
+		return decode.StreamReadAppend(r, b, int(inclLen))
+	}
+}
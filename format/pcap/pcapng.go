@@ -3,22 +3,29 @@ package pcap
 // https://pcapng.github.io/pcapng/draft-ietf-opsawg-pcapng.html
 
 import (
+	"bufio"
 	"encoding/binary"
+	"fmt"
+	"math"
 	"net"
+	"time"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/inet/flowsdecoder"
+	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var pcapngLinkFrameGroup decode.Group
 var pcapngTCPStreamGroup decode.Group
+var pcapngUDPFlowGroup decode.Group
 var pcapngIPvPacket4Group decode.Group
+var pcapngIPv6PacketGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.PCAPNG,
 		&decode.Format{
 			Description: "PCAPNG packet capture",
@@ -27,9 +34,12 @@ func init() {
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.Link_Frame}, Out: &pcapngLinkFrameGroup},
 				{Groups: []*decode.Group{format.TCP_Stream}, Out: &pcapngTCPStreamGroup},
+				{Groups: []*decode.Group{format.UDP_Flow}, Out: &pcapngUDPFlowGroup},
 				{Groups: []*decode.Group{format.IPv4Packet}, Out: &pcapngIPvPacket4Group},
+				{Groups: []*decode.Group{format.IPv6Packet}, Out: &pcapngIPv6PacketGroup},
 			},
 			DecodeFn: decodePcapng,
+			StreamFn: streamPcapng,
 		})
 }
 
@@ -46,6 +56,8 @@ var ngEndianMap = scalar.UintMapSymStr{
 const (
 	blockTypeSectionHeader        = 0x0a0d0d0a
 	blockTypeInterfaceDescription = 0x00000001
+	blockTypeObsoletePacket       = 0x00000002
+	blockTypeSimplePacket         = 0x00000003
 	blockTypeNameResolution       = 0x00000004
 	blockTypeInterfaceStatistics  = 0x00000005
 	blockTypeEnhancedPacketBlock  = 0x00000006
@@ -54,8 +66,8 @@ const (
 // from https://pcapng.github.io/pcapng/draft-ietf-opsawg-pcapng.html#section_block_code_registry
 var blockTypeMap = scalar.UintMap{
 	blockTypeInterfaceDescription: {Sym: "interface_description", Description: "Interface Description Block"},
-	0x00000002:                    {Description: "Packet Block"},
-	0x00000003:                    {Description: "Simple Packet Block"},
+	blockTypeObsoletePacket:       {Sym: "obsolete_packet", Description: "Packet Block"},
+	blockTypeSimplePacket:         {Sym: "simple_packet", Description: "Simple Packet Block"},
 	blockTypeNameResolution:       {Sym: "name_resolution", Description: "Name Resolution Block"},
 	blockTypeInterfaceStatistics:  {Sym: "interface_statistics", Description: "Interface Statistics Block"},
 	blockTypeEnhancedPacketBlock:  {Sym: "enhanced_packet", Description: "Enhanced Packet Block"},
@@ -186,9 +198,11 @@ var nameResolutionRecordMap = scalar.UintMapSymStr{
 	nameResolutionRecordIpv6: "ipv6",
 }
 
-func decoodeOptions(d *decode.D, opts scalar.UintMap) {
+// decoodeOptions decodes options and returns option values by code
+func decoodeOptions(d *decode.D, opts scalar.UintMap) map[uint64][]byte {
+	values := map[uint64][]byte{}
 	if d.BitsLeft() < 32 {
-		return
+		return values
 	}
 	seenEnd := false
 	for !seenEnd {
@@ -199,10 +213,27 @@ func decoodeOptions(d *decode.D, opts scalar.UintMap) {
 				seenEnd = true
 				return
 			}
+			values[code] = d.PeekBytes(int(length))
 			d.FieldUTF8NullFixedLen("value", int(length))
 			d.FieldRawLen("padding", int64(d.AlignBits(32)))
 		})
 	}
+	return values
+}
+
+// timestamp in units of interface tsresol, default microseconds
+func pcapngTimestamp(ts uint64, tsresol []byte) time.Time {
+	base, exp := 10.0, 6.0
+	if len(tsresol) == 1 {
+		if tsresol[0]&0x80 != 0 {
+			base = 2
+		}
+		exp = float64(tsresol[0] & 0x7f)
+	}
+	unitsPerSecond := math.Pow(base, exp)
+	sec := math.Floor(float64(ts) / unitsPerSecond)
+	nsec := (float64(ts) - sec*unitsPerSecond) / unitsPerSecond * 1e9
+	return time.Unix(int64(sec), int64(nsec))
 }
 
 // TODO: share
@@ -214,43 +245,49 @@ var mapUToIPv4Sym = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
 })
 
 var blockFns = map[uint64]func(d *decode.D, dc *decodeContext){
-	// TODO: SimplePacket
-	// TODO: Packet
 	blockTypeInterfaceDescription: func(d *decode.D, dc *decodeContext) {
 		typ := d.FieldU16("link_type", format.LinkTypeMap)
 		d.FieldU16("reserved")
-		d.FieldU32("snap_len")
-		d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, interfaceDescriptionOptionsMap) })
+		snapLen := d.FieldU32("snap_len")
+		var options map[uint64][]byte
+		d.FieldArray("options", func(d *decode.D) { options = decoodeOptions(d, interfaceDescriptionOptionsMap) })
 
+		dc.interfaceTsresols[len(dc.interfaceTypes)] = options[interfaceDescriptionTsresol]
+		dc.interfaceSnapLens[len(dc.interfaceTypes)] = int(snapLen)
 		dc.interfaceTypes[len(dc.interfaceTypes)] = int(typ)
 	},
-	blockTypeEnhancedPacketBlock: func(d *decode.D, dc *decodeContext) {
-		interfaceID := d.FieldU32("interface_id")
-		d.FieldU32("timestamp_high")
-		d.FieldU32("timestamp_low")
+	blockTypeObsoletePacket: func(d *decode.D, dc *decodeContext) {
+		interfaceID := d.FieldU16("interface_id")
+		d.FieldU16("drops_count")
+		timestampHigh := d.FieldU32("timestamp_high")
+		timestampLow := d.FieldU32("timestamp_low")
 		capturedLength := d.FieldU32("capture_packet_length")
 		d.FieldU32("original_packet_length")
 
-		bs := d.ReadAllBits(d.BitBufRange(d.Pos(), int64(capturedLength)*8))
-
-		linkType := dc.interfaceTypes[int(interfaceID)]
-
-		if fn, ok := linkToDecodeFn[linkType]; ok {
-			// TODO: report decode errors
-			_ = fn(dc.flowDecoder, bs)
+		ts := pcapngTimestamp(timestampHigh<<32|timestampLow, dc.interfaceTsresols[int(interfaceID)])
+		fieldPacket(d, dc, int(interfaceID), ts, int64(capturedLength))
+		d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, enhancedPacketOptionsMap) })
+	},
+	blockTypeSimplePacket: func(d *decode.D, dc *decodeContext) {
+		originalLength := d.FieldU32("original_packet_length")
+
+		// no captured length, packet is the smallest of original length, snap length
+		// of first interface and the rest of the block. Has no timestamp.
+		capturedLength := mathex.Min(int64(originalLength), d.BitsLeft()/8)
+		if snapLen := dc.interfaceSnapLens[0]; snapLen > 0 {
+			capturedLength = mathex.Min(capturedLength, int64(snapLen))
 		}
+		fieldPacket(d, dc, 0, time.Time{}, capturedLength)
+	},
+	blockTypeEnhancedPacketBlock: func(d *decode.D, dc *decodeContext) {
+		interfaceID := d.FieldU32("interface_id")
+		timestampHigh := d.FieldU32("timestamp_high")
+		timestampLow := d.FieldU32("timestamp_low")
+		capturedLength := d.FieldU32("capture_packet_length")
+		d.FieldU32("original_packet_length")
 
-		d.FieldFormatOrRawLen(
-			"packet",
-			int64(capturedLength)*8,
-			&pcapngLinkFrameGroup,
-			format.Link_Frame_In{
-				Type:           linkType,
-				IsLittleEndian: d.Endian == decode.LittleEndian,
-			},
-		)
-
-		d.FieldRawLen("padding", int64(d.AlignBits(32)))
+		ts := pcapngTimestamp(timestampHigh<<32|timestampLow, dc.interfaceTsresols[int(interfaceID)])
+		fieldPacket(d, dc, int(interfaceID), ts, int64(capturedLength))
 		d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, enhancedPacketOptionsMap) })
 	},
 	blockTypeNameResolution: func(d *decode.D, _ *decodeContext) {
@@ -292,14 +329,44 @@ var blockFns = map[uint64]func(d *decode.D, dc *decodeContext){
 	},
 }
 
+// fieldPacket feeds the packet to the flow decoder and adds it as a lazy field
+// followed by padding, shared by the packet block types
+func fieldPacket(d *decode.D, dc *decodeContext, interfaceID int, ts time.Time, capturedLength int64) {
+	bs := d.ReadAllBits(d.BitBufRange(d.Pos(), capturedLength*8))
+
+	linkType := dc.interfaceTypes[interfaceID]
+
+	if fn, ok := linkToDecodeFn[linkType]; ok {
+		// TODO: report decode errors
+		_ = fn(dc.flowDecoder, bs, flowsdecoder.CaptureInfo{Index: dc.packetIndex, Timestamp: ts})
+	}
+	dc.packetIndex++
+
+	// decoded on first access as there can be lots of packets
+	d.FieldLazy("packet", capturedLength*8, func(d *decode.D) {
+		d.FieldFormatOrRawLen(
+			"packet",
+			d.BitsLeft(),
+			&pcapngLinkFrameGroup,
+			format.Link_Frame_In{
+				Type:           linkType,
+				IsLittleEndian: d.Endian == decode.LittleEndian,
+			},
+		)
+	})
+
+	d.FieldRawLen("padding", int64(d.AlignBits(32)))
+}
+
 func decodeBlock(d *decode.D, dc *decodeContext) {
+	blockStart := d.Pos()
 	typ := d.FieldU32("type", blockTypeMap, scalar.UintHex)
-	length := d.FieldU32("length") - 8
+	// type, length and footer length
+	length := d.FieldU32("length", d.UintAssertRange(12, math.MaxUint32)) - 8
+	// with recover option continue with next block
+	d.ResyncAt(blockStart + (int64(length)+8)*8)
 	const footerLengthSize = 32
 	blockLen := int64(length)*8 - footerLengthSize
-	if blockLen <= 0 {
-		d.Fatalf("%d blockLen < 0", blockLen)
-	}
 	d.FramedFn(blockLen, func(d *decode.D) {
 		if fn, ok := blockFns[typ]; ok {
 			fn(d, dc)
@@ -333,7 +400,7 @@ func decodeSection(d *decode.D, dc *decodeContext) {
 			}
 			sectionD.Endian = d.Endian
 			d.SeekRel(-64)
-			length := d.FieldU32("length") - 8 - 4
+			length := d.FieldU32("length", d.UintAssertRange(12, math.MaxUint32)) - 8 - 4
 			d.SeekRel(32)
 
 			d.FramedFn(int64(length)*8, func(d *decode.D) {
@@ -359,6 +426,9 @@ func decodeSection(d *decode.D, dc *decodeContext) {
 type decodeContext struct {
 	sectionHeaderFound bool
 	interfaceTypes     map[int]int
+	interfaceTsresols  map[int][]byte
+	interfaceSnapLens  map[int]int
+	packetIndex        int // packet block index in section
 	flowDecoder        *flowsdecoder.Decoder
 }
 
@@ -367,14 +437,21 @@ func decodePcapng(d *decode.D) any {
 	for !d.End() {
 		fd := flowsdecoder.New(flowsdecoder.DecoderOptions{CheckTCPOptions: false})
 		dc := decodeContext{
-			interfaceTypes: map[int]int{},
-			flowDecoder:    fd,
+			interfaceTypes:    map[int]int{},
+			interfaceTsresols: map[int][]byte{},
+			interfaceSnapLens: map[int]int{},
+			flowDecoder:       fd,
 		}
 
 		d.FieldStruct("section", func(d *decode.D) {
 			decodeSection(d, &dc)
 			fd.Flush()
-			fieldFlows(d, dc.flowDecoder, pcapngTCPStreamGroup, pcapngIPvPacket4Group)
+			fieldFlows(d, dc.flowDecoder, flowFormats{
+				tcpStream:  pcapngTCPStreamGroup,
+				udpFlow:    pcapngUDPFlowGroup,
+				ipv4Packet: pcapngIPvPacket4Group,
+				ipv6Packet: pcapngIPv6PacketGroup,
+			})
 		})
 		if dc.sectionHeaderFound {
 			sectionHeaders++
@@ -388,3 +465,59 @@ func decodePcapng(d *decode.D) any {
 
 	return nil
 }
+
+// streamPcapng chunks are the section header and interface description blocks
+// followed by one other block
+func streamPcapng(r *bufio.Reader) decode.StreamChunkFn {
+	var prefix []byte
+	var byteOrder binary.ByteOrder
+
+	return func() ([]byte, error) {
+		for {
+			b, err := decode.StreamRead(r, 8)
+			if err != nil {
+				return nil, err
+			}
+			typ := binary.BigEndian.Uint32(b[0:4])
+			if typ == blockTypeSectionHeader {
+				if b, err = decode.StreamReadAppend(r, b, 4); err != nil {
+					return nil, err
+				}
+				switch binary.BigEndian.Uint32(b[8:12]) {
+				case ngBigEndian:
+					byteOrder = binary.BigEndian
+				case ngLittleEndian:
+					byteOrder = binary.LittleEndian
+				default:
+					return nil, fmt.Errorf("invalid byte order magic")
+				}
+			} else if byteOrder == nil {
+				return nil, fmt.Errorf("no section header found")
+			} else {
+				typ = byteOrder.Uint32(b[0:4])
+			}
+
+			length := int(byteOrder.Uint32(b[4:8]))
+			if length < len(b) || length%4 != 0 {
+				return nil, fmt.Errorf("invalid block length %d", length)
+			}
+			if b, err = decode.StreamReadAppend(r, b, length-len(b)); err != nil {
+				return nil, err
+			}
+
+			switch typ {
+			case blockTypeSectionHeader:
+				if len(b) < 24 {
+					return nil, fmt.Errorf("invalid section header length %d", len(b))
+				}
+				// section length is not known for a chunk
+				binary.BigEndian.PutUint64(b[16:24], 0xffff_ffff_ffff_ffff)
+				prefix = b
+			case blockTypeInterfaceDescription:
+				prefix = append(prefix, b...)
+			default:
+				return append(append([]byte{}, prefix...), b...), nil
+			}
+		}
+	}
+}
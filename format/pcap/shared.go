@@ -1,13 +1,17 @@
 package pcap
 
 import (
+	"bytes"
+	"time"
+
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/inet/flowsdecoder"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
 )
 
-var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte) error{
+var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte, ci flowsdecoder.CaptureInfo) error{
 	format.LinkTypeETHERNET:   (*flowsdecoder.Decoder).EthernetFrame,
 	format.LinkTypeIPv4:       (*flowsdecoder.Decoder).IPv4Packet,
 	format.LinkTypeIPv6:       (*flowsdecoder.Decoder).IPv6Packet,
@@ -17,22 +21,81 @@ var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte) error{
 	format.LinkTypeRAW:        (*flowsdecoder.Decoder).RAWIPFrame,
 }
 
+// flowFormats are groups used to decode flows
+type flowFormats struct {
+	tcpStream  decode.Group
+	udpFlow    decode.Group
+	ipv4Packet decode.Group
+	ipv6Packet decode.Group
+}
+
 // TODO: make some of this shared if more packet capture formats are added
-func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Group, ipv4PacketFormat decode.Group) {
-	d.FieldArray("ipv4_reassembled", func(d *decode.D) {
-		for _, p := range fd.IPV4Reassembled {
-			br := bitio.NewBitReader(p.Datagram, -1)
-			if dv, _, _ := d.TryFieldFormatBitBuf(
-				"ipv4_packet",
-				br,
-				&ipv4PacketFormat,
-				nil,
-			); dv == nil {
-				d.FieldRootBitBuf("ipv4_packet", br)
-			}
+func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, ff flowFormats) {
+	fieldIPReassembled(d, "ipv4_reassembled", "ipv4_packet", fd.IPV4Reassembled, ff.ipv4Packet)
+	fieldIPReassembled(d, "ipv6_reassembled", "ipv6_packet", fd.IPV6Reassembled, ff.ipv6Packet)
+	fieldTCPConnections(d, fd, ff.tcpStream)
+	fieldUDPFlows(d, fd, ff.udpFlow)
+}
+
+// fieldLazyFlows adds flow fields that are decoded on first access, flowsFn
+// should feed all packets to the flows decoder once and return it
+func fieldLazyFlows(d *decode.D, flowsFn func(d *decode.D) *flowsdecoder.Decoder, ff flowFormats) {
+	pos := d.Pos()
+	d.FieldLazyRange("ipv4_reassembled", pos, 0, func(d *decode.D) {
+		fieldIPReassembled(d, "ipv4_reassembled", "ipv4_packet", flowsFn(d).IPV4Reassembled, ff.ipv4Packet)
+	})
+	d.FieldLazyRange("ipv6_reassembled", pos, 0, func(d *decode.D) {
+		fieldIPReassembled(d, "ipv6_reassembled", "ipv6_packet", flowsFn(d).IPV6Reassembled, ff.ipv6Packet)
+	})
+	d.FieldLazyRange("tcp_connections", pos, 0, func(d *decode.D) {
+		fieldTCPConnections(d, flowsFn(d), ff.tcpStream)
+	})
+	d.FieldLazyRange("udp_flows", pos, 0, func(d *decode.D) {
+		fieldUDPFlows(d, flowsFn(d), ff.udpFlow)
+	})
+}
+
+// timestamp as unix time in seconds
+func fieldTimestamp(d *decode.D, name string, t time.Time) {
+	d.FieldValueFlt(name, float64(t.Unix())+float64(t.Nanosecond())/float64(time.Second), scalar.FltDescription(t.UTC().Format(time.RFC3339Nano)))
+}
+
+// fieldIPReassembled adds reassembled datagrams with packet indexes of the
+// fragments, packet is missing if reassembly did not complete
+func fieldIPReassembled(d *decode.D, name string, packetName string, rs []flowsdecoder.IPReassembled, ipPacketFormat decode.Group) {
+	d.FieldArray(name, func(d *decode.D) {
+		for _, r := range rs {
+			d.FieldStruct("datagram", func(d *decode.D) {
+				d.FieldValueStr("source_ip", r.SourceIP.String())
+				d.FieldValueStr("destination_ip", r.DestinationIP.String())
+				d.FieldArray("packet_indexes", func(d *decode.D) {
+					for _, i := range r.PacketIndexes {
+						d.FieldValueUint("packet_index", uint64(i))
+					}
+				})
+				d.FieldArray("anomalies", func(d *decode.D) {
+					for _, a := range r.Anomalies {
+						d.FieldValueStr("anomaly", a)
+					}
+				})
+				if r.Datagram == nil {
+					return
+				}
+				br := bitio.NewBitReader(r.Datagram, -1)
+				if dv, _, _ := d.TryFieldFormatBitBuf(
+					packetName,
+					br,
+					&ipPacketFormat,
+					nil,
+				); dv == nil {
+					d.FieldRootBitBuf(packetName, br)
+				}
+			})
 		}
 	})
+}
 
+func fieldTCPConnections(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Group) {
 	d.FieldArray("tcp_connections", func(d *decode.D) {
 		for _, s := range fd.TCPConnections {
 			d.FieldStruct("tcp_connection", func(d *decode.D) {
@@ -68,6 +131,7 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 						DestinationPort: s.Server.Endpoint.Port,
 					})
 				})
+				clientTo, clientToOk := clientV.(format.TCP_Stream_Out)
 				d.FieldStruct("server", func(d *decode.D) {
 					serverV = f(d, &s.Server, format.TCP_Stream_In{
 						IsClient:        false,
@@ -76,10 +140,10 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 						SkippedBytes:    s.Server.SkippedBytes,
 						SourcePort:      s.Server.Endpoint.Port,
 						DestinationPort: s.Client.Endpoint.Port,
+						ClientOut:       clientTo.InArg,
 					})
 				})
 
-				clientTo, clientToOk := clientV.(format.TCP_Stream_Out)
 				serverTo, serverToOk := serverV.(format.TCP_Stream_Out)
 				if clientToOk && serverToOk {
 					if clientTo.PostFn != nil {
@@ -93,3 +157,52 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 		}
 	})
 }
+
+func fieldUDPFlows(d *decode.D, fd *flowsdecoder.Decoder, udpFlowFormat decode.Group) {
+	d.FieldArray("udp_flows", func(d *decode.D) {
+		for _, f := range fd.UDPFlows {
+			d.FieldStruct("udp_flow", func(d *decode.D) {
+				fieldEndpoint := func(d *decode.D, e flowsdecoder.UDPEndpoint) {
+					d.FieldValueStr("ip", e.IP.String())
+					d.FieldValueUint("port", uint64(e.Port), format.UDPPortMap)
+				}
+				d.FieldStruct("client", func(d *decode.D) { fieldEndpoint(d, f.Client) })
+				d.FieldStruct("server", func(d *decode.D) { fieldEndpoint(d, f.Server) })
+
+				// datagram payloads are concatenated into one flow buffer
+				buf := &bytes.Buffer{}
+				ufi := format.UDP_Flow_In{
+					ClientPort: f.Client.Port,
+					ServerPort: f.Server.Port,
+				}
+				d.FieldArray("datagrams", func(d *decode.D) {
+					for _, dg := range f.Datagrams {
+						d.FieldStruct("datagram", func(d *decode.D) {
+							d.FieldValueUint("packet_index", uint64(dg.CaptureInfo.Index))
+							fieldTimestamp(d, "timestamp", dg.CaptureInfo.Timestamp)
+							d.FieldValueBool("is_client", dg.IsClient)
+							d.FieldValueUint("length", uint64(len(dg.Payload)))
+						})
+						buf.Write(dg.Payload)
+						ufi.Datagrams = append(ufi.Datagrams, format.UDP_Flow_Datagram{
+							IsClient:    dg.IsClient,
+							PacketIndex: dg.CaptureInfo.Index,
+							Timestamp:   dg.CaptureInfo.Timestamp,
+							Length:      int64(len(dg.Payload)),
+						})
+					}
+				})
+
+				br := bitio.NewBitReader(buf.Bytes(), -1)
+				if dv, _, _ := d.TryFieldFormatBitBuf(
+					"flow",
+					br,
+					&udpFlowFormat,
+					ufi,
+				); dv == nil {
+					d.FieldRootBitBuf("flow", br)
+				}
+			})
+		}
+	})
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -19,7 +19,7 @@ var iccProfileGroup decode.Group
 var exifGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.PNG,
 		&decode.Format{
 			Description: "Portable Network Graphics file",
@@ -29,9 +29,89 @@ func init() {
 				{Groups: []*decode.Group{format.ICC_Profile}, Out: &iccProfileGroup},
 				{Groups: []*decode.Group{format.Exif}, Out: &exifGroup},
 			},
+			Schema: pngSchema(),
 		})
 }
 
+// fields depend on chunk type so all except common fields are optional
+func pngSchema() *decode.Schema {
+	rgb := func() []*decode.Schema {
+		return []*decode.Schema{
+			decode.SchemaUint("r").Opt(),
+			decode.SchemaUint("g").Opt(),
+			decode.SchemaUint("b").Opt(),
+		}
+	}
+	chunkFields := []*decode.Schema{
+		decode.SchemaUint("length"),
+		decode.SchemaStr("type"),
+		decode.SchemaBool("ancillary"),
+		decode.SchemaBool("private"),
+		decode.SchemaBool("reserved"),
+		decode.SchemaBool("safe_to_copy"),
+		// IHDR, fcTL
+		decode.SchemaUint("width").Opt(),
+		decode.SchemaUint("height").Opt(),
+		decode.SchemaUint("bit_depth").Opt(),
+		decode.SchemaUint("color_type", colorTypeMap).Opt(),
+		decode.SchemaUint("compression_method", compressionNames).Opt(),
+		decode.SchemaUint("filter_method", scalar.UintMapSymStr{0: "adaptive_filtering"}).Opt(),
+		decode.SchemaUint("interlace_method", scalar.UintMapSymStr{0: "none", 1: "adam7"}).Opt(),
+		// tEXt, zTXt, iCCP
+		decode.SchemaStr("keyword").Opt(),
+		decode.SchemaStr("text").Opt(),
+		decode.SchemaStr("profile_name").Opt(),
+		decode.SchemaBitBuf("compressed").Opt(),
+		decode.SchemaAnyOf("uncompressed",
+			decode.SchemaStruct("", decode.SchemaStr("text")),
+			decode.SchemaFormat("", &iccProfileGroup),
+		).Opt(),
+		// pHYs
+		decode.SchemaUint("x_pixels_per_unit").Opt(),
+		decode.SchemaUint("y_pixels_per_unit").Opt(),
+		decode.SchemaUint("unit").Opt(),
+		// bKGD, tRNS
+		decode.SchemaUint("index").Opt(),
+		decode.SchemaUint("gray").Opt(),
+		decode.SchemaUint("alpha").Opt(),
+		decode.SchemaArray("alphas", decode.SchemaUint("alpha")).Opt(),
+		// gAMA
+		decode.SchemaUint("value").Opt(),
+		// cHRM
+		decode.SchemaFlt("white_point_x").Opt(),
+		decode.SchemaFlt("white_point_y").Opt(),
+		decode.SchemaFlt("red_x").Opt(),
+		decode.SchemaFlt("red_y").Opt(),
+		decode.SchemaFlt("green_x").Opt(),
+		decode.SchemaFlt("green_y").Opt(),
+		decode.SchemaFlt("blue_x").Opt(),
+		decode.SchemaFlt("blue_y").Opt(),
+		// eXIf
+		decode.SchemaFormat("exif", &exifGroup).Opt(),
+		// acTL, fcTL, fdAT
+		decode.SchemaUint("num_frames").Opt(),
+		decode.SchemaUint("num_plays").Opt(),
+		decode.SchemaUint("sequence_number").Opt(),
+		decode.SchemaUint("x_offset").Opt(),
+		decode.SchemaUint("y_offset").Opt(),
+		decode.SchemaUint("delay_num").Opt(),
+		decode.SchemaUint("delay_sep").Opt(),
+		decode.SchemaUint("dispose_op", disposeOpNames).Opt(),
+		decode.SchemaUint("blend_op", blendOpNames).Opt(),
+		// PLTE
+		decode.SchemaArray("palette", decode.SchemaStruct("color", rgb()...)).Opt(),
+		// unknown chunks, fdAT
+		decode.SchemaBitBuf("data").Opt(),
+		decode.SchemaUint("crc"),
+	}
+	chunkFields = append(chunkFields, rgb()...)
+
+	return decode.SchemaStruct("",
+		decode.SchemaBitBuf("signature"),
+		decode.SchemaArray("chunks", decode.SchemaStruct("chunk", chunkFields...)),
+	)
+}
+
 const (
 	compressionDeflate = 0
 )
@@ -86,6 +166,8 @@ func pngDecode(d *decode.D) any {
 	d.FieldStructArrayLoop("chunks", "chunk", func() bool { return d.NotEnd() && !iEndFound }, func(d *decode.D) {
 		chunkLength := d.FieldU32("length")
 		crcStartPos := d.Pos()
+		// with recover option continue with next chunk, type, data and crc
+		d.ResyncAt(crcStartPos + (4+int64(chunkLength)+4)*8)
 		chunkType := d.FieldUTF8("type", 4)
 		// upper/lower case in chunk type is used for flags
 		d.SeekRel(-4 * 8)
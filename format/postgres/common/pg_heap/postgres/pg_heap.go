@@ -1,10 +1,12 @@
 package postgres
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/postgres/common"
+	"github.com/wader/fq/internal/mathex"
 
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/scalar"
@@ -67,6 +69,7 @@ const (
 
 // typedef uint16 LocationIndex;
 // #define SizeOfPageHeaderData (offsetof(PageHeaderData, pd_linp))
+const SizeOfPageHeaderData = 24
 
 // type = struct HeapTupleHeaderData {
 /*    0      |    12 */ // union {
@@ -142,36 +145,91 @@ func Decode(heap *Heap, d *decode.D) any {
 }
 
 func decodeHeapPages(heap *Heap, d *decode.D) {
-	blockNumber := uint32(heap.Args.Page + heap.Args.Segment*common.RelSegSize)
 	count := int64(0)
 	for {
 		if d.End() {
 			return
 		}
 
-		d.FieldStruct("page", func(d *decode.D) {
-			decodeHeapPage(heap, d, blockNumber)
+		// end of page is checked before the page is decoded on first access
+		pageBegin := count * common.PageSize
+		pageEnd0 := (count + 1) * common.PageSize
+		pageLen := mathex.Min(common.PageSize*8, d.BitsLeft())
+		if pageLen < common.PageSize*8 {
+			d.Errorf("invalid page %d end expected %d, actual %d\n", count, pageEnd0, pageBegin+pageLen/8)
+		} else {
+			endLen := uint64(pageTuplesEnd(d, pageBegin))
+			pageEnd := int64(common.TypeAlign(common.PageSize, endLen))
+			if pageEnd0 != pageEnd {
+				d.Errorf("invalid page %d end expected %d, actual %d, endLen  %d\n", count, pageEnd0, pageEnd, endLen)
+			}
+		}
+
+		pageHeap := heap.pageCopy()
+		pageBlockNumber := heapBlockNumber(heap, d, pageBegin, count)
+		d.FieldLazy("page", pageLen, func(d *decode.D) {
+			d.FieldStruct("page", func(d *decode.D) {
+				decodeHeapPage(pageHeap, d, pageBlockNumber, pageBegin)
+			})
 		})
-		blockNumber++
 		count++
+	}
+}
 
-		// end of Page
-		endLen := uint64(d.Pos() / 8)
-		pageEnd := int64(common.TypeAlign(common.PageSize, endLen))
-		pageEnd0 := count * common.PageSize
-		if pageEnd0 != pageEnd {
-			d.Errorf("invalid page %d end expected %d, actual %d, endLen  %d\n", count-1, pageEnd0, pageEnd, endLen)
+// heapBlockNumber returns block number of page number count at byte position
+// pageBegin. If segment files are concatenated the segment number is taken
+// from the name of the file the page is in, ex: 16994.1 is segment 1, a name
+// without number like 16994 is the segment option, default 0.
+func heapBlockNumber(heap *Heap, d *decode.D, pageBegin int64, count int64) uint32 {
+	for _, o := range d.Options.Origins {
+		if pageBegin*8 < o.Range.Start || pageBegin*8 >= o.Range.Stop() {
+			continue
+		}
+		segment, ok := common.SegmentNumber(o.Name)
+		if !ok {
+			segment = heap.Args.Segment
 		}
-		d.SeekAbs(pageEnd0 * 8)
+		return uint32((pageBegin-o.Range.Start/8)/common.PageSize + int64(segment)*common.RelSegSize)
 	}
+	return uint32(int64(heap.Args.Page) + count + int64(heap.Args.Segment)*common.RelSegSize)
 }
 
-func decodeHeapPage(heap *Heap, d *decode.D, blockNumber uint32) {
-	page := &HeapPage{}
-	if heap.Page != nil {
-		// use prev page
-		page.BytesPosBegin = heap.Page.BytesPosEnd
+// pageCopy returns a heap without current page state so that pages decoded on
+// first access don't share state
+func (heap *Heap) pageCopy() *Heap {
+	return &Heap{
+		Args:                 heap.Args,
+		DecodePageHeaderData: heap.DecodePageHeaderData,
+		DecodePageSpecial:    heap.DecodePageSpecial,
 	}
+}
+
+// pageTuplesEnd returns byte position after the last tuple of page starting at
+// byte position pageBegin, or after the item ids if there are no tuples
+func pageTuplesEnd(d *decode.D, pageBegin int64) int64 {
+	header := d.BytesRange(pageBegin*8, SizeOfPageHeaderData)
+	lower := int64(binary.LittleEndian.Uint16(header[12:14]))
+	end := pageBegin + mathex.Max(lower, SizeOfPageHeaderData)
+	if lower <= SizeOfPageHeaderData || lower > common.PageSize {
+		return end
+	}
+	items := d.BytesRange((pageBegin+SizeOfPageHeaderData)*8, int(lower-SizeOfPageHeaderData)/4*4)
+	for i := 0; i+4 <= len(items); i += 4 {
+		itemIDData := binary.LittleEndian.Uint32(items[i : i+4])
+		off := int64(itemIDData & 0x7fff)
+		flags := (itemIDData >> 15) & 0x3
+		l := int64((itemIDData >> 17) & 0x7fff)
+		if off == 0 || l == 0 || flags != common.LP_NORMAL {
+			continue
+		}
+		end = mathex.Max(end, pageBegin+int64(common.TypeAlign8(uint64(off+l))))
+	}
+	return end
+}
+
+func decodeHeapPage(heap *Heap, d *decode.D, blockNumber uint32, bytesPosBegin int64) {
+	page := &HeapPage{}
+	page.BytesPosBegin = bytesPosBegin
 	page.BytesPosEnd = int64(common.TypeAlign(common.PageSize, uint64(page.BytesPosBegin)+1))
 	heap.Page = page
 	heap.Special = &PageSpecial{}
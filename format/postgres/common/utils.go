@@ -1,5 +1,11 @@
 package common
 
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
 func TypeAlign(alignVal uint64, alignLen uint64) uint64 {
 	return (alignLen + alignVal - 1) & ^(alignVal - 1)
 }
@@ -22,3 +28,13 @@ func IsMaskSet(value uint64, mask uint64) uint64 {
 func IsMaskSet0(value uint64, mask uint64) bool {
 	return (value & mask) > 0
 }
+
+// SegmentNumber returns segment number of a relation segment file name, ex:
+// 16994.1 is 1. false if name has no segment number.
+func SegmentNumber(name string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(name), "."))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
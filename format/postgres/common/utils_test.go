@@ -86,3 +86,23 @@ func TestIsMaskSet(t *testing.T) {
 		t.Errorf("mask must be 0\n")
 	}
 }
+
+func TestSegmentNumber(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected int
+		ok       bool
+	}{
+		{"16994", 0, false},
+		{"16994.1", 1, true},
+		{"base/5/16994.12", 12, true},
+		{"16994.fsm", 0, false},
+		{"16994.-1", 0, false},
+	}
+	for _, tc := range testCases {
+		n, ok := common.SegmentNumber(tc.name)
+		if n != tc.expected || ok != tc.ok {
+			t.Errorf("%s: expected %d %v got %d %v", tc.name, tc.expected, tc.ok, n, ok)
+		}
+	}
+}
@@ -7,14 +7,14 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 //go:embed pg_btree.md
 var pgBTreeFS embed.FS
 
 func init() {
-	interp.RegisterFormat(format.Pg_BTree, &decode.Format{
+	registry.RegisterFormat(format.Pg_BTree, &decode.Format{
 		Description: "PostgreSQL btree index file",
 		DecodeFn:    decodePgBTree,
 		DefaultInArg: format.Pg_BTree_In{
@@ -23,7 +23,7 @@ func init() {
 		RootArray: true,
 		RootName:  "pages",
 	})
-	interp.RegisterFS(pgBTreeFS)
+	registry.RegisterFS(pgBTreeFS)
 }
 
 func decodePgBTree(d *decode.D) any {
@@ -22,21 +22,21 @@ import (
 	"github.com/wader/fq/format/postgres/flavours/postgres13"
 	"github.com/wader/fq/format/postgres/flavours/postgres14"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 //go:embed pg_control.md
 var pgControlFS embed.FS
 
 func init() {
-	interp.RegisterFormat(format.Pg_Control, &decode.Format{
+	registry.RegisterFormat(format.Pg_Control, &decode.Format{
 		Description: "PostgreSQL control file",
 		DecodeFn:    decodePgControl,
 		DefaultInArg: format.Pg_Control_In{
 			Flavour: "",
 		},
 	})
-	interp.RegisterFS(pgControlFS)
+	registry.RegisterFS(pgControlFS)
 }
 
 const (
@@ -8,7 +8,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 // TO DO
@@ -18,7 +18,7 @@ import (
 var pgHeapFS embed.FS
 
 func init() {
-	interp.RegisterFormat(format.Pg_Heap, &decode.Format{
+	registry.RegisterFormat(format.Pg_Heap, &decode.Format{
 		Description: "PostgreSQL heap file",
 		DecodeFn:    decodePgheap,
 		DefaultInArg: format.Pg_Heap_In{
@@ -29,7 +29,7 @@ func init() {
 		RootArray: true,
 		RootName:  "pages",
 	})
-	interp.RegisterFS(pgHeapFS)
+	registry.RegisterFS(pgHeapFS)
 }
 
 func decodePgheap(d *decode.D) any {
@@ -9,7 +9,7 @@ import (
 	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -17,13 +17,13 @@ import (
 var protobufFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Protobuf,
 		&decode.Format{
 			Description: "Protobuf",
 			DecodeFn:    protobufDecode,
 		})
-	interp.RegisterFS(protobufFS)
+	registry.RegisterFS(protobufFS)
 }
 
 const (
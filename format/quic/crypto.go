@@ -0,0 +1,243 @@
+package quic
+
+// Packet protection
+// https://www.rfc-editor.org/rfc/rfc9001#section-5
+// https://www.rfc-editor.org/rfc/rfc9369#section-3.3 version 2 salt and labels
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	versionNegotiation = 0x0000_0000
+	version1           = 0x0000_0001
+	version2           = 0x6b33_43cf
+	versionDraft29     = 0xff00_001d
+	versionDraft30     = 0xff00_001e
+	versionDraft31     = 0xff00_001f
+	versionDraft32     = 0xff00_0020
+)
+
+type versionParams struct {
+	initialSalt []byte
+	labelPrefix string
+	// long header packet type bits for initial, 0-rtt, handshake and retry
+	packetTypes [4]uint64
+}
+
+var versions = map[uint64]versionParams{
+	version1: {
+		initialSalt: []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a},
+		labelPrefix: "quic",
+		packetTypes: [4]uint64{0, 1, 2, 3},
+	},
+	version2: {
+		initialSalt: []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9},
+		labelPrefix: "quicv2",
+		packetTypes: [4]uint64{1, 2, 3, 0},
+	},
+}
+
+func init() {
+	draft := versionParams{
+		initialSalt: []byte{0xaf, 0xbf, 0xec, 0x28, 0x99, 0x93, 0xd2, 0x4c, 0x9e, 0x97, 0x86, 0xf1, 0x9c, 0x61, 0x11, 0xe0, 0x43, 0x90, 0xa8, 0x99},
+		labelPrefix: "quic",
+		packetTypes: [4]uint64{0, 1, 2, 3},
+	}
+	for _, v := range []uint64{versionDraft29, versionDraft30, versionDraft31, versionDraft32} {
+		versions[v] = draft
+	}
+}
+
+// packetType returns version independent packet type for long header type bits
+func (vp versionParams) packetType(typeBits uint64) uint64 {
+	for i, t := range vp.packetTypes {
+		if t == typeBits {
+			return uint64(i)
+		}
+	}
+	panic("unreachable")
+}
+
+type cipherSuite struct {
+	hash   func() hash.Hash
+	keyLen int
+	aead   func(key []byte) (cipher.AEAD, error)
+	hp     func(key []byte) (func(sample []byte) []byte, error)
+}
+
+func aesGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.4.3
+func aesHP(key []byte) (func(sample []byte) []byte, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return func(sample []byte) []byte {
+		mask := make([]byte, aes.BlockSize)
+		b.Encrypt(mask, sample[0:aes.BlockSize])
+		return mask
+	}, nil
+}
+
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.4.4
+func chacha20HP(key []byte) (func(sample []byte) []byte, error) {
+	if len(key) != chacha20.KeySize {
+		return nil, errors.New("invalid chacha20 key size")
+	}
+	return func(sample []byte) []byte {
+		mask := make([]byte, 5)
+		c, err := chacha20.NewUnauthenticatedCipher(key, sample[4:16])
+		if err != nil {
+			panic(err)
+		}
+		c.SetCounter(binary.LittleEndian.Uint32(sample[0:4]))
+		c.XORKeyStream(mask, mask)
+		return mask
+	}, nil
+}
+
+const (
+	cipherSuiteTLS_AES_128_GCM_SHA256       = 0x1301
+	cipherSuiteTLS_AES_256_GCM_SHA384       = 0x1302
+	cipherSuiteTLS_CHACHA20_POLY1305_SHA256 = 0x1303
+)
+
+var cipherSuites = map[uint64]cipherSuite{
+	cipherSuiteTLS_AES_128_GCM_SHA256:       {hash: sha256.New, keyLen: 16, aead: aesGCM, hp: aesHP},
+	cipherSuiteTLS_AES_256_GCM_SHA384:       {hash: sha512.New384, keyLen: 32, aead: aesGCM, hp: aesHP},
+	cipherSuiteTLS_CHACHA20_POLY1305_SHA256: {hash: sha256.New, keyLen: 32, aead: chacha20poly1305.New, hp: chacha20HP},
+}
+
+// https://www.rfc-editor.org/rfc/rfc8446#section-7.1
+func hkdfExpandLabel(hash func() hash.Hash, secret []byte, label string, length int) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+	out := make([]byte, length)
+	if _, err := hkdf.Expand(hash, secret, b.BytesOrPanic()).Read(out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// keys for one direction and packet number space
+type keys struct {
+	vp     versionParams
+	suite  cipherSuite
+	secret []byte
+	aead   cipher.AEAD
+	iv     []byte
+	hp     func(sample []byte) []byte
+}
+
+func newKeys(vp versionParams, suite cipherSuite, secret []byte) (*keys, error) {
+	aead, err := suite.aead(hkdfExpandLabel(suite.hash, secret, vp.labelPrefix+" key", suite.keyLen))
+	if err != nil {
+		return nil, err
+	}
+	hp, err := suite.hp(hkdfExpandLabel(suite.hash, secret, vp.labelPrefix+" hp", suite.keyLen))
+	if err != nil {
+		return nil, err
+	}
+	return &keys{
+		vp:     vp,
+		suite:  suite,
+		secret: secret,
+		aead:   aead,
+		iv:     hkdfExpandLabel(suite.hash, secret, vp.labelPrefix+" iv", 12),
+		hp:     hp,
+	}, nil
+}
+
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.2
+func newInitialKeys(vp versionParams, dcid []byte, isClient bool) (*keys, error) {
+	suite := cipherSuites[cipherSuiteTLS_AES_128_GCM_SHA256]
+	initialSecret := hkdf.Extract(suite.hash, dcid, vp.initialSalt)
+	label := "server in"
+	if isClient {
+		label = "client in"
+	}
+	return newKeys(vp, suite, hkdfExpandLabel(suite.hash, initialSecret, label, suite.hash().Size()))
+}
+
+// next returns keys after a key update, header protection key is not updated
+// https://www.rfc-editor.org/rfc/rfc9001#section-6
+func (k *keys) next() (*keys, error) {
+	secret := hkdfExpandLabel(k.suite.hash, k.secret, k.vp.labelPrefix+" ku", k.suite.hash().Size())
+	nk, err := newKeys(k.vp, k.suite, secret)
+	if err != nil {
+		return nil, err
+	}
+	nk.hp = k.hp
+	return nk, nil
+}
+
+// unprotectHeader removes header protection in place and returns packet number length
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.4.1
+func (k *keys) unprotectHeader(packet []byte, pnOffset int, isLong bool) (int, error) {
+	const sampleLen = 16
+	if len(packet) < pnOffset+4+sampleLen {
+		return 0, fmt.Errorf("packet too short for header protection sample")
+	}
+	mask := k.hp(packet[pnOffset+4 : pnOffset+4+sampleLen])
+	if isLong {
+		packet[0] ^= mask[0] & 0x0f
+	} else {
+		packet[0] ^= mask[0] & 0x1f
+	}
+	pnLen := int(packet[0]&0x03) + 1
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return pnLen, nil
+}
+
+// open decrypts payload using header as associated data
+func (k *keys) open(pn uint64, header []byte, payload []byte) ([]byte, error) {
+	nonce := make([]byte, len(k.iv))
+	copy(nonce, k.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	return k.aead.Open(nil, nonce, payload, header)
+}
+
+// decodePacketNumber reconstructs full packet number from truncated packet number
+// https://www.rfc-editor.org/rfc/rfc9000#appendix-A.3
+func decodePacketNumber(largest int64, truncated uint64, pnLen int) uint64 {
+	expected := uint64(largest + 1)
+	win := uint64(1) << (pnLen * 8)
+	hwin := win / 2
+	mask := win - 1
+	candidate := (expected &^ mask) | truncated
+	if candidate+hwin <= expected && candidate < (1<<62)-win {
+		return candidate + win
+	}
+	if candidate > expected+hwin && candidate >= win {
+		return candidate - win
+	}
+	return candidate
+}
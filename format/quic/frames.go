@@ -0,0 +1,258 @@
+package quic
+
+// Frames
+// https://www.rfc-editor.org/rfc/rfc9000#section-19
+// https://www.rfc-editor.org/rfc/rfc9221#section-4 datagram
+
+import (
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	framePadding                  = 0x00
+	framePing                     = 0x01
+	frameAck                      = 0x02
+	frameAckECN                   = 0x03
+	frameResetStream              = 0x04
+	frameStopSending              = 0x05
+	frameCrypto                   = 0x06
+	frameNewToken                 = 0x07
+	frameStream                   = 0x08 // 0x08-0x0f
+	frameMaxData                  = 0x10
+	frameMaxStreamData            = 0x11
+	frameMaxStreamsBidi           = 0x12
+	frameMaxStreamsUni            = 0x13
+	frameDataBlocked              = 0x14
+	frameStreamDataBlocked        = 0x15
+	frameStreamsBlockedBidi       = 0x16
+	frameStreamsBlockedUni        = 0x17
+	frameNewConnectionID          = 0x18
+	frameRetireConnectionID       = 0x19
+	framePathChallenge            = 0x1a
+	framePathResponse             = 0x1b
+	frameConnectionClose          = 0x1c
+	frameConnectionCloseApp       = 0x1d
+	frameHandshakeDone            = 0x1e
+	frameDatagram                 = 0x30
+	frameDatagramWithLength       = 0x31
+	frameStreamBitOffset          = 0x04
+	frameStreamBitLength          = 0x02
+	frameStreamBitFin             = 0x01
+	frameStreamTypeMask           = 0xf8
+	transportErrorCryptoErrorBase = 0x0100
+)
+
+var frameTypeMap = scalar.UintMapSymStr{
+	framePadding:            "padding",
+	framePing:               "ping",
+	frameAck:                "ack",
+	frameAckECN:             "ack_ecn",
+	frameResetStream:        "reset_stream",
+	frameStopSending:        "stop_sending",
+	frameCrypto:             "crypto",
+	frameNewToken:           "new_token",
+	0x08:                    "stream",
+	0x09:                    "stream",
+	0x0a:                    "stream",
+	0x0b:                    "stream",
+	0x0c:                    "stream",
+	0x0d:                    "stream",
+	0x0e:                    "stream",
+	0x0f:                    "stream",
+	frameMaxData:            "max_data",
+	frameMaxStreamData:      "max_stream_data",
+	frameMaxStreamsBidi:     "max_streams_bidi",
+	frameMaxStreamsUni:      "max_streams_uni",
+	frameDataBlocked:        "data_blocked",
+	frameStreamDataBlocked:  "stream_data_blocked",
+	frameStreamsBlockedBidi: "streams_blocked_bidi",
+	frameStreamsBlockedUni:  "streams_blocked_uni",
+	frameNewConnectionID:    "new_connection_id",
+	frameRetireConnectionID: "retire_connection_id",
+	framePathChallenge:      "path_challenge",
+	framePathResponse:       "path_response",
+	frameConnectionClose:    "connection_close",
+	frameConnectionCloseApp: "connection_close_application",
+	frameHandshakeDone:      "handshake_done",
+	frameDatagram:           "datagram",
+	frameDatagramWithLength: "datagram",
+}
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-20.1
+var transportErrorMap = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	names := scalar.UintMapSymStr{
+		0x00: "no_error",
+		0x01: "internal_error",
+		0x02: "connection_refused",
+		0x03: "flow_control_error",
+		0x04: "stream_limit_error",
+		0x05: "stream_state_error",
+		0x06: "final_size_error",
+		0x07: "frame_encoding_error",
+		0x08: "transport_parameter_error",
+		0x09: "connection_id_limit_error",
+		0x0a: "protocol_violation",
+		0x0b: "invalid_token",
+		0x0c: "application_error",
+		0x0d: "crypto_buffer_exceeded",
+		0x0e: "key_update_error",
+		0x0f: "aead_limit_reached",
+		0x10: "no_viable_path",
+		0x11: "version_negotiation_error",
+	}
+	if s.Actual >= transportErrorCryptoErrorBase && s.Actual <= transportErrorCryptoErrorBase+0xff {
+		s.Sym = "crypto_error"
+		return s, nil
+	}
+	return names.MapUint(s)
+})
+
+var streamIDMapper = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	// https://www.rfc-editor.org/rfc/rfc9000#section-2.1
+	initiator := "client"
+	if s.Actual&0x1 != 0 {
+		initiator = "server"
+	}
+	dir := "bidi"
+	if s.Actual&0x2 != 0 {
+		dir = "uni"
+	}
+	s.Description = initiator + "_" + dir
+	return s, nil
+})
+
+// frameCtx is where frames of a packet gets recorded
+type frameCtx struct {
+	c        *conn
+	isClient bool
+	space    int
+}
+
+func fieldAckFrame(d *decode.D, frameType uint64) {
+	fieldVarint(d, "largest_acknowledged")
+	fieldVarint(d, "ack_delay")
+	rangeCount := fieldVarint(d, "ack_range_count")
+	fieldVarint(d, "first_ack_range")
+	d.FieldArray("ack_ranges", func(d *decode.D) {
+		for i := uint64(0); i < rangeCount; i++ {
+			d.FieldStruct("ack_range", func(d *decode.D) {
+				fieldVarint(d, "gap")
+				fieldVarint(d, "ack_range_length")
+			})
+		}
+	})
+	if frameType == frameAckECN {
+		d.FieldStruct("ecn_counts", func(d *decode.D) {
+			fieldVarint(d, "ect0_count")
+			fieldVarint(d, "ect1_count")
+			fieldVarint(d, "ecn_ce_count")
+		})
+	}
+}
+
+func (fc frameCtx) fieldFrame(d *decode.D) {
+	// padding is usually long runs of zero bytes, collect into one frame
+	if d.PeekUintBits(8) == framePadding {
+		fieldVarint(d, "type", frameTypeMap, scalar.UintHex)
+		n := int64(0)
+		for _, b := range d.PeekBytes(int(d.BitsLeft() / 8)) {
+			if b != 0 {
+				break
+			}
+			n++
+		}
+		if n > 0 {
+			d.FieldRawLen("padding", n*8)
+		}
+		return
+	}
+
+	frameType := fieldVarint(d, "type", frameTypeMap, scalar.UintHex)
+
+	switch {
+	case frameType == framePing,
+		frameType == frameHandshakeDone:
+	case frameType == frameAck,
+		frameType == frameAckECN:
+		fieldAckFrame(d, frameType)
+	case frameType == frameResetStream:
+		fieldVarint(d, "stream_id", streamIDMapper)
+		fieldVarint(d, "application_protocol_error_code", scalar.UintHex)
+		fieldVarint(d, "final_size")
+	case frameType == frameStopSending:
+		fieldVarint(d, "stream_id", streamIDMapper)
+		fieldVarint(d, "application_protocol_error_code", scalar.UintHex)
+	case frameType == frameCrypto:
+		offset := fieldVarint(d, "offset")
+		length := fieldVarint(d, "length")
+		data := d.ReadAllBits(d.FieldRawLen("data", int64(length)*8))
+		fc.c.addCrypto(fc.isClient, fc.space, offset, data)
+	case frameType == frameNewToken:
+		length := fieldVarint(d, "token_length")
+		d.FieldRawLen("token", int64(length)*8)
+	case frameType&frameStreamTypeMask == frameStream:
+		hasOffset := frameType&frameStreamBitOffset != 0
+		hasLength := frameType&frameStreamBitLength != 0
+		fin := frameType&frameStreamBitFin != 0
+		d.FieldValueBool("off", hasOffset)
+		d.FieldValueBool("len", hasLength)
+		d.FieldValueBool("fin", fin)
+		streamID := fieldVarint(d, "stream_id", streamIDMapper)
+		offset := uint64(0)
+		if hasOffset {
+			offset = fieldVarint(d, "offset")
+		}
+		length := uint64(d.BitsLeft() / 8)
+		if hasLength {
+			length = fieldVarint(d, "length")
+		}
+		data := d.ReadAllBits(d.FieldRawLen("data", int64(length)*8))
+		fc.c.addStream(fc.isClient, streamID, offset, data, fin)
+	case frameType == frameMaxData:
+		fieldVarint(d, "maximum_data")
+	case frameType == frameMaxStreamData:
+		fieldVarint(d, "stream_id", streamIDMapper)
+		fieldVarint(d, "maximum_stream_data")
+	case frameType == frameMaxStreamsBidi,
+		frameType == frameMaxStreamsUni:
+		fieldVarint(d, "maximum_streams")
+	case frameType == frameDataBlocked:
+		fieldVarint(d, "maximum_data")
+	case frameType == frameStreamDataBlocked:
+		fieldVarint(d, "stream_id", streamIDMapper)
+		fieldVarint(d, "maximum_stream_data")
+	case frameType == frameStreamsBlockedBidi,
+		frameType == frameStreamsBlockedUni:
+		fieldVarint(d, "maximum_streams")
+	case frameType == frameNewConnectionID:
+		fieldVarint(d, "sequence_number")
+		fieldVarint(d, "retire_prior_to")
+		length := d.FieldU8("length")
+		d.FieldRawLen("connection_id", int64(length)*8)
+		d.FieldRawLen("stateless_reset_token", 128)
+	case frameType == frameRetireConnectionID:
+		fieldVarint(d, "sequence_number")
+	case frameType == framePathChallenge,
+		frameType == framePathResponse:
+		d.FieldRawLen("data", 64)
+	case frameType == frameConnectionClose,
+		frameType == frameConnectionCloseApp:
+		if frameType == frameConnectionClose {
+			fieldVarint(d, "error_code", transportErrorMap, scalar.UintHex)
+			fieldVarint(d, "frame_type", frameTypeMap, scalar.UintHex)
+		} else {
+			fieldVarint(d, "error_code", scalar.UintHex)
+		}
+		length := fieldVarint(d, "reason_phrase_length")
+		d.FieldUTF8("reason_phrase", int(length))
+	case frameType == frameDatagram:
+		d.FieldRawLen("data", d.BitsLeft())
+	case frameType == frameDatagramWithLength:
+		length := fieldVarint(d, "length")
+		d.FieldRawLen("data", int64(length)*8)
+	default:
+		// unknown frame type, frame length is unknown so rest of packet
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
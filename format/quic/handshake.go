@@ -0,0 +1,334 @@
+package quic
+
+// TLS 1.3 handshake messages carried in CRYPTO frames
+// https://www.rfc-editor.org/rfc/rfc9001#section-4
+// https://www.rfc-editor.org/rfc/rfc8446#section-4
+
+import (
+	"github.com/wader/fq/format/tls/ciphersuites"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	handshakeClientHello         = 1
+	handshakeServerHello         = 2
+	handshakeNewSessionTicket    = 4
+	handshakeEncryptedExtensions = 8
+	handshakeCertificate         = 11
+	handshakeCertificateRequest  = 13
+	handshakeCertificateVerify   = 15
+	handshakeFinished            = 20
+)
+
+var handshakeTypeMap = scalar.UintMapSymStr{
+	handshakeClientHello:         "client_hello",
+	handshakeServerHello:         "server_hello",
+	handshakeNewSessionTicket:    "new_session_ticket",
+	handshakeEncryptedExtensions: "encrypted_extensions",
+	handshakeCertificate:         "certificate",
+	handshakeCertificateRequest:  "certificate_request",
+	handshakeCertificateVerify:   "certificate_verify",
+	handshakeFinished:            "finished",
+}
+
+const (
+	extensionServerName                          = 0
+	extensionStatusRequest                       = 5
+	extensionSupportedGroups                     = 10
+	extensionECPointFormats                      = 11
+	extensionSignatureAlgorithms                 = 13
+	extensionApplicationLayerProtocolNegotiation = 16
+	extensionSignedCertificateTimestamp          = 18
+	extensionExtendedMasterSecret                = 23
+	extensionPreSharedKey                        = 41
+	extensionEarlyData                           = 42
+	extensionSupportedVersions                   = 43
+	extensionPSKKeyExchangeModes                 = 45
+	extensionSignatureAlgorithmsCert             = 50
+	extensionKeyShare                            = 51
+	extensionQUICTransportParameters             = 57
+	extensionRenegotiationInfo                   = 0xff01
+)
+
+var extensionTypeMap = scalar.UintMapSymStr{
+	extensionServerName:                          "server_name",
+	extensionStatusRequest:                       "status_request",
+	extensionSupportedGroups:                     "supported_groups",
+	extensionECPointFormats:                      "ec_point_formats",
+	extensionSignatureAlgorithms:                 "signature_algorithms",
+	extensionApplicationLayerProtocolNegotiation: "application_layer_protocol_negotiation",
+	extensionSignedCertificateTimestamp:          "signed_certificate_timestamp",
+	extensionExtendedMasterSecret:                "extended_master_secret",
+	extensionPreSharedKey:                        "pre_shared_key",
+	extensionEarlyData:                           "early_data",
+	extensionSupportedVersions:                   "supported_versions",
+	extensionPSKKeyExchangeModes:                 "psk_key_exchange_modes",
+	extensionSignatureAlgorithmsCert:             "signature_algorithms_cert",
+	extensionKeyShare:                            "key_share",
+	extensionQUICTransportParameters:             "quic_transport_parameters",
+	extensionRenegotiationInfo:                   "renegotiation_info",
+}
+
+var tlsVersionMap = scalar.UintMapSymStr{
+	0x0303: "tls1.2",
+	0x0304: "tls1.3",
+}
+
+var namedGroupMap = scalar.UintMapSymStr{
+	0x0017: "secp256r1",
+	0x0018: "secp384r1",
+	0x0019: "secp521r1",
+	0x001d: "x25519",
+	0x001e: "x448",
+	0x11ec: "x25519mlkem768",
+}
+
+var cipherSuiteNames = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	if suit, ok := ciphersuites.Suits[int(s.Actual)]; ok {
+		s.Sym = suit.Name
+	}
+	return s, nil
+})
+
+const (
+	transportParameterOriginalDestinationConnectionID = 0x00
+	transportParameterStatelessResetToken             = 0x02
+	transportParameterDisableActiveMigration          = 0x0c
+	transportParameterPreferredAddress                = 0x0d
+	transportParameterInitialSourceConnectionID       = 0x0f
+	transportParameterRetrySourceConnectionID         = 0x10
+	transportParameterVersionInformation              = 0x11
+	transportParameterGreaseQUICBit                   = 0x2ab2
+)
+
+var transportParameterMap = scalar.UintMapSymStr{
+	0x00:   "original_destination_connection_id",
+	0x01:   "max_idle_timeout",
+	0x02:   "stateless_reset_token",
+	0x03:   "max_udp_payload_size",
+	0x04:   "initial_max_data",
+	0x05:   "initial_max_stream_data_bidi_local",
+	0x06:   "initial_max_stream_data_bidi_remote",
+	0x07:   "initial_max_stream_data_uni",
+	0x08:   "initial_max_streams_bidi",
+	0x09:   "initial_max_streams_uni",
+	0x0a:   "ack_delay_exponent",
+	0x0b:   "max_ack_delay",
+	0x0c:   "disable_active_migration",
+	0x0d:   "preferred_address",
+	0x0e:   "active_connection_id_limit",
+	0x0f:   "initial_source_connection_id",
+	0x10:   "retry_source_connection_id",
+	0x11:   "version_information",
+	0x20:   "max_datagram_frame_size",
+	0x2ab2: "grease_quic_bit",
+}
+
+// handshake state needed for decryption and stream decoding
+type handshakeInfo struct {
+	clientRandom []byte
+	cipherSuite  uint64
+	hasSuite     bool
+	alpn         string // selected protocol
+}
+
+func fieldTransportParameters(d *decode.D) {
+	d.FieldArray("transport_parameters", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("transport_parameter", func(d *decode.D) {
+				id := fieldVarint(d, "id", transportParameterMap, scalar.UintHex)
+				length := fieldVarint(d, "length")
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					switch id {
+					case transportParameterOriginalDestinationConnectionID,
+						transportParameterInitialSourceConnectionID,
+						transportParameterRetrySourceConnectionID,
+						transportParameterStatelessResetToken:
+						d.FieldRawLen("value", d.BitsLeft())
+					case transportParameterDisableActiveMigration,
+						transportParameterGreaseQUICBit:
+					case transportParameterPreferredAddress:
+						d.FieldU32("ipv4_address", scalar.UintHex)
+						d.FieldU16("ipv4_port")
+						d.FieldRawLen("ipv6_address", 128)
+						d.FieldU16("ipv6_port")
+						cidLen := d.FieldU8("connection_id_length")
+						d.FieldRawLen("connection_id", int64(cidLen)*8)
+						d.FieldRawLen("stateless_reset_token", 128)
+					case transportParameterVersionInformation:
+						d.FieldU32("chosen_version", versionMap, scalar.UintHex)
+						d.FieldArray("available_versions", func(d *decode.D) {
+							for !d.End() {
+								d.FieldU32("version", versionMap, scalar.UintHex)
+							}
+						})
+					default:
+						if _, ok := transportParameterMap[id]; ok {
+							fieldVarint(d, "value")
+						} else if d.BitsLeft() > 0 {
+							// reserved and unknown parameters
+							d.FieldRawLen("value", d.BitsLeft())
+						}
+					}
+				})
+			})
+		}
+	})
+}
+
+func fieldExtension(d *decode.D, hi *handshakeInfo, msgType uint64) {
+	typ := d.FieldU16("type", extensionTypeMap)
+	length := d.FieldU16("length")
+	if length == 0 {
+		return
+	}
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		switch typ {
+		case extensionServerName:
+			d.FieldU16("server_names_length")
+			d.FieldArray("server_names", func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("server_name", func(d *decode.D) {
+						d.FieldU8("type")
+						length := d.FieldU16("length")
+						d.FieldUTF8("name", int(length))
+					})
+				}
+			})
+		case extensionApplicationLayerProtocolNegotiation:
+			d.FieldU16("protocols_length")
+			d.FieldArray("protocols", func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("protocol", func(d *decode.D) {
+						length := d.FieldU8("length")
+						name := d.FieldUTF8("name", int(length))
+						// server selects one protocol
+						if msgType == handshakeEncryptedExtensions {
+							hi.alpn = name
+						}
+					})
+				}
+			})
+		case extensionSupportedVersions:
+			if msgType == handshakeServerHello {
+				d.FieldU16("version", tlsVersionMap, scalar.UintHex)
+				return
+			}
+			d.FieldU8("versions_length")
+			d.FieldArray("versions", func(d *decode.D) {
+				for !d.End() {
+					d.FieldU16("version", tlsVersionMap, scalar.UintHex)
+				}
+			})
+		case extensionSupportedGroups:
+			d.FieldU16("groups_length")
+			d.FieldArray("groups", func(d *decode.D) {
+				for !d.End() {
+					d.FieldU16("group", namedGroupMap, scalar.UintHex)
+				}
+			})
+		case extensionKeyShare:
+			fieldKeyShareEntry := func(d *decode.D) {
+				d.FieldU16("group", namedGroupMap, scalar.UintHex)
+				length := d.FieldU16("key_exchange_length")
+				d.FieldRawLen("key_exchange", int64(length)*8)
+			}
+			if msgType == handshakeServerHello {
+				fieldKeyShareEntry(d)
+				return
+			}
+			d.FieldU16("client_shares_length")
+			d.FieldArray("client_shares", func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("client_share", fieldKeyShareEntry)
+				}
+			})
+		case extensionQUICTransportParameters:
+			fieldTransportParameters(d)
+		default:
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+}
+
+func fieldExtensions(d *decode.D, hi *handshakeInfo, msgType uint64) {
+	length := d.FieldU16("extensions_length")
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		d.FieldArray("extensions", func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("extension", func(d *decode.D) { fieldExtension(d, hi, msgType) })
+			}
+		})
+	})
+}
+
+func fieldHandshakeMessage(d *decode.D, hi *handshakeInfo) {
+	msgType := d.FieldU8("type", handshakeTypeMap)
+	length := d.FieldU24("length")
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		switch msgType {
+		case handshakeClientHello,
+			handshakeServerHello:
+			d.FieldU16("version", tlsVersionMap, scalar.UintHex)
+			d.FieldRawLen("random", 32*8)
+			sessionIDLength := d.FieldU8("session_id_length")
+			d.FieldRawLen("session_id", int64(sessionIDLength)*8)
+			if msgType == handshakeServerHello {
+				d.FieldU16("cipher_suite", cipherSuiteNames, scalar.UintHex)
+				d.FieldU8("compression_method")
+			} else {
+				cipherSuitesLength := d.FieldU16("cipher_suites_length")
+				d.FramedFn(int64(cipherSuitesLength)*8, func(d *decode.D) {
+					d.FieldArray("cipher_suites", func(d *decode.D) {
+						for !d.End() {
+							d.FieldU16("cipher_suite", cipherSuiteNames, scalar.UintHex)
+						}
+					})
+				})
+				compressionMethodsLength := d.FieldU8("compression_methods_length")
+				d.FieldRawLen("compression_methods", int64(compressionMethodsLength)*8)
+			}
+			fieldExtensions(d, hi, msgType)
+		case handshakeEncryptedExtensions:
+			fieldExtensions(d, hi, msgType)
+		case handshakeNewSessionTicket:
+			d.FieldU32("lifetime")
+			d.FieldU32("age_add")
+			nonceLength := d.FieldU8("nonce_length")
+			d.FieldRawLen("nonce", int64(nonceLength)*8)
+			ticketLength := d.FieldU16("ticket_length")
+			d.FieldRawLen("ticket", int64(ticketLength)*8)
+			fieldExtensions(d, hi, msgType)
+		case handshakeFinished:
+			d.FieldRawLen("verify_data", d.BitsLeft())
+		default:
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+}
+
+// scanHello picks up client random and cipher suite needed to derive keys from
+// possibly incomplete crypto stream data
+func (hi *handshakeInfo) scanHello(bs []byte, isClient bool) {
+	// type, length, version, random
+	const randomOffset = 1 + 3 + 2
+	if len(bs) < randomOffset+32 {
+		return
+	}
+	switch {
+	case isClient && bs[0] == handshakeClientHello && hi.clientRandom == nil:
+		hi.clientRandom = append([]byte(nil), bs[randomOffset:randomOffset+32]...)
+	case !isClient && bs[0] == handshakeServerHello && !hi.hasSuite:
+		sessionIDOffset := randomOffset + 32
+		if len(bs) < sessionIDOffset+1 {
+			return
+		}
+		suiteOffset := sessionIDOffset + 1 + int(bs[sessionIDOffset])
+		if len(bs) < suiteOffset+2 {
+			return
+		}
+		hi.cipherSuite = uint64(bs[suiteOffset])<<8 | uint64(bs[suiteOffset+1])
+		hi.hasSuite = true
+	}
+}
@@ -0,0 +1,637 @@
+package quic
+
+// https://www.rfc-editor.org/rfc/rfc9000 QUIC transport
+// https://www.rfc-editor.org/rfc/rfc9001 Using TLS to secure QUIC
+// https://www.rfc-editor.org/rfc/rfc8999 Version independent properties
+// https://www.rfc-editor.org/rfc/rfc9369 QUIC version 2
+//
+// TODO: connection migration, multiple connection ids
+// TODO: keep previous keys to handle reordered packets around a key update
+
+import (
+	"embed"
+	"encoding/binary"
+	"sort"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/tls/keylog"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed quic.md
+var quicFS embed.FS
+
+var quicStreamsGroup decode.Group
+
+func init() {
+	registry.RegisterFormat(
+		format.QUIC,
+		&decode.Format{
+			Description:  "QUIC",
+			Groups:       []*decode.Group{format.UDP_Flow},
+			DecodeFn:     quicDecode,
+			DefaultInArg: format.TLS_In{},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.QUIC_Streams}, Out: &quicStreamsGroup},
+			},
+		})
+	registry.RegisterFS(quicFS)
+}
+
+// client initial packets has to be in datagrams of at least this size
+// https://www.rfc-editor.org/rfc/rfc9000#section-14.1
+const minInitialDatagramSize = 1200
+
+var versionMap = scalar.UintMapSymStr{
+	versionNegotiation: "version_negotiation",
+	version1:           "version_1",
+	version2:           "version_2",
+	versionDraft29:     "draft_29",
+	versionDraft30:     "draft_30",
+	versionDraft31:     "draft_31",
+	versionDraft32:     "draft_32",
+}
+
+var headerFormMap = scalar.UintMapSymStr{
+	0: "short",
+	1: "long",
+}
+
+const (
+	packetTypeInitial = iota
+	packetTypeZeroRTT
+	packetTypeHandshake
+	packetTypeRetry
+)
+
+var packetTypeMap = scalar.UintMapSymStr{
+	packetTypeInitial:   "initial",
+	packetTypeZeroRTT:   "zero_rtt",
+	packetTypeHandshake: "handshake",
+	packetTypeRetry:     "retry",
+}
+
+const (
+	spaceInitial = iota
+	spaceHandshake
+	spaceApplication
+	spaceCount
+)
+
+var packetSpaceMap = scalar.UintMapSymStr{
+	spaceInitial:     "initial",
+	spaceHandshake:   "handshake",
+	spaceApplication: "application",
+}
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-16
+func varint(d *decode.D) uint64 {
+	lengthBits := d.U2()
+	return d.U(int(8<<lengthBits) - 2)
+}
+
+func fieldVarint(d *decode.D, name string, sms ...scalar.UintMapper) uint64 {
+	return d.FieldUintFn(name, varint, sms...)
+}
+
+// reassembler collects possibly out of order data and keeps contiguous data from offset zero
+type reassembler struct {
+	buf       []byte
+	pending   []segment
+	finalSize int64
+}
+
+type segment struct {
+	offset uint64
+	data   []byte
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{finalSize: -1}
+}
+
+func (r *reassembler) add(offset uint64, data []byte) {
+	r.pending = append(r.pending, segment{offset: offset, data: data})
+	for progress := true; progress; {
+		progress = false
+		n := r.pending[:0]
+		for _, s := range r.pending {
+			end := s.offset + uint64(len(s.data))
+			switch {
+			case end <= uint64(len(r.buf)):
+				// retransmission
+			case s.offset <= uint64(len(r.buf)):
+				r.buf = append(r.buf, s.data[uint64(len(r.buf))-s.offset:]...)
+				progress = true
+			default:
+				n = append(n, s)
+			}
+		}
+		r.pending = n
+	}
+}
+
+func (r *reassembler) hasEnd() bool {
+	return r.finalSize != -1 && int64(len(r.buf)) == r.finalSize
+}
+
+// peer is state for packets sent by client or server
+type peer struct {
+	scid      []byte
+	keys      [spaceCount]*keys
+	nextKeys  *keys
+	keyPhase  uint64
+	largestPN [spaceCount]int64
+	crypto    [spaceCount]*reassembler
+}
+
+type streamKey struct {
+	id       uint64
+	isClient bool
+}
+
+type initialKeysKey struct {
+	version  uint64
+	isClient bool
+}
+
+type conn struct {
+	keylog       keylog.Map
+	version      uint64
+	initialDCID  []byte
+	resetInitial bool
+	initialKeys  map[initialKeysKey]*keys
+	earlyKeys    *keys
+	hi           handshakeInfo
+	client       peer
+	server       peer
+	streams      map[streamKey]*reassembler
+}
+
+func newConn(km keylog.Map) *conn {
+	c := &conn{
+		keylog:      km,
+		initialKeys: map[initialKeysKey]*keys{},
+		streams:     map[streamKey]*reassembler{},
+	}
+	for _, p := range []*peer{&c.client, &c.server} {
+		for i := 0; i < spaceCount; i++ {
+			p.largestPN[i] = -1
+			p.crypto[i] = newReassembler()
+		}
+	}
+	return c
+}
+
+func (c *conn) peer(isClient bool) *peer {
+	if isClient {
+		return &c.client
+	}
+	return &c.server
+}
+
+func (c *conn) addCrypto(isClient bool, space int, offset uint64, data []byte) {
+	r := c.peer(isClient).crypto[space]
+	r.add(offset, data)
+	if space == spaceInitial {
+		c.hi.scanHello(r.buf, isClient)
+	}
+}
+
+func (c *conn) addStream(isClient bool, id uint64, offset uint64, data []byte, fin bool) {
+	k := streamKey{id: id, isClient: isClient}
+	r, ok := c.streams[k]
+	if !ok {
+		r = newReassembler()
+		c.streams[k] = r
+	}
+	r.add(offset, data)
+	if fin {
+		r.finalSize = int64(offset) + int64(len(data))
+	}
+}
+
+func (c *conn) initialKeysFor(version uint64, isClient bool) *keys {
+	k := initialKeysKey{version: version, isClient: isClient}
+	if ik, ok := c.initialKeys[k]; ok {
+		return ik
+	}
+	ik, err := newInitialKeys(versions[version], c.initialDCID, isClient)
+	if err != nil {
+		return nil
+	}
+	c.initialKeys[k] = ik
+	return ik
+}
+
+func (c *conn) secret(label int) ([]byte, bool) {
+	if c.keylog == nil || c.hi.clientRandom == nil {
+		return nil, false
+	}
+	var clientRandom [32]byte
+	copy(clientRandom[:], c.hi.clientRandom)
+	return c.keylog.Lookup(label, clientRandom)
+}
+
+// candidateKeys returns keys to try to decrypt a packet with
+func (c *conn) candidateKeys(version uint64, isClient bool, packetType uint64, isLong bool) []*keys {
+	p := c.peer(isClient)
+
+	if !isLong {
+		if k := p.keys[spaceApplication]; k != nil {
+			return []*keys{k}
+		}
+		label := keylog.ServerTrafficSecret0
+		if isClient {
+			label = keylog.ClientTrafficSecret0
+		}
+		return c.suiteKeys(version, label, func(k *keys) { p.keys[spaceApplication] = k })
+	}
+
+	switch packetType {
+	case packetTypeInitial:
+		if k := c.initialKeysFor(version, isClient); k != nil {
+			return []*keys{k}
+		}
+	case packetTypeHandshake:
+		if k := p.keys[spaceHandshake]; k != nil {
+			return []*keys{k}
+		}
+		label := keylog.ServerHandshakeTrafficSecret
+		if isClient {
+			label = keylog.ClientHandshakeTrafficSecret
+		}
+		return c.suiteKeys(version, label, func(k *keys) { p.keys[spaceHandshake] = k })
+	case packetTypeZeroRTT:
+		if c.earlyKeys != nil {
+			return []*keys{c.earlyKeys}
+		}
+		// server hello with selected cipher suite is usually not seen yet so try all
+		var ks []*keys
+		secret, ok := c.secret(keylog.ClientEarlyTrafficSecret)
+		if !ok {
+			return nil
+		}
+		for _, id := range []uint64{cipherSuiteTLS_AES_128_GCM_SHA256, cipherSuiteTLS_AES_256_GCM_SHA384, cipherSuiteTLS_CHACHA20_POLY1305_SHA256} {
+			if k, err := newKeys(versions[version], cipherSuites[id], secret); err == nil {
+				ks = append(ks, k)
+			}
+		}
+		return ks
+	}
+
+	return nil
+}
+
+// suiteKeys returns keys for keylog secret using negotiated cipher suite, setFn is called
+// if keys could be created so they can be reused
+func (c *conn) suiteKeys(version uint64, label int, setFn func(k *keys)) []*keys {
+	suite, ok := cipherSuites[c.hi.cipherSuite]
+	if !c.hi.hasSuite || !ok {
+		return nil
+	}
+	secret, ok := c.secret(label)
+	if !ok {
+		return nil
+	}
+	k, err := newKeys(versions[version], suite, secret)
+	if err != nil {
+		return nil
+	}
+	setFn(k)
+	return []*keys{k}
+}
+
+type openResult struct {
+	header []byte
+	pnLen  int
+	pn     uint64
+	plain  []byte
+	keys   *keys
+}
+
+// open removes header protection and decrypts packet using first keys that works
+func (c *conn) open(candidates []*keys, p *peer, space int, packet []byte, pnOffset int, isLong bool) (openResult, bool) {
+	for _, k := range candidates {
+		unprotected := append([]byte(nil), packet...)
+		pnLen, err := k.unprotectHeader(unprotected, pnOffset, isLong)
+		if err != nil {
+			continue
+		}
+		truncated := uint64(0)
+		for _, b := range unprotected[pnOffset : pnOffset+pnLen] {
+			truncated = truncated<<8 | uint64(b)
+		}
+		pn := decodePacketNumber(p.largestPN[space], truncated, pnLen)
+		header := unprotected[0 : pnOffset+pnLen]
+		payload := unprotected[pnOffset+pnLen:]
+
+		ok := false
+		keys := k
+		if !isLong && uint64(header[0]>>2)&0x1 != p.keyPhase {
+			// key update, header protection key stays the same
+			// https://www.rfc-editor.org/rfc/rfc9001#section-6
+			if p.nextKeys == nil {
+				p.nextKeys, _ = k.next()
+			}
+			if p.nextKeys != nil {
+				keys = p.nextKeys
+			}
+		}
+		plain, err := keys.open(pn, header, payload)
+		if err == nil {
+			ok = true
+		}
+		if !ok {
+			continue
+		}
+
+		if keys != k {
+			p.keys[spaceApplication] = keys
+			p.nextKeys = nil
+			p.keyPhase ^= 1
+		}
+		if int64(pn) > p.largestPN[space] {
+			p.largestPN[space] = int64(pn)
+		}
+
+		return openResult{
+			header: header,
+			pnLen:  pnLen,
+			pn:     pn,
+			plain:  plain,
+			keys:   keys,
+		}, true
+	}
+
+	return openResult{}, false
+}
+
+// fieldProtectedPayload decodes packet number and payload of a long header packet with
+// a packet number or a short header packet. length is in bytes from current position.
+func (c *conn) fieldProtectedPayload(d *decode.D, start int64, isClient bool, version uint64, packetType uint64, isLong bool, length int64) {
+	space := spaceApplication
+	if isLong {
+		switch packetType {
+		case packetTypeInitial:
+			space = spaceInitial
+		case packetTypeHandshake:
+			space = spaceHandshake
+		}
+	}
+	p := c.peer(isClient)
+
+	pnOffset := int((d.Pos() - start) / 8)
+	packet := d.BytesRange(start, pnOffset+int(length))
+	r, ok := c.open(c.candidateKeys(version, isClient, packetType, isLong), p, space, packet, pnOffset, isLong)
+	if !ok {
+		d.FieldRawLen("protected_payload", length*8)
+		return
+	}
+	if packetType == packetTypeZeroRTT && isLong {
+		c.earlyKeys = r.keys
+	}
+
+	if isLong {
+		d.FieldValueUint("reserved_bits", uint64(r.header[0]>>2)&0x3)
+	} else {
+		d.FieldValueUint("reserved_bits", uint64(r.header[0]>>3)&0x3)
+		d.FieldValueUint("key_phase", uint64(r.header[0]>>2)&0x1)
+	}
+	d.FieldValueUint("packet_number_length", uint64(r.pnLen))
+	d.FieldValueUint("packet_number", r.pn)
+	d.FieldRawLen("protected_packet_number", int64(r.pnLen)*8)
+	d.FieldRawLen("protected_payload", (length-int64(r.pnLen))*8)
+
+	// struct keeps decrypted frames after header fields as they are sorted by position
+	fc := frameCtx{c: c, isClient: isClient, space: space}
+	d.FieldStruct("payload", func(d *decode.D) {
+		d.FieldArrayRootBitBufFn("frames", bitio.NewBitReader(r.plain, -1), func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("frame", fc.fieldFrame)
+			}
+		})
+	})
+}
+
+func (c *conn) fieldConnectionID(d *decode.D, name string) []byte {
+	length := d.FieldU8(name + "_length")
+	return d.ReadAllBits(d.FieldRawLen(name, int64(length)*8))
+}
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-17.2
+func (c *conn) fieldLongHeaderPacket(d *decode.D, isClient bool) {
+	start := d.Pos()
+	version := binary.BigEndian.Uint32(d.PeekBytes(5)[1:5])
+	vp, knownVersion := versions[uint64(version)]
+
+	d.FieldU1("header_form", headerFormMap)
+	if version == versionNegotiation {
+		// https://www.rfc-editor.org/rfc/rfc9000#section-17.2.1
+		d.FieldU7("unused")
+		d.FieldU32("version", versionMap, scalar.UintHex)
+		c.fieldConnectionID(d, "dcid")
+		c.fieldConnectionID(d, "scid")
+		d.FieldArray("supported_versions", func(d *decode.D) {
+			for !d.End() {
+				d.FieldU32("version", versionMap, scalar.UintHex)
+			}
+		})
+		// client will start over with new version and possibly new connection id
+		c.resetInitial = true
+		return
+	}
+	if !knownVersion {
+		d.FieldU7("version_specific_bits")
+		d.FieldU32("version", versionMap, scalar.UintHex)
+		c.fieldConnectionID(d, "dcid")
+		c.fieldConnectionID(d, "scid")
+		d.FieldRawLen("data", d.BitsLeft())
+		return
+	}
+
+	d.FieldU1("fixed_bit")
+	packetType := vp.packetType(d.FieldU2("long_packet_type", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		s.Sym = packetTypeMap[vp.packetType(s.Actual)]
+		return s, nil
+	})))
+	if packetType == packetTypeRetry {
+		d.FieldU4("unused")
+	} else {
+		d.FieldU4("protected_bits")
+	}
+	d.FieldU32("version", versionMap, scalar.UintHex)
+	dcid := c.fieldConnectionID(d, "dcid")
+	scid := c.fieldConnectionID(d, "scid")
+
+	c.peer(isClient).scid = scid
+	c.version = uint64(version)
+
+	switch packetType {
+	case packetTypeRetry:
+		// https://www.rfc-editor.org/rfc/rfc9000#section-17.2.5
+		const integrityTagLen = 128
+		d.FieldRawLen("retry_token", d.BitsLeft()-integrityTagLen)
+		d.FieldRawLen("retry_integrity_tag", integrityTagLen)
+		// client initial keys are now derived from connection id chosen by server
+		c.resetInitial = true
+		return
+	case packetTypeInitial:
+		if isClient && (c.initialDCID == nil || c.resetInitial) {
+			c.initialDCID = dcid
+			c.initialKeys = map[initialKeysKey]*keys{}
+			c.resetInitial = false
+		}
+		tokenLength := fieldVarint(d, "token_length")
+		d.FieldRawLen("token", int64(tokenLength)*8)
+	}
+
+	length := fieldVarint(d, "length")
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		c.fieldProtectedPayload(d, start, isClient, uint64(version), packetType, true, int64(length))
+	})
+}
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-17.3
+func (c *conn) fieldShortHeaderPacket(d *decode.D, isClient bool) {
+	start := d.Pos()
+
+	d.FieldU1("header_form", headerFormMap)
+	d.FieldU1("fixed_bit")
+	d.FieldU1("spin_bit")
+	d.FieldU5("protected_bits")
+	// destination connection id length is not in short header, is the peers source connection id
+	peerSCID := c.peer(!isClient).scid
+	if peerSCID == nil {
+		d.FieldRawLen("data", d.BitsLeft())
+		return
+	}
+	d.FieldRawLen("dcid", int64(len(peerSCID))*8)
+
+	c.fieldProtectedPayload(d, start, isClient, c.version, 0, false, d.BitsLeft()/8)
+}
+
+func quicDecode(d *decode.D) any {
+	var ufi format.UDP_Flow_In
+	if !d.ArgAs(&ufi) || len(ufi.Datagrams) == 0 {
+		d.Fatalf("quic requires udp flow datagrams")
+	}
+	var ti format.TLS_In
+	d.ArgAs(&ti)
+
+	// first datagram should be a client initial packet
+	first := ufi.Datagrams[0]
+	if !first.IsClient || first.Length < minInitialDatagramSize {
+		d.Fatalf("first datagram is not a client initial")
+	}
+	header := d.PeekBytes(5)
+	vp, ok := versions[uint64(binary.BigEndian.Uint32(header[1:5]))]
+	if header[0]&0xc0 != 0xc0 || !ok || vp.packetType(uint64(header[0]>>4)&0x3) != packetTypeInitial {
+		d.Fatalf("first datagram is not a client initial")
+	}
+
+	var km keylog.Map
+	if ti.Keylog != "" {
+		var err error
+		km, err = keylog.Parse(ti.Keylog)
+		if err != nil {
+			d.Fatalf("failed to parse keylog: %s", err)
+		}
+	}
+
+	c := newConn(km)
+
+	d.FieldArray("datagrams", func(d *decode.D) {
+		for _, dg := range ufi.Datagrams {
+			d.FieldStruct("datagram", func(d *decode.D) {
+				d.FieldValueUint("packet_index", uint64(dg.PacketIndex))
+				d.FieldValueBool("is_client", dg.IsClient)
+				d.FramedFn(dg.Length*8, func(d *decode.D) {
+					d.FieldArray("packets", func(d *decode.D) {
+						// multiple packets can be coalesced into one datagram
+						// https://www.rfc-editor.org/rfc/rfc9000#section-12.2
+						for !d.End() && d.PeekUintBits(8) != 0 {
+							d.FieldStruct("packet", func(d *decode.D) {
+								if d.PeekUintBits(1) == 1 {
+									c.fieldLongHeaderPacket(d, dg.IsClient)
+								} else {
+									c.fieldShortHeaderPacket(d, dg.IsClient)
+								}
+							})
+						}
+					})
+					if !d.End() {
+						d.FieldRawLen("padding", d.BitsLeft())
+					}
+				})
+			})
+		}
+	})
+
+	d.FieldArray("crypto_streams", func(d *decode.D) {
+		for _, isClient := range []bool{true, false} {
+			for space := 0; space < spaceCount; space++ {
+				r := c.peer(isClient).crypto[space]
+				if len(r.buf) == 0 {
+					continue
+				}
+				d.FieldStruct("crypto_stream", func(d *decode.D) {
+					d.FieldValueBool("is_client", isClient)
+					d.FieldValueUint("packet_space", uint64(space), packetSpaceMap)
+					d.FieldArrayRootBitBufFn("messages", bitio.NewBitReader(r.buf, -1), func(d *decode.D) {
+						for !d.End() {
+							// handshake message header is type and 24 bit length
+							if d.BitsLeft() < 32 || (int64(d.PeekUintBits(32)&0xff_ff_ff)+4)*8 > d.BitsLeft() {
+								d.FieldRawLen("incomplete", d.BitsLeft())
+								break
+							}
+							d.FieldStruct("message", func(d *decode.D) { fieldHandshakeMessage(d, &c.hi) })
+						}
+					})
+				})
+			}
+		}
+	})
+
+	var streamKeys []streamKey
+	for k := range c.streams {
+		streamKeys = append(streamKeys, k)
+	}
+	sort.Slice(streamKeys, func(i, j int) bool {
+		if streamKeys[i].id != streamKeys[j].id {
+			return streamKeys[i].id < streamKeys[j].id
+		}
+		return streamKeys[i].isClient && !streamKeys[j].isClient
+	})
+
+	qsi := format.QUIC_Streams_In{ALPN: c.hi.alpn}
+	var streamsBuf []byte
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, k := range streamKeys {
+			r := c.streams[k]
+			d.FieldStruct("stream", func(d *decode.D) {
+				d.FieldValueUint("stream_id", k.id, streamIDMapper)
+				d.FieldValueBool("is_client", k.isClient)
+				d.FieldValueBool("has_end", r.hasEnd())
+				d.FieldRootBitBuf("data", bitio.NewBitReader(r.buf, -1))
+			})
+			qsi.Streams = append(qsi.Streams, format.QUIC_Stream{
+				ID:       k.id,
+				IsClient: k.isClient,
+				HasEnd:   r.hasEnd(),
+				Length:   int64(len(r.buf)),
+			})
+			streamsBuf = append(streamsBuf, r.buf...)
+		}
+	})
+
+	if len(qsi.Streams) > 0 {
+		// decode application protocol, ex: http3
+		d.TryFieldFormatBitBuf("application", bitio.NewBitReader(streamsBuf, -1), &quicStreamsGroup, qsi)
+	}
+
+	return nil
+}
@@ -22,8 +22,8 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -36,7 +36,7 @@ var aviMpegHEVCAUGroup decode.Group
 var aviFLACFrameGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.AVI,
 		&decode.Format{
 			Description: "Audio Video Interleaved",
@@ -52,7 +52,7 @@ func init() {
 			},
 			Groups: []*decode.Group{format.Probe},
 		})
-	interp.RegisterFS(aviFS)
+	registry.RegisterFS(aviFS)
 }
 
 var aviListTypeDescriptions = scalar.StrMapDescription{
@@ -10,7 +10,7 @@ package riff
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -18,7 +18,7 @@ var wavHeaderGroup decode.Group
 var wavFooterGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.WAV,
 		&decode.Format{
 			ProbeOrder:  format.ProbeOrderBinFuzzy, // after most others (overlap some with webp)
@@ -14,7 +14,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -25,7 +25,7 @@ var rtmpMpegASCGroup decode.Group
 var rtmpFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.RTMP,
 		&decode.Format{
 			Description: "Real-Time Messaging Protocol",
@@ -38,7 +38,7 @@ func init() {
 				{Groups: []*decode.Group{format.MPEG_ASC}, Out: &rtmpMpegASCGroup},
 			},
 		})
-	interp.RegisterFS(rtmpFS)
+	registry.RegisterFS(rtmpFS)
 }
 
 // from RTMP spec
@@ -0,0 +1,230 @@
+package format_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+type schemaTest struct {
+	fuzzTest
+	args   []string
+	stdout *bytes.Buffer
+}
+
+func (st *schemaTest) Args() []string { return st.args }
+func (st *schemaTest) Stdin() interp.Input {
+	return fuzzTestInput{FileReader: interp.FileReader{R: &bytes.Buffer{}}}
+}
+func (st *schemaTest) Stdout() interp.Output { return fuzzTestOutput{st.stdout} }
+func (st *schemaTest) FS() fs.FS             { return osFS{} }
+
+// schemaTestRun runs fq and returns JSON outputs
+func schemaTestRun(t *testing.T, args ...string) []any {
+	t.Helper()
+	st := &schemaTest{args: append([]string{"fq", "-c"}, args...), stdout: &bytes.Buffer{}}
+	q, err := interp.New(st, interp.DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Main(context.Background(), st.Stdout(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	var vs []any
+	jd := json.NewDecoder(st.stdout)
+	jd.UseNumber()
+	for jd.More() {
+		var v any
+		if err := jd.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+// schemaValidate validates v using the subset of JSON schema generated by
+// format_schema. It's stricter than JSON schema as properties not in the
+// schema are errors unless additionalProperties is true, this is to catch a
+// static schema not matching the decoder
+func schemaValidate(root map[string]any, s map[string]any, v any, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		def, ok := root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %s not found", path, ref)
+		}
+		return schemaValidate(root, def, v, path)
+	}
+
+	if alts, ok := s["anyOf"].([]any); ok {
+		var errs []string
+		for _, a := range alts {
+			err := schemaValidate(root, a.(map[string]any), v, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s: no alternative matched: %s", path, strings.Join(errs, ", "))
+	}
+
+	if enum, ok := s["enum"].([]any); ok {
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %v not in enum", path, v)
+	}
+
+	typ, _ := s["type"].(string)
+	switch typ {
+	case "":
+		return nil
+	case "object":
+		o, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object got %v", path, v)
+		}
+		props, _ := s["properties"].(map[string]any)
+		req, _ := s["required"].([]any)
+		for _, r := range req {
+			if _, ok := o[r.(string)]; !ok {
+				return fmt.Errorf("%s: required property %s missing", path, r)
+			}
+		}
+		var keys []string
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ps, ok := props[k].(map[string]any)
+			if !ok {
+				if s["additionalProperties"] == true {
+					continue
+				}
+				return fmt.Errorf("%s: property %s not in schema", path, k)
+			}
+			if err := schemaValidate(root, ps, o[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+	case "array":
+		a, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array got %v", path, v)
+		}
+		items, _ := s["items"].(map[string]any)
+		for i, e := range a {
+			if err := schemaValidate(root, items, e, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected %s got %v", path, typ, v)
+		}
+		if typ == "integer" && strings.ContainsAny(n.String(), ".eE") {
+			return fmt.Errorf("%s: expected integer got %v", path, v)
+		}
+		if _, ok := s["minimum"]; ok && strings.HasPrefix(n.String(), "-") {
+			return fmt.Errorf("%s: expected non-negative got %v", path, v)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected string got %v", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean got %v", path, v)
+		}
+	case "null":
+		if v != nil {
+			return fmt.Errorf("%s: expected null got %v", path, v)
+		}
+	default:
+		return fmt.Errorf("%s: unknown type %s", path, typ)
+	}
+
+	return nil
+}
+
+// decoded testdata for formats with a static schema should match the schema
+func TestFormatSchemaStatic(t *testing.T) {
+	for _, f := range interp.DefaultRegistry.MustAll().Formats {
+		if f.Schema == nil {
+			continue
+		}
+		f := f
+		t.Run(f.Name, func(t *testing.T) {
+			filenames, err := filepath.Glob(filepath.Join(f.Name, "testdata", "*."+f.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(filenames) == 0 {
+				t.Fatalf("no %s testdata files", f.Name)
+			}
+			schema := schemaTestRun(t, "-n", fmt.Sprintf("%q | format_schema", f.Name))[0].(map[string]any)
+			for _, filename := range filenames {
+				v := schemaTestRun(t, "-d", f.Name, "tovalue", filename)[0]
+				if err := schemaValidate(schema, schema, v, filename); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+// schema derived from decoded files should be a non-stub schema that match the files
+func TestFormatSchemaDerived(t *testing.T) {
+	filenames, err := filepath.Glob(filepath.Join("mp3", "testdata", "*.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := schemaTestRun(t, append([]string{"--format-schema", "mp3"}, filenames...)...)[0].(map[string]any)
+	def := schema["$defs"].(map[string]any)["mp3"].(map[string]any)
+	if def["type"] != "object" || def["properties"] == nil {
+		t.Fatalf("expected derived mp3 schema got %v", def)
+	}
+	for _, filename := range filenames {
+		v := schemaTestRun(t, "-d", "mp3", "tovalue", filename)[0]
+		if err := schemaValidate(schema, schema, v, filename); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// frame missing required fields should not match
+	v := map[string]any{"headers": []any{}, "frames": []any{map[string]any{"nope": true}}, "footers": []any{}}
+	if err := schemaValidate(schema, schema, v, "nope"); err == nil {
+		t.Error("expected validation error")
+	}
+}
+
+// static schema with unknown fields should not match unless it's open, ex mp4 box
+func TestFormatSchemaStaticOpen(t *testing.T) {
+	schema := schemaTestRun(t, "--format-schema", "mp4")[0].(map[string]any)
+	box := map[string]any{"size": json.Number("8"), "type": "free", "some_field": json.Number("1")}
+	v := map[string]any{"boxes": []any{map[string]any{"size": json.Number("8"), "type": "moov", "boxes": []any{box}}}}
+	if err := schemaValidate(schema, schema, v, "box"); err != nil {
+		t.Error(err)
+	}
+	v["nope"] = true
+	if err := schemaValidate(schema, schema, v, "root"); err == nil {
+		t.Error("expected validation error")
+	}
+	delete(v, "nope")
+	delete(box, "type")
+	if err := schemaValidate(schema, schema, v, "box"); err == nil {
+		t.Error("expected validation error for nested box missing type")
+	}
+}
@@ -0,0 +1,86 @@
+package format_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+type streamTestOutput struct {
+	mu  sync.Mutex
+	b   []byte
+	out chan struct{}
+}
+
+func (o *streamTestOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.b) == 0 {
+		close(o.out)
+	}
+	o.b = append(o.b, p...)
+	return len(p), nil
+}
+
+type streamTest struct {
+	fuzzTest
+	args   []string
+	stdin  io.Reader
+	stdout *streamTestOutput
+}
+
+func (st *streamTest) Args() []string { return st.args }
+func (st *streamTest) Stdin() interp.Input {
+	return fuzzTestInput{FileReader: interp.FileReader{R: st.stdin}}
+}
+func (st *streamTest) Stdout() interp.Output { return fuzzTestOutput{st.stdout} }
+
+// probing a stream without -d should not wait for more input than the first
+// chunk, ex: a live capture
+func TestStreamProbeLiveInput(t *testing.T) {
+	b, err := os.ReadFile("pcap/testdata/ipv4frags.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// header and first packet, incl_len is at 24+8
+	firstLen := 24 + 16 + (int(b[32]) | int(b[33])<<8)
+
+	pr, pw := io.Pipe()
+	st := &streamTest{
+		args:   []string{"fq", "--stream-decode", ".packets[0].packet | ._len"},
+		stdin:  pr,
+		stdout: &streamTestOutput{out: make(chan struct{})},
+	}
+	q, err := interp.New(st, interp.DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- q.Main(context.Background(), st.Stdout(), "test")
+	}()
+
+	if _, err := pw.Write(b[0:firstLen]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-st.stdout.out:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no output for first packet")
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	st.stdout.mu.Lock()
+	defer st.stdout.mu.Unlock()
+	if string(st.stdout.b) != "8080\n" {
+		t.Fatalf("unexpected output %q", st.stdout.b)
+	}
+}
@@ -5,18 +5,21 @@ package tar
 
 import (
 	"bytes"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var probeGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.TAR,
 		&decode.Format{
 			Description: "Tar archive",
@@ -43,58 +46,91 @@ func tarDecode(d *decode.D) any {
 	endMarker := [blockBytes * 2]byte{}
 	var endMarkerStart int64
 	var endMarkerEnd int64
-	filesCount := 0
-
-	d.FieldArray("files", func(d *decode.D) {
-		for !d.End() {
-			d.FieldStruct("file", func(d *decode.D) {
-				d.FieldUTF8("name", 100, mapTrimSpaceNull)
-				d.FieldUTF8NullFixedLen("mode", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8NullFixedLen("uid", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8NullFixedLen("gid", 8, scalar.TryStrSymParseUint(8))
-				size, sizeOk := d.FieldScalarUTF8NullFixedLen("size", 12, scalar.TryStrSymParseUint(8)).TrySymUint()
-				if !sizeOk {
-					d.Fatalf("could not decode size")
-				}
-				size *= 8
-				d.FieldUTF8NullFixedLen("mtime", 12, scalar.TryStrSymParseUint(8), scalar.StrFn(func(s scalar.Str) (scalar.Str, error) {
-					// TODO: string might not be a number, move to scalar?
-					if v, ok := s.TrySymUint(); ok {
-						s.Description = unixTimeEpochDate.Add(time.Duration(v) * time.Second).Format(time.RFC3339)
-					}
-					return s, nil
-				}))
-				d.FieldUTF8NullFixedLen("chksum", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8("typeflag", 1, mapTrimSpaceNull)
-				d.FieldUTF8("linkname", 100, mapTrimSpaceNull)
-				d.FieldUTF8("magic", 6, mapTrimSpaceNull, d.StrAssert("ustar"))
-				d.FieldUTF8NullFixedLen("version", 2, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8("uname", 32, mapTrimSpaceNull)
-				d.FieldUTF8("gname", 32, mapTrimSpaceNull)
-				d.FieldUTF8NullFixedLen("devmajor", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8NullFixedLen("devminor", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8("prefix", 155, mapTrimSpaceNull)
-				d.FieldRawLen("header_block_padding", blockPadding(d), d.BitBufIsZero())
-
-				d.FieldFormatOrRawLen("data", int64(size), &probeGroup, nil)
-
-				d.FieldRawLen("data_block_padding", blockPadding(d), d.BitBufIsZero())
-			})
-			filesCount++
-
-			if d.BitsLeft() >= int64(len(endMarker))*8 && bytes.Equal(d.PeekBytes(len(endMarker)), endMarker[:]) {
-				endMarkerStart = d.Pos()
-				// consensus seems to be to allow more than 2 zero blocks at end
-				d.SeekRel(int64(len(endMarker)) * 8)
-				zeroBlock := [blockBytes]byte{}
-				for d.BitsLeft() >= blockBytes*8 && bytes.Equal(d.PeekBytes(blockBytes), zeroBlock[:]) {
-					d.SeekRel(int64(len(zeroBlock)) * 8)
+
+	decodeFile := func(d *decode.D) {
+		d.FieldStruct("file", func(d *decode.D) {
+			d.FieldUTF8("name", 100, mapTrimSpaceNull)
+			d.FieldUTF8NullFixedLen("mode", 8, scalar.TryStrSymParseUint(8))
+			d.FieldUTF8NullFixedLen("uid", 8, scalar.TryStrSymParseUint(8))
+			d.FieldUTF8NullFixedLen("gid", 8, scalar.TryStrSymParseUint(8))
+			size, sizeOk := d.FieldScalarUTF8NullFixedLen("size", 12, scalar.TryStrSymParseUint(8)).TrySymUint()
+			if !sizeOk {
+				d.Fatalf("could not decode size")
+			}
+			size *= 8
+			d.FieldUTF8NullFixedLen("mtime", 12, scalar.TryStrSymParseUint(8), scalar.StrFn(func(s scalar.Str) (scalar.Str, error) {
+				// TODO: string might not be a number, move to scalar?
+				if v, ok := s.TrySymUint(); ok {
+					s.Description = unixTimeEpochDate.Add(time.Duration(v) * time.Second).Format(time.RFC3339)
 				}
-				endMarkerEnd = d.Pos()
-				break
+				return s, nil
+			}))
+			d.FieldUTF8NullFixedLen("chksum", 8, scalar.TryStrSymParseUint(8))
+			d.FieldUTF8("typeflag", 1, mapTrimSpaceNull)
+			d.FieldUTF8("linkname", 100, mapTrimSpaceNull)
+			d.FieldUTF8("magic", 6, mapTrimSpaceNull, d.StrAssert("ustar"))
+			d.FieldUTF8NullFixedLen("version", 2, scalar.TryStrSymParseUint(8))
+			d.FieldUTF8("uname", 32, mapTrimSpaceNull)
+			d.FieldUTF8("gname", 32, mapTrimSpaceNull)
+			d.FieldUTF8NullFixedLen("devmajor", 8, scalar.TryStrSymParseUint(8))
+			d.FieldUTF8NullFixedLen("devminor", 8, scalar.TryStrSymParseUint(8))
+			d.FieldUTF8("prefix", 155, mapTrimSpaceNull)
+			d.FieldRawLen("header_block_padding", blockPadding(d), d.BitBufIsZero())
+
+			d.FieldFormatOrRawLen("data", int64(size), &probeGroup, nil)
+
+			d.FieldRawLen("data_block_padding", blockPadding(d), d.BitBufIsZero())
+		})
+	}
+
+	// scan headers for size to find ranges of files, files are then decoded in parallel.
+	// stop at first header without magic, as a sequential decode would have
+	var files []ranges.Range
+	badHeaderPos := int64(-1)
+	for !d.End() {
+		start := d.Pos()
+		if d.BitsLeft() < blockBits {
+			badHeaderPos = start
+			break
+		}
+		d.SeekAbs(start + 257*8)
+		if strings.TrimRight(d.UTF8(6), " \x00") != "ustar" {
+			badHeaderPos = start
+			break
+		}
+		d.SeekAbs(start + 124*8)
+		size, err := strconv.ParseUint(strings.TrimSpace(d.UTF8NullFixedLen(12)), 8, 64)
+		if err != nil {
+			d.Fatalf("could not decode size")
+		}
+		d.SeekAbs(start + blockBits)
+		d.SeekRel(int64(size) * 8)
+		d.SeekRel(blockPadding(d))
+		files = append(files, ranges.Range{Start: start, Len: d.Pos() - start})
+
+		if d.BitsLeft() >= int64(len(endMarker))*8 && bytes.Equal(d.PeekBytes(len(endMarker)), endMarker[:]) {
+			endMarkerStart = d.Pos()
+			// consensus seems to be to allow more than 2 zero blocks at end
+			d.SeekRel(int64(len(endMarker)) * 8)
+			zeroBlock := [blockBytes]byte{}
+			for d.BitsLeft() >= blockBytes*8 && bytes.Equal(d.PeekBytes(blockBytes), zeroBlock[:]) {
+				d.SeekRel(int64(len(zeroBlock)) * 8)
 			}
+			endMarkerEnd = d.Pos()
+			break
 		}
-	})
+	}
+	// first header is checked before decoding files so that probe fails fast
+	if badHeaderPos != -1 && len(files) == 0 {
+		d.SeekAbs(badHeaderPos)
+		d.Fatalf("no valid file header found")
+	}
+	d.FieldArrayParallel("files", "file", files, decodeFile)
+	if badHeaderPos != -1 {
+		d.SeekAbs(badHeaderPos)
+		d.Fatalf("invalid file header")
+	}
+
 	endMarkerSize := endMarkerEnd - endMarkerStart
 	if endMarkerSize > 0 {
 		d.RangeFn(endMarkerStart, endMarkerSize, func(d *decode.D) {
@@ -102,7 +138,7 @@ func tarDecode(d *decode.D) any {
 		})
 	}
 
-	if filesCount == 0 {
+	if len(files) == 0 {
 		d.Errorf("no files found")
 	}
 
@@ -11,6 +11,7 @@ import (
 
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/unicode"
@@ -238,5 +239,5 @@ func init() {
 		return bb.String()
 	})
 
-	interp.RegisterFS(textFS)
+	registry.RegisterFS(textFS)
 }
@@ -6,13 +6,13 @@ package tiff
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 // currently just a alias for tiff
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Exif,
 		&decode.Format{
 			Description: "Exchangeable Image File Format",
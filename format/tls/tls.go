@@ -6,7 +6,8 @@ package tls
 //
 // TODO: key exchange alg, decode key exchange parameters
 // TODO: renegotiation, client/server hello again etc, uses current cipher state, keep track of key change
-// TODO: tls 1.3, ssl? combine or own format?
+// TODO: ssl? combine or own format?
+// TODO: tls 1.3 0-RTT early data, psk
 // TODO: ALPN
 // TODO: pcapng keylog
 // TODO: add fields for seq, calculated things? prf result and decode key/iv?
@@ -31,8 +32,8 @@ import (
 	"github.com/wader/fq/format/tls/tlsdecrypt"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -40,9 +41,10 @@ import (
 var tlsFS embed.FS
 
 var asn1BerGroup decode.Group
+var tcpStreamGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.TLS,
 		&decode.Format{
 			Description:  "Transport layer security",
@@ -51,9 +53,10 @@ func init() {
 			DefaultInArg: format.TLS_In{},
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.ASN1_BER}, Out: &asn1BerGroup},
+				{Groups: []*decode.Group{format.TCP_Stream}, Out: &tcpStreamGroup},
 			},
 		})
-	interp.RegisterFS(tlsFS)
+	registry.RegisterFS(tlsFS)
 }
 
 const (
@@ -106,6 +109,8 @@ const (
 	handshakeMsgTypeClientHello        = 1
 	handshakeMsgTypeServerHello        = 2
 	handshakeMsgTypeNewSessionTicket   = 4
+	handshakeMsgTypeEndOfEarlyData     = 5
+	handshakeMsgTypeEncryptedExtension = 8
 	handshakeMsgTypeCertificate        = 11
 	handshakeMsgTypeServerKeyExchange  = 12
 	handshakeMsgTypeCertificateRequest = 13
@@ -113,6 +118,7 @@ const (
 	handshakeMsgTypeCertificateVerify  = 15
 	handshakeMsgTypeClientKeyExchange  = 16
 	handshakeMsgTypeFinished           = 20
+	handshakeMsgTypeKeyUpdate          = 24
 )
 
 var handshakeMsgTypeNames = scalar.UintMapSymStr{
@@ -120,6 +126,8 @@ var handshakeMsgTypeNames = scalar.UintMapSymStr{
 	handshakeMsgTypeClientHello:        "client_hello",
 	handshakeMsgTypeServerHello:        "server_hello",
 	handshakeMsgTypeNewSessionTicket:   "new_session_ticket",
+	handshakeMsgTypeEndOfEarlyData:     "end_of_early_data",
+	handshakeMsgTypeEncryptedExtension: "encrypted_extensions",
 	handshakeMsgTypeCertificate:        "certificate",
 	handshakeMsgTypeServerKeyExchange:  "server_key_exchange",
 	handshakeMsgTypeCertificateRequest: "certificate_request",
@@ -127,6 +135,17 @@ var handshakeMsgTypeNames = scalar.UintMapSymStr{
 	handshakeMsgTypeCertificateVerify:  "certificate_verify",
 	handshakeMsgTypeClientKeyExchange:  "client_key_exchange",
 	handshakeMsgTypeFinished:           "finished",
+	handshakeMsgTypeKeyUpdate:          "key_update",
+}
+
+const (
+	keyUpdateNotRequested = 0
+	keyUpdateRequested    = 1
+)
+
+var keyUpdateRequestNames = scalar.UintMapSymStr{
+	keyUpdateNotRequested: "update_not_requested",
+	keyUpdateRequested:    "update_requested",
 }
 
 const (
@@ -202,6 +221,7 @@ type encryptedRecord struct {
 	recordType uint64
 	d          *decode.D
 	r          ranges.Range
+	dataV      *decode.Value
 }
 
 type keyExchange struct {
@@ -213,6 +233,9 @@ type keyExchange struct {
 
 type tlsCtx struct {
 	rootD *decode.D
+	tsi   format.TCP_Stream_In // used to decode application data stream
+	// output from decoding application data stream, client side is passed to server side
+	streamOut any
 
 	version uint64
 	random  [32]byte
@@ -233,7 +256,12 @@ type tlsCtx struct {
 	clientCtx *tlsCtx
 }
 
-func decodeTLSExtension(d *decode.D) {
+// version is negotiated using supported_versions extension in server hello
+func (tc *tlsCtx) isTLS13() bool {
+	return tc.serverCtx != nil && tc.serverCtx.version == versionTLS_1_3
+}
+
+func decodeTLSExtension(d *decode.D, tc *tlsCtx, msgType uint64) {
 	typ := d.FieldU16("type", extensionNames)
 	length := d.FieldU16("length")
 	// server sometimes use empty extension to indicate things, ex: accept SNI
@@ -285,6 +313,43 @@ func decodeTLSExtension(d *decode.D) {
 					}
 				})
 			})
+		case extensionSupportedVersions:
+			// https://www.rfc-editor.org/rfc/rfc8446#section-4.2.1
+			if msgType == handshakeMsgTypeClientHello {
+				versionsLength := d.FieldU8("versions_length")
+				d.FieldArray("versions", func(d *decode.D) {
+					d.FramedFn(int64(versionsLength)*8, func(d *decode.D) {
+						for !d.End() {
+							d.FieldU16("version", versionNames, scalar.UintHex)
+						}
+					})
+				})
+			} else {
+				tc.version = d.FieldU16("selected_version", versionNames, scalar.UintHex)
+			}
+		case extensionKeyShare:
+			// https://www.rfc-editor.org/rfc/rfc8446#section-4.2.8
+			decodeKeyShareEntry := func(d *decode.D) {
+				d.FieldU16("group", scalar.UintHex) // TODO: names
+				length := d.FieldU16("key_exchange_length")
+				d.FieldRawLen("key_exchange", int64(length)*8)
+			}
+			switch {
+			case msgType == handshakeMsgTypeClientHello:
+				clientSharesLength := d.FieldU16("client_shares_length")
+				d.FieldArray("client_shares", func(d *decode.D) {
+					d.FramedFn(int64(clientSharesLength)*8, func(d *decode.D) {
+						for !d.End() {
+							d.FieldStruct("client_share", decodeKeyShareEntry)
+						}
+					})
+				})
+			case length == 2:
+				// hello retry request only has selected group
+				d.FieldU16("selected_group", scalar.UintHex)
+			default:
+				d.FieldStruct("server_share", decodeKeyShareEntry)
+			}
 		case extensionSignatureAlgorithms:
 			protocolsLength := d.FieldU16("signature_algorithm_length")
 			d.FieldArray("signature_algorithms", func(d *decode.D) {
@@ -303,6 +368,16 @@ func decodeTLSExtension(d *decode.D) {
 	})
 }
 
+func decodeTLSExtensions(d *decode.D, tc *tlsCtx, msgType uint64) {
+	d.FieldArray("extensions", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("extension", func(d *decode.D) {
+				decodeTLSExtension(d, tc, msgType)
+			})
+		}
+	})
+}
+
 func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 	msgType := d.FieldU8("type", handshakeMsgTypeNames)
 	length := d.FieldU24("length")
@@ -353,14 +428,20 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 			if d.BitsLeft() > 0 {
 				extensionsLength := d.FieldU16("extensions_length")
 				d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
-					d.FieldArray("extensions", func(d *decode.D) {
-						for !d.End() {
-							d.FieldStruct("extension", decodeTLSExtension)
-						}
-					})
+					decodeTLSExtensions(d, tc, msgType)
 				})
 			}
+		case handshakeMsgTypeEncryptedExtension:
+			extensionsLength := d.FieldU16("extensions_length")
+			d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
+				decodeTLSExtensions(d, tc, msgType)
+			})
 		case handshakeMsgTypeCertificate:
+			isTLS13 := tc.isTLS13()
+			if isTLS13 {
+				contextLength := d.FieldU8("certificate_request_context_length")
+				d.FieldRawLen("certificate_request_context", int64(contextLength)*8)
+			}
 			certificatesLength := d.FieldU24("certificates_length")
 			d.FramedFn(int64(certificatesLength)*8, func(d *decode.D) {
 				d.FieldArray("certificates", func(d *decode.D) {
@@ -368,10 +449,39 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 						d.FieldStruct("certificate", func(d *decode.D) {
 							length := d.FieldU24("length")
 							d.FieldFormatLen("data", int64(length)*8, &asn1BerGroup, nil)
+							// tls 1.3 has extensions per certificate entry
+							if isTLS13 {
+								extensionsLength := d.FieldU16("extensions_length")
+								d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
+									decodeTLSExtensions(d, tc, msgType)
+								})
+							}
 						})
 					}
 				})
 			})
+		case handshakeMsgTypeCertificateRequest:
+			if !tc.isTLS13() {
+				d.FieldRawLen("data", d.BitsLeft())
+				return
+			}
+			contextLength := d.FieldU8("certificate_request_context_length")
+			d.FieldRawLen("certificate_request_context", int64(contextLength)*8)
+			extensionsLength := d.FieldU16("extensions_length")
+			d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
+				decodeTLSExtensions(d, tc, msgType)
+			})
+		case handshakeMsgTypeCertificateVerify:
+			if !tc.isTLS13() {
+				d.FieldRawLen("data", d.BitsLeft())
+				return
+			}
+			d.FieldStruct("signature_algorithm", func(d *decode.D) {
+				d.FieldU8("hash", hashAlgorithmNames)
+				d.FieldU8("signature", signatureAlgorithmNames)
+				length := d.FieldU16("length")
+				d.FieldRawLen("data", int64(length)*8)
+			})
 		case handshakeMsgTypeClientKeyExchange,
 			handshakeMsgTypeServerKeyExchange:
 			// is decoded later in decodeTLSPostKeyExchange
@@ -387,9 +497,26 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 		case handshakeMsgTypeFinished:
 			d.FieldRawLen("verify_data", d.BitsLeft())
 		case handshakeMsgTypeNewSessionTicket:
-			d.FieldU32("lifetime_hint")
+			if !tc.isTLS13() {
+				d.FieldU32("lifetime_hint")
+				ticketLength := d.FieldU16("ticket_length")
+				d.FieldRawLen("ticket", int64(ticketLength)*8)
+				return
+			}
+			// https://www.rfc-editor.org/rfc/rfc8446#section-4.6.1
+			d.FieldU32("ticket_lifetime")
+			d.FieldU32("ticket_age_add")
+			nonceLength := d.FieldU8("ticket_nonce_length")
+			d.FieldRawLen("ticket_nonce", int64(nonceLength)*8)
 			ticketLength := d.FieldU16("ticket_length")
 			d.FieldRawLen("ticket", int64(ticketLength)*8)
+			extensionsLength := d.FieldU16("extensions_length")
+			d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
+				decodeTLSExtensions(d, tc, msgType)
+			})
+		case handshakeMsgTypeEndOfEarlyData:
+		case handshakeMsgTypeKeyUpdate:
+			d.FieldU8("request_update", keyUpdateRequestNames)
 		default:
 			d.FieldRawLen("data", d.BitsLeft())
 		}
@@ -403,13 +530,15 @@ func decodeTLSRecord(d *decode.D, tc *tlsCtx, isEncrypted bool) {
 	d.FieldU16("version", versionNames, scalar.UintHex, d.UintAssert(versionValid...))
 	length := d.FieldU16("length")
 	d.FramedFn(int64(length)*8, func(d *decode.D) {
-		if isEncrypted {
+		// tls 1.3 might not send change cipher spec so application data is always assumed to be encrypted
+		if isEncrypted || recordType == recordTypeApplicationData {
 			d.FieldRawLen("encrypted_data", d.BitsLeft())
 			// is decoded later in decodeTLSPostEncryptedRecords
 			tc.encryptedRecords = append(tc.encryptedRecords, encryptedRecord{
 				recordType: recordType,
 				d:          d,
 				r:          ranges.Range{Start: recordStart, Len: d.Pos() - recordStart},
+				dataV:      d.FieldGet("encrypted_data"),
 			})
 			return
 		}
@@ -442,6 +571,11 @@ func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
 	// to decrypt tls we need:
 	//  - client random to look up shared master secret
 	//  - client and server random to generate cipher iv/key in both directions
+	if tc.isTLS13() {
+		decodeTLS13PostEncryptedRecords(rootD, tc, kl)
+		return
+	}
+
 	masterSecret, _ := kl.Lookup(keylog.ClientRandom, tc.clientCtx.random)
 	if masterSecret == nil {
 		// TODO: info/warn?
@@ -464,7 +598,7 @@ func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
 
 	for _, r := range tc.encryptedRecords {
 		encryptedRecord := r.d.ReadAllBits(rootD.BitBufRange(r.r.Start, r.r.Len))
-		plain, decryptErr := td.Decrypt(encryptedRecord)
+		plain, _, decryptErr := td.Decrypt(encryptedRecord)
 		if decryptErr != nil {
 			// TODO: warn
 			// log.Printf("err: %#+v\n", decryptErr)
@@ -511,8 +645,143 @@ func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
 	}
 
 	if hasApplicationStream {
-		applicationBytes := applicationStream.Bytes()
-		rootD.FieldRootBitBuf("stream", bitio.NewBitReader(applicationBytes, -1))
+		decodeTLSApplicationStream(rootD, tc, applicationStream.Bytes())
+	}
+}
+
+// decode as a tcp stream, ex: http
+func decodeTLSApplicationStream(rootD *decode.D, tc *tlsCtx, bs []byte) {
+	br := bitio.NewBitReader(bs, -1)
+	tsi := tc.tsi
+	tsi.HasStart = true
+	if !tsi.IsClient && tc.clientCtx != nil {
+		if to, ok := tc.clientCtx.streamOut.(format.TCP_Stream_Out); ok {
+			tsi.ClientOut = to.InArg
+		}
+	}
+	dv, out, _ := rootD.TryFieldFormatBitBuf("stream", br, &tcpStreamGroup, tsi)
+	if dv == nil {
+		rootD.FieldRootBitBuf("stream", br)
+	}
+	tc.streamOut = out
+}
+
+// in TLS 1.3 only application data records are encrypted, change cipher spec is only sent
+// for middlebox compatibility so records after it can be plain, ex: hello retry request
+// https://www.rfc-editor.org/rfc/rfc8446#appendix-D.4
+func decodeTLS13PostPlainRecords(tc *tlsCtx) {
+	var encryptedRecords []encryptedRecord
+	for _, r := range tc.encryptedRecords {
+		if r.recordType == recordTypeApplicationData {
+			encryptedRecords = append(encryptedRecords, r)
+			continue
+		}
+
+		plain := r.d.ReadAllBits(r.d.BitBufRange(r.dataV.Range.Start, r.dataV.Range.Len))
+		if err := r.dataV.Remove(); err != nil {
+			panic(err)
+		}
+		r.d.FieldStructRootBitBufFn("message", bitio.NewBitReader(plain, -1), func(d *decode.D) {
+			decodeTLSRecordMessage(d, tc, r.recordType)
+		})
+	}
+	tc.encryptedRecords = encryptedRecords
+}
+
+// handshakeMessagesLen returns length of complete handshake messages at start of bs and their types
+func handshakeMessagesLen(bs []byte) (int, []uint64) {
+	n := 0
+	var msgTypes []uint64
+	for len(bs)-n >= 4 {
+		length := int(bs[n+1])<<16 | int(bs[n+2])<<8 | int(bs[n+3])
+		if len(bs)-n < 4+length {
+			break
+		}
+		msgTypes = append(msgTypes, uint64(bs[n]))
+		n += 4 + length
+	}
+	return n, msgTypes
+}
+
+func decodeTLS13PostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
+	// to decrypt tls 1.3 we need:
+	//  - client random to look up traffic secrets
+	//  - handshake traffic secret for records up to and including finished message
+	//  - traffic secret for records after finished message, is updated by key update messages
+	isClient := tc == tc.clientCtx
+	handshakeLabel, trafficLabel := keylog.ServerHandshakeTrafficSecret, keylog.ServerTrafficSecret0
+	if isClient {
+		handshakeLabel, trafficLabel = keylog.ClientHandshakeTrafficSecret, keylog.ClientTrafficSecret0
+	}
+	handshakeSecret, _ := kl.Lookup(handshakeLabel, tc.clientCtx.random)
+	trafficSecret, _ := kl.Lookup(trafficLabel, tc.clientCtx.random)
+	if handshakeSecret == nil {
+		// TODO: info/warn?
+		return
+	}
+
+	td := tlsdecrypt.Decryptor{
+		IsClient:      isClient,
+		Version:       int(tc.serverCtx.version),
+		CipherSuite:   int(tc.serverCtx.server.nextCipherSuit),
+		TrafficSecret: handshakeSecret,
+	}
+
+	applicationStream := &bytes.Buffer{}
+	hasApplicationStream := false
+	// handshake messages can be split into multiple records or multiple messages in one record
+	var handshakeBuf []byte
+
+	for _, r := range tc.encryptedRecords {
+		encryptedRecord := r.d.ReadAllBits(rootD.BitBufRange(r.r.Start, r.r.Len))
+		plain, contentType, decryptErr := td.Decrypt(encryptedRecord)
+		if decryptErr != nil {
+			// TODO: warn
+			continue
+		}
+
+		r.d.FieldValueUint("content_type", uint64(contentType), recordTypeNames)
+
+		switch contentType {
+		case recordTypeHandshake:
+			handshakeBuf = append(handshakeBuf, plain...)
+			n, msgTypes := handshakeMessagesLen(handshakeBuf)
+			if n == 0 {
+				r.d.FieldRootBitBuf("fragment", bitio.NewBitReader(plain, -1))
+				continue
+			}
+			r.d.FieldArrayRootBitBufFn("messages", bitio.NewBitReader(handshakeBuf[:n], -1), func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("message", func(d *decode.D) {
+						decodeTLSHandshake(d, tc)
+					})
+				}
+			})
+			handshakeBuf = append([]byte(nil), handshakeBuf[n:]...)
+
+			for _, msgType := range msgTypes {
+				switch msgType {
+				case handshakeMsgTypeFinished:
+					td.SetTrafficSecret(trafficSecret)
+				case handshakeMsgTypeKeyUpdate:
+					if err := td.UpdateTrafficSecret(); err != nil {
+						return
+					}
+				}
+			}
+		case recordTypeApplicationData:
+			applicationStream.Write(plain)
+			hasApplicationStream = true
+			r.d.FieldRootBitBuf("message", bitio.NewBitReader(plain, -1))
+		default:
+			r.d.FieldStructRootBitBufFn("message", bitio.NewBitReader(plain, -1), func(d *decode.D) {
+				decodeTLSRecordMessage(d, tc, uint64(contentType))
+			})
+		}
+	}
+
+	if hasApplicationStream {
+		decodeTLSApplicationStream(rootD, tc, applicationStream.Bytes())
 	}
 }
 
@@ -691,6 +960,7 @@ func decodeTLS(d *decode.D) any {
 
 	tc := &tlsCtx{
 		rootD: d,
+		tsi:   tsi,
 	}
 	tc.server.currentCipherSuit = ciphersuites.TLS_NULL_WITH_NULL_NULL
 	tc.server.nextCipherSuit = ciphersuites.TLS_NULL_WITH_NULL_NULL
@@ -737,6 +1007,11 @@ func decodeTLS(d *decode.D) any {
 			decodeTLSPostKeyExchange(clientTc)
 			decodeTLSPostKeyExchange(serverTc)
 
+			if serverTc.isTLS13() {
+				decodeTLS13PostPlainRecords(clientTc)
+				decodeTLS13PostPlainRecords(serverTc)
+			}
+
 			if ti.Keylog == "" {
 				return
 			}
@@ -1,7 +1,6 @@
 package tlsdecrypt
 
 // TODO: SSLv3 MAC
-// TODO: TLS 1.3
 
 import (
 	"fmt"
@@ -9,13 +8,18 @@ import (
 )
 
 type Decryptor struct {
-	IsClient     bool
-	Version      int
-	CipherSuite  int
+	IsClient    bool
+	Version     int
+	CipherSuite int
+
+	// TLS 1.0-1.2
 	MasterSecret []byte
 	ClientRandom []byte
 	ServerRandom []byte
 
+	// TLS 1.3 traffic secret for the direction, change with SetTrafficSecret
+	TrafficSecret []byte
+
 	halfConn *halfConn
 }
 
@@ -58,39 +62,91 @@ func establishKeys(
 	}
 }
 
-func (d *Decryptor) Decrypt(record []byte) ([]byte, error) {
+// Decrypt decrypts a record including record header and returns plain data and content type.
+// For TLS 1.3 the content type is the inner content type.
+func (d *Decryptor) Decrypt(record []byte) ([]byte, int, error) {
 	if d.halfConn == nil {
-		cipherSuite := cipherSuiteByID(uint16(d.CipherSuite))
-		if cipherSuite == nil {
-			return nil, fmt.Errorf("unsupported cipher suit %x", d.CipherSuite)
-		}
-
-		keys := establishKeys(
-			uint16(d.Version),
-			cipherSuite,
-			d.MasterSecret,
-			d.ClientRandom,
-			d.ServerRandom,
-		)
-
-		var cipher any
-		var mac hash.Hash
-		if d.IsClient {
-			cipher = keys.clientCipher
-			mac = keys.clientHash
+		var err error
+		if d.Version == VersionTLS13 {
+			d.halfConn, err = d.halfConnTLS13()
 		} else {
-			cipher = keys.serverCipher
-			mac = keys.serverHash
+			d.halfConn, err = d.halfConnTLS12()
 		}
-
-		d.halfConn = &halfConn{
-			version: uint16(d.Version),
-			cipher:  cipher,
-			mac:     mac,
-			seq:     [8]byte{}, // zero
+		if err != nil {
+			return nil, 0, err
 		}
 	}
 
-	plain, _, err := d.halfConn.decrypt(record)
-	return plain, err
+	plain, typ, err := d.halfConn.decrypt(record)
+	return plain, int(typ), err
+}
+
+// SetTrafficSecret changes TLS 1.3 traffic secret, ex: after handshake is finished.
+// Sequence number is reset.
+func (d *Decryptor) SetTrafficSecret(trafficSecret []byte) {
+	d.TrafficSecret = trafficSecret
+	d.halfConn = nil
+}
+
+// UpdateTrafficSecret derives next TLS 1.3 traffic secret after a key update
+func (d *Decryptor) UpdateTrafficSecret() error {
+	suite := cipherSuiteTLS13ByID(uint16(d.CipherSuite))
+	if suite == nil {
+		return fmt.Errorf("unsupported cipher suit %x", d.CipherSuite)
+	}
+	d.SetTrafficSecret(suite.nextTrafficSecret(d.TrafficSecret))
+	return nil
+}
+
+func (d *Decryptor) halfConnTLS12() (*halfConn, error) {
+	cipherSuite := cipherSuiteByID(uint16(d.CipherSuite))
+	if cipherSuite == nil {
+		return nil, fmt.Errorf("unsupported cipher suit %x", d.CipherSuite)
+	}
+
+	keys := establishKeys(
+		uint16(d.Version),
+		cipherSuite,
+		d.MasterSecret,
+		d.ClientRandom,
+		d.ServerRandom,
+	)
+
+	var cipher any
+	var mac hash.Hash
+	if d.IsClient {
+		cipher = keys.clientCipher
+		mac = keys.clientHash
+	} else {
+		cipher = keys.serverCipher
+		mac = keys.serverHash
+	}
+
+	return &halfConn{
+		version: uint16(d.Version),
+		cipher:  cipher,
+		mac:     mac,
+		seq:     [8]byte{}, // zero
+	}, nil
+}
+
+// TLS 1.3 has no key exchange derived keys, key and iv are derived from the traffic secret
+// https://www.rfc-editor.org/rfc/rfc8446#section-7.3
+func (d *Decryptor) halfConnTLS13() (*halfConn, error) {
+	cipherSuite := cipherSuiteTLS13ByID(uint16(d.CipherSuite))
+	if cipherSuite == nil {
+		return nil, fmt.Errorf("unsupported cipher suit %x", d.CipherSuite)
+	}
+	if len(d.TrafficSecret) != cipherSuite.hash.Size() {
+		return nil, fmt.Errorf("traffic secret length %d, expected %d", len(d.TrafficSecret), cipherSuite.hash.Size())
+	}
+
+	key, iv := cipherSuite.trafficKey(d.TrafficSecret)
+
+	return &halfConn{
+		version:       VersionTLS13,
+		cipher:        cipherSuite.aead(key, iv),
+		seq:           [8]byte{}, // zero
+		trafficSecret: d.TrafficSecret,
+	}, nil
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -21,7 +22,7 @@ import (
 var tomlFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.TOML,
 		&decode.Format{
 			Description: "Tom's Obvious, Minimal Language",
@@ -30,7 +31,7 @@ func init() {
 			DecodeFn:    decodeTOML,
 			Functions:   []string{"_todisplay"},
 		})
-	interp.RegisterFS(tomlFS)
+	registry.RegisterFS(tomlFS)
 	interp.RegisterFunc0("to_toml", toTOML)
 }
 
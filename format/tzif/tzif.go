@@ -6,7 +6,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -14,14 +14,14 @@ import (
 var tzifFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Tzif,
 		&decode.Format{
 			Description: "Time Zone Information Format",
 			DecodeFn:    decodeTZIF,
 			Groups:      []*decode.Group{format.Probe},
 		})
-	interp.RegisterFS(tzifFS)
+	registry.RegisterFS(tzifFS)
 }
 
 func decodeTZIF(d *decode.D) any {
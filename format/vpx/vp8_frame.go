@@ -5,14 +5,14 @@ package vpx
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 // TODO: vpx frame?
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.VP8_Frame,
 		&decode.Format{
 			Description: "VP8 frame",
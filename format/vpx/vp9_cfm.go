@@ -5,11 +5,11 @@ package vpx
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.VP9_CFM,
 		&decode.Format{
 			Description: "VP9 Codec Feature Metadata",
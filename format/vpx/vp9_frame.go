@@ -5,7 +5,7 @@ package vpx
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -55,7 +55,7 @@ var vp9ProfilesMap = scalar.UintMapDescription{
 }
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.VP9_Frame,
 		&decode.Format{
 			Description: "VP9 frame",
@@ -5,11 +5,11 @@ package vpx
 import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 )
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.VPX_CCR,
 		&decode.Format{
 			Description: "VPX Codec Configuration Record",
@@ -9,7 +9,7 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -17,14 +17,14 @@ import (
 var wasmFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.WASM,
 		&decode.Format{
 			Description: "WebAssembly Binary Format",
 			DecodeFn:    decodeWASM,
 			Groups:      []*decode.Group{format.Probe},
 		})
-	interp.RegisterFS(wasmFS)
+	registry.RegisterFS(wasmFS)
 }
 
 const (
@@ -7,7 +7,7 @@ import (
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 	"golang.org/x/net/html"
 )
@@ -17,7 +17,7 @@ import (
 var htmlFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.HTML,
 		&decode.Format{
 			Description: "HyperText Markup Language",
@@ -29,7 +29,7 @@ func init() {
 			},
 			Functions: []string{"_todisplay"},
 		})
-	interp.RegisterFS(htmlFS)
+	registry.RegisterFS(htmlFS)
 }
 
 func fromHTMLToObject(n *html.Node, hi format.HTML_In) any {
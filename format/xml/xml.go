@@ -27,6 +27,7 @@ import (
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 	"golang.org/x/exp/slices"
 )
@@ -36,7 +37,7 @@ import (
 var xmlFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.XML,
 		&decode.Format{
 			Description: "Extensible Markup Language",
@@ -50,7 +51,7 @@ func init() {
 			},
 			Functions: []string{"_todisplay"},
 		})
-	interp.RegisterFS(xmlFS)
+	registry.RegisterFS(xmlFS)
 	interp.RegisterFunc1("to_xml", toXML)
 	interp.RegisterFunc0("from_xmlentities", func(_ *interp.Interp, c string) any {
 		return html.UnescapeString(c)
@@ -12,6 +12,7 @@ import (
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 	"gopkg.in/yaml.v3"
 )
@@ -20,7 +21,7 @@ import (
 var yamlFS embed.FS
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.YAML,
 		&decode.Format{
 			Description: "YAML Ain't Markup Language",
@@ -29,7 +30,7 @@ func init() {
 			DecodeFn:    decodeYAML,
 			Functions:   []string{"_todisplay"},
 		})
-	interp.RegisterFS(yamlFS)
+	registry.RegisterFS(yamlFS)
 	interp.RegisterFunc0("to_yaml", toYAML)
 }
 
@@ -11,7 +11,8 @@ import (
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -21,7 +22,7 @@ var zipFS embed.FS
 var probeGroup decode.Group
 
 func init() {
-	interp.RegisterFormat(
+	registry.RegisterFormat(
 		format.Zip,
 		&decode.Format{
 			Description: "ZIP archive",
@@ -34,7 +35,7 @@ func init() {
 				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
 			},
 		})
-	interp.RegisterFS(zipFS)
+	registry.RegisterFS(zipFS)
 }
 
 const (
@@ -164,11 +165,22 @@ func zipDecode(d *decode.D) any {
 	var offsetCD uint64
 	var sizeCD uint64
 	var diskNr uint64
+	var diskNrCD uint64
+
+	// split archives concatenated in disk order, ex .z01, .z02 and .zip, has
+	// offsets relative to start of each disk
+	concatenated := len(d.Options.Origins) > 1
+	diskStart := func(nr uint64) int64 {
+		if concatenated && nr < uint64(len(d.Options.Origins)) {
+			return d.Options.Origins[nr].Range.Start
+		}
+		return 0
+	}
 
 	d.FieldStruct("end_of_central_directory_record", func(d *decode.D) {
 		d.FieldRawLen("signature", 4*8, d.AssertBitBuf(endOfCentralDirectoryRecordSignature))
 		diskNr = d.FieldU16("disk_nr")
-		d.FieldU16("central_directory_start_disk_nr")
+		diskNrCD = d.FieldU16("central_directory_start_disk_nr")
 		d.FieldU16("nr_of_central_directory_records_on_disk")
 		d.FieldU16("nr_of_central_directory_records")
 		sizeCD = d.FieldU32("size_of_central_directory")
@@ -185,21 +197,22 @@ func zipDecode(d *decode.D) any {
 		d.SeekRel(p)
 
 		var offsetEOCD uint64
+		var diskNrEOCD uint64
 		d.FieldStruct("end_of_central_directory_locator", func(d *decode.D) {
 			d.FieldRawLen("signature", 4*8, d.AssertBitBuf(endOfCentralDirectoryLocatorSignature))
-			diskNr = d.FieldU32("disk_nr")
+			diskNrEOCD = d.FieldU32("disk_nr")
 			offsetEOCD = d.FieldU64("offset_of_end_of_central_directory_record")
 			diskNr = d.FieldU32("total_disk_nr")
 		})
 
-		d.SeekAbs(int64(offsetEOCD) * 8)
+		d.SeekAbs(diskStart(diskNrEOCD) + int64(offsetEOCD)*8)
 		d.FieldStruct("end_of_central_directory_record_zip64", func(d *decode.D) {
 			d.FieldRawLen("signature", 4*8, d.AssertBitBuf(endOfCentralDirectoryRecord64Signature))
 			sizeEOCD := d.FieldU64("size_of_end_of_central_directory")
 			d.FieldU16("version_made_by")
 			d.FieldU16("version_needed_to_extract")
 			diskNr = d.FieldU32("disk_nr")
-			d.FieldU32("central_directory_start_disk_nr")
+			diskNrCD = d.FieldU32("central_directory_start_disk_nr")
 			d.FieldU64("nr_of_central_directory_records_on_disk")
 			d.FieldU64("nr_of_central_directory_records")
 			sizeCD = d.FieldU64("size_of_central_directory")
@@ -217,9 +230,10 @@ func zipDecode(d *decode.D) any {
 		})
 	}
 
-	var localFileOffsets []uint64
+	var localFileStarts []int64
 
-	d.SeekAbs(int64(offsetCD) * 8)
+	startCD := diskStart(diskNrCD) + int64(offsetCD)*8
+	d.SeekAbs(startCD)
 	d.FieldArray("central_directories", func(d *decode.D) {
 		d.FramedFn(int64(sizeCD)*8, func(d *decode.D) {
 			for !d.End() {
@@ -276,7 +290,7 @@ func zipDecode(d *decode.D) any {
 												localFileOffset = d.FieldU64("relative_offset_of_local_file_header")
 											}
 											if !d.End() {
-												d.FieldU32("disk_number_where_file_starts")
+												diskNrStart = d.FieldU32("disk_number_where_file_starts")
 											}
 										default:
 											d.FieldRawLen("data", int64(dataSize)*8)
@@ -288,122 +302,133 @@ func zipDecode(d *decode.D) any {
 					})
 					d.FieldUTF8("file_comment", int(fileCommentLength))
 
-					if diskNrStart == diskNr {
-						localFileOffsets = append(localFileOffsets, localFileOffset)
+					if concatenated || diskNrStart == diskNr {
+						localFileStarts = append(localFileStarts, diskStart(diskNrStart)+int64(localFileOffset)*8)
 					}
 				})
 			}
 		})
 	})
 
-	d.FieldArray("local_files", func(d *decode.D) {
-		for _, o := range localFileOffsets {
-			d.SeekAbs(int64(o) * 8)
-			d.FieldStruct("local_file", func(d *decode.D) {
-				var hasDataDescriptor bool
-				d.FieldRawLen("signature", 4*8, d.AssertBitBuf(localFileSignature))
-				d.FieldU16("version_needed")
-				d.FieldStruct("flags", func(d *decode.D) {
-					// TODO: 16LE, should have some kind of native endian flag reader helper?
-					d.FieldU1("unused0")
-					d.FieldBool("strong_encryption")
-					d.FieldBool("compressed_patched_data")
-					d.FieldBool("enhanced_deflation")
-					hasDataDescriptor = d.FieldBool("data_descriptor")
-					d.FieldBool("compression0")
-					d.FieldBool("compression1")
-					d.FieldBool("encrypted")
-
-					d.FieldU2("reserved0")
-					d.FieldBool("mask_header_values")
-					d.FieldBool("reserved1")
-					d.FieldBool("language_encoding")
-					d.FieldU3("unused1")
-				})
-				compressionMethod := d.FieldU16("compression_method", compressionMethodMap)
-				d.FieldStruct("last_modification_date", fieldMSDOSTime)
-				d.FieldStruct("last_modification_time", fieldMSDOSDate)
-				d.FieldU32("crc32_uncompressed", scalar.UintHex)
-				compressedSizeBytes := d.FieldU32("compressed_size")
-				d.FieldU32("uncompressed_size")
-				fileNameLength := d.FieldU16("file_name_length")
-				extraFieldLength := d.FieldU16("extra_field_length")
-				d.FieldUTF8("file_name", int(fileNameLength))
-				d.FieldArray("extra_fields", func(d *decode.D) {
-					d.FramedFn(int64(extraFieldLength)*8, func(d *decode.D) {
-						for !d.End() {
-							d.FieldStruct("extra_field", func(d *decode.D) {
-								headerID := d.FieldU16("header_id", headerIDMap, scalar.UintHex)
-								dataSize := d.FieldU16("data_size")
-								d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
-									switch headerID {
-									case headerIDZip64ExtendedInformation:
-										d.FieldU64("uncompressed_size")
-										// TODO: spec says these should be here but real zip64 seems to not have them? optional?
-										if !d.End() {
-											compressedSizeBytes = d.FieldU64("compressed_size")
-										}
-									default:
-										d.FieldRawLen("data", int64(dataSize)*8)
+	// local files are independent so decode them in parallel, each local file
+	// ends at most where next local file or central directory starts
+	localFileStops := append([]int64{startCD}, localFileStarts...)
+	var localFiles []ranges.Range
+	for _, start := range localFileStarts {
+		stop := d.Len()
+		for _, s := range localFileStops {
+			if s > start && s < stop {
+				stop = s
+			}
+		}
+		localFiles = append(localFiles, ranges.Range{Start: start, Len: stop - start})
+	}
+
+	d.FieldArrayParallel("local_files", "local_file", localFiles, func(d *decode.D) {
+		d.FieldStruct("local_file", func(d *decode.D) {
+			var hasDataDescriptor bool
+			d.FieldRawLen("signature", 4*8, d.AssertBitBuf(localFileSignature))
+			d.FieldU16("version_needed")
+			d.FieldStruct("flags", func(d *decode.D) {
+				// TODO: 16LE, should have some kind of native endian flag reader helper?
+				d.FieldU1("unused0")
+				d.FieldBool("strong_encryption")
+				d.FieldBool("compressed_patched_data")
+				d.FieldBool("enhanced_deflation")
+				hasDataDescriptor = d.FieldBool("data_descriptor")
+				d.FieldBool("compression0")
+				d.FieldBool("compression1")
+				d.FieldBool("encrypted")
+
+				d.FieldU2("reserved0")
+				d.FieldBool("mask_header_values")
+				d.FieldBool("reserved1")
+				d.FieldBool("language_encoding")
+				d.FieldU3("unused1")
+			})
+			compressionMethod := d.FieldU16("compression_method", compressionMethodMap)
+			d.FieldStruct("last_modification_date", fieldMSDOSTime)
+			d.FieldStruct("last_modification_time", fieldMSDOSDate)
+			d.FieldU32("crc32_uncompressed", scalar.UintHex)
+			compressedSizeBytes := d.FieldU32("compressed_size")
+			d.FieldU32("uncompressed_size")
+			fileNameLength := d.FieldU16("file_name_length")
+			extraFieldLength := d.FieldU16("extra_field_length")
+			d.FieldUTF8("file_name", int(fileNameLength))
+			d.FieldArray("extra_fields", func(d *decode.D) {
+				d.FramedFn(int64(extraFieldLength)*8, func(d *decode.D) {
+					for !d.End() {
+						d.FieldStruct("extra_field", func(d *decode.D) {
+							headerID := d.FieldU16("header_id", headerIDMap, scalar.UintHex)
+							dataSize := d.FieldU16("data_size")
+							d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
+								switch headerID {
+								case headerIDZip64ExtendedInformation:
+									d.FieldU64("uncompressed_size")
+									// TODO: spec says these should be here but real zip64 seems to not have them? optional?
+									if !d.End() {
+										compressedSizeBytes = d.FieldU64("compressed_size")
 									}
-								})
+								default:
+									d.FieldRawLen("data", int64(dataSize)*8)
+								}
 							})
-						}
-					})
+						})
+					}
 				})
-				compressedSize := int64(compressedSizeBytes) * 8
-				compressedStart := d.Pos()
+			})
+			compressedSize := int64(compressedSizeBytes) * 8
+			compressedStart := d.Pos()
+
+			compressedLimit := compressedSize
+			if compressedLimit == 0 {
+				compressedLimit = d.BitsLeft()
+			}
 
-				compressedLimit := compressedSize
-				if compressedLimit == 0 {
-					compressedLimit = d.BitsLeft()
+			if compressionMethod == compressionMethodNone {
+				d.FieldFormatOrRawLen("uncompressed", compressedSize, &probeGroup, nil)
+			} else {
+				var rFn func(r io.Reader) io.Reader
+				if zi.Uncompress {
+					switch compressionMethod {
+					case compressionMethodDeflated:
+						// bitio.NewIOReadSeeker implements io.ByteReader so that deflate don't do own
+						// buffering and might read more than needed messing up knowing compressed size
+						rFn = func(r io.Reader) io.Reader { return flate.NewReader(r) }
+					}
 				}
 
-				if compressionMethod == compressionMethodNone {
-					d.FieldFormatOrRawLen("uncompressed", compressedSize, &probeGroup, nil)
-				} else {
-					var rFn func(r io.Reader) io.Reader
-					if zi.Uncompress {
-						switch compressionMethod {
-						case compressionMethodDeflated:
-							// bitio.NewIOReadSeeker implements io.ByteReader so that deflate don't do own
-							// buffering and might read more than needed messing up knowing compressed size
-							rFn = func(r io.Reader) io.Reader { return flate.NewReader(r) }
-						}
+				if rFn != nil {
+					readCompressedSize, uncompressedBR, dv, _, _ :=
+						d.TryFieldReaderRangeFormat("uncompressed", d.Pos(), compressedLimit, rFn, &probeGroup, nil)
+					if dv == nil && uncompressedBR != nil {
+						d.FieldRootBitBuf("uncompressed", uncompressedBR)
+					}
+					if compressedSize == 0 {
+						compressedSize = readCompressedSize
 					}
+					d.FieldRawLen("compressed", compressedSize)
 
-					if rFn != nil {
-						readCompressedSize, uncompressedBR, dv, _, _ :=
-							d.TryFieldReaderRangeFormat("uncompressed", d.Pos(), compressedLimit, rFn, &probeGroup, nil)
-						if dv == nil && uncompressedBR != nil {
-							d.FieldRootBitBuf("uncompressed", uncompressedBR)
-						}
-						if compressedSize == 0 {
-							compressedSize = readCompressedSize
-						}
+				} else {
+					if compressedSize != 0 {
 						d.FieldRawLen("compressed", compressedSize)
-
-					} else {
-						if compressedSize != 0 {
-							d.FieldRawLen("compressed", compressedSize)
-						}
 					}
 				}
+			}
 
-				d.SeekAbs(compressedStart + compressedSize)
+			d.SeekAbs(compressedStart + compressedSize)
 
-				if hasDataDescriptor {
-					d.FieldStruct("data_indicator", func(d *decode.D) {
-						if bytes.Equal(d.PeekBytes(4), dataIndicatorSignature) {
-							d.FieldRawLen("signature", 4*8, d.AssertBitBuf(dataIndicatorSignature))
-						}
-						d.FieldU32("crc32_uncompressed", scalar.UintHex)
-						d.FieldU32("compressed_size")
-						d.FieldU32("uncompressed_size")
-					})
-				}
-			})
-		}
+			if hasDataDescriptor {
+				d.FieldStruct("data_indicator", func(d *decode.D) {
+					if bytes.Equal(d.PeekBytes(4), dataIndicatorSignature) {
+						d.FieldRawLen("signature", 4*8, d.AssertBitBuf(dataIndicatorSignature))
+					}
+					d.FieldU32("crc32_uncompressed", scalar.UintHex)
+					d.FieldU32("compressed_size")
+					d.FieldU32("uncompressed_size")
+				})
+			}
+		})
 	})
 
 	return nil
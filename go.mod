@@ -15,6 +15,11 @@ require (
 	// bump: gomod-BurntSushi/toml link "Source diff $CURRENT..$LATEST" https://github.com/BurntSushi/toml/compare/v$CURRENT..v$LATEST
 	github.com/BurntSushi/toml v1.2.1
 
+	// bump: gomod-andybalholm-brotli /github\.com\/andybalholm\/brotli v(.*)/ https://github.com/andybalholm/brotli.git|^1
+	// bump: gomod-andybalholm-brotli command go get -d github.com/andybalholm/brotli@v$LATEST && go mod tidy
+	// bump: gomod-andybalholm-brotli link "Source diff $CURRENT..$LATEST" https://github.com/andybalholm/brotli/compare/v$CURRENT..v$LATEST
+	github.com/andybalholm/brotli v1.0.5
+
 	// bump: gomod-creasty-defaults /github\.com\/creasty\/defaults v(.*)/ https://github.com/creasty/defaults.git|^1
 	// bump: gomod-creasty-defaults command go get -d github.com/creasty/defaults@v$LATEST && go mod tidy
 	// bump: gomod-creasty-defaults link "Source diff $CURRENT..$LATEST" https://github.com/creasty/defaults/compare/v$CURRENT..v$LATEST
@@ -5,6 +5,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"time"
 )
 
 func Start(cpuProfilePath string, memProfilePath string) func() {
@@ -49,3 +50,28 @@ func Start(cpuProfilePath string, memProfilePath string) func() {
 		}
 	}
 }
+
+// Usage is time and memory used while running a function
+type Usage struct {
+	Duration   time.Duration
+	AllocBytes uint64
+	Allocs     uint64
+}
+
+// Measure runs fn and returns time and memory allocated. Allocations done by
+// other goroutines at the same time are included. Reading memory statistics
+// stops the world so it's quite expensive.
+func Measure(fn func()) Usage {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	fn()
+	d := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return Usage{
+		Duration:   d,
+		AllocBytes: after.TotalAlloc - before.TotalAlloc,
+		Allocs:     after.Mallocs - before.Mallocs,
+	}
+}
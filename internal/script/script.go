@@ -189,6 +189,33 @@ func (cr *CaseRun) ConfigDir() (string, error) { return "/config", nil }
 
 func (cr *CaseRun) FS() fs.FS { return cr.Case }
 
+func (cr *CaseRun) IndexCache() interp.IndexCache {
+	if cr.Case.indexCache == nil {
+		cr.Case.indexCache = memIndexCache{}
+	}
+	return cr.Case.indexCache
+}
+
+// AbsPath returns path relative to testdata directory, same as Open
+func (cr *CaseRun) AbsPath(name string) (string, error) {
+	return filepath.ToSlash(cr.Case.testAbsPath(name)), nil
+}
+
+// WriteFile keeps files in memory, they can be opened by later runs
+func (cr *CaseRun) WriteFile(name string, data []byte) error {
+	if cr.Case.written == nil {
+		cr.Case.written = map[string][]byte{}
+	}
+	if _, ok := cr.Case.written[name]; ok {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	cr.Case.written[name] = data
+	return nil
+}
+
+var _ interp.IndexCacher = (*CaseRun)(nil)
+var _ interp.FileWriter = (*CaseRun)(nil)
+
 func (cr *CaseRun) Readline(opts interp.ReadlineOpts) (string, error) {
 	cr.ActualStdoutBuf.WriteString(opts.Prompt)
 	if cr.ReadlinesPos >= len(cr.Readlines) {
@@ -269,6 +296,9 @@ type Case struct {
 	Path   string
 	Parts  []part
 	WasRun bool
+
+	indexCache memIndexCache // shared by all runs
+	written    map[string][]byte
 }
 
 func (c *Case) ToActual() string {
@@ -339,15 +369,64 @@ func normalizeOSError(err error) error {
 	return err
 }
 
+type memIndexCache map[string][]byte
+
+func (c memIndexCache) Keys() ([]string, error) {
+	var keys []string
+	for k := range c {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (c memIndexCache) Get(key string) ([]byte, error) {
+	b, ok := c[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return b, nil
+}
+
+func (c memIndexCache) Set(key string, b []byte) error {
+	c[key] = b
+	return nil
+}
+
+func (c memIndexCache) Delete(key string) error {
+	delete(c, key)
+	return nil
+}
+
+// testAbsPath returns path relative to testdata directory, cwd is directory where
+// current script file is and absolute paths are relative to testdata directory
+func (c *Case) testAbsPath(name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+	const testData = "testdata"
+	testDataIndex := strings.Index(c.Path, testData)
+	testCwd := filepath.Dir(c.Path[testDataIndex+len(testData):])
+	return filepath.Join(testCwd, name)
+}
+
 func (c *Case) Open(name string) (fs.File, error) {
 	const testData = "testdata"
 	testDataIndex := strings.Index(c.Path, testData)
-	// cwd is directory where current script file is
 	testRoot := c.Path[0 : testDataIndex+len(testData)]
-	testCwd := filepath.Dir(c.Path[testDataIndex+len(testData):])
-	testAbsPath := filepath.Join(testCwd, name)
+	testAbsPath := c.testAbsPath(name)
 	fsPath := filepath.Join(testRoot, testAbsPath)
 
+	if data, ok := c.written[name]; ok {
+		return interp.FileReader{
+			R: io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))),
+			FileInfo: interp.FixedFileInfo{
+				FName: filepath.Base(name),
+				FSize: int64(len(data)),
+			},
+		}, nil
+	}
+
 	for _, p := range c.Parts {
 		f, ok := p.(*caseFile)
 		if !ok {
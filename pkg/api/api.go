@@ -0,0 +1,136 @@
+// Package api is a typed Go API for using fq decoders without jq.
+//
+// Formats are registered by importing them, usually all of them:
+//
+//	import _ "github.com/wader/fq/format/all"
+//
+// Decode a file and look up values:
+//
+//	f, err := api.Open("file.mp4")
+//	...
+//	defer f.Close()
+//	v, err := f.Decode(ctx, "mp4", api.Options{})
+//	...
+//	brand, err := v.Path("boxes[0].major_brand")
+//	...
+//	s, err := brand.Str()
+//
+// The API only depends on the format registry in the registry package and not on
+// the jq interpreter. Note that formats that also provide jq functions, ex: json
+// and xml, import the interpreter so importing them or format/all will link it.
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/mitchellh/copystructure"
+	"github.com/wader/fq/internal/aheadreadseeker"
+	"github.com/wader/fq/internal/mapstruct"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
+)
+
+// Options for decoding
+type Options struct {
+	Force   bool // ignore decoder asserts
+	Recover bool // record errors in structs and arrays and continue decoding if possible
+	// Format specific options, same as -o name=value, ex: {"skip_samples": true} for mp4
+	FormatOptions map[string]any
+}
+
+// Formats returns sorted names of all registered formats and groups
+func Formats() []string {
+	var names []string
+	for name := range registry.Default.Groups() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// File is an opened file that can be decoded
+type File struct {
+	f    *os.File
+	br   bitio.ReaderAtSeeker
+	name string
+}
+
+// Open opens file at path for decoding
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	const cacheReadAheadSize = 512 * 1024
+	return &File{
+		f:    f,
+		br:   bitio.NewIOBitReadSeeker(aheadreadseeker.New(f, cacheReadAheadSize)),
+		name: path,
+	}, nil
+}
+
+// Close closes the file, values decoded from it can't be used after close
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// Decode decodes file as format, ex "mp4" or a group like "probe".
+// Value can be non-nil even if there is an error if decoding partially
+// succeeded, same as fq does by default.
+func (f *File) Decode(ctx context.Context, format string, opts Options) (*Value, error) {
+	return decodeBitReader(ctx, f.br, f.name, format, opts)
+}
+
+// Decode decodes rs as format, see File.Decode
+func Decode(ctx context.Context, rs io.ReadSeeker, format string, opts Options) (*Value, error) {
+	return decodeBitReader(ctx, bitio.NewIOBitReadSeeker(rs), "", format, opts)
+}
+
+func decodeBitReader(ctx context.Context, br bitio.ReaderAtSeeker, name string, format string, opts Options) (*Value, error) {
+	group, err := registry.Default.Group(format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", format, err)
+	}
+
+	var parseOptsFn func(init any) any
+	if len(opts.FormatOptions) > 0 {
+		parseOptsFn = func(init any) any {
+			v, err := copystructure.Copy(init)
+			if err != nil {
+				return nil
+			}
+			if err := mapstruct.ToStruct(opts.FormatOptions, &v); err != nil {
+				return nil
+			}
+			// nil if same as init
+			if reflect.DeepEqual(init, v) {
+				return nil
+			}
+			return v
+		}
+	}
+
+	dv, _, err := decode.Decode(ctx, br, group, decode.Options{
+		IsRoot:      true,
+		FillGaps:    true,
+		Force:       opts.Force,
+		Recover:     opts.Recover,
+		Description: name,
+		ParseOptsFn: parseOptsFn,
+	})
+	if dv == nil {
+		if err == nil {
+			err = errors.New("no value")
+		}
+		return nil, err
+	}
+
+	return &Value{dv: dv}, err
+}
@@ -0,0 +1,150 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"go/build"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/wader/fq/format/all"
+	"github.com/wader/fq/pkg/api"
+)
+
+func decodeAAC(t *testing.T) *api.Value {
+	t.Helper()
+	f, err := api.Open("../../format/mp4/testdata/aac.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	v, err := f.Decode(context.Background(), "mp4", api.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestPath(t *testing.T) {
+	v := decodeAAC(t)
+
+	if v.Format() != "mp4" {
+		t.Errorf("expected format mp4 got %q", v.Format())
+	}
+
+	typeV, err := v.Path("boxes[0].type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := typeV.Str(); err != nil || s != "ftyp" {
+		t.Errorf("expected ftyp got %q %v", s, err)
+	}
+	if b, err := typeV.Bytes(); err != nil || string(b) != "ftyp" {
+		t.Errorf("expected ftyp bytes got %q %v", b, err)
+	}
+	if p := typeV.PathString(); p != ".boxes[0].type" {
+		t.Errorf("expected .boxes[0].type got %q", p)
+	}
+	if r := typeV.Range(); r.Start != 4*8 || r.Len != 4*8 {
+		t.Errorf("unexpected range %s", r)
+	}
+
+	timeScaleV, err := v.Path(".boxes[-1].boxes[0].time_scale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := timeScaleV.Uint(); err != nil || n != 1000 {
+		t.Errorf("expected 1000 got %d %v", n, err)
+	}
+	if _, err := timeScaleV.Str(); err == nil {
+		t.Error("expected type error")
+	}
+
+	for _, p := range []string{"boxes[4]", "boxes[0].nope", "boxes[a]", "boxes[0].type.a"} {
+		if _, err := v.Path(p); err == nil {
+			t.Errorf("%s: expected error", p)
+		}
+	}
+}
+
+func TestIter(t *testing.T) {
+	v := decodeAAC(t)
+
+	boxesV, err := v.Field("boxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	it := boxesV.Iter()
+	for it.Next() {
+		s, err := it.Value().Path("type")
+		if err != nil {
+			t.Fatal(err)
+		}
+		str, _ := s.Str()
+		types = append(types, str)
+	}
+	expected := []string{"ftyp", "free", "mdat", "moov"}
+	if !reflect.DeepEqual(expected, types) {
+		t.Errorf("expected %v got %v", expected, types)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	v := decodeAAC(t)
+
+	var elstPath string
+	errFound := errors.New("found")
+	err := v.Walk(func(v *api.Value, _ int) error {
+		if v.Name() == "brands" {
+			return api.ErrSkipChildren
+		}
+		if s, err := v.Str(); err == nil && s == "elst" {
+			elstPath = v.PathString()
+			return errFound
+		}
+		return nil
+	})
+	if !errors.Is(err, errFound) {
+		t.Fatalf("expected found error got %v", err)
+	}
+	if elstPath != ".boxes[3].boxes[1].boxes[1].boxes[0].type" {
+		t.Errorf("unexpected path %q", elstPath)
+	}
+}
+
+func TestFormatNotFound(t *testing.T) {
+	f, err := api.Open("../../format/mp4/testdata/aac.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Decode(context.Background(), "nope", api.Options{}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// api should not depend on the jq interpreter
+func TestNoInterpDependency(t *testing.T) {
+	seen := map[string]bool{}
+	var walk func(path string, srcDir string)
+	walk = func(path string, srcDir string) {
+		if seen[path] || !strings.HasPrefix(path, "github.com/wader/") {
+			return
+		}
+		seen[path] = true
+		if path == "github.com/wader/fq/pkg/interp" || path == "github.com/wader/gojq" {
+			t.Errorf("api depends on %s", path)
+			return
+		}
+		p, err := build.Import(path, srcDir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range p.Imports {
+			walk(i, p.Dir)
+		}
+	}
+	walk("github.com/wader/fq/pkg/api", ".")
+}
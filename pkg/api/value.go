@@ -0,0 +1,409 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// ErrSkipChildren can be returned from a WalkFn to not walk children of a value
+var ErrSkipChildren = errors.New("skip children")
+
+// Value is a decoded struct, array or scalar. Lazy values are decoded on first
+// access.
+type Value struct {
+	dv *decode.Value
+}
+
+type scalarable interface {
+	ScalarActual() any
+	ScalarSym() any
+	ScalarIsGap() bool
+}
+
+func (v *Value) compound() *decode.Compound {
+	v.dv.Resolve()
+	c, _ := v.dv.V.(*decode.Compound)
+	return c
+}
+
+func (v *Value) scalar() (scalarable, error) {
+	v.dv.Resolve()
+	s, ok := v.dv.V.(scalarable)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a scalar", v.PathString())
+	}
+	return s, nil
+}
+
+// Decoded returns the underlying decode value
+func (v *Value) Decoded() *decode.Value { return v.dv }
+
+// Name of value, field name or array element name
+func (v *Value) Name() string { return v.dv.Name }
+
+// Format name if value is the root of a decoded format, otherwise empty
+func (v *Value) Format() string {
+	if v.dv.Format == nil {
+		return ""
+	}
+	return v.dv.Format.Name
+}
+
+// Description of value, ex: name of a decoded file
+func (v *Value) Description() string {
+	if c := v.compound(); c != nil {
+		return c.Description
+	}
+	return v.dv.Description
+}
+
+// Err is error recorded while decoding value, ex: with the recover option
+func (v *Value) Err() error {
+	v.dv.Resolve()
+	return v.dv.Err
+}
+
+// Range of value in bits
+func (v *Value) Range() ranges.Range { return v.dv.Range }
+
+// Parent value or nil if root
+func (v *Value) Parent() *Value {
+	if v.dv.Parent == nil {
+		return nil
+	}
+	return &Value{dv: v.dv.Parent}
+}
+
+// PathString returns path to value as a jq path expression, ex: .a.b[3]
+func (v *Value) PathString() string {
+	var parts []string
+	for dv := v.dv; dv.Parent != nil; dv = dv.Parent {
+		if c, ok := dv.Parent.V.(*decode.Compound); ok && c.IsArray {
+			parts = append(parts, "["+strconv.Itoa(dv.Index)+"]")
+		} else {
+			parts = append(parts, "."+dv.Name)
+		}
+	}
+	if len(parts) == 0 {
+		return "."
+	}
+	var sb strings.Builder
+	for i := len(parts) - 1; i >= 0; i-- {
+		sb.WriteString(parts[i])
+	}
+	return sb.String()
+}
+
+// IsStruct returns true if value is a struct
+func (v *Value) IsStruct() bool {
+	c := v.compound()
+	return c != nil && !c.IsArray
+}
+
+// IsArray returns true if value is an array
+func (v *Value) IsArray() bool {
+	c := v.compound()
+	return c != nil && c.IsArray
+}
+
+// IsGap returns true if value is a gap field for bits not decoded by any decoder
+func (v *Value) IsGap() bool {
+	s, err := v.scalar()
+	return err == nil && s.ScalarIsGap()
+}
+
+// Len is number of fields or elements, zero for scalars
+func (v *Value) Len() int {
+	if c := v.compound(); c != nil {
+		return len(c.Children)
+	}
+	return 0
+}
+
+// Index returns element i in an array or field i in a struct, negative i
+// index from the end
+func (v *Value) Index(i int) (*Value, error) {
+	c := v.compound()
+	if c == nil {
+		return nil, fmt.Errorf("%s: can't index scalar", v.PathString())
+	}
+	if i < 0 {
+		i += len(c.Children)
+	}
+	if i < 0 || i >= len(c.Children) {
+		return nil, fmt.Errorf("%s: index %d out of range", v.PathString(), i)
+	}
+	return &Value{dv: c.Children[i]}, nil
+}
+
+// Field returns struct field by name
+func (v *Value) Field(name string) (*Value, error) {
+	c := v.compound()
+	if c == nil || c.IsArray {
+		return nil, fmt.Errorf("%s: not a struct", v.PathString())
+	}
+	for _, cv := range c.Children {
+		if cv.Name == name {
+			return &Value{dv: cv}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: field %q not found", v.PathString(), name)
+}
+
+// Path looks up a value using a path relative to v, ex: "a.b[3]", ".a[-1].b"
+// or "[0]". Negative indexes index from the end.
+func (v *Value) Path(p string) (*Value, error) {
+	cv := v
+	s := p
+	for s != "" {
+		var err error
+		switch s[0] {
+		case '.':
+			s = s[1:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("%s: missing ]", p)
+			}
+			i, err := strconv.Atoi(s[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid index %q", p, s[1:end])
+			}
+			if cv, err = cv.Index(i); err != nil {
+				return nil, err
+			}
+			s = s[end+1:]
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end == -1 {
+				end = len(s)
+			}
+			if cv, err = cv.Field(s[0:end]); err != nil {
+				return nil, err
+			}
+			s = s[end:]
+		}
+	}
+	return cv, nil
+}
+
+// Actual returns the actual scalar value, ex: uint64, string or a bit reader
+func (v *Value) Actual() (any, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return nil, err
+	}
+	return s.ScalarActual(), nil
+}
+
+// Sym returns symbolic value of scalar or nil if it has none
+func (v *Value) Sym() (any, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return nil, err
+	}
+	return s.ScalarSym(), nil
+}
+
+func (v *Value) typeErr(expected string) error {
+	a, _ := v.Actual()
+	return fmt.Errorf("%s: expected %s got %T", v.PathString(), expected, a)
+}
+
+// Uint returns value of an unsigned integer, signed and big integers are
+// converted if in range
+func (v *Value) Uint() (uint64, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return 0, err
+	}
+	switch s := s.(type) {
+	case *scalar.Uint:
+		return s.Actual, nil
+	case *scalar.Sint:
+		if s.Actual >= 0 {
+			return uint64(s.Actual), nil
+		}
+	case *scalar.BigInt:
+		if s.Actual != nil && s.Actual.IsUint64() {
+			return s.Actual.Uint64(), nil
+		}
+	}
+	return 0, v.typeErr("uint")
+}
+
+// Sint returns value of a signed integer, unsigned and big integers are
+// converted if in range
+func (v *Value) Sint() (int64, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return 0, err
+	}
+	switch s := s.(type) {
+	case *scalar.Sint:
+		return s.Actual, nil
+	case *scalar.Uint:
+		if s.Actual <= math.MaxInt64 {
+			return int64(s.Actual), nil
+		}
+	case *scalar.BigInt:
+		if s.Actual != nil && s.Actual.IsInt64() {
+			return s.Actual.Int64(), nil
+		}
+	}
+	return 0, v.typeErr("sint")
+}
+
+// BigInt returns value of any integer as a big integer
+func (v *Value) BigInt() (*big.Int, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return nil, err
+	}
+	switch s := s.(type) {
+	case *scalar.BigInt:
+		if s.Actual != nil {
+			return new(big.Int).Set(s.Actual), nil
+		}
+	case *scalar.Uint:
+		return new(big.Int).SetUint64(s.Actual), nil
+	case *scalar.Sint:
+		return big.NewInt(s.Actual), nil
+	}
+	return nil, v.typeErr("integer")
+}
+
+// Float returns value of a float, integers are converted
+func (v *Value) Float() (float64, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return 0, err
+	}
+	switch s := s.(type) {
+	case *scalar.Flt:
+		return s.Actual, nil
+	case *scalar.Uint:
+		return float64(s.Actual), nil
+	case *scalar.Sint:
+		return float64(s.Actual), nil
+	}
+	return 0, v.typeErr("float")
+}
+
+// Str returns value of a string
+func (v *Value) Str() (string, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return "", err
+	}
+	if s, ok := s.(*scalar.Str); ok {
+		return s.Actual, nil
+	}
+	return "", v.typeErr("string")
+}
+
+// Bool returns value of a boolean
+func (v *Value) Bool() (bool, error) {
+	s, err := v.scalar()
+	if err != nil {
+		return false, err
+	}
+	if s, ok := s.(*scalar.Bool); ok {
+		return s.Actual, nil
+	}
+	return false, v.typeErr("bool")
+}
+
+// BitReader returns a bit reader for the bits of the value. For raw bits
+// fields it's the field content, ex: decompressed data, otherwise the range of
+// the value in the buffer it was decoded from.
+func (v *Value) BitReader() (bitio.ReaderAtSeeker, error) {
+	v.dv.Resolve()
+	if s, ok := v.dv.V.(*scalar.BitBuf); ok {
+		return s.Actual, nil
+	}
+	return bitioex.Range(v.dv.RootReader, v.dv.Range.Start, v.dv.Range.Len)
+}
+
+// Bytes returns the bits of the value as bytes, zero bit padded if not byte
+// aligned, see BitReader
+func (v *Value) Bytes() ([]byte, error) {
+	br, err := v.BitReader()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err := bitioex.CopyBits(buf, br); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Iter is an iterator over fields or elements of a value, lazy values are
+// decoded when reached
+//
+//	it := v.Iter()
+//	for it.Next() {
+//		c := it.Value()
+//		...
+//	}
+type Iter struct {
+	c *decode.Compound
+	i int
+}
+
+// Iter returns an iterator over fields or elements, empty for scalars
+func (v *Value) Iter() *Iter {
+	return &Iter{c: v.compound(), i: -1}
+}
+
+// Next moves to next value, returns false when done
+func (it *Iter) Next() bool {
+	if it.c == nil || it.i+1 >= len(it.c.Children) {
+		return false
+	}
+	it.i++
+	return true
+}
+
+// Value returns current value
+func (it *Iter) Value() *Value {
+	return &Value{dv: it.c.Children[it.i]}
+}
+
+// WalkFn is called for each value by Walk
+type WalkFn func(v *Value, depth int) error
+
+// Walk calls fn for v and all values below it in pre-order, lazy values are
+// decoded when reached. Return ErrSkipChildren to skip children of a value,
+// any other error stops the walk and is returned.
+func (v *Value) Walk(fn WalkFn) error {
+	var walkFn func(v *Value, depth int) error
+	walkFn = func(v *Value, depth int) error {
+		if err := fn(v, depth); err != nil {
+			if errors.Is(err, ErrSkipChildren) {
+				return nil
+			}
+			return err
+		}
+		it := v.Iter()
+		for it.Next() {
+			if err := walkFn(it.Value(), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walkFn(v, 0)
+}
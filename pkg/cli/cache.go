@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+// dirIndexCache stores each entry as a file in dir
+type dirIndexCache struct {
+	dir string
+}
+
+const dirIndexCacheExt = ".json"
+
+func (c dirIndexCache) path(key string) string {
+	return filepath.Join(c.dir, key+dirIndexCacheExt)
+}
+
+func (c dirIndexCache) Keys() ([]string, error) {
+	des, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, de := range des {
+		if name := de.Name(); strings.HasSuffix(name, dirIndexCacheExt) {
+			keys = append(keys, strings.TrimSuffix(name, dirIndexCacheExt))
+		}
+	}
+	return keys, nil
+}
+
+func (c dirIndexCache) Get(key string) ([]byte, error) {
+	return os.ReadFile(c.path(key))
+}
+
+func (c dirIndexCache) Set(key string, b []byte) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	// write to temp file and rename to not leave partial entries
+	f, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path(key))
+}
+
+func (c dirIndexCache) Delete(key string) error {
+	return os.Remove(c.path(key))
+}
+
+func (*stdOS) IndexCache() interp.IndexCache {
+	p, err := os.UserCacheDir()
+	if err != nil {
+		return nil
+	}
+	return dirIndexCache{dir: filepath.Join(p, "fq", "index")}
+}
@@ -100,6 +100,14 @@ type stdinInput struct {
 	fs.File
 }
 
+func (i stdinInput) RawMode() (func() error, error) {
+	state, err := readline.MakeRaw(int(i.fdTerminal))
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return readline.Restore(int(i.fdTerminal), state) }, nil
+}
+
 func (o *stdOS) Stdin() interp.Input {
 	return stdinInput{
 		fdTerminal: fdTerminal(os.Stdin.Fd()),
@@ -160,6 +168,27 @@ func (stdOSFS) Open(name string) (fs.File, error) { return os.Open(name) }
 
 func (*stdOS) FS() fs.FS { return stdOSFS{} }
 
+func (*stdOS) AbsPath(name string) (string, error) { return filepath.Abs(name) }
+
+func (*stdOS) WriteFile(name string, data []byte) error {
+	name = filepath.FromSlash(name)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var _ interp.IndexCacher = (*stdOS)(nil)
+var _ interp.FileWriter = (*stdOS)(nil)
+
 func (o *stdOS) Readline(opts interp.ReadlineOpts) (string, error) {
 	if o.rl == nil {
 		var err error
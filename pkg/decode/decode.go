@@ -11,6 +11,7 @@ import (
 
 	"github.com/wader/fq/internal/bitioex"
 	"github.com/wader/fq/internal/ioex"
+	"github.com/wader/fq/internal/profile"
 	"github.com/wader/fq/internal/recoverfn"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/ranges"
@@ -38,6 +39,16 @@ type Options struct {
 	InArg       any
 	ParseOptsFn func(init any) any
 	ReadBuf     *[]byte
+	Index       *Index   // if not nil, root format can add or use cached records
+	Origins     []Origin // if input is a concatenation of files, in order
+	Recover     bool     // record errors in structs and arrays and continue at resync position
+	Trace       bool     // record call sites, positions, seeks, peeks and formats in Value.Trace
+}
+
+// Origin is a file that a range of a concatenated input comes from
+type Origin struct {
+	Name  string
+	Range ranges.Range
 }
 
 // Decode try decode group and return first success and all other decoder errors
@@ -56,6 +67,16 @@ func decode(ctx context.Context, br bitio.ReaderAtSeeker, group *Group, opts Opt
 		decodeRange = ranges.Range{Len: brLen}
 	}
 
+	// make origins relative to decode range
+	if len(opts.Origins) > 0 && decodeRange.Start != 0 {
+		origins := make([]Origin, len(opts.Origins))
+		for i, o := range opts.Origins {
+			o.Range.Start -= decodeRange.Start
+			origins[i] = o
+		}
+		opts.Origins = origins
+	}
+
 	if group == nil {
 		panic("group is nil, failed to register format?")
 	}
@@ -104,14 +125,38 @@ func decode(ctx context.Context, br bitio.ReaderAtSeeker, group *Group, opts Opt
 			return nil, nil, IOError{Err: err, Op: "BitBufRange", ReadSize: decodeRange.Len, Pos: decodeRange.Start}
 		}
 
-		d := newDecoder(ctx, f, cBR, opts)
+		// only keep records from the format that succeeds
+		if opts.Index != nil && !opts.Index.Cached {
+			opts.Index.Format = ""
+			opts.Index.Records = nil
+		}
+
+		// recover only applies to a chosen format, formats tried when probing are
+		// decoded without it so that the same format is found as without recover
+		fOpts := opts
+		if len(group.Formats) != 1 {
+			fOpts.Recover = false
+		}
+		d := newDecoder(ctx, f, cBR, fOpts)
 
 		d.inArgs = inArgs
 
 		var decodeV any
-		r, rOk := recoverfn.Run(func() {
-			decodeV = f.DecodeFn(d)
-		})
+		var r recoverfn.Raw
+		var rOk bool
+		decodeFn := func() {
+			r, rOk = recoverfn.Run(func() {
+				decodeV = f.DecodeFn(d)
+			})
+		}
+		if opts.Trace {
+			d.Value.Trace = newTrace(0)
+			u := profile.Measure(decodeFn)
+			d.Value.Trace.PosAfter = d.Pos()
+			d.Value.Trace.Usage = &u
+		} else {
+			decodeFn()
+		}
 
 		if ctx != nil && ctx.Err() != nil {
 			return nil, nil, ctx.Err()
@@ -150,9 +195,12 @@ func decode(ctx context.Context, br bitio.ReaderAtSeeker, group *Group, opts Opt
 		}
 
 		var minMaxRange ranges.Range
-		if err := d.Value.WalkRootPreOrder(func(v *Value, _ *Value, _ int, _ int) error {
+		if err := d.Value.walkRootNoResolve(true, func(v *Value, _ *Value, _ int, _ int) error {
 			minMaxRange = ranges.MinMax(minMaxRange, v.Range)
 			v.Range.Start += decodeRange.Start
+			if v.Trace != nil {
+				v.Trace.shift(decodeRange.Start)
+			}
 			v.RootReader = br
 			return nil
 		}); err != nil {
@@ -186,6 +234,8 @@ type D struct {
 	readBuf *[]byte
 
 	inArgs []any
+	format *Format
+	resync *resync // set if recover option is used, shared with range decoders
 }
 
 // TODO: new struct decoder?
@@ -216,6 +266,7 @@ func newDecoder(ctx context.Context, format *Format, br bitio.ReaderAtSeeker, op
 
 		bitBuf:  br,
 		readBuf: opts.ReadBuf,
+		format:  format,
 	}
 }
 
@@ -238,6 +289,10 @@ func (d *D) ArgAs(target any) bool {
 }
 
 func (d *D) fieldDecoder(name string, bitBuf bitio.ReaderAtSeeker, v any) *D {
+	var trace *Trace
+	if d.Options.Trace {
+		trace = newTrace(d.Pos())
+	}
 	return &D{
 		Ctx:    d.Ctx,
 		Endian: d.Endian,
@@ -246,11 +301,13 @@ func (d *D) fieldDecoder(name string, bitBuf bitio.ReaderAtSeeker, v any) *D {
 			V:          v,
 			Range:      ranges.Range{Start: d.Pos(), Len: 0},
 			RootReader: bitBuf,
+			Trace:      trace,
 		},
 		Options: d.Options,
 
 		bitBuf:  bitBuf,
 		readBuf: d.readBuf,
+		format:  d.format,
 	}
 }
 
@@ -326,6 +383,11 @@ func (d *D) SharedReadBuf(n int) []byte {
 func (d *D) FillGaps(r ranges.Range, namePrefix string) {
 	makeWalkFn := func(fn func(iv *Value)) func(iv *Value, rootV *Value, depth int, rootDepth int) error {
 		return func(iv *Value, _ *Value, _ int, _ int) error {
+			// unresolved lazy value covers its whole range
+			if iv.IsLazy() {
+				fn(iv)
+				return ErrWalkSkipChildren
+			}
 			switch iv.V.(type) {
 			case *Compound:
 			default:
@@ -338,10 +400,10 @@ func (d *D) FillGaps(r ranges.Range, namePrefix string) {
 	// TODO: redo this, tries to get rid of slice grow
 	// TODO: pre-sorted somehow?
 	n := 0
-	_ = d.Value.WalkRootPreOrder(makeWalkFn(func(_ *Value) { n++ }))
+	_ = d.Value.walkRootNoResolve(true, makeWalkFn(func(_ *Value) { n++ }))
 	valueRanges := make([]ranges.Range, n)
 	i := 0
-	_ = d.Value.WalkRootPreOrder(makeWalkFn(func(iv *Value) {
+	_ = d.Value.walkRootNoResolve(true, makeWalkFn(func(iv *Value) {
 		valueRanges[i] = iv.Range
 		i++
 	}))
@@ -442,6 +504,7 @@ func (d *D) TryPeekBytes(nBytes int) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	d.traceEvent(TraceEvent{Op: TraceOpPeek, Pos: start, Len: int64(nBytes) * 8})
 	bs, err := d.TryBytesLen(nBytes)
 	if _, err := d.bitBuf.SeekBits(start, io.SeekStart); err != nil {
 		return nil, err
@@ -485,6 +548,7 @@ func (d *D) TryPeekBits(nBits int) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
+	d.traceEvent(TraceEvent{Op: TraceOpPeek, Pos: start, Len: int64(nBits)})
 	n, err := d.TryUintBits(nBits)
 	if _, err := d.bitBuf.SeekBits(start, io.SeekStart); err != nil {
 		return 0, err
@@ -529,6 +593,8 @@ func (d *D) TryPeekFind(nBits int, seekBits int64, maxLen int64, fn func(v uint6
 			return 0, 0, err
 		}
 	}
+	// length of peeked bits, negative if searching backwards
+	d.traceEvent(TraceEvent{Op: TraceOpPeek, Pos: start, Len: count})
 	if _, err := d.bitBuf.SeekBits(start, io.SeekStart); err != nil {
 		return 0, 0, err
 	}
@@ -741,6 +807,7 @@ func (d *D) trySeekAbs(pos int64, fns ...func(d *D)) (int64, error) {
 		oldPos = d.Pos()
 	}
 
+	d.traceEvent(TraceEvent{Op: TraceOpSeek, Pos: d.Pos(), To: pos})
 	pos, err := d.bitBuf.SeekBits(pos, io.SeekStart)
 	if err != nil {
 		return 0, err
@@ -842,7 +909,7 @@ func (d *D) FieldArray(name string, fn func(d *D)) *D {
 	c := &Compound{IsArray: true}
 	cd := d.fieldDecoder(name, d.bitBuf, c)
 	d.AddChild(cd.Value)
-	fn(cd)
+	d.fieldFn(cd, fn)
 	return cd
 }
 
@@ -856,7 +923,7 @@ func (d *D) FieldStruct(name string, fn func(d *D)) *D {
 	c := &Compound{IsArray: false}
 	cd := d.fieldDecoder(name, d.bitBuf, c)
 	d.AddChild(cd.Value)
-	fn(cd)
+	d.fieldFn(cd, fn)
 	return cd
 }
 
@@ -968,6 +1035,20 @@ func (d *D) RangeFn(firstBit int64, nBits int64, fn func(d *D)) int64 {
 	return endPos - startPos
 }
 
+// formatFailed reports if a sub format decode failed. With the recover option a
+// decode of a chosen format that recovered from errors in structs or arrays is
+// kept, the errors are on its values. Err on the root value is set if the format
+// itself failed. Probed formats are decoded without recover, see decode.
+func (d *D) formatFailed(dv *Value) bool {
+	if dv == nil {
+		return true
+	}
+	if d.Options.Recover && dv.Err == nil {
+		return false
+	}
+	return dv.Errors() != nil
+}
+
 func (d *D) Format(group *Group, inArg any) any {
 	dv, v, err := decode(d.Ctx, d.bitBuf, group, Options{
 		Force:       d.Options.Force,
@@ -976,11 +1057,14 @@ func (d *D) Format(group *Group, inArg any) any {
 		Range:       ranges.Range{Start: d.Pos(), Len: d.BitsLeft()},
 		InArg:       inArg,
 		ParseOptsFn: d.Options.ParseOptsFn,
+		Recover:     d.Options.Recover,
+		Trace:       d.Options.Trace,
 		ReadBuf:     d.readBuf,
 	})
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		d.IOPanic(err, "Format: decode")
 	}
+	d.traceFormat(dv)
 
 	switch vv := dv.V.(type) {
 	case *Compound:
@@ -1007,12 +1091,15 @@ func (d *D) TryFieldFormat(name string, group *Group, inArg any) (*Value, any, e
 		Range:       ranges.Range{Start: d.Pos(), Len: d.BitsLeft()},
 		InArg:       inArg,
 		ParseOptsFn: d.Options.ParseOptsFn,
+		Recover:     d.Options.Recover,
+		Trace:       d.Options.Trace,
 		ReadBuf:     d.readBuf,
 	})
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		return nil, nil, err
 	}
 
+	d.traceFormat(dv)
 	d.AddChild(dv)
 	if _, err := d.bitBuf.SeekBits(dv.Range.Len, io.SeekCurrent); err != nil {
 		d.IOPanic(err, "TryFieldFormat: SeekRel")
@@ -1023,7 +1110,7 @@ func (d *D) TryFieldFormat(name string, group *Group, inArg any) (*Value, any, e
 
 func (d *D) FieldFormat(name string, group *Group, inArg any) (*Value, any) {
 	dv, v, err := d.TryFieldFormat(name, group, inArg)
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		d.IOPanic(err, "FieldFormat: TryFieldFormat")
 	}
 	return dv, v
@@ -1046,12 +1133,15 @@ func (d *D) TryFieldFormatLen(name string, nBits int64, group *Group, inArg any)
 		Range:       ranges.Range{Start: d.Pos(), Len: nBits},
 		InArg:       inArg,
 		ParseOptsFn: d.Options.ParseOptsFn,
+		Recover:     d.Options.Recover,
+		Trace:       d.Options.Trace,
 		ReadBuf:     d.readBuf,
 	})
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		return nil, nil, err
 	}
 
+	d.traceFormat(dv)
 	d.AddChild(dv)
 	if _, err := d.bitBuf.SeekBits(nBits, io.SeekCurrent); err != nil {
 		d.IOPanic(err, "TryFieldFormatLen: SeekRel")
@@ -1062,7 +1152,7 @@ func (d *D) TryFieldFormatLen(name string, nBits int64, group *Group, inArg any)
 
 func (d *D) FieldFormatLen(name string, nBits int64, group *Group, inArg any) (*Value, any) {
 	dv, v, err := d.TryFieldFormatLen(name, nBits, group, inArg)
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		d.IOPanic(err, "FieldFormatLen: TryFieldFormatLen")
 	}
 	return dv, v
@@ -1086,12 +1176,15 @@ func (d *D) TryFieldFormatRange(name string, firstBit int64, nBits int64, group
 		Range:       ranges.Range{Start: firstBit, Len: nBits},
 		InArg:       inArg,
 		ParseOptsFn: d.Options.ParseOptsFn,
+		Recover:     d.Options.Recover,
+		Trace:       d.Options.Trace,
 		ReadBuf:     d.readBuf,
 	})
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		return nil, nil, err
 	}
 
+	d.traceFormat(dv)
 	d.AddChild(dv)
 
 	return dv, v, err
@@ -1099,7 +1192,7 @@ func (d *D) TryFieldFormatRange(name string, firstBit int64, nBits int64, group
 
 func (d *D) FieldFormatRange(name string, firstBit int64, nBits int64, group *Group, inArg any) (*Value, any) {
 	dv, v, err := d.TryFieldFormatRange(name, firstBit, nBits, group, inArg)
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		d.IOPanic(err, "FieldFormatRange: TryFieldFormatRange")
 	}
 
@@ -1114,14 +1207,17 @@ func (d *D) TryFieldFormatBitBuf(name string, br bitio.ReaderAtSeeker, group *Gr
 		IsRoot:      true,
 		InArg:       inArg,
 		ParseOptsFn: d.Options.ParseOptsFn,
+		Recover:     d.Options.Recover,
+		Trace:       d.Options.Trace,
 		ReadBuf:     d.readBuf,
 	})
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		return nil, nil, err
 	}
 
 	dv.Range.Start = d.Pos()
 
+	d.traceFormat(dv)
 	d.AddChild(dv)
 
 	return dv, v, err
@@ -1129,7 +1225,7 @@ func (d *D) TryFieldFormatBitBuf(name string, br bitio.ReaderAtSeeker, group *Gr
 
 func (d *D) FieldFormatBitBuf(name string, br bitio.ReaderAtSeeker, group *Group, inArg any) (*Value, any) {
 	dv, v, err := d.TryFieldFormatBitBuf(name, br, group, inArg)
-	if dv == nil || dv.Errors() != nil {
+	if d.formatFailed(dv) {
 		d.IOPanic(err, "FieldFormatBitBuf: TryFieldFormatBitBuf")
 	}
 
@@ -1248,6 +1344,10 @@ func (d *D) TryFieldValue(name string, fn func() (*Value, error)) (*Value, error
 	if err != nil {
 		return nil, err
 	}
+	if d.Options.Trace {
+		v.Trace = newTrace(start)
+		v.Trace.PosAfter = stop
+	}
 	d.AddChild(v)
 
 	return v, err
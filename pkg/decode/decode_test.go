@@ -0,0 +1,29 @@
+package decode_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+)
+
+// testDecodeErr decodes b as root using fn as decode function
+func testDecodeErr(ctx context.Context, b []byte, fn func(d *decode.D)) (*decode.Value, error) {
+	f := &decode.Format{
+		Name:     "test",
+		DecodeFn: func(d *decode.D) any { fn(d); return nil },
+	}
+	g := &decode.Group{Name: "test", Formats: []*decode.Format{f}}
+	dv, _, err := decode.Decode(ctx, bitio.NewBitReader(b, -1), g, decode.Options{IsRoot: true, FillGaps: true})
+	return dv, err
+}
+
+func testDecode(t *testing.T, b []byte, fn func(d *decode.D)) *decode.Value {
+	t.Helper()
+	dv, err := testDecodeErr(context.Background(), b, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dv
+}
@@ -1,5 +1,7 @@
 package decode
 
+import "bufio"
+
 type Group struct {
 	Name         string
 	Formats      []*Format
@@ -23,6 +25,8 @@ type Format struct {
 	Dependencies       []Dependency
 	Functions          []string
 	SkipDecodeFunction bool
+	StreamFn           func(r *bufio.Reader) StreamChunkFn // splits non-seekable input into chunks, see StreamChunkFn
+	Schema             *Schema                             // optional static description of decoded values, see Schema
 }
 
 func FormatFn(fn func(d *D) any) *Group {
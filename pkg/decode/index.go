@@ -0,0 +1,86 @@
+package decode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/wader/fq/pkg/ranges"
+)
+
+// Index is the top-level layout of a decode, ex ranges of packets or records.
+// A format can add records while decoding and if the index was loaded from a
+// cache use them instead of scanning the input again.
+type Index struct {
+	Format  string
+	Records map[string][]ranges.Range
+	// Cached is true if index was loaded and should be used instead of added to
+	Cached bool
+}
+
+// indexJSON is used to store ranges as a flat start, len, ... array to keep
+// size down as there can be lots of records
+type indexJSON struct {
+	Format  string             `json:"format"`
+	Records map[string][]int64 `json:"records"`
+}
+
+func (i *Index) MarshalJSON() ([]byte, error) {
+	ij := indexJSON{Format: i.Format, Records: map[string][]int64{}}
+	for name, rs := range i.Records {
+		flat := make([]int64, 0, len(rs)*2)
+		for _, r := range rs {
+			flat = append(flat, r.Start, r.Len)
+		}
+		ij.Records[name] = flat
+	}
+	return json.Marshal(ij)
+}
+
+func (i *Index) UnmarshalJSON(b []byte) error {
+	var ij indexJSON
+	if err := json.Unmarshal(b, &ij); err != nil {
+		return err
+	}
+	i.Format = ij.Format
+	i.Records = map[string][]ranges.Range{}
+	for name, flat := range ij.Records {
+		if len(flat)%2 != 0 {
+			return fmt.Errorf("%s: odd number of range values", name)
+		}
+		rs := make([]ranges.Range, len(flat)/2)
+		for j := range rs {
+			rs[j] = ranges.Range{Start: flat[j*2], Len: flat[j*2+1]}
+		}
+		i.Records[name] = rs
+	}
+	return nil
+}
+
+// IsEmpty returns true if no records has been added
+func (i *Index) IsEmpty() bool {
+	return len(i.Records) == 0
+}
+
+// IndexRecords returns cached record ranges for name, if found decoder should
+// use them instead of scanning the input
+func (d *D) IndexRecords(name string) ([]ranges.Range, bool) {
+	idx := d.Options.Index
+	if idx == nil || !idx.Cached || idx.Format != d.format.Name {
+		return nil, false
+	}
+	rs, ok := idx.Records[name]
+	return rs, ok
+}
+
+// IndexAddRecord adds a record range for name to the index
+func (d *D) IndexAddRecord(name string, r ranges.Range) {
+	idx := d.Options.Index
+	if idx == nil || idx.Cached {
+		return
+	}
+	if idx.Records == nil {
+		idx.Records = map[string][]ranges.Range{}
+	}
+	idx.Format = d.format.Name
+	idx.Records[name] = append(idx.Records[name], r)
+}
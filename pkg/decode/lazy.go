@@ -0,0 +1,222 @@
+package decode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/internal/recoverfn"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/ranges"
+)
+
+// Lazy fields are decoded on first access. Until then the value is an empty
+// compound placeholder that covers the range of the field. Resolve is called
+// by Walk and when the value is accessed from jq, it runs the decode function
+// and replaces the placeholder with the field it added.
+//
+// Note that errors from the decode function will not fail the parent decode,
+// instead they end up as an error on the field when resolved.
+
+type lazyValue struct {
+	once sync.Once
+	// set using atomic as IsLazy can be called concurrently with resolve
+	resolved uint32
+	fn       func(d *D)
+	ctx      context.Context
+	bitBuf   bitio.ReaderAtSeeker
+	start    int64
+	nBits    int64
+	endian   Endian
+	options  Options
+	inArgs   []any
+	format   *Format
+	// fillGaps fills gaps inside the range, parallel arrays leave it to the
+	// root decoder
+	fillGaps bool
+}
+
+// IsLazy returns true if v is a lazy field that has not been resolved yet
+func (v *Value) IsLazy() bool {
+	return v.lazy != nil && atomic.LoadUint32(&v.lazy.resolved) == 0
+}
+
+// Resolve decodes v if it's a lazy field, safe to call multiple times and on
+// non-lazy values
+func (v *Value) Resolve() {
+	_ = v.resolve()
+}
+
+// resolve returns error if decode function failed, errors from formats
+// decoded by the function are only set on the value
+func (v *Value) resolve() error {
+	if v.lazy == nil {
+		return nil
+	}
+	var err error
+	v.lazy.once.Do(func() {
+		err = v.lazy.resolve(v)
+		atomic.StoreUint32(&v.lazy.resolved, 1)
+		// release reader and closures
+		v.lazy.fn = nil
+		v.lazy.ctx = nil
+		v.lazy.bitBuf = nil
+		v.lazy.inArgs = nil
+		v.lazy.format = nil
+	})
+	return err
+}
+
+func (l *lazyValue) resolve(v *Value) error {
+	br, err := bitioex.Range(l.bitBuf, 0, l.start+l.nBits)
+	if err == nil {
+		_, err = br.SeekBits(l.start, io.SeekStart)
+	}
+	if err != nil {
+		v.Err = IOError{Err: err, Op: "Resolve", ReadSize: l.nBits, Pos: l.start}
+		return v.Err
+	}
+
+	ctx := l.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	d := &D{
+		Ctx:    ctx,
+		Endian: l.endian,
+		Value: &Value{
+			Name:       v.Name,
+			V:          &Compound{},
+			Range:      ranges.Range{Start: l.start, Len: 0},
+			RootReader: br,
+		},
+		Options: l.options,
+		bitBuf:  br,
+		inArgs:  l.inArgs,
+		format:  l.format,
+	}
+
+	r, rOk := recoverfn.Run(func() { l.fn(d) })
+
+	var resolveErr error
+	if !rOk {
+		if err, ok := r.RecoverV.(error); ok {
+			resolveErr = err
+		} else {
+			resolveErr = fmt.Errorf("recoverable non-panic error :%v", r.RecoverV)
+		}
+	}
+
+	children := d.Value.V.(*Compound).Children
+	if len(children) != 1 {
+		if resolveErr == nil {
+			resolveErr = fmt.Errorf("lazy field %s: expected one field got %d", v.Name, len(children))
+		}
+		if len(children) == 0 {
+			v.Err = resolveErr
+			return resolveErr
+		}
+	}
+	cv := children[0]
+
+	if !cv.IsRoot && l.nBits > 0 && l.fillGaps {
+		if _, ok := cv.V.(*Compound); ok {
+			cd := *d
+			cd.Value = cv
+			cd.FillGaps(ranges.Range{Start: l.start, Len: l.nBits}, "gap")
+		}
+	}
+	cv.postProcess()
+
+	// ranges are relative to the captured reader, move them to where the
+	// placeholder is now
+	delta := v.Range.Start - l.start
+	if cv.IsRoot {
+		v.Range = ranges.Range{Start: cv.Range.Start + delta, Len: cv.Range.Len}
+		v.RootReader = cv.RootReader
+	} else {
+		if !l.fillGaps {
+			v.Range = ranges.Range{Start: cv.Range.Start + delta, Len: cv.Range.Len}
+		}
+		rootReader := v.RootReader
+		_ = cv.Walk(WalkOpts{
+			PreOrder:  true,
+			OneRoot:   true,
+			NoResolve: true,
+			Fn: func(wv *Value, _ *Value, _ int, _ int) error {
+				wv.Range.Start += delta
+				wv.RootReader = rootReader
+				return nil
+			},
+		})
+	}
+
+	v.V = cv.V
+	v.IsRoot = cv.IsRoot
+	v.Format = cv.Format
+	v.Description = cv.Description
+	v.Err = cv.Err
+	if resolveErr != nil && v.Err == nil {
+		v.Err = resolveErr
+	}
+	if c, ok := v.V.(*Compound); ok {
+		for _, f := range c.Children {
+			f.Parent = v
+		}
+	}
+
+	return resolveErr
+}
+
+func (d *D) fieldLazy(name string, firstBit int64, nBits int64, fn func(d *D)) *Value {
+	if nBits < 0 {
+		d.Fatalf("%d nBits < 0", nBits)
+	}
+	// make sure range is valid now instead of when resolved
+	d.BitBufRange(firstBit, nBits)
+
+	// index is only used during decode
+	options := d.Options
+	options.Index = nil
+
+	v := &Value{
+		Name:       name,
+		V:          &Compound{},
+		Range:      ranges.Range{Start: firstBit, Len: nBits},
+		RootReader: d.bitBuf,
+		lazy: &lazyValue{
+			fn:       fn,
+			bitBuf:   d.bitBuf,
+			start:    firstBit,
+			nBits:    nBits,
+			endian:   d.Endian,
+			options:  options,
+			inArgs:   d.inArgs,
+			format:   d.format,
+			fillGaps: true,
+		},
+	}
+	d.AddChild(v)
+
+	return v
+}
+
+// FieldLazy adds a field that is decoded by fn on first access. fn will decode
+// from current position nBits forward and should add exactly one field.
+// When done position will be nBits forward.
+func (d *D) FieldLazy(name string, nBits int64, fn func(d *D)) *Value {
+	v := d.fieldLazy(name, d.Pos(), nBits, fn)
+	d.SeekRel(nBits)
+	return v
+}
+
+// FieldLazyRange adds a field that is decoded by fn on first access. fn will
+// decode from firstBit position nBits forward and should add exactly one field.
+// Position will not change.
+func (d *D) FieldLazyRange(name string, firstBit int64, nBits int64, fn func(d *D)) *Value {
+	return d.fieldLazy(name, firstBit, nBits, fn)
+}
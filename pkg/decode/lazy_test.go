@@ -0,0 +1,74 @@
+package decode_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func TestLazyErrors(t *testing.T) {
+	dv := testDecode(t, []byte{1, 2}, func(d *decode.D) {
+		d.FieldU8("a")
+		d.FieldLazy("b", 8, func(d *decode.D) {
+			d.FieldU8("b")
+			d.Errorf("lazy error")
+		})
+	})
+	b := dv.V.(*decode.Compound).Children[1]
+
+	if !b.IsLazy() {
+		t.Fatal("expected b to be lazy")
+	}
+	// unresolved lazy fields are not part of errors
+	if errs := dv.Errors(); errs != nil {
+		t.Fatalf("expected no errors got %v", errs)
+	}
+
+	b.Resolve()
+	if b.IsLazy() {
+		t.Fatal("expected b to be resolved")
+	}
+	errs := dv.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("expected one error got %v", errs)
+	}
+	var de decode.DecoderError
+	if !errors.As(errs[0], &de) || de.Reason != "lazy error" {
+		t.Fatalf("expected lazy error got %v", errs[0])
+	}
+}
+
+// run with -race
+func TestLazyConcurrentResolve(t *testing.T) {
+	b := make([]byte, 64)
+	dv := testDecode(t, b, func(d *decode.D) {
+		d.FieldArray("a", func(d *decode.D) {
+			for !d.End() {
+				d.FieldLazy("b", 8, func(d *decode.D) { d.FieldU8("b") })
+			}
+		})
+	})
+	a := dv.V.(*decode.Compound).Children[0]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, c := range a.V.(*decode.Compound).Children {
+				_ = c.IsLazy()
+				c.Resolve()
+				if c.IsLazy() {
+					t.Error("expected resolved")
+				}
+				if _, ok := c.V.(*scalar.Uint); !ok {
+					t.Errorf("expected scalar got %T", c.V)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
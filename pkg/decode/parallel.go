@@ -0,0 +1,95 @@
+package decode
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/ranges"
+)
+
+// Parallel arrays decode independent ranges, ex pages or archive entries, using
+// a pool of workers. Each range is decoded by its own decoder, reads from the
+// shared input are serialized as the underlying reader might seek. Fields are
+// added in range order so the result is the same as a sequential decode.
+// Records decoded on first access using FieldLazy, ex: pcap packets and pg_heap
+// pages, or records that depend on previous records don't gain from it.
+
+// lockedReaderAt serializes reads from a reader shared by workers
+type lockedReaderAt struct {
+	mu *sync.Mutex
+	r  bitio.ReaderAt
+}
+
+func (r lockedReaderAt) ReadBitsAt(p []byte, nBits int64, bitOff int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.ReadBitsAt(p, nBits, bitOff)
+}
+
+// FieldArrayParallel adds array name with one elemName field per range decoded
+// by fn. fn is called concurrently so it should not modify shared state, it will
+// decode from range start and should add exactly one field. If fn fails the
+// first error in range order fails the decode.
+// When done position will be at end of the last range.
+func (d *D) FieldArrayParallel(name string, elemName string, rs []ranges.Range, fn func(d *D)) *D {
+	l, err := bitioex.Len(d.bitBuf)
+	if err != nil {
+		d.IOPanic(err, "FieldArrayParallel: Len")
+	}
+	lr := lockedReaderAt{mu: &sync.Mutex{}, r: d.bitBuf}
+
+	var vs []*Value
+	var end int64
+	cd := d.FieldArray(name, func(d *D) {
+		for _, r := range rs {
+			v := d.fieldLazy(elemName, r.Start, r.Len, fn)
+			v.lazy.ctx = d.Ctx
+			// own reader per range as resolve seeks
+			v.lazy.bitBuf = bitio.NewSectionReader(lr, 0, l)
+			v.lazy.fillGaps = false
+			vs = append(vs, v)
+			if r.Stop() > end {
+				end = r.Stop()
+			}
+		}
+	})
+
+	errs := make([]error, len(vs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := runtime.GOMAXPROCS(0)
+	for i := 0; i < workers && i < len(vs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				errs[j] = vs[j].resolve()
+			}
+		}()
+	}
+	for i := range vs {
+		if d.Ctx != nil && d.Ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if d.Ctx != nil && d.Ctx.Err() != nil {
+		d.IOPanic(d.Ctx.Err(), "FieldArrayParallel")
+	}
+	for _, err := range errs {
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(vs) > 0 {
+		d.SeekAbs(end)
+	}
+
+	return cd
+}
@@ -0,0 +1,146 @@
+package decode_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// ranges in reverse byte order, each byte is the index of its range
+func testParallelInput(n int) ([]byte, []ranges.Range) {
+	b := make([]byte, n+1)
+	var rs []ranges.Range
+	for i := 0; i < n; i++ {
+		b[n-1-i] = byte(i)
+		rs = append(rs, ranges.Range{Start: int64(n-1-i) * 8, Len: 8})
+	}
+	b[n] = 0xff
+	return b, rs
+}
+
+func TestFieldArrayParallelOrder(t *testing.T) {
+	b, rs := testParallelInput(64)
+	dv := testDecode(t, b, func(d *decode.D) {
+		d.FieldArrayParallel("a", "b", rs, func(d *decode.D) {
+			// finish in different order than started
+			time.Sleep(time.Duration(d.PeekBytes(1)[0]%4) * time.Millisecond)
+			d.FieldU8("b")
+		})
+		// position should be at end of last range
+		d.FieldU8("after")
+	})
+
+	a := dv.V.(*decode.Compound).Children[0]
+	for i, c := range a.V.(*decode.Compound).Children {
+		if c.Name != "b" || c.Index != i {
+			t.Fatalf("%d: unexpected name %s index %d", i, c.Name, c.Index)
+		}
+		s, ok := c.V.(*scalar.Uint)
+		if !ok {
+			t.Fatalf("%d: expected scalar got %T", i, c.V)
+		}
+		if s.Actual != uint64(i) {
+			t.Errorf("%d: expected %d got %d", i, i, s.Actual)
+		}
+	}
+	after := dv.V.(*decode.Compound).Children[1]
+	if s := after.V.(*scalar.Uint); after.Name != "after" || s.Actual != 0xff {
+		t.Errorf("unexpected after field %s %v", after.Name, s.Actual)
+	}
+}
+
+func TestFieldArrayParallelError(t *testing.T) {
+	b, rs := testParallelInput(64)
+	_, err := testDecodeErr(context.Background(), b, func(d *decode.D) {
+		d.FieldArrayParallel("a", "b", rs, func(d *decode.D) {
+			i := d.PeekBytes(1)[0]
+			// later range finish first
+			if i == 10 {
+				time.Sleep(10 * time.Millisecond)
+				d.Errorf("range 10")
+			}
+			if i == 20 {
+				d.Errorf("range 20")
+			}
+			d.FieldU8("b")
+		})
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var fe decode.FormatsError
+	if !errors.As(err, &fe) {
+		t.Fatalf("expected formats error got %v", err)
+	}
+	// first error in range order
+	if !strings.Contains(err.Error(), "range 10") {
+		t.Fatalf("expected range 10 error got %v", err)
+	}
+}
+
+func TestFieldArrayParallelCancel(t *testing.T) {
+	b, rs := testParallelInput(64)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := testDecodeErr(ctx, b, func(d *decode.D) {
+		d.FieldArrayParallel("a", "b", rs, func(d *decode.D) { d.FieldU8("b") })
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error got %v", err)
+	}
+}
+
+// run with -race, lazy fields inside parallel decoded ranges are resolved
+// concurrently using the shared reader
+func TestFieldArrayParallelLazyChildren(t *testing.T) {
+	b := make([]byte, 64*4)
+	for i := range b {
+		b[i] = byte(i / 4)
+	}
+	var rs []ranges.Range
+	for i := 0; i < 64; i++ {
+		rs = append(rs, ranges.Range{Start: int64(i) * 32, Len: 32})
+	}
+	dv := testDecode(t, b, func(d *decode.D) {
+		d.FieldArrayParallel("a", "b", rs, func(d *decode.D) {
+			d.FieldStruct("b", func(d *decode.D) {
+				d.FieldU8("c")
+				d.FieldLazy("d", 24, func(d *decode.D) { d.FieldU24("d") })
+			})
+		})
+	})
+	a := dv.V.(*decode.Compound).Children[0]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j, c := range a.V.(*decode.Compound).Children {
+				c.Resolve()
+				l := c.V.(*decode.Compound).ByName["d"]
+				l.Resolve()
+				s, ok := l.V.(*scalar.Uint)
+				if !ok {
+					t.Errorf("%d: expected scalar got %T", j, l.V)
+					return
+				}
+				if expected := uint64(j)<<16 | uint64(j)<<8 | uint64(j); s.Actual != expected {
+					t.Errorf("%d: expected %x got %x", j, expected, s.Actual)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if errs := dv.Errors(); errs != nil {
+		t.Fatalf("expected no errors got %v", errs)
+	}
+}
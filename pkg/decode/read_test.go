@@ -0,0 +1,28 @@
+package decode_test
+
+import (
+	"testing"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func TestUTF8ShortString(t *testing.T) {
+	// length prefixed "ab" and fixed length 4 with "c" and padding
+	dv := testDecode(t, []byte{2, 'a', 'b', 1, 'c', 0, 0}, func(d *decode.D) {
+		d.FieldUTF8ShortString("a")
+		d.FieldUTF8ShortStringFixedLen("b", 4)
+	})
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{name: "a", expected: "ab"},
+		{name: "b", expected: "c"},
+	} {
+		s, ok := dv.V.(*decode.Compound).ByName[tc.name].V.(*scalar.Str)
+		if !ok || s.Actual != tc.expected {
+			t.Errorf("%s: expected %q got %v", tc.name, tc.expected, dv.V.(*decode.Compound).ByName[tc.name].V)
+		}
+	}
+}
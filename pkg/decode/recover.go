@@ -0,0 +1,91 @@
+package decode
+
+import (
+	"github.com/wader/fq/internal/recoverfn"
+	"github.com/wader/fq/pkg/ranges"
+)
+
+// With the recover option a struct or array that fails to decode gets the error
+// set on its value and decoding continues at the position the format declared
+// using ResyncAt or ResyncFn. If nothing was declared the error is passed on to
+// the parent struct or array.
+
+type resync struct {
+	pos int64
+	fn  func(d *D)
+}
+
+// ResyncAt declares that if current struct or array fails to decode with the
+// recover option, decoding should continue at pos. Usually end of a record
+// with a known size.
+func (d *D) ResyncAt(pos int64) {
+	if d.resync == nil {
+		return
+	}
+	d.resync.pos = pos
+	d.resync.fn = nil
+}
+
+// ResyncFn declares that if current struct or array fails to decode with the
+// recover option, fn should be called with position at start of the struct or
+// array and seek to where decoding can continue. Usually next sync marker.
+// If fn fails or don't move forward the error is passed on.
+func (d *D) ResyncFn(fn func(d *D)) {
+	if d.resync == nil {
+		return
+	}
+	d.resync.pos = -1
+	d.resync.fn = fn
+}
+
+// fieldFn calls fn with field decoder cd, records end position if tracing and
+// recovers and resyncs on error if recover option is used
+func (d *D) fieldFn(cd *D, fn func(d *D)) {
+	if cd.Value.Trace != nil {
+		defer func() { cd.Value.Trace.PosAfter = cd.Pos() }()
+	}
+	if !d.Options.Recover {
+		fn(cd)
+		return
+	}
+
+	start := cd.Value.Range.Start
+	cd.resync = &resync{pos: -1}
+	r, rOk := recoverfn.Run(func() { fn(cd) })
+	if rOk {
+		return
+	}
+	rs := cd.resync
+	repanic := func() { panic(r.RecoverV) }
+	if d.Ctx != nil && d.Ctx.Err() != nil {
+		repanic()
+	}
+	err, ok := r.RecoverV.(error)
+	if !ok {
+		repanic()
+	}
+
+	switch {
+	case rs.fn != nil:
+		if _, err := d.trySeekAbs(start); err != nil {
+			repanic()
+		}
+		if _, ok := recoverfn.Run(func() { rs.fn(d) }); !ok || d.Pos() <= start {
+			repanic()
+		}
+	case rs.pos > start:
+		if _, err := d.trySeekAbs(rs.pos); err != nil {
+			repanic()
+		}
+	default:
+		repanic()
+	}
+	// seek can be past end
+	if d.Pos() > d.Len() {
+		repanic()
+	}
+
+	cd.Value.Err = FormatError{Err: err, Format: d.format, Stacktrace: r}
+	// skipped bits end up as gaps in the failed value
+	cd.FillGaps(ranges.Range{Start: start, Len: d.Pos() - start}, "gap")
+}
@@ -0,0 +1,73 @@
+package decode_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+)
+
+// records of one byte that has to be zero, failed record resyncs at next record
+var testRecoverRecords = &decode.Format{
+	Name: "records",
+	DecodeFn: func(d *decode.D) any {
+		d.FieldArray("records", func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("record", func(d *decode.D) {
+					d.ResyncAt(d.Pos() + 8)
+					d.FieldU8("v", d.UintAssert(0))
+				})
+			}
+		})
+		return nil
+	},
+}
+
+var testRecoverFail = &decode.Format{
+	Name:     "fail",
+	DecodeFn: func(d *decode.D) any { d.Fatalf("fail"); return nil },
+}
+
+func testRecoverDecode(t *testing.T, group *decode.Group) *decode.Value {
+	t.Helper()
+	outer := &decode.Format{
+		Name: "outer",
+		DecodeFn: func(d *decode.D) any {
+			if _, _, err := d.TryFieldFormat("inner", group, nil); err != nil {
+				d.FieldRawLen("raw", d.BitsLeft())
+			}
+			return nil
+		},
+	}
+	g := &decode.Group{Name: "outer", Formats: []*decode.Format{outer}}
+	dv, _, err := decode.Decode(context.Background(), bitio.NewBitReader([]byte{0, 0xff, 0}, -1), g, decode.Options{IsRoot: true, Recover: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dv
+}
+
+func TestRecoverChosenFormat(t *testing.T) {
+	dv := testRecoverDecode(t, &decode.Group{Name: "records", Formats: []*decode.Format{testRecoverRecords}})
+	inner := dv.V.(*decode.Compound).ByName["inner"]
+	if inner == nil || inner.Format != testRecoverRecords {
+		t.Fatalf("expected recovered inner format got %v", dv.V.(*decode.Compound).Children)
+	}
+	records := inner.V.(*decode.Compound).ByName["records"].V.(*decode.Compound).Children
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records got %d", len(records))
+	}
+	if records[0].Err != nil || records[1].Err == nil || records[2].Err != nil {
+		t.Errorf("expected only second record to have error")
+	}
+}
+
+func TestRecoverProbeUnchanged(t *testing.T) {
+	// records would be kept with recover but probe should find same format as without
+	dv := testRecoverDecode(t, &decode.Group{Name: "probe", Formats: []*decode.Format{testRecoverRecords, testRecoverFail}})
+	c := dv.V.(*decode.Compound)
+	if c.ByName["inner"] != nil || c.ByName["raw"] == nil {
+		t.Fatalf("expected probe to fail and raw field got %v", c.Children)
+	}
+}
@@ -0,0 +1,110 @@
+package decode
+
+// Schema is a static description of the value tree a format decodes. It's
+// optional and only used to describe the output, ex: to generate JSON schema
+// for tovalue output. Struct fields not known to always be present should be
+// marked with Opt. A struct that can have more fields than described should be
+// marked with Open. Schemas can be recursive, ex: a struct with an array of
+// itself.
+//
+//	Schema: decode.SchemaStruct("",
+//		decode.SchemaUint("length"),
+//		decode.SchemaUint("type", typeMap),
+//		decode.SchemaArray("items", decode.SchemaFormat("item", &itemGroup)),
+//	)
+type Schema struct {
+	Name        string
+	Type        SchemaType
+	Description string
+	Optional    bool
+	Additional  bool      // struct can have fields not in Fields
+	Fields      []*Schema // struct fields
+	Elem        *Schema   // array elements
+	AnyOf       []*Schema // any of alternatives
+	Syms        []any     // sym maps, ex: scalar.UintMapSymStr
+	Group       *Group    // format decoded by field
+}
+
+type SchemaType string
+
+const (
+	SchemaTypeAny    SchemaType = "any"
+	SchemaTypeStruct SchemaType = "struct"
+	SchemaTypeArray  SchemaType = "array"
+	SchemaTypeUint   SchemaType = "uint"
+	SchemaTypeSint   SchemaType = "sint"
+	SchemaTypeBigInt SchemaType = "bigint"
+	SchemaTypeFlt    SchemaType = "flt"
+	SchemaTypeStr    SchemaType = "str"
+	SchemaTypeBool   SchemaType = "bool"
+	SchemaTypeBitBuf SchemaType = "bitbuf"
+	SchemaTypeFormat SchemaType = "format"
+	SchemaTypeAnyOf  SchemaType = "any_of"
+)
+
+func SchemaStruct(name string, fields ...*Schema) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeStruct, Fields: fields}
+}
+
+func SchemaArray(name string, elem *Schema) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeArray, Elem: elem}
+}
+
+func SchemaAnyOf(name string, alts ...*Schema) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeAnyOf, AnyOf: alts}
+}
+
+func SchemaFormat(name string, group *Group) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeFormat, Group: group}
+}
+
+func SchemaAny(name string) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeAny}
+}
+
+func SchemaBitBuf(name string) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeBitBuf}
+}
+
+func SchemaBool(name string, syms ...any) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeBool, Syms: syms}
+}
+
+func SchemaUint(name string, syms ...any) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeUint, Syms: syms}
+}
+
+func SchemaSint(name string, syms ...any) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeSint, Syms: syms}
+}
+
+func SchemaBigInt(name string) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeBigInt}
+}
+
+func SchemaFlt(name string, syms ...any) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeFlt, Syms: syms}
+}
+
+func SchemaStr(name string, syms ...any) *Schema {
+	return &Schema{Name: name, Type: SchemaTypeStr, Syms: syms}
+}
+
+// Opt marks a struct field as optional
+func (s *Schema) Opt() *Schema {
+	s.Optional = true
+	return s
+}
+
+// Open marks a struct as possibly having more fields than described, ex: fields
+// that depend on some type
+func (s *Schema) Open() *Schema {
+	s.Additional = true
+	return s
+}
+
+// Desc sets description
+func (s *Schema) Desc(d string) *Schema {
+	s.Description = d
+	return s
+}
@@ -0,0 +1,44 @@
+package decode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamChunkFn returns the next chunk of a sequential format. A chunk should be
+// possible to decode on its own with the same format, ex: a file header followed
+// by one record. A chunk should end with the bytes last read, bytes before that
+// are assumed to be earlier read bytes like a header. Returns io.EOF when there
+// are no more chunks.
+type StreamChunkFn func() ([]byte, error)
+
+// StreamMaxChunkSize limits memory usage when a chunk length is read from input
+const StreamMaxChunkSize = 64 * 1024 * 1024
+
+// StreamRead reads n bytes from r, io.EOF if r is at end and io.ErrUnexpectedEOF
+// if only some bytes could be read.
+func StreamRead(r *bufio.Reader, n int) ([]byte, error) {
+	if n < 0 || n > StreamMaxChunkSize {
+		return nil, fmt.Errorf("chunk size %d outside 0-%d", n, StreamMaxChunkSize)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// StreamReadAppend is StreamRead that appends to b, io.ErrUnexpectedEOF even if
+// nothing could be read as b is assumed to be a started chunk.
+func StreamReadAppend(r *bufio.Reader, b []byte, n int) ([]byte, error) {
+	nb, err := StreamRead(r, n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(b, nb...), nil
+}
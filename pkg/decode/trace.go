@@ -0,0 +1,98 @@
+package decode
+
+import (
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/internal/profile"
+)
+
+// Trace is recorded for values when decoding with the Trace option. Positions
+// are bit positions in the same buffer as the value range.
+type Trace struct {
+	Caller    string // file:line of decoder code that added the value
+	Func      string // function of decoder code that added the value
+	PosBefore int64
+	PosAfter  int64
+	Events    []TraceEvent
+	Usage     *profile.Usage // set for format roots, time and memory used by format decoder
+}
+
+type TraceOp string
+
+const (
+	TraceOpSeek   TraceOp = "seek"
+	TraceOpPeek   TraceOp = "peek"
+	TraceOpFormat TraceOp = "format"
+)
+
+// TraceEvent is something done while decoding a struct or array that is not
+// adding a field
+type TraceEvent struct {
+	Op     TraceOp
+	Caller string
+	Pos    int64  // position when event happened
+	To     int64  // seek target
+	Len    int64  // peek or format length
+	Format string // format name for format events
+}
+
+const decodePkgPrefix = "github.com/wader/fq/pkg/decode."
+
+// traceCaller returns the first caller outside of the decode package
+func traceCaller() (string, string) {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[0:n])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, decodePkgPrefix) &&
+			!strings.HasPrefix(f.Function, "github.com/wader/fq/internal/") {
+			// ex: png/png.go:123 and png.pngDecode
+			file := filepath.Join(filepath.Base(filepath.Dir(f.File)), filepath.Base(f.File))
+			fn := f.Function
+			if i := strings.LastIndex(fn, "/"); i != -1 {
+				fn = fn[i+1:]
+			}
+			return file + ":" + strconv.Itoa(f.Line), fn
+		}
+		if !more {
+			return "", ""
+		}
+	}
+}
+
+func newTrace(pos int64) *Trace {
+	caller, fn := traceCaller()
+	return &Trace{Caller: caller, Func: fn, PosBefore: pos, PosAfter: pos}
+}
+
+func (d *D) traceEvent(e TraceEvent) {
+	if !d.Options.Trace || d.Value.Trace == nil {
+		return
+	}
+	e.Caller, _ = traceCaller()
+	d.Value.Trace.Events = append(d.Value.Trace.Events, e)
+}
+
+func (t *Trace) shift(delta int64) {
+	t.PosBefore += delta
+	t.PosAfter += delta
+	for i := range t.Events {
+		e := &t.Events[i]
+		e.Pos += delta
+		if e.Op == TraceOpSeek {
+			e.To += delta
+		}
+	}
+}
+
+func (d *D) traceFormat(dv *Value) {
+	var name string
+	if dv.Format != nil {
+		name = dv.Format.Name
+	}
+	d.traceEvent(TraceEvent{Op: TraceOpFormat, Pos: dv.Range.Start, Len: dv.Range.Len, Format: name})
+}
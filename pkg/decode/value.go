@@ -31,6 +31,9 @@ type Value struct {
 	Format      *Format // TODO: rework
 	Description string
 	Err         error
+	Trace       *Trace // only set when decoding with Trace option
+
+	lazy *lazyValue
 }
 
 type WalkFn func(v *Value, rootV *Value, depth int, rootDepth int) error
@@ -40,15 +43,20 @@ var ErrWalkBreak = errors.New("break")
 var ErrWalkStop = errors.New("stop")
 
 type WalkOpts struct {
-	PreOrder bool
-	OneRoot  bool
-	Fn       WalkFn
+	PreOrder  bool
+	OneRoot   bool
+	NoResolve bool // don't resolve lazy values, they are walked as empty compounds
+	Fn        WalkFn
 }
 
 func (v *Value) Walk(opts WalkOpts) error {
 	var walkFn WalkFn
 
 	walkFn = func(wv *Value, rootV *Value, depth int, rootDepth int) error {
+		if !opts.NoResolve {
+			wv.Resolve()
+		}
+
 		if opts.OneRoot && wv != v && wv.IsRoot {
 			return nil
 		}
@@ -143,6 +151,16 @@ func (v *Value) WalkRootPostOrder(fn WalkFn) error {
 	})
 }
 
+// walkRootNoResolve is used during decode where lazy values should stay unresolved
+func (v *Value) walkRootNoResolve(preOrder bool, fn WalkFn) error {
+	return v.Walk(WalkOpts{
+		PreOrder:  preOrder,
+		OneRoot:   true,
+		NoResolve: true,
+		Fn:        fn,
+	})
+}
+
 func (v *Value) root(findSubRoot bool, findFormatRoot bool) *Value {
 	rootV := v
 	for rootV.Parent != nil {
@@ -162,14 +180,18 @@ func (v *Value) Root() *Value       { return v.root(false, false) }
 func (v *Value) BufferRoot() *Value { return v.root(true, false) }
 func (v *Value) FormatRoot() *Value { return v.root(true, true) }
 
+// Errors returns errors for v and its children. Lazy values are not resolved
+// so errors in them are only included if they have been resolved. This means
+// that a format with lazy fields can decode fine and be accepted by Try*
+// functions even if a lazy field will fail when accessed.
 func (v *Value) Errors() []error {
 	var errs []error
-	_ = v.WalkPreOrder(func(v *Value, _ *Value, _ int, _ int) error {
+	_ = v.Walk(WalkOpts{PreOrder: true, NoResolve: true, Fn: func(v *Value, _ *Value, _ int, _ int) error {
 		if v.Err != nil {
 			errs = append(errs, v.Err)
 		}
 		return nil
-	})
+	}})
 	return errs
 }
 
@@ -181,7 +203,7 @@ func (v *Value) InnerRange() ranges.Range {
 }
 
 func (v *Value) postProcess() {
-	if err := v.WalkRootPostOrder(func(v *Value, _ *Value, _ int, _ int) error {
+	if err := v.walkRootNoResolve(false, func(v *Value, _ *Value, _ int, _ int) error {
 		switch vv := v.V.(type) {
 		case *Compound:
 			first := true
@@ -267,7 +289,7 @@ func (v *Value) Remove() error {
 			if _, ok := fv.ByName[v.Name]; !ok {
 				return fmt.Errorf("d not in parent ByName")
 			}
-			delete(fv.ByName, p.Name)
+			delete(fv.ByName, v.Name)
 		}
 		found := false
 		var cs []*Value
@@ -0,0 +1,39 @@
+package decode_test
+
+import (
+	"testing"
+
+	"github.com/wader/fq/pkg/decode"
+)
+
+func TestValueRemove(t *testing.T) {
+	dv := testDecode(t, []byte{1, 2}, func(d *decode.D) {
+		d.FieldStruct("s", func(d *decode.D) {
+			d.FieldU8("a")
+			d.FieldU8("b")
+		})
+	})
+	s := dv.V.(*decode.Compound).ByName["s"]
+	sc := s.V.(*decode.Compound)
+
+	if err := sc.ByName["b"].Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sc.ByName["b"]; ok {
+		t.Error("expected b to be removed from ByName")
+	}
+	if len(sc.Children) != 1 || sc.Children[0].Name != "a" {
+		t.Errorf("expected only a in children got %d children", len(sc.Children))
+	}
+	// parent is not affected
+	if _, ok := dv.V.(*decode.Compound).ByName["s"]; !ok {
+		t.Error("expected s to still be in root ByName")
+	}
+
+	if err := s.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(); err == nil {
+		t.Error("expected error when removing already removed value")
+	}
+}
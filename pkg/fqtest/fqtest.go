@@ -42,9 +42,12 @@ func TestPath(t *testing.T, registry *interp.Registry) {
 					}
 
 					err = i.Main(context.Background(), cr.Stdout(), "testversion")
+					// same exit code as cli
 					if err != nil {
 						if ex, ok := err.(interp.Exiter); ok { //nolint:errorlint
 							cr.ActualExitCode = ex.ExitCode()
+						} else {
+							cr.ActualExitCode = 1
 						}
 					}
 				})
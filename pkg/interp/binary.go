@@ -146,7 +146,9 @@ func (i *Interp) _toBits(c any, opts toBitsOpts) any {
 
 type openFile struct {
 	Binary
+	file       fs.File
 	filename   string
+	fi         fs.FileInfo // set if regular file
 	progressFn progressreadseeker.ProgressFn
 }
 
@@ -162,46 +164,60 @@ func (of *openFile) ToBinary() (Binary, error) {
 	return NewBinaryFromBitReader(of.br, 8, 0)
 }
 
-// opens a file for reading from filesystem
-// TODO: when to close? when br loses all refs? need to use finalizer somehow?
-func (i *Interp) _open(c any) any {
-	if i.EvalInstance.IsCompleting {
-		// TODO: have dummy values for each type for completion?
-		br, _ := NewBinaryFromBitReader(bitio.NewBitReader([]byte{}, -1), 8, 0)
-		return br
-	}
-
-	var err error
-	var f fs.File
-	var path string
-
+// openInputFile opens file at path c or stdin if c is null
+func (i *Interp) openInputFile(c any) (fs.File, string, error) {
 	switch c.(type) {
 	case nil:
-		path = "<stdin>"
-		f = i.OS.Stdin()
+		return i.OS.Stdin(), "<stdin>", nil
 	default:
-		path, err = toString(c)
+		path, err := toString(c)
 		if err != nil {
-			return fmt.Errorf("%s: %w", path, err)
+			return nil, path, fmt.Errorf("%s: %w", path, err)
 		}
-		f, err = i.OS.FS().Open(path)
+		f, err := i.OS.FS().Open(path)
 		if err != nil {
 			// path context added in jq error code
 			var pe *fs.PathError
 			if errors.As(err, &pe) {
-				return pe.Err
+				return nil, path, pe.Err
 			}
-			return err
+			return nil, path, err
 		}
+		return f, path, nil
+	}
+}
+
+// opens a file for reading from filesystem
+// TODO: when to close? when br loses all refs? need to use finalizer somehow?
+func (i *Interp) _open(c any) any {
+	if i.EvalInstance.IsCompleting {
+		// TODO: have dummy values for each type for completion?
+		br, _ := NewBinaryFromBitReader(bitio.NewBitReader([]byte{}, -1), 8, 0)
+		return br
+	}
+
+	of, err := i.openPath(c)
+	if err != nil {
+		return err
+	}
+	return of
+}
+
+// openPath opens file at path c or stdin if c is null
+func (i *Interp) openPath(c any) (*openFile, error) {
+	f, path, err := i.openInputFile(c)
+	if err != nil {
+		return nil, err
 	}
 
 	var bEnd int64
 	var fRS io.ReadSeeker
+	var fi fs.FileInfo
 
 	fFI, err := f.Stat()
 	if err != nil {
 		f.Close()
-		return err
+		return nil, err
 	}
 
 	// ctxreadseeker is used to make sure any io calls can be canceled
@@ -212,6 +228,7 @@ func (i *Interp) _open(c any) any {
 		if rs, ok := f.(io.ReadSeeker); ok {
 			fRS = ctxreadseeker.New(i.EvalInstance.Ctx, rs)
 			bEnd = fFI.Size()
+			fi = fFI
 		}
 	}
 
@@ -219,14 +236,16 @@ func (i *Interp) _open(c any) any {
 		buf, err := io.ReadAll(ctxreadseeker.New(i.EvalInstance.Ctx, &ioex.ReadErrSeeker{Reader: f}))
 		if err != nil {
 			f.Close()
-			return err
+			return nil, err
 		}
 		fRS = bytes.NewReader(buf)
 		bEnd = int64(len(buf))
 	}
 
 	bbf := &openFile{
+		file:     f,
 		filename: path,
+		fi:       fi,
 	}
 
 	const progressPrecision = 1024
@@ -245,11 +264,8 @@ func (i *Interp) _open(c any) any {
 	// bitio.Buffer -> (bitio.Reader) -> aheadreadseeker -> progressreadseeker -> ctxreadseeker -> readseeker
 
 	bbf.br = bitio.NewIOBitReadSeeker(aheadRs)
-	if err != nil {
-		return err
-	}
 
-	return bbf
+	return bbf, nil
 }
 
 var _ Value = Binary{}
@@ -0,0 +1,670 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/internal/ansi"
+	"github.com/wader/fq/internal/asciiwriter"
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/internal/hexpairwriter"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+
+	"github.com/wader/gojq"
+)
+
+// interactive full screen terminal browser with a collapsible field tree and
+// a hex pane highlighting the selected field
+
+func init() {
+	RegisterIter1("_browse", (*Interp)._browse)
+}
+
+const (
+	browseAltScreen    = "\x1b[?1049h"
+	browseNormalScreen = "\x1b[?1049l"
+	browseHideCursor   = "\x1b[?25l"
+	browseShowCursor   = "\x1b[?25h"
+	browseHome         = "\x1b[H"
+	browseClearLine    = "\x1b[K"
+)
+
+var browseInverse = ansi.Code{SetString: "\x1b[7m", ResetString: "\x1b[0m"}
+
+const browseHelp = "q quit  r return value  arrows/hjkl move and collapse/expand  enter toggle  / search  n next  o jump to offset"
+
+type browseKey int
+
+const (
+	browseKeyNone browseKey = iota
+	browseKeyRune
+	browseKeyUp
+	browseKeyDown
+	browseKeyLeft
+	browseKeyRight
+	browseKeyPageUp
+	browseKeyPageDown
+	browseKeyHome
+	browseKeyEnd
+	browseKeyEnter
+	browseKeyBackspace
+	browseKeyEscape
+	browseKeyInterrupt
+)
+
+type browseInput struct {
+	key browseKey
+	r   rune
+}
+
+// browseParseInput parses keys from raw terminal input, unknown escape
+// sequences are skipped
+func browseParseInput(b []byte) []browseInput {
+	var ins []browseInput
+	escapes := map[string]browseKey{
+		"\x1b[A": browseKeyUp, "\x1bOA": browseKeyUp,
+		"\x1b[B": browseKeyDown, "\x1bOB": browseKeyDown,
+		"\x1b[C": browseKeyRight, "\x1bOC": browseKeyRight,
+		"\x1b[D": browseKeyLeft, "\x1bOD": browseKeyLeft,
+		"\x1b[5~": browseKeyPageUp,
+		"\x1b[6~": browseKeyPageDown,
+		"\x1b[H":  browseKeyHome, "\x1b[1~": browseKeyHome, "\x1bOH": browseKeyHome,
+		"\x1b[F": browseKeyEnd, "\x1b[4~": browseKeyEnd, "\x1bOF": browseKeyEnd,
+	}
+
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b:
+			if len(b) == 1 {
+				ins = append(ins, browseInput{key: browseKeyEscape})
+				b = b[1:]
+				continue
+			}
+			found := false
+			for s, k := range escapes {
+				if bytes.HasPrefix(b, []byte(s)) {
+					ins = append(ins, browseInput{key: k})
+					b = b[len(s):]
+					found = true
+					break
+				}
+			}
+			if !found {
+				// skip unknown CSI/SS3 sequence
+				n := 1
+				if len(b) > 1 && (b[1] == '[' || b[1] == 'O') {
+					n = 2
+					for n < len(b) && !(b[n] >= 0x40 && b[n] <= 0x7e) {
+						n++
+					}
+					n = mathex.Min(n+1, len(b))
+				} else {
+					ins = append(ins, browseInput{key: browseKeyEscape})
+				}
+				b = b[n:]
+			}
+		case c == 3:
+			ins = append(ins, browseInput{key: browseKeyInterrupt})
+			b = b[1:]
+		case c == '\r' || c == '\n':
+			ins = append(ins, browseInput{key: browseKeyEnter})
+			b = b[1:]
+		case c == 0x7f || c == 0x08:
+			ins = append(ins, browseInput{key: browseKeyBackspace})
+			b = b[1:]
+		default:
+			r := []rune(string(b[0:mathex.Min(len(b), 4)]))[0]
+			ins = append(ins, browseInput{key: browseKeyRune, r: r})
+			b = b[len(string(r)):]
+		}
+	}
+
+	return ins
+}
+
+type browseRow struct {
+	v     *decode.Value
+	depth int
+}
+
+type browser struct {
+	opts     Options
+	root     *decode.Value
+	all      []*decode.Value
+	expanded map[*decode.Value]bool
+	rows     []browseRow
+	sel      int
+	top      int
+	width    int
+	height   int
+	status   string
+	search   string
+	prompt   string
+	input    string
+	promptFn func(s string)
+}
+
+func newBrowser(root *decode.Value, opts Options) *browser {
+	b := &browser{
+		opts:     opts,
+		root:     root,
+		expanded: map[*decode.Value]bool{root: true},
+	}
+	_ = root.WalkPreOrder(func(v *decode.Value, _ *decode.Value, _ int, _ int) error {
+		b.all = append(b.all, v)
+		return nil
+	})
+	b.rebuild()
+	return b
+}
+
+func (b *browser) selected() *decode.Value { return b.rows[b.sel].v }
+
+func (b *browser) rebuild() {
+	var sv *decode.Value
+	if b.sel < len(b.rows) {
+		sv = b.rows[b.sel].v
+	}
+	b.rows = b.rows[:0]
+	var walk func(v *decode.Value, depth int)
+	walk = func(v *decode.Value, depth int) {
+		v.Resolve()
+		b.rows = append(b.rows, browseRow{v: v, depth: depth})
+		if c, ok := v.V.(*decode.Compound); ok && b.expanded[v] {
+			for _, cv := range c.Children {
+				walk(cv, depth+1)
+			}
+		}
+	}
+	walk(b.root, 0)
+
+	b.sel = 0
+	for i, r := range b.rows {
+		if r.v == sv {
+			b.sel = i
+			break
+		}
+	}
+}
+
+// reveal expands all parents of v and selects it
+func (b *browser) reveal(v *decode.Value) {
+	for p := v.Parent; p != nil; p = p.Parent {
+		b.expanded[p] = true
+		if p == b.root {
+			break
+		}
+	}
+	b.rebuild()
+	for i, r := range b.rows {
+		if r.v == v {
+			b.sel = i
+			break
+		}
+	}
+}
+
+func (b *browser) treeHeight() int { return b.height - 2 - b.hexHeight() }
+func (b *browser) hexHeight() int  { return mathex.Clamp(2, 12, (b.height-2)/3) }
+
+func (b *browser) move(n int) {
+	b.sel = mathex.Clamp(0, len(b.rows)-1, b.sel+n)
+}
+
+func (b *browser) toggle(expand bool) {
+	v := b.selected()
+	if _, ok := v.V.(*decode.Compound); !ok {
+		if !expand && v.Parent != nil && v != b.root {
+			b.reveal(v.Parent)
+		}
+		return
+	}
+	if !expand && !b.expanded[v] && v.Parent != nil && v != b.root {
+		b.reveal(v.Parent)
+		return
+	}
+	b.expanded[v] = expand
+	b.rebuild()
+}
+
+func browseValueStrings(v *decode.Value) []string {
+	s, ok := v.V.(Scalarable)
+	if !ok {
+		return nil
+	}
+	var ss []string
+	actual := s.ScalarActual()
+	if _, ok := actual.(bitio.ReaderAtSeeker); !ok {
+		ss = append(ss, previewValue(actual, s.ScalarDisplayFormat()))
+		ss = append(ss, fmt.Sprint(actual))
+	}
+	if sym := s.ScalarSym(); sym != nil {
+		ss = append(ss, previewValue(sym, scalar.NumberDecimal))
+	}
+	if desc := s.ScalarDescription(); desc != "" {
+		ss = append(ss, desc)
+	}
+	return ss
+}
+
+// searchNext finds next value after selected value with a matching value or description
+func (b *browser) searchNext() {
+	if b.search == "" {
+		return
+	}
+	needle := strings.ToLower(b.search)
+	start := 0
+	sv := b.selected()
+	for i, v := range b.all {
+		if v == sv {
+			start = i + 1
+			break
+		}
+	}
+	for i := 0; i < len(b.all); i++ {
+		v := b.all[(start+i)%len(b.all)]
+		for _, s := range browseValueStrings(v) {
+			if strings.Contains(strings.ToLower(s), needle) {
+				b.reveal(v)
+				return
+			}
+		}
+	}
+	b.status = fmt.Sprintf("%q not found", b.search)
+}
+
+// jumpOffset selects the deepest field in the root buffer that includes byte offset
+func (b *browser) jumpOffset(s string) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		b.status = fmt.Sprintf("invalid offset %q", s)
+		return
+	}
+	bitOffset := n * 8
+	var found *decode.Value
+	_ = b.root.WalkPreOrder(func(v *decode.Value, _ *decode.Value, _ int, _ int) error {
+		if v != b.root && v.IsRoot {
+			return decode.ErrWalkSkipChildren
+		}
+		r := v.InnerRange()
+		if bitOffset >= r.Start && bitOffset < r.Stop() {
+			found = v
+			return nil
+		}
+		if _, ok := v.V.(*decode.Compound); ok {
+			return decode.ErrWalkSkipChildren
+		}
+		return nil
+	})
+	if found == nil {
+		b.status = fmt.Sprintf("no field at offset %s", mathex.PadFormatInt(n, b.opts.Addrbase, true, 0))
+		return
+	}
+	b.reveal(found)
+}
+
+// handle returns exit true if browser should exit and ret true if selected
+// value should be returned
+func (b *browser) handle(in browseInput) (exit bool, ret bool) {
+	if b.promptFn != nil {
+		switch in.key {
+		case browseKeyEnter:
+			fn := b.promptFn
+			b.promptFn = nil
+			fn(b.input)
+		case browseKeyEscape, browseKeyInterrupt:
+			b.promptFn = nil
+		case browseKeyBackspace:
+			if r := []rune(b.input); len(r) > 0 {
+				b.input = string(r[0 : len(r)-1])
+			}
+		case browseKeyRune:
+			b.input += string(in.r)
+		}
+		return false, false
+	}
+
+	b.status = ""
+	pageSize := mathex.Max(1, b.treeHeight()-1)
+	switch in.key {
+	case browseKeyUp:
+		b.move(-1)
+	case browseKeyDown:
+		b.move(1)
+	case browseKeyPageUp:
+		b.move(-pageSize)
+	case browseKeyPageDown:
+		b.move(pageSize)
+	case browseKeyHome:
+		b.move(-len(b.rows))
+	case browseKeyEnd:
+		b.move(len(b.rows))
+	case browseKeyLeft:
+		b.toggle(false)
+	case browseKeyRight:
+		b.toggle(true)
+	case browseKeyEnter:
+		b.toggle(!b.expanded[b.selected()])
+	case browseKeyEscape, browseKeyInterrupt:
+		return true, false
+	case browseKeyRune:
+		switch in.r {
+		case 'q':
+			return true, false
+		case 'r':
+			return true, true
+		case 'k':
+			b.move(-1)
+		case 'j':
+			b.move(1)
+		case 'h':
+			b.toggle(false)
+		case 'l':
+			b.toggle(true)
+		case ' ':
+			b.toggle(!b.expanded[b.selected()])
+		case 'g':
+			b.move(-len(b.rows))
+		case 'G':
+			b.move(len(b.rows))
+		case 'n':
+			b.searchNext()
+		case '/':
+			b.prompt = "search: "
+			b.input = ""
+			b.promptFn = func(s string) {
+				b.search = s
+				b.searchNext()
+			}
+		case 'o', ':':
+			b.prompt = "offset: "
+			b.input = ""
+			b.promptFn = b.jumpOffset
+		}
+	}
+	return false, false
+}
+
+func (b *browser) rowText(r browseRow, deco Decorator) string {
+	v := r.v
+	sb := &strings.Builder{}
+
+	sb.WriteString(indentStr(treeIndentWidth * r.depth))
+	c, isCompound := v.V.(*decode.Compound)
+	switch {
+	case !isCompound:
+		sb.WriteString("  ")
+	case b.expanded[v] && b.opts.Unicode:
+		sb.WriteString("▼ ")
+	case b.expanded[v]:
+		sb.WriteString("- ")
+	case b.opts.Unicode:
+		sb.WriteString("▶ ")
+	default:
+		sb.WriteString("+ ")
+	}
+
+	isInArray := false
+	if v.Parent != nil {
+		if pc, ok := v.Parent.V.(*decode.Compound); ok {
+			isInArray = pc.IsArray
+		}
+	}
+	switch {
+	case r.depth == 0:
+		sb.WriteString(valuePathExprDecorated(v, deco))
+	case isInArray:
+		fmt.Fprintf(sb, "%s%s%s", deco.Index.F("["), deco.Number.F(strconv.Itoa(v.Index)), deco.Index.F("]"))
+	default:
+		sb.WriteString(deco.ObjectKey.Wrap(v.Name))
+	}
+
+	var desc string
+	if isCompound {
+		if c.IsArray {
+			fmt.Fprintf(sb, "%s%s:%s%s", deco.Index.F("["), deco.Number.F("0"), deco.Number.F(strconv.Itoa(len(c.Children))), deco.Index.F("]"))
+		} else {
+			fmt.Fprintf(sb, "%s", deco.Object.F("{}"))
+		}
+		sb.WriteString(":")
+		if isInArray {
+			fmt.Fprintf(sb, " %s", v.Name)
+		}
+		if c.Description != "" {
+			fmt.Fprintf(sb, " %s", deco.Value.F(c.Description))
+		}
+	} else if s, ok := v.V.(Scalarable); ok {
+		sb.WriteString(":")
+		actual := s.ScalarActual()
+		sym := s.ScalarSym()
+		df := s.ScalarDisplayFormat()
+		if sym == nil {
+			fmt.Fprintf(sb, " %s", deco.ValueColor(actual).F(previewValue(actual, df)))
+		} else {
+			fmt.Fprintf(sb, " %s", deco.ValueColor(sym).F(previewValue(sym, scalar.NumberDecimal)))
+			fmt.Fprintf(sb, " (%s)", deco.ValueColor(actual).F(previewValue(actual, df)))
+		}
+		desc = s.ScalarDescription()
+		if desc != "" {
+			fmt.Fprintf(sb, " (%s)", deco.Value.F(desc))
+		}
+	}
+	if v.Format != nil {
+		fmt.Fprintf(sb, " (%s)", deco.Value.F(v.Format.Name))
+	}
+	if v.Err != nil {
+		fmt.Fprintf(sb, " %s", deco.Error.F("!"+v.Err.Error()))
+	}
+
+	return sb.String()
+}
+
+func (b *browser) hexLines(n int) ([]string, error) {
+	deco := b.opts.Decorator
+	sv := b.selected()
+	rootV := sv.BufferRoot()
+	r := sv.InnerRange()
+
+	rootLen, err := bitioex.Len(rootV.RootReader)
+	if err != nil {
+		return nil, err
+	}
+	rootBytes := bitio.BitsByteCount(rootLen)
+	addrWidth := mathex.DigitsInBase(rootBytes, true, b.opts.Addrbase)
+
+	// fit addr|hex|ascii
+	lineBytes := int64(b.opts.LineBytes)
+	for lineBytes > 1 && int64(addrWidth)+lineBytes*4+2 > int64(b.width) {
+		lineBytes /= 2
+	}
+
+	selStart := r.Start / 8
+	selStop := bitio.BitsByteCount(r.Stop())
+	startLine := mathex.Max(0, selStart/lineBytes-1)
+	startByte := startLine * lineBytes
+	nBytes := mathex.Min(int64(n)*lineBytes, rootBytes-startByte)
+	if nBytes < 0 {
+		nBytes = 0
+	}
+	nBits := mathex.Min(nBytes*8, rootLen-startByte*8)
+	br, err := bitioex.Range(rootV.RootReader, startByte*8, nBits)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err := bitioex.CopyBits(buf, br); err != nil {
+		return nil, err
+	}
+	bs := buf.Bytes()
+
+	var lines []string
+	for l := int64(0); l < int64(n); l++ {
+		lineStart := startByte + l*lineBytes
+		if lineStart >= rootBytes {
+			break
+		}
+		var hexSB, asciiSB strings.Builder
+		for i := int64(0); i < lineBytes; i++ {
+			pos := lineStart + i
+			if i > 0 {
+				hexSB.WriteString(" ")
+			}
+			bi := pos - startByte
+			if bi >= int64(len(bs)) {
+				hexSB.WriteString("  ")
+				asciiSB.WriteString(" ")
+				continue
+			}
+			c := bs[bi]
+			hp := hexpairwriter.Pair(c)
+			a := asciiwriter.SafeASCII(c)
+			if pos >= selStart && pos < selStop {
+				hexSB.WriteString(browseInverse.Wrap(hp))
+				asciiSB.WriteString(browseInverse.Wrap(a))
+			} else {
+				hexSB.WriteString(deco.ByteColor(c).Wrap(hp))
+				asciiSB.WriteString(deco.ByteColor(c).Wrap(a))
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s%s%s%s%s%s",
+			deco.DumpAddr.F(mathex.PadFormatInt(lineStart, b.opts.Addrbase, true, addrWidth)),
+			deco.Column,
+			hexSB.String(),
+			deco.Column,
+			asciiSB.String(),
+			deco.Column,
+		))
+	}
+
+	return lines, nil
+}
+
+func (b *browser) fitLine(s string) string {
+	if ansi.Len(s) > b.width {
+		return ansi.Slice(s, 0, b.width)
+	}
+	return s
+}
+
+func (b *browser) render(w io.Writer) error {
+	deco := b.opts.Decorator
+	treeH := b.treeHeight()
+
+	// keep selection visible
+	if b.sel < b.top {
+		b.top = b.sel
+	}
+	if b.sel >= b.top+treeH {
+		b.top = b.sel - treeH + 1
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString(browseHome)
+	for i := 0; i < treeH; i++ {
+		ri := b.top + i
+		if ri < len(b.rows) {
+			if ri == b.sel {
+				sb.WriteString(browseInverse.Wrap(b.fitLine(b.rowText(b.rows[ri], PlainDecorator))))
+			} else {
+				sb.WriteString(b.fitLine(b.rowText(b.rows[ri], deco)))
+			}
+		}
+		sb.WriteString(browseClearLine + "\r\n")
+	}
+
+	sv := b.selected()
+	r := sv.InnerRange()
+	sb.WriteString(browseInverse.Wrap(b.fitLine(fmt.Sprintf("%s %s (%s)",
+		valuePathExprDecorated(sv, PlainDecorator),
+		mathex.BitRange(r).StringByteBits(b.opts.Addrbase),
+		mathex.Bits(r.Len).StringByteBits(b.opts.Sizebase),
+	))))
+	sb.WriteString(browseClearLine + "\r\n")
+
+	hexH := b.hexHeight()
+	lines, err := b.hexLines(hexH)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < hexH; i++ {
+		if i < len(lines) {
+			sb.WriteString(b.fitLine(lines[i]))
+		}
+		sb.WriteString(browseClearLine + "\r\n")
+	}
+
+	switch {
+	case b.promptFn != nil:
+		sb.WriteString(b.fitLine(b.prompt + b.input))
+	case b.status != "":
+		sb.WriteString(b.fitLine(deco.Error.Wrap(b.status)))
+	default:
+		sb.WriteString(b.fitLine(browseHelp))
+	}
+	sb.WriteString(browseClearLine)
+
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+func (i *Interp) _browse(c any, v any) gojq.Iter {
+	dv, ok := c.(DecodeValue)
+	if !ok {
+		return gojq.NewIter(errors.New("expected a decode value"))
+	}
+	opts := OptionsFromValue(v)
+
+	stdin := i.OS.Stdin()
+	stdout := i.OS.Stdout()
+	rm, ok := stdin.(RawModer)
+	if !ok || !stdin.IsTerminal() || !stdout.IsTerminal() {
+		return gojq.NewIter(errors.New("browse requires stdin and stdout to be a terminal"))
+	}
+
+	restore, err := rm.RawMode()
+	if err != nil {
+		return gojq.NewIter(err)
+	}
+	fmt.Fprint(stdout, browseAltScreen+browseHideCursor)
+	defer func() {
+		fmt.Fprint(stdout, browseShowCursor+browseNormalScreen)
+		_ = restore()
+	}()
+
+	b := newBrowser(dv.DecodeValue(), opts)
+	buf := make([]byte, 256)
+	for {
+		b.width, b.height = stdout.Size()
+		if b.width < 20 || b.height < 6 {
+			return gojq.NewIter(errors.New("terminal too small for browse"))
+		}
+		if err := b.render(stdout); err != nil {
+			return gojq.NewIter(err)
+		}
+
+		n, err := stdin.Read(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return gojq.NewIter()
+			}
+			return gojq.NewIter(err)
+		}
+		for _, in := range browseParseInput(buf[0:n]) {
+			exit, ret := b.handle(in)
+			if !exit {
+				continue
+			}
+			if ret {
+				return gojq.NewIter(makeDecodeValue(b.selected(), decodeValueValue))
+			}
+			return gojq.NewIter()
+		}
+	}
+}
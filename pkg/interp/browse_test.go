@@ -0,0 +1,344 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+)
+
+// select graphic rendition codes like colors and inverse
+var browseTestSGR = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func browseTestValue(t *testing.T) *decode.Value {
+	t.Helper()
+	f := &decode.Format{
+		Name: "test",
+		DecodeFn: func(d *decode.D) any {
+			d.FieldU8("a")
+			d.FieldArray("b", func(d *decode.D) {
+				d.FieldU8("c")
+				d.FieldU8("c")
+			})
+			return nil
+		},
+	}
+	dv, _, err := decode.Decode(context.Background(), bitio.NewBitReader([]byte{1, 2, 3}, -1),
+		&decode.Group{Name: "test", Formats: []*decode.Format{f}},
+		decode.Options{IsRoot: true, FillGaps: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dv
+}
+
+func browseTestBrowser(t *testing.T) *browser {
+	t.Helper()
+	b := newBrowser(browseTestValue(t), OptionsFromValue(map[string]any{"line_bytes": 16, "addrbase": 16, "sizebase": 10}))
+	b.width, b.height = 80, 20
+	return b
+}
+
+// rows as paths, selected row is prefixed with >
+func browseTestRows(b *browser) []string {
+	var ss []string
+	for i, r := range b.rows {
+		s := valuePathExprDecorated(r.v, PlainDecorator)
+		if i == b.sel {
+			s = ">" + s
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+func browseTestKeys(b *browser, s string) (exit bool, ret bool) {
+	for _, in := range browseParseInput([]byte(s)) {
+		if exit, ret = b.handle(in); exit {
+			return exit, ret
+		}
+	}
+	return false, false
+}
+
+func TestBrowseParseInput(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []browseInput
+	}{
+		{"", nil},
+		{"ab", []browseInput{{key: browseKeyRune, r: 'a'}, {key: browseKeyRune, r: 'b'}}},
+		{"åä", []browseInput{{key: browseKeyRune, r: 'å'}, {key: browseKeyRune, r: 'ä'}}},
+		{"\x1b[A\x1bOB\x1b[C\x1b[D", []browseInput{{key: browseKeyUp}, {key: browseKeyDown}, {key: browseKeyRight}, {key: browseKeyLeft}}},
+		{"\x1b[5~\x1b[6~\x1b[H\x1b[4~", []browseInput{{key: browseKeyPageUp}, {key: browseKeyPageDown}, {key: browseKeyHome}, {key: browseKeyEnd}}},
+		{"\r\n\x7f\x08\x03", []browseInput{{key: browseKeyEnter}, {key: browseKeyEnter}, {key: browseKeyBackspace}, {key: browseKeyBackspace}, {key: browseKeyInterrupt}}},
+		{"\x1b", []browseInput{{key: browseKeyEscape}}},
+		// unknown sequences are skipped
+		{"\x1b[1;5Aq", []browseInput{{key: browseKeyRune, r: 'q'}}},
+		{"\x1b[", nil},
+		{"\x1bxq", []browseInput{{key: browseKeyEscape}, {key: browseKeyRune, r: 'x'}, {key: browseKeyRune, r: 'q'}}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.input, func(t *testing.T) {
+			actual := browseParseInput([]byte(tc.input))
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBrowseHandle(t *testing.T) {
+	testCases := []struct {
+		name         string
+		keys         string
+		expectedRows []string
+		expectedExit bool
+		expectedRet  bool
+	}{
+		{"initial", "", []string{">.", ".a", ".b"}, false, false},
+		{"move", "jj", []string{".", ".a", ">.b"}, false, false},
+		{"move clamp", "kkk\x1b[B\x1b[B\x1b[B\x1b[B", []string{".", ".a", ">.b"}, false, false},
+		{"home end", "G\x1b[H", []string{">.", ".a", ".b"}, false, false},
+		{"expand", "jjl", []string{".", ".a", ">.b", ".b[0]", ".b[1]"}, false, false},
+		{"expand enter", "jj\r", []string{".", ".a", ">.b", ".b[0]", ".b[1]"}, false, false},
+		{"collapse", "jjlh", []string{".", ".a", ">.b"}, false, false},
+		{"collapse enter", "jj\r ", []string{".", ".a", ">.b"}, false, false},
+		{"collapse from child selects parent", "jjljh", []string{".", ".a", ">.b", ".b[0]", ".b[1]"}, false, false},
+		{"collapse root", "h", []string{">."}, false, false},
+		{"expand scalar does nothing", "jl", []string{".", ">.a", ".b"}, false, false},
+		{"search", "/3\r", []string{".", ".a", ".b", ".b[0]", ">.b[1]"}, false, false},
+		{"search next wraps", "/2\rGn", []string{".", ".a", ".b", ">.b[0]", ".b[1]"}, false, false},
+		{"search escape", "/3\x1b", []string{">.", ".a", ".b"}, false, false},
+		{"search backspace", "/4\x7f3\r", []string{".", ".a", ".b", ".b[0]", ">.b[1]"}, false, false},
+		{"offset", "o1\r", []string{".", ".a", ".b", ">.b[0]", ".b[1]"}, false, false},
+		{"offset hex", ":0x2\r", []string{".", ".a", ".b", ".b[0]", ">.b[1]"}, false, false},
+		{"quit", "jq", []string{".", ">.a", ".b"}, true, false},
+		{"escape", "j\x1b", []string{".", ">.a", ".b"}, true, false},
+		{"interrupt", "j\x03", []string{".", ">.a", ".b"}, true, false},
+		{"return", "jr", []string{".", ">.a", ".b"}, true, true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b := browseTestBrowser(t)
+			exit, ret := browseTestKeys(b, tc.keys)
+			if actual := browseTestRows(b); !reflect.DeepEqual(tc.expectedRows, actual) {
+				t.Errorf("expected rows %v got %v", tc.expectedRows, actual)
+			}
+			if exit != tc.expectedExit || ret != tc.expectedRet {
+				t.Errorf("expected exit %v ret %v got %v %v", tc.expectedExit, tc.expectedRet, exit, ret)
+			}
+		})
+	}
+}
+
+func TestBrowseStatus(t *testing.T) {
+	testCases := []struct {
+		keys     string
+		expected string
+	}{
+		{"/nope\r", `"nope" not found`},
+		{"oabc\r", `invalid offset "abc"`},
+		{"o100\r", "no field at offset 0x64"},
+		// status is cleared by next key
+		{"o100\rj", ""},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.keys, func(t *testing.T) {
+			b := browseTestBrowser(t)
+			browseTestKeys(b, tc.keys)
+			if b.status != tc.expected {
+				t.Errorf("expected status %q got %q", tc.expected, b.status)
+			}
+		})
+	}
+}
+
+func TestBrowseRowText(t *testing.T) {
+	b := browseTestBrowser(t)
+	browseTestKeys(b, "jjl")
+	var actual []string
+	for _, r := range b.rows {
+		actual = append(actual, b.rowText(r, PlainDecorator))
+	}
+	expected := []string{
+		"- .{}: (test)",
+		"    a: 1",
+		"  - b[0:2]:",
+		"      [0]: 2",
+		"      [1]: 3",
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestBrowseHexLines(t *testing.T) {
+	b := browseTestBrowser(t)
+	browseTestKeys(b, "jjlj")
+	lines, err := b.hexLines(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("expected one line got %v", lines)
+	}
+	// selected .b[0] byte is inverse
+	expected := "0x0|01 " + browseInverse.Wrap("02") + " 03"
+	if !strings.HasPrefix(lines[0], expected) {
+		t.Errorf("expected prefix %q got %q", expected, lines[0])
+	}
+}
+
+func TestBrowseRender(t *testing.T) {
+	b := browseTestBrowser(t)
+	b.height = 10
+	browseTestKeys(b, "jjl")
+	buf := &bytes.Buffer{}
+	if err := b.render(buf); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, l := range strings.Split(strings.TrimPrefix(buf.String(), browseHome), "\r\n") {
+		lines = append(lines, browseTestSGR.ReplaceAllString(strings.ReplaceAll(l, browseClearLine, ""), ""))
+	}
+
+	// tree height is 10-2-2 and hex height is 2
+	expected := []string{
+		"- .{}: (test)",
+		"    a: 1",
+		"  - b[0:2]:",
+		"      [0]: 2",
+		"      [1]: 3",
+		"",
+		".b 0x1-0x2.7 (2)",
+		"0x0|01 02 03",
+		"",
+		browseHelp[0:b.width],
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines got %d: %q", len(expected), len(lines), lines)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(lines[i], e) {
+			t.Errorf("line %d: expected prefix %q got %q", i, e, lines[i])
+		}
+	}
+
+	// selection is kept visible when moving below tree
+	b.height = 6
+	browseTestKeys(b, "G")
+	buf.Reset()
+	if err := b.render(buf); err != nil {
+		t.Fatal(err)
+	}
+	if b.top != 3 {
+		t.Errorf("expected top 3 got %d", b.top)
+	}
+	if !strings.Contains(buf.String(), browseInverse.Wrap("      [1]: 3")) {
+		t.Errorf("expected selected last row got %q", buf.String())
+	}
+}
+
+type browseTestInput struct {
+	r        io.Reader
+	terminal bool
+	rawMode  int
+}
+
+func (i *browseTestInput) Stat() (fs.FileInfo, error) { return nil, fs.ErrInvalid }
+func (i *browseTestInput) Read(p []byte) (int, error) { return i.r.Read(p) }
+func (i *browseTestInput) Close() error               { return nil }
+func (i *browseTestInput) Size() (int, int)           { return 0, 0 }
+func (i *browseTestInput) IsTerminal() bool           { return i.terminal }
+func (i *browseTestInput) RawMode() (func() error, error) {
+	i.rawMode++
+	return func() error { i.rawMode--; return nil }, nil
+}
+
+type browseTestOutput struct {
+	io.Writer
+	width  int
+	height int
+}
+
+func (o browseTestOutput) Size() (int, int) { return o.width, o.height }
+func (o browseTestOutput) IsTerminal() bool { return true }
+
+type browseTestOS struct {
+	serveTestOS
+	stdin  *browseTestInput
+	stdout browseTestOutput
+}
+
+func (o browseTestOS) Stdin() Input   { return o.stdin }
+func (o browseTestOS) Stdout() Output { return o.stdout }
+
+func browseTestRun(t *testing.T, stdin *browseTestInput, height int) ([]any, string, error) {
+	t.Helper()
+	out := &bytes.Buffer{}
+	i, err := New(browseTestOS{stdin: stdin, stdout: browseTestOutput{Writer: out, width: 80, height: height}}, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter := i._browse(makeDecodeValue(browseTestValue(t), decodeValueValue), map[string]any{"line_bytes": 16, "addrbase": 16, "sizebase": 10})
+	var vs []any
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := v.(error); ok {
+			return nil, out.String(), err
+		}
+		vs = append(vs, v)
+	}
+	return vs, out.String(), nil
+}
+
+func TestBrowse(t *testing.T) {
+	stdin := &browseTestInput{r: strings.NewReader("jjljr"), terminal: true}
+	vs, out, err := browseTestRun(t, stdin, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 {
+		t.Fatalf("expected one value got %v", vs)
+	}
+	dv, ok := vs[0].(DecodeValue)
+	if !ok || valuePathExprDecorated(dv.DecodeValue(), PlainDecorator) != ".b[0]" {
+		t.Errorf("expected .b[0] got %v", vs[0])
+	}
+	if stdin.rawMode != 0 {
+		t.Errorf("expected raw mode to be restored")
+	}
+	if !strings.HasPrefix(out, browseAltScreen) || !strings.HasSuffix(out, browseNormalScreen) {
+		t.Errorf("expected alt screen to be entered and left got %q", out)
+	}
+
+	// quit and end of input returns nothing
+	for _, keys := range []string{"q", "jj"} {
+		vs, _, err := browseTestRun(t, &browseTestInput{r: strings.NewReader(keys), terminal: true}, 20)
+		if err != nil || vs != nil {
+			t.Errorf("%s: expected no values got %v %v", keys, vs, err)
+		}
+	}
+
+	if _, _, err := browseTestRun(t, &browseTestInput{r: strings.NewReader("q")}, 20); err == nil || !strings.Contains(err.Error(), "terminal") {
+		t.Errorf("expected terminal error got %v", err)
+	}
+	if _, _, err := browseTestRun(t, &browseTestInput{r: strings.NewReader("q"), terminal: true}, 5); err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Errorf("expected too small error got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package interp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+
+	"github.com/wader/fq/pkg/decode"
+)
+
+// index cache stores the top-level layout of a decode, ex ranges of packets, so
+// that large files can be decoded again without scanning the whole file.
+// Entries are keyed by filename, size, modification time, format and decode
+// options and are stored using OS IndexCache
+
+func init() {
+	RegisterFunc0("index_cache_prune", (*Interp).indexCachePrune)
+}
+
+// IndexCache stores decode indexes, keys are safe to use as filenames
+type IndexCache interface {
+	Keys() ([]string, error)
+	Get(key string) ([]byte, error)
+	Set(key string, b []byte) error
+	Delete(key string) error
+}
+
+// bump if index layout changes
+const indexCacheVersion = 1
+
+type indexCacheEntry struct {
+	Version  int           `json:"version"`
+	Filename string        `json:"filename"`
+	Size     int64         `json:"size"`
+	ModTime  int64         `json:"mod_time"`
+	Index    *decode.Index `json:"index"`
+}
+
+func (e indexCacheEntry) matches(fi fs.FileInfo) bool {
+	return e.Version == indexCacheVersion &&
+		e.Size == fi.Size() &&
+		e.ModTime == fi.ModTime().UnixNano()
+}
+
+func indexCacheKey(filename string, fi fs.FileInfo, group string, force bool, args []any) (string, error) {
+	b, err := json.Marshal([]any{
+		indexCacheVersion,
+		filename,
+		fi.Size(),
+		fi.ModTime().UnixNano(),
+		group,
+		force,
+		args,
+	})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// indexCacheGet returns cached index or an empty index to be filled by decode
+func indexCacheGet(c IndexCache, key string, fi fs.FileInfo) *decode.Index {
+	b, err := c.Get(key)
+	if err != nil {
+		return &decode.Index{}
+	}
+	var e indexCacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || !e.matches(fi) || e.Index == nil {
+		return &decode.Index{}
+	}
+	e.Index.Cached = true
+	return e.Index
+}
+
+func indexCacheSet(c IndexCache, key string, filename string, fi fs.FileInfo, idx *decode.Index) error {
+	b, err := json.Marshal(indexCacheEntry{
+		Version:  indexCacheVersion,
+		Filename: filename,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime().UnixNano(),
+		Index:    idx,
+	})
+	if err != nil {
+		return err
+	}
+	return c.Set(key, b)
+}
+
+// indexCachePrune removes entries for files that has been changed or removed
+func (i *Interp) indexCachePrune(c any) any {
+	var ic IndexCache
+	if icr, ok := i.OS.(IndexCacher); ok {
+		ic = icr.IndexCache()
+	}
+	if ic == nil {
+		return errors.New("index cache not available")
+	}
+	keys, err := ic.Keys()
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	kept := 0
+	for _, key := range keys {
+		keep := func() bool {
+			b, err := ic.Get(key)
+			if err != nil {
+				return false
+			}
+			var e indexCacheEntry
+			if err := json.Unmarshal(b, &e); err != nil {
+				return false
+			}
+			f, err := i.OS.FS().Open(e.Filename)
+			if err != nil {
+				return false
+			}
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil {
+				return false
+			}
+			return e.matches(fi)
+		}()
+		if keep {
+			kept++
+			continue
+		}
+		if err := ic.Delete(key); err != nil {
+			return err
+		}
+		removed++
+	}
+
+	return map[string]any{
+		"removed": removed,
+		"kept":    kept,
+	}
+}
@@ -0,0 +1,125 @@
+package interp
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
+)
+
+// concatenated input presents multiple files, ex segment files or split
+// archives, as one binary. Origins are passed to decoders so that they can
+// know which file a range comes from.
+
+func init() {
+	RegisterFunc1("open_concat", (*Interp)._openConcat)
+	RegisterFunc0("origin", (*Interp).origin)
+}
+
+type concatReader struct {
+	*bitio.MultiReader
+	origins []decode.Origin
+}
+
+func (i *Interp) _openConcat(_ any, paths []any) any {
+	if i.EvalInstance.IsCompleting {
+		br, _ := NewBinaryFromBitReader(bitio.NewBitReader([]byte{}, -1), 8, 0)
+		return br
+	}
+	if len(paths) == 0 {
+		return errors.New("expected at least one path")
+	}
+
+	cf := &openFile{}
+	var rs []bitio.ReadAtSeeker
+	var origins []decode.Origin
+	var names []string
+	var pos int64
+	var totalSize int64
+	var ofs []*openFile
+	// close already opened files on error
+	closeAll := func() {
+		for _, of := range ofs {
+			of.file.Close()
+		}
+	}
+	for _, p := range paths {
+		of, err := i.openPath(p)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		ofs = append(ofs, of)
+		l, err := bitioex.Len(of.br)
+		if err != nil {
+			closeAll()
+			return err
+		}
+
+		// report progress for all files
+		prevSize := pos / 8
+		of.progressFn = func(approxReadBytes int64, _ int64) {
+			if cf.progressFn != nil {
+				cf.progressFn(prevSize+approxReadBytes, totalSize)
+			}
+		}
+
+		rs = append(rs, of.br)
+		origins = append(origins, decode.Origin{Name: of.filename, Range: ranges.Range{Start: pos, Len: l}})
+		names = append(names, of.filename)
+		pos += l
+	}
+	totalSize = pos / 8
+
+	mr, err := bitio.NewMultiReader(rs...)
+	if err != nil {
+		closeAll()
+		return err
+	}
+	cf.filename = strings.Join(names, "+")
+	cf.br = &concatReader{MultiReader: mr, origins: origins}
+
+	return cf
+}
+
+// origin returns file and position relative to the file for a value decoded
+// from a concatenated input, null if not concatenated
+func (i *Interp) origin(c any) any {
+	dvc, ok := c.(DecodeValue)
+	if !ok {
+		return errors.New("expected a decode value")
+	}
+	dv := dvc.DecodeValue()
+
+	top := dv
+	for top.Parent != nil {
+		top = top.Parent
+	}
+	cr, ok := top.RootReader.(*concatReader)
+	if !ok {
+		return nil
+	}
+	// values in nested buffers, ex decompressed data, use position of the
+	// field with the buffer
+	v := dv
+	for v.RootReader != top.RootReader && v.Parent != nil {
+		v = v.Parent
+	}
+
+	for j, o := range cr.origins {
+		if v.Range.Start < o.Range.Stop() || j == len(cr.origins)-1 {
+			start := v.Range.Start - o.Range.Start
+			return map[string]any{
+				"name":  o.Name,
+				"start": big.NewInt(start),
+				"stop":  big.NewInt(start + v.Range.Len),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package interp
+
+import (
+	"errors"
+	"math"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
+	"golang.org/x/exp/slices"
+)
+
+// coverage reports how much of the bits of a decode value are understood by
+// the decoders, that is covered by non-gap scalar fields
+
+func init() {
+	RegisterFunc1("_coverage", (*Interp)._coverage)
+}
+
+type coverageOpts struct {
+	Depth int
+	Gaps  int
+}
+
+type coverageStats struct {
+	bits    int64
+	decoded int64
+	gap     int64
+	overlap int64
+	unknown int64
+}
+
+func (cs *coverageStats) add(o coverageStats) {
+	cs.bits += o.bits
+	cs.decoded += o.decoded
+	cs.gap += o.gap
+	cs.overlap += o.overlap
+	cs.unknown += o.unknown
+}
+
+// percent of total, null for an empty value as there is nothing to cover
+func coveragePercent(n int64, total int64) any {
+	if total == 0 {
+		return nil
+	}
+	return math.Round(float64(n)/float64(total)*10000) / 100
+}
+
+func (cs coverageStats) toValue() map[string]any {
+	return map[string]any{
+		"bits":            int(cs.bits),
+		"decoded_bits":    int(cs.decoded),
+		"gap_bits":        int(cs.gap),
+		"overlap_bits":    int(cs.overlap),
+		"unknown_bits":    int(cs.unknown),
+		"decoded_percent": coveragePercent(cs.decoded, cs.bits),
+		"gap_percent":     coveragePercent(cs.gap, cs.bits),
+		"overlap_percent": coveragePercent(cs.overlap, cs.bits),
+		"unknown_percent": coveragePercent(cs.unknown, cs.bits),
+	}
+}
+
+// coverageLeaves collects scalar ranges for v that are in the same buffer as v,
+// nested buffer roots (ex: decompressed data) are skipped
+func coverageLeaves(v *decode.Value) (decoded []ranges.Range, gaps []*decode.Value) {
+	_ = v.WalkRootPreOrder(func(wv *decode.Value, _ *decode.Value, _ int, _ int) error {
+		if wv != v && wv.IsRoot {
+			return decode.ErrWalkSkipChildren
+		}
+		s, ok := wv.V.(Scalarable)
+		if !ok {
+			return nil
+		}
+		if s.ScalarIsGap() {
+			gaps = append(gaps, wv)
+		} else {
+			decoded = append(decoded, wv.Range)
+		}
+		return nil
+	})
+	return decoded, gaps
+}
+
+// rangesClip limits ranges to be inside r, empty ranges are removed
+func rangesClip(r ranges.Range, rs []ranges.Range) []ranges.Range {
+	var crs []ranges.Range
+	for _, cr := range rs {
+		start := cr.Start
+		stop := cr.Stop()
+		if start < r.Start {
+			start = r.Start
+		}
+		if stop > r.Stop() {
+			stop = r.Stop()
+		}
+		if stop <= start {
+			continue
+		}
+		crs = append(crs, ranges.Range{Start: start, Len: stop - start})
+	}
+	return crs
+}
+
+// rangesCoverage returns number of bits covered by at least one range and
+// number of bits covered by more than one range
+func rangesCoverage(rs []ranges.Range) (covered int64, overlap int64) {
+	type edge struct {
+		pos   int64
+		delta int
+	}
+	edges := make([]edge, 0, len(rs)*2)
+	for _, r := range rs {
+		if r.Len <= 0 {
+			continue
+		}
+		edges = append(edges, edge{pos: r.Start, delta: 1}, edge{pos: r.Stop(), delta: -1})
+	}
+	// stops before starts at same position so that adjacent ranges don't overlap
+	slices.SortFunc(edges, func(a, b edge) bool {
+		if a.pos == b.pos {
+			return a.delta < b.delta
+		}
+		return a.pos < b.pos
+	})
+
+	depth := 0
+	var lastPos int64
+	for _, e := range edges {
+		if depth >= 1 {
+			covered += e.pos - lastPos
+		}
+		if depth >= 2 {
+			overlap += e.pos - lastPos
+		}
+		depth += e.delta
+		lastPos = e.pos
+	}
+
+	return covered, overlap
+}
+
+func coverageValue(v *decode.Value) coverageStats {
+	r := v.InnerRange()
+	decoded, gapVs := coverageLeaves(v)
+	decoded = rangesClip(r, decoded)
+	gaps := make([]ranges.Range, len(gapVs))
+	for i, gv := range gapVs {
+		gaps[i] = gv.Range
+	}
+	gaps = rangesClip(r, gaps)
+
+	decodedBits, overlapBits := rangesCoverage(decoded)
+	allBits, _ := rangesCoverage(append(append([]ranges.Range{}, decoded...), gaps...))
+
+	return coverageStats{
+		bits:    r.Len,
+		decoded: decodedBits,
+		gap:     allBits - decodedBits,
+		overlap: overlapBits,
+		unknown: r.Len - allBits,
+	}
+}
+
+func (i *Interp) _coverage(c any, opts coverageOpts) any {
+	dvc, ok := c.(DecodeValue)
+	if !ok {
+		return errors.New("expected a decode value")
+	}
+	dv := dvc.DecodeValue()
+
+	total := coverageValue(dv)
+
+	// per format, nested format roots are also counted in all its parents formats
+	formats := map[string]*coverageStats{}
+	formatCounts := map[string]int{}
+	var fieldVs []any
+	_ = dv.WalkPreOrder(func(wv *decode.Value, _ *decode.Value, depth int, _ int) error {
+		if _, ok := wv.V.(*decode.Compound); !ok {
+			return nil
+		}
+		if wv.Format != nil {
+			name := wv.Format.Name
+			fs, ok := formats[name]
+			if !ok {
+				fs = &coverageStats{}
+				formats[name] = fs
+			}
+			fs.add(coverageValue(wv))
+			formatCounts[name]++
+		}
+		if depth > 0 && depth <= opts.Depth {
+			fv := coverageValue(wv).toValue()
+			fv["path"] = valuePathExprDecorated(wv, PlainDecorator)
+			fv["start"] = int(wv.Range.Start)
+			fv["stop"] = int(wv.Range.Stop())
+			fieldVs = append(fieldVs, fv)
+		}
+		return nil
+	})
+
+	formatVs := map[string]any{}
+	for name, fs := range formats {
+		fv := fs.toValue()
+		fv["count"] = formatCounts[name]
+		formatVs[name] = fv
+	}
+
+	_, gapVs := coverageLeaves(dv)
+	slices.SortStableFunc(gapVs, func(a, b *decode.Value) bool { return a.Range.Len > b.Range.Len })
+	if len(gapVs) > opts.Gaps {
+		gapVs = gapVs[0:opts.Gaps]
+	}
+	largestGapVs := []any{}
+	for _, gv := range gapVs {
+		largestGapVs = append(largestGapVs, map[string]any{
+			"path":  valuePathExprDecorated(gv, PlainDecorator),
+			"start": int(gv.Range.Start),
+			"stop":  int(gv.Range.Stop()),
+			"bits":  int(gv.Range.Len),
+		})
+	}
+
+	if fieldVs == nil {
+		fieldVs = []any{}
+	}
+
+	cv := total.toValue()
+	cv["formats"] = formatVs
+	cv["fields"] = fieldVs
+	cv["largest_gaps"] = largestGapVs
+
+	return cv
+}
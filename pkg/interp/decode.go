@@ -174,9 +174,13 @@ func (i *Interp) _toValue(c any, om map[string]any) any {
 }
 
 type decodeOpts struct {
-	Force    bool
-	Progress string
-	Remain   map[string]any `mapstruct:",remain"`
+	Force        bool
+	Progress     string
+	Cache        bool
+	CacheMinSize int64
+	Recover      bool
+	Trace        bool
+	Remain       map[string]any `mapstruct:",remain"`
 }
 
 func (i *Interp) _decode(c any, format string, opts decodeOpts) any {
@@ -237,6 +241,52 @@ func (i *Interp) _decode(c any, format string, opts decodeOpts) any {
 		return err
 	}
 
+	parseOptsFn := func(init any) any {
+		v, err := copystructure.Copy(init)
+		if err != nil {
+			return nil
+		}
+
+		if len(opts.Remain) > 0 {
+			if err := mapstruct.ToStruct(opts.Remain, &v); err != nil {
+				// TODO: currently ignores failed struct mappings
+				return nil
+			}
+		}
+		// nil if same as init
+		if reflect.DeepEqual(init, v) {
+			return nil
+		}
+
+		return v
+	}
+
+	// use index cache for large enough regular files
+	var index *decode.Index
+	var indexCache IndexCache
+	var indexKey string
+	var indexFilename string
+	if of, ok := c.(*openFile); ok && opts.Cache && of.fi != nil && of.fi.Size() >= opts.CacheMinSize {
+		ic, icOk := i.OS.(IndexCacher)
+		if icOk {
+			indexCache = ic.IndexCache()
+		}
+		if indexCache != nil {
+			// absolute path so that same file opened from different directories share entry
+			// and can be found by index_cache_prune
+			if indexFilename, err = ic.AbsPath(of.filename); err == nil {
+				if indexKey, err = indexCacheKey(indexFilename, of.fi, formatName, opts.Force, decodeArgs(decodeGroup, parseOptsFn)); err == nil {
+					index = indexCacheGet(indexCache, indexKey, of.fi)
+				}
+			}
+		}
+	}
+
+	var origins []decode.Origin
+	if cr, ok := bv.br.(*concatReader); ok {
+		origins = cr.origins
+	}
+
 	dv, formatOut, err := decode.Decode(i.EvalInstance.Ctx, bv.br, decodeGroup,
 		decode.Options{
 			IsRoot:      true,
@@ -244,27 +294,25 @@ func (i *Interp) _decode(c any, format string, opts decodeOpts) any {
 			Force:       opts.Force,
 			Range:       bv.r,
 			Description: filename,
-			ParseOptsFn: func(init any) any {
-				v, err := copystructure.Copy(init)
-				if err != nil {
-					return nil
-				}
-
-				if len(opts.Remain) > 0 {
-					if err := mapstruct.ToStruct(opts.Remain, &v); err != nil {
-						// TODO: currently ignores failed struct mappings
-						return nil
-					}
-				}
-				// nil if same as init
-				if reflect.DeepEqual(init, v) {
-					return nil
-				}
-
-				return v
-			},
+			ParseOptsFn: parseOptsFn,
+			Index:       index,
+			Origins:     origins,
+			Recover:     opts.Recover,
+			Trace:       opts.Trace,
 		},
 	)
+	if index != nil {
+		// err can be probe errors so check decode value for errors
+		decodeOk := dv != nil && dv.Errors() == nil
+		// cache is best effort so errors are ignored
+		switch {
+		case index.Cached && !decodeOk:
+			// might be stale, decode again next time
+			_ = indexCache.Delete(indexKey)
+		case !index.Cached && decodeOk && !index.IsEmpty():
+			_ = indexCacheSet(indexCache, indexKey, indexFilename, c.(*openFile).fi, index)
+		}
+	}
 	if dv == nil {
 		var decodeFormatsErr decode.FormatsError
 		if errors.As(err, &decodeFormatsErr) {
@@ -291,6 +339,26 @@ func (i *Interp) _decode(c any, format string, opts decodeOpts) any {
 	return makeDecodeValueOut(dv, decodeValueValue, formatOutMap)
 }
 
+// decodeArgs returns format arguments used when decoding group
+func decodeArgs(group *decode.Group, parseOptsFn func(init any) any) []any {
+	inArgs := []any{group.DefaultInArg}
+	for _, f := range group.Formats {
+		inArgs = append(inArgs, f.DefaultInArg)
+	}
+
+	var args []any
+	for _, a := range inArgs {
+		if a == nil {
+			continue
+		}
+		if pa := parseOptsFn(a); pa != nil {
+			a = pa
+		}
+		args = append(args, a)
+	}
+	return args
+}
+
 func valueKey(name string, a, b func(name string) any) any {
 	if strings.HasPrefix(name, "_") {
 		return a(name)
@@ -340,6 +408,9 @@ func makeDecodeValue(dv *decode.Value, kind decodeValueKind) any {
 }
 
 func makeDecodeValueOut(dv *decode.Value, kind decodeValueKind, out any) any {
+	// lazy values are decoded on first access
+	dv.Resolve()
+
 	switch vv := dv.V.(type) {
 	case *decode.Compound:
 		if vv.IsArray {
@@ -478,6 +549,7 @@ func (dvb decodeValueBase) ExtKeys() []string {
 		"_start",
 		"_stop",
 		"_sym",
+		"_trace",
 	}
 
 	if _, ok := dvb.dv.V.(*decode.Compound); ok {
@@ -571,6 +643,11 @@ func (dvb decodeValueBase) JQValueKey(name string) any {
 		default:
 			return nil
 		}
+	case "_trace":
+		if dv.Trace == nil {
+			return nil
+		}
+		return traceToValue(dv.Trace)
 
 	case "_error":
 		var formatErr decode.FormatError
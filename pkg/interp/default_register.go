@@ -5,18 +5,22 @@ import (
 
 	"github.com/wader/fq/internal/gojqex"
 	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/registry"
 	"github.com/wader/gojq"
 )
 
-// DefaultRegistry global registry used by formats
-var DefaultRegistry = NewRegistry()
+// DefaultRegistry global registry with formats from registry.Default and jq functions
+// registered by formats
+var DefaultRegistry = NewRegistry(registry.Default)
 
+// Deprecated: Use registry.RegisterFormat
 func RegisterFormat(group *decode.Group, format *decode.Format) {
-	DefaultRegistry.Format(group, format)
+	registry.RegisterFormat(group, format)
 }
 
+// Deprecated: Use registry.RegisterFS
 func RegisterFS(fs fs.ReadDirFS) {
-	DefaultRegistry.FS(fs)
+	registry.RegisterFS(fs)
 }
 
 func RegisterFunc0[Tc any](name string, fn func(e *Interp, c Tc) any) {
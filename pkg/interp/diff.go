@@ -0,0 +1,596 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/wader/fq/internal/ansi"
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/internal/columnwriter"
+	"github.com/wader/fq/internal/gojqex"
+	"github.com/wader/fq/internal/hexpairwriter"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+)
+
+// structural diff of two decode values, structs are aligned by field name and
+// arrays by first matching identical elements and then elements with the same
+// name, format and type/id/tag field
+
+func init() {
+	RegisterFunc2("_decode_diff", (*Interp)._decodeDiff)
+}
+
+const (
+	diffOpChanged = "changed"
+	diffOpAdded   = "added"
+	diffOpRemoved = "removed"
+)
+
+// max number of LCS table cells before falling back to align arrays by index
+const diffMaxLCSCells = 4 * 1024 * 1024
+
+// fields used to tell array elements apart, ex: mp4 box type and matroska element id
+var diffArrayKeyFields = []string{"type", "id", "tag", "name", "code"}
+
+type diffChange struct {
+	op string
+	a  *decode.Value
+	b  *decode.Value
+}
+
+func diffValueBytes(v *decode.Value) ([]byte, error) {
+	r := v.InnerRange()
+	br, err := bitioex.Range(v.BufferRoot().RootReader, r.Start, r.Len)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err := bitioex.CopyBits(buf, br); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func diffBitBufEqual(a, b bitio.ReaderAtSeeker) bool {
+	aLen, aErr := bitioex.Len(a)
+	bLen, bErr := bitioex.Len(b)
+	if aErr != nil || bErr != nil || aLen != bLen {
+		return false
+	}
+	aBuf := &bytes.Buffer{}
+	bBuf := &bytes.Buffer{}
+	aBR, aErr := bitio.CloneReadSeeker(a)
+	bBR, bErr := bitio.CloneReadSeeker(b)
+	if aErr != nil || bErr != nil {
+		return false
+	}
+	if _, err := bitioex.CopyBits(aBuf, aBR); err != nil {
+		return false
+	}
+	if _, err := bitioex.CopyBits(bBuf, bBR); err != nil {
+		return false
+	}
+	return bytes.Equal(aBuf.Bytes(), bBuf.Bytes())
+}
+
+func diffScalarEqual(a, b Scalarable) bool {
+	aa := a.ScalarActual()
+	ba := b.ScalarActual()
+	switch av := aa.(type) {
+	case bitio.ReaderAtSeeker:
+		bv, ok := ba.(bitio.ReaderAtSeeker)
+		return ok && diffBitBufEqual(av, bv)
+	case *big.Int:
+		bv, ok := ba.(*big.Int)
+		return ok && av.Cmp(bv) == 0
+	default:
+		return reflect.DeepEqual(aa, ba)
+	}
+}
+
+// diffArrayKey is used to pair up array elements that are not identical
+func diffArrayKey(v *decode.Value) string {
+	v.Resolve()
+	var sb strings.Builder
+	sb.WriteString(v.Name)
+	if v.Format != nil {
+		sb.WriteString("/")
+		sb.WriteString(v.Format.Name)
+	}
+	if c, ok := v.V.(*decode.Compound); ok && !c.IsArray {
+		for _, k := range diffArrayKeyFields {
+			kv, ok := c.ByName[k]
+			if !ok {
+				continue
+			}
+			if s, ok := kv.V.(Scalarable); ok {
+				fmt.Fprintf(&sb, "/%s=%v", k, s.ScalarActual())
+				break
+			}
+		}
+	}
+	return sb.String()
+}
+
+// diffArrayHash is used to find array elements that are likely identical, see
+// diffArrayIdentical
+func diffArrayHash(v *decode.Value) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%d/", diffArrayKey(v), v.InnerRange().Len)
+	if b, err := diffValueBytes(v); err == nil {
+		_, _ = h.Write(b)
+	}
+	return h.Sum64()
+}
+
+func diffArrayIdentical(a, b *decode.Value) bool {
+	if a.InnerRange().Len != b.InnerRange().Len || diffArrayKey(a) != diffArrayKey(b) {
+		return false
+	}
+	aBytes, aErr := diffValueBytes(a)
+	bBytes, bErr := diffValueBytes(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aBytes, bBytes)
+}
+
+// diffLCS returns index pairs of a longest common subsequence, falls back to
+// pairing by index if the table would be too large
+func diffLCS(n, m int, eq func(i, j int) bool) [][2]int {
+	var pairs [][2]int
+
+	// common prefix and suffix
+	prefix := 0
+	for prefix < n && prefix < m && eq(prefix, prefix) {
+		pairs = append(pairs, [2]int{prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && eq(n-1-suffix, m-1-suffix) {
+		suffix++
+	}
+
+	an := n - prefix - suffix
+	bm := m - prefix - suffix
+	if an > 0 && bm > 0 {
+		if an*bm > diffMaxLCSCells {
+			for i := 0; i < an && i < bm; i++ {
+				if eq(prefix+i, prefix+i) {
+					pairs = append(pairs, [2]int{prefix + i, prefix + i})
+				}
+			}
+		} else {
+			// lengths of LCS for a[i:] and b[j:]
+			t := make([][]int, an+1)
+			for i := range t {
+				t[i] = make([]int, bm+1)
+			}
+			for i := an - 1; i >= 0; i-- {
+				for j := bm - 1; j >= 0; j-- {
+					if eq(prefix+i, prefix+j) {
+						t[i][j] = t[i+1][j+1] + 1
+					} else {
+						t[i][j] = mathex.Max(t[i+1][j], t[i][j+1])
+					}
+				}
+			}
+			for i, j := 0, 0; i < an && j < bm; {
+				switch {
+				case eq(prefix+i, prefix+j):
+					pairs = append(pairs, [2]int{prefix + i, prefix + j})
+					i++
+					j++
+				case t[i+1][j] >= t[i][j+1]:
+					i++
+				default:
+					j++
+				}
+			}
+		}
+	}
+
+	for i := suffix; i > 0; i-- {
+		pairs = append(pairs, [2]int{n - i, m - i})
+	}
+
+	return pairs
+}
+
+type diffCtx struct {
+	changes []diffChange
+}
+
+func (dc *diffCtx) add(op string, a, b *decode.Value) {
+	dc.changes = append(dc.changes, diffChange{op: op, a: a, b: b})
+}
+
+// diffArrayIDs returns ids for elements of a and b where identical elements
+// have the same id. Hash matches are confirmed with diffArrayIdentical.
+func diffArrayIDs(a, b []*decode.Value) ([]int, []int) {
+	type class struct {
+		v  *decode.Value
+		id int
+	}
+	classes := map[uint64][]class{}
+	nextID := 0
+	id := func(v *decode.Value) int {
+		h := diffArrayHash(v)
+		for _, c := range classes[h] {
+			if diffArrayIdentical(c.v, v) {
+				return c.id
+			}
+		}
+		classes[h] = append(classes[h], class{v: v, id: nextID})
+		nextID++
+		return nextID - 1
+	}
+	aIDs := make([]int, len(a))
+	for i, v := range a {
+		aIDs[i] = id(v)
+	}
+	bIDs := make([]int, len(b))
+	for i, v := range b {
+		bIDs[i] = id(v)
+	}
+	return aIDs, bIDs
+}
+
+func (dc *diffCtx) diffArray(a, b []*decode.Value) {
+	aIDs, bIDs := diffArrayIDs(a, b)
+	identical := diffLCS(len(a), len(b), func(i, j int) bool { return aIDs[i] == bIDs[j] })
+
+	// elements between identical ones are paired by key, unpaired ones are removed or added
+	diffRun := func(a, b []*decode.Value) {
+		aKeys := make([]string, len(a))
+		for i, v := range a {
+			aKeys[i] = diffArrayKey(v)
+		}
+		bKeys := make([]string, len(b))
+		for i, v := range b {
+			bKeys[i] = diffArrayKey(v)
+		}
+		ai, bi := 0, 0
+		for _, p := range diffLCS(len(a), len(b), func(i, j int) bool { return aKeys[i] == bKeys[j] }) {
+			for ; ai < p[0]; ai++ {
+				dc.add(diffOpRemoved, a[ai], nil)
+			}
+			for ; bi < p[1]; bi++ {
+				dc.add(diffOpAdded, nil, b[bi])
+			}
+			dc.diffValue(a[ai], b[bi])
+			ai++
+			bi++
+		}
+		for ; ai < len(a); ai++ {
+			dc.add(diffOpRemoved, a[ai], nil)
+		}
+		for ; bi < len(b); bi++ {
+			dc.add(diffOpAdded, nil, b[bi])
+		}
+	}
+
+	ai, bi := 0, 0
+	for _, p := range identical {
+		diffRun(a[ai:p[0]], b[bi:p[1]])
+		ai = p[0] + 1
+		bi = p[1] + 1
+	}
+	diffRun(a[ai:], b[bi:])
+}
+
+func (dc *diffCtx) diffStruct(a, b *decode.Compound) {
+	for _, av := range a.Children {
+		bv, ok := b.ByName[av.Name]
+		if !ok {
+			dc.add(diffOpRemoved, av, nil)
+			continue
+		}
+		dc.diffValue(av, bv)
+	}
+	for _, bv := range b.Children {
+		if _, ok := a.ByName[bv.Name]; !ok {
+			dc.add(diffOpAdded, nil, bv)
+		}
+	}
+}
+
+func (dc *diffCtx) diffValue(a, b *decode.Value) {
+	a.Resolve()
+	b.Resolve()
+	switch av := a.V.(type) {
+	case *decode.Compound:
+		bv, ok := b.V.(*decode.Compound)
+		if !ok || av.IsArray != bv.IsArray {
+			dc.add(diffOpChanged, a, b)
+			return
+		}
+		if av.IsArray {
+			dc.diffArray(av.Children, bv.Children)
+		} else {
+			dc.diffStruct(av, bv)
+		}
+	case Scalarable:
+		bv, ok := b.V.(Scalarable)
+		if !ok || !diffScalarEqual(av, bv) {
+			dc.add(diffOpChanged, a, b)
+		}
+	}
+}
+
+type decodeDiff struct {
+	gojqex.Array
+	changes []diffChange
+}
+
+var _ Value = decodeDiff{}
+var _ Display = decodeDiff{}
+
+func diffSideValue(v *decode.Value) any {
+	if v == nil {
+		return nil
+	}
+	sv := map[string]any{
+		"path":  valuePathExprDecorated(v, PlainDecorator),
+		"start": int(v.Range.Start),
+		"stop":  int(v.Range.Stop()),
+	}
+	if _, ok := v.V.(Scalarable); ok {
+		sv["value"] = makeDecodeValue(v, decodeValueValue)
+	}
+	return sv
+}
+
+func newDecodeDiff(changes []diffChange) decodeDiff {
+	vs := make([]any, len(changes))
+	for i, c := range changes {
+		pv := c.a
+		if pv == nil {
+			pv = c.b
+		}
+		vs[i] = map[string]any{
+			"path": valuePathExprDecorated(pv, PlainDecorator),
+			"op":   c.op,
+			"a":    diffSideValue(c.a),
+			"b":    diffSideValue(c.b),
+		}
+	}
+	return decodeDiff{Array: vs, changes: changes}
+}
+
+func (decodeDiff) ExtType() string       { return "decode_diff" }
+func (decodeDiff) ExtKeys() []string     { return nil }
+func (dd decodeDiff) JQValueToGoJQ() any { return toValue(nil, []any(dd.Array)) }
+func (dd decodeDiff) JQValueToGoJQEx(optsFn func() *Options) any {
+	return toValue(optsFn, []any(dd.Array))
+}
+
+func (i *Interp) _decodeDiff(_ any, a any, b any) any {
+	adv, aOk := a.(DecodeValue)
+	bdv, bOk := b.(DecodeValue)
+	if !aOk || !bOk {
+		return errors.New("expected two decode values")
+	}
+	dc := &diffCtx{}
+	dc.diffValue(adv.DecodeValue(), bdv.DecodeValue())
+	return newDecodeDiff(dc.changes)
+}
+
+// diffHexSide is the address and hex column lines for one side of a change
+type diffHexSide struct {
+	addr []string
+	hex  []string
+}
+
+// diffHexBytes returns bytes of v starting at the byte that includes its first
+// bit, at most DisplayBytes. Both sides of a change are sliced the same way so
+// that bytes at the same index are at the same offset relative to the values.
+func diffHexBytes(v *decode.Value, opts Options) ([]byte, error) {
+	r := v.InnerRange()
+	if r.Len == 0 {
+		return nil, nil
+	}
+	rootBR := v.BufferRoot().RootReader
+	rootLen, err := bitioex.Len(rootBR)
+	if err != nil {
+		return nil, err
+	}
+	startByte := r.Start / 8
+	nBytes := bitio.BitsByteCount(r.Stop()) - startByte
+	if opts.DisplayBytes > 0 {
+		nBytes = mathex.Min(nBytes, int64(opts.DisplayBytes))
+	}
+	nBits := mathex.Min(nBytes*8, rootLen-startByte*8)
+	br, err := bitioex.Range(rootBR, startByte*8, nBits)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err := bitioex.CopyBits(buf, br); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// diffHex renders bytes bs of v from diffHexBytes, bytes that differ from other
+// at the same index are highlighted
+func diffHex(v *decode.Value, bs []byte, other []byte, opts Options) diffHexSide {
+	var ds diffHexSide
+	deco := opts.Decorator
+
+	r := v.InnerRange()
+	if r.Len == 0 {
+		return ds
+	}
+	startByte := r.Start / 8
+	stopByte := bitio.BitsByteCount(r.Stop())
+	truncated := opts.DisplayBytes > 0 && stopByte-startByte > int64(opts.DisplayBytes)
+
+	addrWidth := mathex.DigitsInBase(stopByte, true, opts.Addrbase)
+	lineBytes := int64(opts.LineBytes)
+	var line strings.Builder
+	for i, b := range bs {
+		pos := startByte + int64(i)
+		col := pos % lineBytes
+		if i == 0 || col == 0 {
+			ds.addr = append(ds.addr, deco.DumpAddr.Wrap(mathex.PadFormatInt(pos-col, opts.Addrbase, true, addrWidth)))
+			line.Reset()
+			if i == 0 {
+				line.WriteString(strings.Repeat(" ", int(col)*3))
+			}
+		} else {
+			line.WriteString(" ")
+		}
+		c := deco.ByteColor(b)
+		if other != nil && (i >= len(other) || other[i] != b) {
+			c = deco.Error
+		}
+		line.WriteString(c.Wrap(hexpairwriter.Pair(b)))
+		if col == lineBytes-1 || i == len(bs)-1 {
+			ds.hex = append(ds.hex, line.String())
+		}
+	}
+	if truncated {
+		ds.addr = append(ds.addr, deco.DumpAddr.Wrap("*"))
+		ds.hex = append(ds.hex, fmt.Sprintf("until %s (%s)",
+			mathex.Bits(r.Stop()-1).StringByteBits(opts.Addrbase),
+			mathex.PadFormatInt(bitio.BitsByteCount(r.Len), opts.Sizebase, true, 0)))
+	}
+
+	return ds
+}
+
+func diffPreview(v *decode.Value, deco Decorator) string {
+	switch vv := v.V.(type) {
+	case *decode.Compound:
+		if vv.IsArray {
+			return deco.Array.Wrap("[]")
+		}
+		return deco.Object.Wrap("{}")
+	case Scalarable:
+		actual := vv.ScalarActual()
+		if _, ok := actual.(bitio.ReaderAtSeeker); ok {
+			return "raw bits"
+		}
+		return deco.ValueColor(actual).Wrap(previewValue(actual, vv.ScalarDisplayFormat()))
+	}
+	return ""
+}
+
+func (dd decodeDiff) Display(w io.Writer, opts Options) error {
+	deco := opts.Decorator
+
+	var displayLenFn func(s string) int
+	var displayTruncateFn func(s string, start, stop int) string
+	if opts.Color {
+		displayLenFn = ansi.Len
+		displayTruncateFn = ansi.Slice
+	}
+
+	var hexHeader string
+	for i := 0; i < opts.LineBytes; i++ {
+		hexHeader += mathex.PadFormatInt(int64(i), opts.Addrbase, false, 2)
+		if i < opts.LineBytes-1 {
+			hexHeader += " "
+		}
+	}
+
+	for _, c := range dd.changes {
+		pv := c.a
+		if pv == nil {
+			pv = c.b
+		}
+		var desc string
+		switch c.op {
+		case diffOpChanged:
+			desc = fmt.Sprintf("%s -> %s", diffPreview(c.a, deco), diffPreview(c.b, deco))
+		case diffOpRemoved:
+			desc = diffPreview(c.a, deco)
+		case diffOpAdded:
+			desc = diffPreview(c.b, deco)
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %s\n", valuePathExprDecorated(pv, deco), c.op, desc); err != nil {
+			return err
+		}
+
+		var aBytes, bBytes []byte
+		var aSide, bSide diffHexSide
+		var err error
+		if c.a != nil {
+			if aBytes, err = diffHexBytes(c.a, opts); err != nil {
+				return err
+			}
+		}
+		if c.b != nil {
+			if bBytes, err = diffHexBytes(c.b, opts); err != nil {
+				return err
+			}
+		}
+		if c.op == diffOpChanged {
+			// highlight all bytes if other side is empty
+			aBytes = append([]byte{}, aBytes...)
+			bBytes = append([]byte{}, bBytes...)
+		}
+		if c.a != nil {
+			aSide = diffHex(c.a, aBytes, bBytes, opts)
+		}
+		if c.b != nil {
+			bSide = diffHex(c.b, bBytes, aBytes, opts)
+		}
+
+		addrColumnWidth := func(s diffHexSide) int {
+			l := 0
+			for _, a := range s.addr {
+				l = mathex.Max(l, ansi.Len(a))
+			}
+			return l
+		}
+		hexColumnWidth := opts.LineBytes*3 - 1
+		cw := columnwriter.New(
+			w,
+			&columnwriter.MultiLineColumn{Width: addrColumnWidth(aSide), LenFn: displayLenFn, SliceFn: displayTruncateFn},
+			columnwriter.BarColumn(deco.Column),
+			&columnwriter.MultiLineColumn{Width: hexColumnWidth, LenFn: displayLenFn, SliceFn: displayTruncateFn},
+			columnwriter.BarColumn(deco.Column),
+			&columnwriter.MultiLineColumn{Width: addrColumnWidth(bSide), LenFn: displayLenFn, SliceFn: displayTruncateFn},
+			columnwriter.BarColumn(deco.Column),
+			&columnwriter.MultiLineColumn{Width: hexColumnWidth, LenFn: displayLenFn, SliceFn: displayTruncateFn},
+			columnwriter.BarColumn(deco.Column),
+		)
+		const (
+			colAAddr = 0
+			colAHex  = 2
+			colBAddr = 4
+			colBHex  = 6
+		)
+		fmt.Fprintln(cw.Columns[colAHex], deco.DumpHeader.Wrap(hexHeader))
+		fmt.Fprintln(cw.Columns[colBHex], deco.DumpHeader.Wrap(hexHeader))
+		fmt.Fprintln(cw.Columns[colAAddr])
+		fmt.Fprintln(cw.Columns[colBAddr])
+		for _, s := range []struct {
+			side    diffHexSide
+			addrCol int
+			hexCol  int
+		}{
+			{aSide, colAAddr, colAHex},
+			{bSide, colBAddr, colBHex},
+		} {
+			for _, a := range s.side.addr {
+				fmt.Fprintln(cw.Columns[s.addrCol], a)
+			}
+			for _, h := range s.side.hex {
+				fmt.Fprintln(cw.Columns[s.hexCol], h)
+			}
+		}
+		if err := cw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
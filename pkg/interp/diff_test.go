@@ -0,0 +1,77 @@
+package interp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/wader/fq/internal/ansi"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+)
+
+func diffTestDecode(t *testing.T, b []byte, fn func(d *decode.D)) *decode.Value {
+	t.Helper()
+	f := &decode.Format{Name: "test", DecodeFn: func(d *decode.D) any { fn(d); return nil }}
+	dv, _, err := decode.Decode(context.Background(), bitio.NewBitReader(b, -1),
+		&decode.Group{Name: "test", Formats: []*decode.Format{f}},
+		decode.Options{IsRoot: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dv
+}
+
+func TestDiffArrayIDs(t *testing.T) {
+	decodeArray := func(b []byte) []*decode.Value {
+		dv := diffTestDecode(t, b, func(d *decode.D) {
+			d.FieldArray("a", func(d *decode.D) {
+				for !d.End() {
+					d.FieldU8("v")
+				}
+			})
+		})
+		return dv.V.(*decode.Compound).Children[0].V.(*decode.Compound).Children
+	}
+	aIDs, bIDs := diffArrayIDs(decodeArray([]byte{1, 2, 1}), decodeArray([]byte{2, 3, 1}))
+	expectedA := []int{0, 1, 0}
+	expectedB := []int{1, 2, 0}
+	if !reflect.DeepEqual(expectedA, aIDs) || !reflect.DeepEqual(expectedB, bIDs) {
+		t.Errorf("expected %v %v got %v %v", expectedA, expectedB, aIDs, bIDs)
+	}
+}
+
+// bytes of both sides are compared from the byte that includes the first bit
+func TestDiffHexUnaligned(t *testing.T) {
+	decodeUnaligned := func(b []byte) *decode.Value {
+		dv := diffTestDecode(t, b, func(d *decode.D) {
+			d.FieldU4("x")
+			d.FieldU8("v")
+			d.FieldU4("y")
+		})
+		return dv.V.(*decode.Compound).ByName["v"]
+	}
+	a := decodeUnaligned([]byte{0x12, 0x34})
+	b := decodeUnaligned([]byte{0x12, 0x44})
+
+	opts := Options{LineBytes: 16, Addrbase: 16, Sizebase: 10, Decorator: PlainDecorator}
+	opts.Decorator.Error = ansi.Code{SetString: "<", ResetString: ">"}
+
+	aBytes, err := diffHexBytes(a, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bBytes, err := diffHexBytes(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aSide := diffHex(a, aBytes, bBytes, opts)
+	bSide := diffHex(b, bBytes, aBytes, opts)
+	if expected := []string{"12 <34>"}; !reflect.DeepEqual(expected, aSide.hex) {
+		t.Errorf("expected %q got %q", expected, aSide.hex)
+	}
+	if expected := []string{"12 <44>"}; !reflect.DeepEqual(expected, bSide.hex) {
+		t.Errorf("expected %q got %q", expected, bSide.hex)
+	}
+}
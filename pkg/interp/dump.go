@@ -1,6 +1,7 @@
 package interp
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -337,6 +338,15 @@ func dumpEx(v *decode.Value, ctx *dumpCtx, depth int, rootV *decode.Value, rootD
 }
 
 func dump(v *decode.Value, w io.Writer, opts Options) error {
+	return dumpFields(v, w, opts, nil)
+}
+
+// dumpFieldFn is called with the dump output lines of each field
+type dumpFieldFn func(v *decode.Value, out []byte) error
+
+// dumpFields dumps v, if fieldFn is not nil output is passed to it per field
+// instead of written to w
+func dumpFields(v *decode.Value, w io.Writer, opts Options, fieldFn dumpFieldFn) error {
 	maxAddrIndentWidth := 0
 	makeWalkFn := func(fn decode.WalkFn) decode.WalkFn {
 		return func(v *decode.Value, rootV *decode.Value, depth int, rootDepth int) error {
@@ -372,6 +382,11 @@ func dump(v *decode.Value, w io.Writer, opts Options) error {
 	// 	treeColumnWidth = mathex.Max(0, opts.Width-(addrColumnWidth+hexColumnWidth+asciiColumnWidth+3 /* bars */))
 	// }
 
+	fieldBuf := &bytes.Buffer{}
+	if fieldFn != nil {
+		w = fieldBuf
+	}
+
 	cw := columnwriter.New(
 		w,
 		&columnwriter.MultiLineColumn{Width: addrColumnWidth, LenFn: displayLenFn, SliceFn: displayTruncateFn},
@@ -405,26 +420,39 @@ func dump(v *decode.Value, w io.Writer, opts Options) error {
 	}
 
 	return v.WalkPreOrder(makeWalkFn(func(v *decode.Value, rootV *decode.Value, depth int, rootDepth int) error {
-		return dumpEx(v, ctx, depth, rootV, rootDepth, maxAddrIndentWidth-rootDepth)
+		err := dumpEx(v, ctx, depth, rootV, rootDepth, maxAddrIndentWidth-rootDepth)
+		if fieldFn != nil && fieldBuf.Len() > 0 {
+			if fErr := fieldFn(v, fieldBuf.Bytes()); fErr != nil {
+				return fErr
+			}
+			fieldBuf.Reset()
+		}
+		return err
 	}))
 }
 
-func hexdump(w io.Writer, bv Binary, opts Options) error {
+// hexdumpValue makes a value that dumps as a hexdump of bv
+func hexdumpValue(bv Binary) (*decode.Value, error) {
 	br, err := bitioex.Range(bv.br, bv.r.Start, bv.r.Len)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decode.Value{
+		// TODO: hack
+		V:          &scalar.BitBuf{Actual: br},
+		Range:      bv.r,
+		RootReader: bv.br,
+	}, nil
+}
+
+func hexdump(w io.Writer, bv Binary, opts Options) error {
+	v, err := hexdumpValue(bv)
 	if err != nil {
 		return err
 	}
 
 	// TODO: hack
 	opts.Verbose = true
-	return dump(
-		&decode.Value{
-			// TODO: hack
-			V:          &scalar.BitBuf{Actual: br},
-			Range:      bv.r,
-			RootReader: bv.br,
-		},
-		w,
-		opts,
-	)
+	return dump(v, w, opts)
 }
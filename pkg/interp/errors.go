@@ -0,0 +1,45 @@
+package interp
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/wader/fq/pkg/decode"
+)
+
+// errors lists errors in a decode value tree, ex errors recorded when decoding
+// with the recover option
+
+func init() {
+	RegisterFunc0("errors", (*Interp).errors)
+}
+
+func (i *Interp) errors(c any) any {
+	dvc, ok := c.(DecodeValue)
+	if !ok {
+		return errors.New("expected a decode value")
+	}
+	dv := dvc.DecodeValue()
+
+	errVs := []any{}
+	_ = dv.WalkPreOrder(func(v *decode.Value, _ *decode.Value, _ int, _ int) error {
+		if v.Err == nil {
+			return nil
+		}
+		var format any
+		var formatErr decode.FormatError
+		if errors.As(v.Err, &formatErr) && formatErr.Format != nil {
+			format = formatErr.Format.Name
+		}
+		errVs = append(errVs, map[string]any{
+			"path":   valuePath(v),
+			"format": format,
+			"error":  v.Err.Error(),
+			"start":  big.NewInt(v.Range.Start),
+			"stop":   big.NewInt(v.Range.Stop()),
+		})
+		return nil
+	})
+
+	return errVs
+}
@@ -0,0 +1,138 @@
+package interp
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/gojq"
+)
+
+// to_tar and export writing of {filename, data} objects, ex: from export_objects
+
+func init() {
+	RegisterFunc0("_to_tar", (*Interp)._toTar)
+}
+
+type exportFile struct {
+	name string
+	data []byte
+}
+
+// exportFiles converts an array of {filename, data} objects, filenames has to be
+// relative and not go outside the export path, both as slash and OS path
+func exportFiles(c any) ([]exportFile, error) {
+	vs, ok := c.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an array but got: %s", gojq.TypeOf(c))
+	}
+	var efs []exportFile
+	for _, v := range vs {
+		o, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected object but got: %s", gojq.TypeOf(v))
+		}
+		name, ok := o["filename"].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected filename string but got: %s", gojq.TypeOf(o["filename"]))
+		}
+		clean := path.Clean(name)
+		osClean := filepath.Clean(filepath.FromSlash(name))
+		if name == "" ||
+			path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") ||
+			filepath.IsAbs(osClean) || filepath.VolumeName(osClean) != "" ||
+			osClean == ".." || strings.HasPrefix(osClean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%q: invalid filename", name)
+		}
+		data, err := toBytes(o["data"])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		efs = append(efs, exportFile{name: clean, data: data})
+	}
+	return efs, nil
+}
+
+// tar with fixed mode and modification time so that same input produce same output
+func exportTar(efs []exportFile) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, ef := range efs {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     ef.name,
+			Mode:     0644,
+			Size:     int64(len(ef.data)),
+			ModTime:  time.Unix(0, 0),
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(ef.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (i *Interp) _toTar(c any) any {
+	efs, err := exportFiles(c)
+	if err != nil {
+		return err
+	}
+	b, err := exportTar(efs)
+	if err != nil {
+		return err
+	}
+	bb, err := NewBinaryFromBitReader(bitio.NewBitReader(b, -1), 8, 0)
+	if err != nil {
+		return err
+	}
+	return bb
+}
+
+// exportWrite writes files into a directory or a tar file if path ends with .tar,
+// returns written paths. Existing files are not overwritten. Only used by
+// --export-objects, see Main, so it's not reachable from queries.
+func (i *Interp) exportWrite(exportPath string, c any) ([]string, error) {
+	if exportPath == "" {
+		return nil, errors.New("export path is empty")
+	}
+	fw, ok := i.OS.(FileWriter)
+	if !ok {
+		return nil, errors.New("writing files not supported")
+	}
+	efs, err := exportFiles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(exportPath, ".tar") {
+		b, err := exportTar(efs)
+		if err != nil {
+			return nil, err
+		}
+		if err := fw.WriteFile(exportPath, b); err != nil {
+			return nil, err
+		}
+		return []string{exportPath}, nil
+	}
+
+	var written []string
+	for _, ef := range efs {
+		p := path.Join(exportPath, ef.name)
+		if err := fw.WriteFile(p, ef.data); err != nil {
+			return written, err
+		}
+		written = append(written, p)
+	}
+	return written, nil
+}
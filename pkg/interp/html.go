@@ -0,0 +1,369 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// to_html and to_svg renders the same output as dump and hexdump as standalone
+// documents, ANSI colors are translated to styles and each field has a tooltip
+// with path, value and range
+
+func init() {
+	RegisterFunc1("_to_html", (*Interp)._toHTML)
+	RegisterFunc1("_to_svg", (*Interp)._toSVG)
+}
+
+var ansiColorCSS = map[int]string{
+	0: "#000000", 1: "#cd3131", 2: "#0dbc79", 3: "#e5e510",
+	4: "#2472c8", 5: "#bc3fbc", 6: "#11a8cd", 7: "#e5e5e5",
+	8: "#666666", 9: "#f14c4c", 10: "#23d18b", 11: "#f5f543",
+	12: "#3b8eea", 13: "#d670d6", 14: "#29b8db", 15: "#ffffff",
+}
+
+const (
+	htmlBackground = "#1e1e1e"
+	htmlForeground = "#d4d4d4"
+)
+
+type ansiStyle struct {
+	fg        int // -1 default otherwise index into ansiColorCSS
+	bg        int
+	bold      bool
+	italic    bool
+	underline bool
+	inverse   bool
+}
+
+var ansiDefaultStyle = ansiStyle{fg: -1, bg: -1}
+
+func (s *ansiStyle) apply(code int) {
+	switch {
+	case code == 0:
+		*s = ansiDefaultStyle
+	case code == 1:
+		s.bold = true
+	case code == 3:
+		s.italic = true
+	case code == 4:
+		s.underline = true
+	case code == 7:
+		s.inverse = true
+	case code == 22:
+		s.bold = false
+	case code == 23:
+		s.italic = false
+	case code == 24:
+		s.underline = false
+	case code == 27:
+		s.inverse = false
+	case code >= 30 && code <= 37:
+		s.fg = code - 30
+	case code == 39:
+		s.fg = -1
+	case code >= 40 && code <= 47:
+		s.bg = code - 40
+	case code == 49:
+		s.bg = -1
+	case code >= 90 && code <= 97:
+		s.fg = code - 90 + 8
+	case code >= 100 && code <= 107:
+		s.bg = code - 100 + 8
+	}
+}
+
+// colors with inverse applied, empty string is default
+func (s ansiStyle) colors() (fg string, bg string) {
+	fg, bg = ansiColorCSS[s.fg], ansiColorCSS[s.bg]
+	if s.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = htmlBackground
+		}
+		if bg == "" {
+			bg = htmlForeground
+		}
+	}
+	return fg, bg
+}
+
+type ansiSegment struct {
+	text  string
+	style ansiStyle
+}
+
+// ansiLines splits text with ANSI SGR escapes into lines of styled segments
+func ansiLines(b []byte) [][]ansiSegment {
+	var lines [][]ansiSegment
+	var line []ansiSegment
+	style := ansiDefaultStyle
+	sb := &strings.Builder{}
+
+	flush := func() {
+		if sb.Len() > 0 {
+			line = append(line, ansiSegment{text: sb.String(), style: style})
+			sb.Reset()
+		}
+	}
+
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == 0x1b && i+1 < len(b) && b[i+1] == '[':
+			end := bytes.IndexByte(b[i:], 'm')
+			if end == -1 {
+				i = len(b)
+				break
+			}
+			flush()
+			params := string(b[i+2 : i+end])
+			if params == "" {
+				style.apply(0)
+			}
+			for _, p := range strings.Split(params, ";") {
+				if n, err := strconv.Atoi(p); err == nil {
+					style.apply(n)
+				}
+			}
+			i += end
+		case b[i] == '\n':
+			flush()
+			lines = append(lines, line)
+			line = nil
+		default:
+			sb.WriteByte(b[i])
+		}
+	}
+	flush()
+	if line != nil {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// htmlFieldTitle is the tooltip for a field
+func htmlFieldTitle(v *decode.Value, opts Options) string {
+	var value string
+	switch vv := v.V.(type) {
+	case *decode.Compound:
+		if vv.IsArray {
+			value = fmt.Sprintf("[0:%d]", len(vv.Children))
+		} else {
+			value = "{}"
+		}
+		if vv.Description != "" {
+			value += " " + vv.Description
+		}
+	case Scalarable:
+		actual := vv.ScalarActual()
+		value = previewValue(actual, vv.ScalarDisplayFormat())
+		if sym := vv.ScalarSym(); sym != nil {
+			value = previewValue(sym, scalar.NumberDecimal) + " (" + value + ")"
+		}
+		if d := vv.ScalarDescription(); d != "" {
+			value += " (" + d + ")"
+		}
+	}
+	if v.Format != nil {
+		value += " (" + v.Format.Name + ")"
+	}
+	if v.Err != nil {
+		value += "\nerror: " + v.Err.Error()
+	}
+
+	ir := v.InnerRange()
+	return fmt.Sprintf("%s\n%s\n%s (%s)",
+		valuePathExprDecorated(v, PlainDecorator),
+		value,
+		mathex.BitRange(ir).StringByteBits(opts.Addrbase),
+		mathex.Bits(ir.Len).StringByteBits(opts.Sizebase),
+	)
+}
+
+type dumpDocField struct {
+	title string
+	lines [][]ansiSegment
+}
+
+// dumpDoc dumps with colors and returns output lines grouped by field
+func dumpDoc(c any, opts Options) ([]dumpDocField, error) {
+	var v *decode.Value
+	switch cv := c.(type) {
+	case DecodeValue:
+		v = cv.DecodeValue()
+	default:
+		bv, err := toBinary(c)
+		if err != nil {
+			return nil, errors.New("expected a decode value or binary")
+		}
+		if v, err = hexdumpValue(bv); err != nil {
+			return nil, err
+		}
+		// TODO: hack, same as hexdump
+		opts.Verbose = true
+	}
+
+	opts.Color = true
+	opts.Decorator = decoratorFromOptions(opts)
+
+	var fields []dumpDocField
+	if err := dumpFields(v, nil, opts, func(v *decode.Value, out []byte) error {
+		fields = append(fields, dumpDocField{
+			title: htmlFieldTitle(v, opts),
+			lines: ansiLines(out),
+		})
+		return nil
+	}); err != nil && !errors.Is(err, decode.ErrWalkBreak) {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func (i *Interp) _toHTML(c any, v any) any {
+	fields, err := dumpDoc(c, OptionsFromValue(v))
+	if err != nil {
+		return err
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>fq</title>
+<style>
+body { margin: 0; background: ` + htmlBackground + `; color: ` + htmlForeground + `; }
+.dump { font: 13px monospace; white-space: pre; padding: 8px; }
+.f:hover { background: #2a2d2e; }
+</style>
+</head>
+<body>
+<div class="dump">`)
+	for _, f := range fields {
+		fmt.Fprintf(sb, `<div class="f" title="%s">`, html.EscapeString(f.title))
+		for _, l := range f.lines {
+			for _, s := range l {
+				text := html.EscapeString(s.text)
+				fg, bg := s.style.colors()
+				var css []string
+				if fg != "" {
+					css = append(css, "color:"+fg)
+				}
+				if bg != "" {
+					css = append(css, "background:"+bg)
+				}
+				if s.style.bold {
+					css = append(css, "font-weight:bold")
+				}
+				if s.style.italic {
+					css = append(css, "font-style:italic")
+				}
+				if s.style.underline {
+					css = append(css, "text-decoration:underline")
+				}
+				if len(css) == 0 {
+					sb.WriteString(text)
+				} else {
+					fmt.Fprintf(sb, `<span style="%s">%s</span>`, strings.Join(css, ";"), text)
+				}
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("</div>")
+	}
+	sb.WriteString("</div>\n</body>\n</html>\n")
+
+	return sb.String()
+}
+
+const (
+	svgFontSize   = 13
+	svgCharWidth  = 7.8 // monospace glyphs are about 0.6em wide
+	svgLineHeight = 16
+	svgPadding    = 8
+)
+
+func (i *Interp) _toSVG(c any, v any) any {
+	fields, err := dumpDoc(c, OptionsFromValue(v))
+	if err != nil {
+		return err
+	}
+
+	body := &strings.Builder{}
+	lineNr := 0
+	maxCols := 0
+	for _, f := range fields {
+		fmt.Fprintf(body, "<g><title>%s</title>\n", html.EscapeString(f.title))
+		fieldY := svgPadding + lineNr*svgLineHeight
+		// rect makes the whole field area hoverable
+		fmt.Fprintf(body, `<rect class="f" x="0" y="%d" width="100%%" height="%d"/>`+"\n", fieldY, len(f.lines)*svgLineHeight)
+		for _, l := range f.lines {
+			y := svgPadding + lineNr*svgLineHeight
+			col := 0
+			for _, s := range l {
+				_, bg := s.style.colors()
+				n := utf8.RuneCountInString(s.text)
+				if bg != "" {
+					fmt.Fprintf(body, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s"/>`+"\n",
+						svgPadding+float64(col)*svgCharWidth, y, float64(n)*svgCharWidth, svgLineHeight, bg)
+				}
+				col += n
+			}
+			maxCols = mathex.Max(maxCols, col)
+
+			fmt.Fprintf(body, `<text x="%d" y="%d">`, svgPadding, y+svgFontSize)
+			for _, s := range l {
+				fg, _ := s.style.colors()
+				var attrs []string
+				if fg != "" {
+					attrs = append(attrs, fmt.Sprintf(`fill="%s"`, fg))
+				}
+				if s.style.bold {
+					attrs = append(attrs, `font-weight="bold"`)
+				}
+				if s.style.italic {
+					attrs = append(attrs, `font-style="italic"`)
+				}
+				if s.style.underline {
+					attrs = append(attrs, `text-decoration="underline"`)
+				}
+				text := html.EscapeString(s.text)
+				if len(attrs) == 0 {
+					body.WriteString(text)
+				} else {
+					fmt.Fprintf(body, "<tspan %s>%s</tspan>", strings.Join(attrs, " "), text)
+				}
+			}
+			body.WriteString("</text>\n")
+			lineNr++
+		}
+		body.WriteString("</g>\n")
+	}
+
+	width := int(float64(maxCols)*svgCharWidth) + svgPadding*2
+	height := lineNr*svgLineHeight + svgPadding*2
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, `<svg xmlns="http://www.w3.org/2000/svg" xml:space="preserve" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	fmt.Fprintf(sb, `<style>
+text { font: %dpx monospace; fill: %s; white-space: pre; }
+.f { fill: transparent; }
+g:hover .f { fill: #2a2d2e; }
+</style>
+`, svgFontSize, htmlForeground)
+	fmt.Fprintf(sb, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", htmlBackground)
+	sb.WriteString(body.String())
+	sb.WriteString("</svg>\n")
+
+	return sb.String()
+}
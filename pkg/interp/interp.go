@@ -140,6 +140,13 @@ type Input interface {
 	Terminal
 }
 
+// Input can optionally implement this to support full screen terminal
+// interfaces like browse
+type RawModer interface {
+	// RawMode puts terminal in raw mode, restore function restores previous mode
+	RawMode() (restore func() error, err error)
+}
+
 type Output interface {
 	io.Writer
 	Terminal
@@ -172,6 +179,21 @@ type OS interface {
 	History() ([]string, error)
 }
 
+// OS can optionally implement this to support decode index cache
+type IndexCacher interface {
+	// IndexCache returns cache used to store decode indexes, nil disables caching
+	IndexCache() IndexCache
+	// AbsPath returns absolute and cleaned path for a name that can be opened using FS()
+	AbsPath(name string) (string, error)
+}
+
+// OS can optionally implement this to support writing files, ex: --export-objects
+type FileWriter interface {
+	// WriteFile writes a new file, parent directories are created if needed,
+	// fails if the file already exists
+	WriteFile(name string, data []byte) error
+}
+
 type FixedFileInfo struct {
 	FName    string
 	FSize    int64
@@ -425,6 +447,22 @@ func (i *Interp) Main(ctx context.Context, output Output, versionStr string) err
 			return v
 		case [2]any:
 			fmt.Fprintln(i.OS.Stderr(), v[:]...)
+		case map[string]any:
+			// files to write from --export-objects, see init.jq
+			eo, ok := v["export_objects"].(map[string]any)
+			if !ok {
+				fmt.Fprintln(i.OS.Stderr(), v)
+				break
+			}
+			exportPath, _ := eo["path"].(string)
+			written, err := i.exportWrite(exportPath, eo["files"])
+			for _, p := range written {
+				fmt.Fprintln(output, p)
+			}
+			if err != nil {
+				fmt.Fprintln(i.OS.Stderr(), err)
+				return err
+			}
 		default:
 			// TODO: can this happen?
 			fmt.Fprintln(i.OS.Stderr(), v)
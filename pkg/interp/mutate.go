@@ -0,0 +1,62 @@
+package interp
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/mutate"
+)
+
+// structural mutation of decoded input, see mutate package
+
+func init() {
+	RegisterFunc1("_mutate", (*Interp)._mutate)
+}
+
+type mutateOpts struct {
+	Seed  int64
+	Count int
+	Kinds []string
+}
+
+func (i *Interp) _mutate(c any, opts mutateOpts) any {
+	dvc, ok := c.(DecodeValue)
+	if !ok {
+		return errors.New("expected a decode value")
+	}
+	dv := dvc.DecodeValue()
+
+	var kinds []mutate.Kind
+	for _, k := range opts.Kinds {
+		kinds = append(kinds, mutate.Kind(k))
+	}
+	buf, ms, err := mutate.Mutate(dv, mutate.Options{
+		Seed:  opts.Seed,
+		Count: opts.Count,
+		Kinds: kinds,
+	})
+	if err != nil {
+		return err
+	}
+
+	bb, err := NewBinaryFromBitReader(bitio.NewBitReader(buf, -1), 8, 0)
+	if err != nil {
+		return err
+	}
+	var mvs []any
+	for _, m := range ms {
+		mvs = append(mvs, map[string]any{
+			"kind":        string(m.Kind),
+			"path":        valuePath(m.Value),
+			"start":       big.NewInt(m.Range.Start),
+			"stop":        big.NewInt(m.Range.Stop()),
+			"description": m.Description,
+		})
+	}
+
+	return map[string]any{
+		"binary":    bb,
+		"mutations": mvs,
+	}
+}
@@ -0,0 +1,450 @@
+package interp
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+	"github.com/wader/gojq"
+)
+
+// format_schema generates JSON schema for tovalue output of a format or group
+// using format metadata and the static schema registered by the format, if any.
+// Formats without a static schema get a schema derived from decoded values, if any
+
+func init() {
+	RegisterFunc1("_format_schema", (*Interp)._formatSchema)
+}
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+func (i *Interp) _formatSchema(c string, values []any) any {
+	group, err := i.Registry.Group(c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+
+	sg := &schemaGen{
+		defs:       map[string]any{},
+		derived:    map[string]*schemaNode{},
+		generating: map[*decode.Schema]bool{},
+		recursive:  map[*decode.Schema]string{},
+	}
+	for _, v := range values {
+		dv, ok := v.(DecodeValue)
+		if !ok {
+			return fmt.Errorf("%s: expected a decode value but got: %s", c, gojq.TypeOf(v))
+		}
+		root := dv.DecodeValue()
+		if root.Format == nil {
+			return fmt.Errorf("%s: expected a format root value", c)
+		}
+		sg.deriveFormat(root)
+	}
+
+	s := sg.group(group)
+	s["$schema"] = jsonSchemaDialect
+	s["title"] = c
+	if len(group.Formats) == 1 {
+		s["description"] = group.Formats[0].Description
+	}
+	s["$defs"] = sg.defs
+
+	return s
+}
+
+type schemaGen struct {
+	defs    map[string]any
+	derived map[string]*schemaNode
+	// format currently generated, used to name recursive static schemas
+	formatName string
+	// static schemas being generated and $defs name if found to be recursive
+	generating map[*decode.Schema]bool
+	recursive  map[*decode.Schema]string
+}
+
+// schemaNode describes values seen at the same place in decoded trees. Value
+// kinds are JSON schema types or "bits", counts are used to know which struct
+// fields are always present
+type schemaNode struct {
+	kinds   map[string]bool
+	formats map[string]*decode.Format
+	structs int
+	fields  map[string]*schemaNode
+	counts  map[string]int
+	items   *schemaNode
+}
+
+func newSchemaNode() *schemaNode {
+	return &schemaNode{
+		kinds:   map[string]bool{},
+		formats: map[string]*decode.Format{},
+		fields:  map[string]*schemaNode{},
+		counts:  map[string]int{},
+	}
+}
+
+func (sg *schemaGen) deriveFormat(dv *decode.Value) {
+	n, ok := sg.derived[dv.Format.Name]
+	if !ok {
+		n = newSchemaNode()
+		sg.derived[dv.Format.Name] = n
+	}
+	sg.derive(n, dv, true)
+}
+
+func (sg *schemaGen) derive(n *schemaNode, dv *decode.Value, root bool) {
+	// lazy values are decoded on first access
+	dv.Resolve()
+
+	if !root && dv.Format != nil {
+		n.formats[dv.Format.Name] = dv.Format
+		sg.deriveFormat(dv)
+		return
+	}
+
+	switch vv := dv.V.(type) {
+	case *decode.Compound:
+		if vv.IsArray {
+			n.kinds["array"] = true
+			if n.items == nil {
+				n.items = newSchemaNode()
+			}
+			for _, c := range vv.Children {
+				sg.derive(n.items, c, false)
+			}
+			return
+		}
+		n.kinds["object"] = true
+		n.structs++
+		seen := map[string]bool{}
+		for _, c := range vv.Children {
+			f, ok := n.fields[c.Name]
+			if !ok {
+				f = newSchemaNode()
+				n.fields[c.Name] = f
+			}
+			// same name can be used more than once in a struct
+			if !seen[c.Name] {
+				seen[c.Name] = true
+				n.counts[c.Name]++
+			}
+			sg.derive(f, c, false)
+		}
+	case scalar.BitBuf, *scalar.BitBuf:
+		n.kinds["bits"] = true
+	case Scalarable:
+		n.kinds[schemaKind(reflect.ValueOf(vv.ScalarValue()))] = true
+	}
+}
+
+// schemaKind is the JSON schema type for a scalar value
+func schemaKind(v reflect.Value) string {
+	if !v.IsValid() {
+		return "null"
+	}
+	if _, ok := v.Interface().(*big.Int); ok {
+		return "integer"
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map:
+		return "object"
+	default:
+		return ""
+	}
+}
+
+func (sg *schemaGen) node(n *schemaNode) map[string]any {
+	var alts []any
+
+	var names []string
+	for name := range n.formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		alts = append(alts, sg.format(n.formats[name]))
+	}
+
+	var kinds []string
+	for k := range n.kinds {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		switch k {
+		case "":
+			// unknown scalar value, can be anything
+			return map[string]any{}
+		case "bits":
+			alts = append(alts, map[string]any{"description": "raw bits, representation depends on bits_format option"})
+		case "object":
+			props := map[string]any{}
+			required := []any{}
+			var fieldNames []string
+			for name := range n.fields {
+				fieldNames = append(fieldNames, name)
+			}
+			sort.Strings(fieldNames)
+			for _, name := range fieldNames {
+				props[name] = sg.node(n.fields[name])
+				if n.counts[name] == n.structs {
+					required = append(required, name)
+				}
+			}
+			js := map[string]any{"type": "object", "properties": props}
+			if len(required) > 0 {
+				js["required"] = required
+			}
+			alts = append(alts, js)
+		case "array":
+			js := map[string]any{"type": "array"}
+			if n.items != nil {
+				js["items"] = sg.node(n.items)
+			}
+			alts = append(alts, js)
+		default:
+			alts = append(alts, map[string]any{"type": k})
+		}
+	}
+
+	switch len(alts) {
+	case 0:
+		return map[string]any{}
+	case 1:
+		return alts[0].(map[string]any)
+	default:
+		return map[string]any{"anyOf": alts}
+	}
+}
+
+func (sg *schemaGen) group(group *decode.Group) map[string]any {
+	var refs []any
+	for _, f := range group.Formats {
+		refs = append(refs, sg.format(f))
+	}
+	switch len(refs) {
+	case 0:
+		return map[string]any{}
+	case 1:
+		return refs[0].(map[string]any)
+	default:
+		return map[string]any{"anyOf": refs}
+	}
+}
+
+func (sg *schemaGen) format(f *decode.Format) map[string]any {
+	ref := map[string]any{"$ref": "#/$defs/" + f.Name}
+	if _, ok := sg.defs[f.Name]; ok {
+		return ref
+	}
+	// add before generating in case format is recursive
+	def := map[string]any{}
+	sg.defs[f.Name] = def
+
+	if f.Schema != nil {
+		prevFormatName := sg.formatName
+		sg.formatName = f.Name
+		for k, v := range sg.schema(f.Schema) {
+			def[k] = v
+		}
+		sg.formatName = prevFormatName
+	} else if n, ok := sg.derived[f.Name]; ok {
+		for k, v := range sg.node(n) {
+			def[k] = v
+		}
+		def["$comment"] = "derived from decoded input"
+	} else {
+		def["$comment"] = "no static schema registered for format and no decoded input to derive from"
+		if f.RootArray {
+			def["type"] = "array"
+		}
+	}
+	def["title"] = f.Name
+	def["description"] = f.Description
+
+	return ref
+}
+
+func (sg *schemaGen) schema(s *decode.Schema) map[string]any {
+	// recursive schema, ex: mp4 box with boxes, is a $defs entry named
+	// <format>_<name> that refers to itself
+	if name, ok := sg.recursive[s]; ok {
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+	if sg.generating[s] {
+		name := sg.formatName + "_" + s.Name
+		sg.recursive[s] = name
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+	sg.generating[s] = true
+	defer delete(sg.generating, s)
+
+	var js map[string]any
+
+	switch s.Type {
+	case decode.SchemaTypeStruct:
+		props := map[string]any{}
+		required := []any{}
+		for _, f := range s.Fields {
+			props[f.Name] = sg.schema(f)
+			if !f.Optional {
+				required = append(required, f.Name)
+			}
+		}
+		js = map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			js["required"] = required
+		}
+		if s.Additional {
+			js["additionalProperties"] = true
+		}
+	case decode.SchemaTypeArray:
+		js = map[string]any{"type": "array"}
+		if s.Elem != nil {
+			js["items"] = sg.schema(s.Elem)
+		}
+	case decode.SchemaTypeAnyOf:
+		var alts []any
+		for _, a := range s.AnyOf {
+			alts = append(alts, sg.schema(a))
+		}
+		js = map[string]any{"anyOf": alts}
+	case decode.SchemaTypeFormat:
+		js = sg.group(s.Group)
+	case decode.SchemaTypeUint:
+		js = map[string]any{"type": "integer", "minimum": 0}
+	case decode.SchemaTypeSint, decode.SchemaTypeBigInt:
+		js = map[string]any{"type": "integer"}
+	case decode.SchemaTypeFlt:
+		js = map[string]any{"type": "number"}
+	case decode.SchemaTypeStr:
+		js = map[string]any{"type": "string"}
+	case decode.SchemaTypeBool:
+		js = map[string]any{"type": "boolean"}
+	case decode.SchemaTypeBitBuf:
+		// depends on bits_format option, string by default
+		js = map[string]any{"description": "raw bits, representation depends on bits_format option"}
+	default:
+		js = map[string]any{}
+	}
+
+	if len(s.Syms) > 0 {
+		// values not in a sym map keep actual value
+		syms, ok := schemaSyms(s.Syms)
+		if ok && len(syms) > 0 {
+			js = map[string]any{"anyOf": []any{map[string]any{"enum": syms}, js}}
+		} else if !ok {
+			// unknown mapper, sym can be anything
+			js = map[string]any{}
+		}
+	}
+	if s.Description != "" {
+		js["description"] = s.Description
+	}
+
+	if name, ok := sg.recursive[s]; ok {
+		sg.defs[name] = js
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+
+	return js
+}
+
+// schemaSyms collects sym values from sym maps like scalar.UintMapSymStr and
+// scalar.UintMap, returns false if some mapper is not a known map
+func schemaSyms(symMaps []any) ([]any, bool) {
+	seen := map[string]bool{}
+	var syms []any
+	add := func(v reflect.Value) {
+		if !v.IsValid() {
+			return
+		}
+		// scalar.Uint etc with Sym field
+		if v.Kind() == reflect.Struct {
+			if v = v.FieldByName("Sym"); !v.IsValid() {
+				return
+			}
+		}
+		if v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		sym := schemaNormalize(v)
+		if sym == nil {
+			return
+		}
+		k := fmt.Sprintf("%T:%v", sym, sym)
+		if seen[k] {
+			return
+		}
+		seen[k] = true
+		syms = append(syms, sym)
+	}
+
+	for _, m := range symMaps {
+		mv := reflect.ValueOf(m)
+		switch {
+		case mv.Kind() == reflect.Map && strings.HasSuffix(mv.Type().Name(), "Description"):
+			// only descriptions
+		case mv.Kind() == reflect.Map:
+			iter := mv.MapRange()
+			for iter.Next() {
+				add(iter.Value())
+			}
+		case mv.Kind() == reflect.Slice && mv.Type().Elem().Kind() == reflect.Struct:
+			// ex scalar.UintRangeToScalar
+			for i := 0; i < mv.Len(); i++ {
+				if sv := mv.Index(i).FieldByName("S"); sv.IsValid() {
+					add(sv)
+				}
+			}
+		default:
+			return nil, false
+		}
+	}
+
+	sort.Slice(syms, func(i, j int) bool {
+		return fmt.Sprint(syms[i]) < fmt.Sprint(syms[j])
+	})
+
+	return syms, true
+}
+
+func schemaNormalize(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := v.Uint()
+		if n > uint64(^uint(0)>>1) {
+			return new(big.Int).SetUint64(n)
+		}
+		return int(n)
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,441 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+
+	"github.com/wader/gojq"
+)
+
+// local http server with a self-contained html page to browse decoded inputs
+// and evaluate queries
+
+//go:embed serve.html
+var serveHTML []byte
+
+func init() {
+	RegisterIter1("_serve", (*Interp)._serve)
+}
+
+const (
+	serveMaxChildren     = 1000
+	serveMaxQueryOutputs = 1000
+	serveQueryTimeout    = 30 * time.Second
+	serveHexContextBytes = 256
+	serveMaxHexBytes     = 4096
+	serveTokenHeader     = "X-Fq-Token"
+)
+
+type serveOpts struct {
+	Addr   string
+	Public bool
+}
+
+type serveInput struct {
+	name string
+	v    *decode.Value
+}
+
+type server struct {
+	i      *Interp
+	inputs []serveInput
+	// random token required by api requests, only known from the printed URL
+	token  string
+	public bool
+	// interp is not safe for concurrent use
+	mu sync.Mutex
+}
+
+// raw bits are truncated
+func serveOptsFn() *Options {
+	return &Options{BitsFormatFn: bitsFormatFnFromOptions(Options{BitsFormat: "truncate"})}
+}
+
+func serveNode(v *decode.Value) map[string]any {
+	v.Resolve()
+	path := valuePath(v)
+	if path == nil {
+		path = []any{}
+	}
+	n := map[string]any{
+		"name":  v.Name,
+		"path":  path,
+		"start": int(v.Range.Start),
+		"stop":  int(v.Range.Stop()),
+	}
+	if v.Format != nil {
+		n["format"] = v.Format.Name
+	}
+	if v.Err != nil {
+		n["error"] = v.Err.Error()
+	}
+	switch vv := v.V.(type) {
+	case *decode.Compound:
+		if vv.IsArray {
+			n["type"] = "array"
+		} else {
+			n["type"] = "struct"
+		}
+		n["children"] = len(vv.Children)
+		if vv.Description != "" {
+			n["description"] = vv.Description
+		}
+	case Scalarable:
+		n["type"] = "scalar"
+		n["value"] = toValue(serveOptsFn, makeDecodeValue(v, decodeValueValue))
+		if sym := vv.ScalarSym(); sym != nil {
+			n["actual"] = toValue(nil, vv.ScalarActual())
+		}
+		if vv.ScalarIsGap() {
+			n["gap"] = true
+		}
+		if d := vv.ScalarDescription(); d != "" {
+			n["description"] = d
+		}
+	}
+	return n
+}
+
+// servePathValue resolves a path like ["frames", 0, "header"] from v
+func servePathValue(v *decode.Value, path []any) (*decode.Value, error) {
+	for _, p := range path {
+		v.Resolve()
+		c, ok := v.V.(*decode.Compound)
+		if !ok {
+			return nil, fmt.Errorf("%v: not a struct or array", p)
+		}
+		switch p := p.(type) {
+		case string:
+			cv, ok := c.ByName[p]
+			if !ok || c.IsArray {
+				return nil, fmt.Errorf("%s: not found", p)
+			}
+			v = cv
+		case float64:
+			idx := int(p)
+			if !c.IsArray || idx < 0 || idx >= len(c.Children) {
+				return nil, fmt.Errorf("%d: not found", idx)
+			}
+			v = c.Children[idx]
+		default:
+			return nil, fmt.Errorf("%v: invalid path part", p)
+		}
+	}
+	v.Resolve()
+	return v, nil
+}
+
+func serveJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func serveError(w http.ResponseWriter, status int, err error) {
+	serveJSON(w, status, map[string]any{"error": err.Error()})
+}
+
+// input and path from query parameters, path is a JSON array
+func (s *server) requestValue(r *http.Request) (*decode.Value, error) {
+	idx, err := strconv.Atoi(r.URL.Query().Get("input"))
+	if err != nil || idx < 0 || idx >= len(s.inputs) {
+		return nil, errors.New("invalid input")
+	}
+	var path []any
+	if p := r.URL.Query().Get("path"); p != "" {
+		if err := json.Unmarshal([]byte(p), &path); err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+	}
+	return servePathValue(s.inputs[idx].v, path)
+}
+
+func (s *server) handleInputs(w http.ResponseWriter, r *http.Request) {
+	var vs []any
+	for _, in := range s.inputs {
+		n := serveNode(in.v)
+		n["filename"] = in.name
+		vs = append(vs, n)
+	}
+	serveJSON(w, http.StatusOK, vs)
+}
+
+func (s *server) handleTree(w http.ResponseWriter, r *http.Request) {
+	v, err := s.requestValue(r)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	n := serveNode(v)
+	var children []any
+	if c, ok := v.V.(*decode.Compound); ok {
+		offset = mathex.Clamp(0, len(c.Children), offset)
+		stop := mathex.Min(len(c.Children), offset+serveMaxChildren)
+		for _, cv := range c.Children[offset:stop] {
+			children = append(children, serveNode(cv))
+		}
+		n["offset"] = offset
+	}
+	n["child_nodes"] = children
+	serveJSON(w, http.StatusOK, n)
+}
+
+// handleHex returns bytes around a value in its buffer, start and stop are in bits
+func (s *server) handleHex(w http.ResponseWriter, r *http.Request) {
+	v, err := s.requestValue(r)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+	rootBR := v.BufferRoot().RootReader
+	rootLen, err := bitioex.Len(rootBR)
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ir := v.InnerRange()
+	rootBytes := bitio.BitsByteCount(rootLen)
+	startByte := mathex.Max(0, ir.Start/8-serveHexContextBytes) &^ 0xf
+	stopByte := mathex.Min(rootBytes, mathex.Min(bitio.BitsByteCount(ir.Stop())+serveHexContextBytes, startByte+serveMaxHexBytes))
+	br, err := bitioex.Range(rootBR, startByte*8, mathex.Min((stopByte-startByte)*8, rootLen-startByte*8))
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if _, err := bitioex.CopyBits(buf, br); err != nil {
+		serveError(w, http.StatusInternalServerError, err)
+		return
+	}
+	serveJSON(w, http.StatusOK, map[string]any{
+		"offset":     int(startByte),
+		"bytes":      hex.EncodeToString(buf.Bytes()),
+		"size":       int(rootBytes),
+		"start":      int(ir.Start),
+		"stop":       int(ir.Stop()),
+		"buffer_len": int(rootLen),
+	})
+}
+
+// handleQuery evaluates a query with an input as input, decode values outputs are
+// returned as nodes, other values as JSON
+func (s *server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		serveError(w, http.StatusMethodNotAllowed, errors.New("POST required"))
+		return
+	}
+	// other pages can POST text/plain without preflight
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		serveError(w, http.StatusUnsupportedMediaType, errors.New("application/json content type required"))
+		return
+	}
+	var req struct {
+		Input int    `json:"input"`
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Input < 0 || req.Input >= len(s.inputs) {
+		serveError(w, http.StatusBadRequest, errors.New("invalid input"))
+		return
+	}
+	in := s.inputs[req.Input]
+
+	ctx, cancelFn := context.WithTimeout(r.Context(), serveQueryTimeout)
+	defer cancelFn()
+	iter, err := s.i.Eval(ctx, makeDecodeValue(in.v, decodeValueValue), req.Query, EvalOpts{filename: "query"})
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	outputs := []any{}
+	truncated := false
+outputLoop:
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if len(outputs) >= serveMaxQueryOutputs {
+			truncated = true
+			// cancel and get error so that eval context is popped
+			cancelFn()
+			_, _ = iter.Next()
+			break outputLoop
+		}
+		switch v := v.(type) {
+		case error:
+			// error ends the query like in jq
+			outputs = append(outputs, map[string]any{"error": v.Error()})
+			cancelFn()
+			_, _ = iter.Next()
+			break outputLoop
+		case DecodeValue:
+			dv := v.DecodeValue()
+			n := serveNode(dv)
+			n["in_input"] = dv.Root() == in.v.Root()
+			outputs = append(outputs, map[string]any{"node": n})
+		default:
+			outputs = append(outputs, map[string]any{
+				"value": toValue(serveOptsFn, v),
+			})
+		}
+	}
+
+	serveJSON(w, http.StatusOK, map[string]any{
+		"outputs":   outputs,
+		"truncated": truncated,
+	})
+}
+
+// checkRequest protects against other web pages using the server. For a loopback
+// server the host has to be loopback to prevent DNS rebinding, a cross-origin
+// request is refused and api requests need the token
+func (s *server) checkRequest(r *http.Request, api bool) error {
+	if !s.public {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("%s: invalid host", r.Host)
+		}
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return fmt.Errorf("%s: cross-origin request", origin)
+		}
+	}
+	if api && subtle.ConstantTimeCompare([]byte(r.Header.Get(serveTokenHeader)), []byte(s.token)) != 1 {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+func (s *server) handler() http.Handler {
+	check := func(api bool, fn http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if err := s.checkRequest(r, api); err != nil {
+				serveError(w, http.StatusForbidden, err)
+				return
+			}
+			fn(w, r)
+		}
+	}
+	lock := func(fn http.HandlerFunc) http.HandlerFunc {
+		return check(true, func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			fn(w, r)
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", check(false, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(serveHTML)
+	}))
+	mux.HandleFunc("/api/inputs", lock(s.handleInputs))
+	mux.HandleFunc("/api/tree", lock(s.handleTree))
+	mux.HandleFunc("/api/hex", lock(s.handleHex))
+	mux.HandleFunc("/api/query", lock(s.handleQuery))
+
+	return mux
+}
+
+// serveAddr uses loopback if addr has no host and refuses non-loopback addresses
+// unless public is set as anyone that can connect can run queries, ex: open files
+func serveAddr(addr string, public bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" && !public {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if public || host == "localhost" {
+		return addr, nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return addr, nil
+	}
+	return "", fmt.Errorf("%s: not a loopback address, use --serve-public to allow", addr)
+}
+
+// input is array of {name: "filename", value: decode value}
+func (i *Interp) _serve(c any, opts serveOpts) gojq.Iter {
+	vs, ok := c.([]any)
+	if !ok {
+		return gojq.NewIter(errors.New("expected an array of inputs"))
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return gojq.NewIter(err)
+	}
+	s := &server{i: i, token: hex.EncodeToString(token), public: opts.Public}
+	for _, v := range vs {
+		in, ok := v.(map[string]any)
+		if !ok {
+			return gojq.NewIter(fmt.Errorf("expected object but got: %s", gojq.TypeOf(v)))
+		}
+		dv, ok := in["value"].(DecodeValue)
+		if !ok {
+			return gojq.NewIter(fmt.Errorf("expected decode value but got: %s", gojq.TypeOf(in["value"])))
+		}
+		name, _ := in["name"].(string)
+		s.inputs = append(s.inputs, serveInput{name: name, v: dv.DecodeValue()})
+	}
+
+	addr, err := serveAddr(opts.Addr, opts.Public)
+	if err != nil {
+		return gojq.NewIter(err)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return gojq.NewIter(err)
+	}
+	// token in fragment so that it's not sent in requests or logged
+	fmt.Fprintf(i.OS.Stderr(), "serving %d inputs on http://%s/#token=%s\n", len(s.inputs), l.Addr(), s.token)
+
+	hs := &http.Server{Handler: s.handler()}
+	ctx := i.EvalInstance.Ctx
+	go func() {
+		<-ctx.Done()
+		_ = hs.Close()
+	}()
+
+	if err := hs.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return gojq.NewIter(err)
+	}
+
+	return gojq.NewIter()
+}
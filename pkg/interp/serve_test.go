@@ -0,0 +1,216 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+)
+
+type serveTestOutput struct{ io.Writer }
+
+func (serveTestOutput) Size() (int, int) { return 120, 25 }
+func (serveTestOutput) IsTerminal() bool { return false }
+
+type serveTestFS struct{}
+
+func (serveTestFS) Open(name string) (fs.File, error) { return nil, fs.ErrNotExist }
+
+type serveTestOS struct{}
+
+func (serveTestOS) Platform() Platform                         { return Platform{} }
+func (serveTestOS) Stdin() Input                               { return nil }
+func (serveTestOS) Stdout() Output                             { return serveTestOutput{io.Discard} }
+func (serveTestOS) Stderr() Output                             { return serveTestOutput{io.Discard} }
+func (serveTestOS) InterruptChan() chan struct{}               { return nil }
+func (serveTestOS) Args() []string                             { return nil }
+func (serveTestOS) Environ() []string                          { return nil }
+func (serveTestOS) ConfigDir() (string, error)                 { return "/config", nil }
+func (serveTestOS) FS() fs.FS                                  { return serveTestFS{} }
+func (serveTestOS) Readline(opts ReadlineOpts) (string, error) { return "", io.EOF }
+func (serveTestOS) History() ([]string, error)                 { return nil, nil }
+
+func serveTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	f := &decode.Format{
+		Name: "test",
+		DecodeFn: func(d *decode.D) any {
+			d.FieldU8("a")
+			d.FieldArray("b", func(d *decode.D) {
+				d.FieldU8("c")
+				d.FieldU8("c")
+			})
+			return nil
+		},
+	}
+	dv, _, err := decode.Decode(context.Background(), bitio.NewBitReader([]byte{1, 2, 3}, -1),
+		&decode.Group{Name: "test", Formats: []*decode.Format{f}},
+		decode.Options{IsRoot: true, FillGaps: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := New(serveTestOS{}, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{i: i, inputs: []serveInput{{name: "test", v: dv}}, token: "test"}
+	hs := httptest.NewServer(s.handler())
+	t.Cleanup(hs.Close)
+	return hs
+}
+
+func serveTestRequestHeaders(t *testing.T, method string, url string, body string, headers map[string]string, status int) map[string]any {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		if k == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: expected status %d got %d: %s", method, url, status, resp.StatusCode, b)
+	}
+	var v map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func serveTestRequest(t *testing.T, method string, url string, body string, status int) map[string]any {
+	t.Helper()
+	return serveTestRequestHeaders(t, method, url, body, map[string]string{
+		serveTokenHeader: "test",
+		"Content-Type":   "application/json",
+	}, status)
+}
+
+func TestServe(t *testing.T) {
+	hs := serveTestServer(t)
+
+	resp, err := http.Get(hs.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(page, serveHTML) {
+		t.Errorf("expected html page")
+	}
+
+	tree := serveTestRequest(t, http.MethodGet, hs.URL+`/api/tree?input=0&path=["b"]`, "", http.StatusOK)
+	if tree["type"] != "array" || len(tree["child_nodes"].([]any)) != 2 {
+		t.Errorf("unexpected tree %v", tree)
+	}
+	serveTestRequest(t, http.MethodGet, hs.URL+`/api/tree?input=1`, "", http.StatusBadRequest)
+
+	hex := serveTestRequest(t, http.MethodGet, hs.URL+`/api/hex?input=0&path=["b",1]`, "", http.StatusOK)
+	if hex["bytes"] != "010203" || hex["start"] != float64(16) || hex["stop"] != float64(24) {
+		t.Errorf("unexpected hex %v", hex)
+	}
+
+	query := serveTestRequest(t, http.MethodPost, hs.URL+"/api/query", `{"input": 0, "query": ".b[1], 1+2"}`, http.StatusOK)
+	outputs := query["outputs"].([]any)
+	if len(outputs) != 2 {
+		t.Fatalf("unexpected query outputs %v", outputs)
+	}
+	if n := outputs[0].(map[string]any)["node"].(map[string]any); n["value"] != float64(3) || n["in_input"] != true {
+		t.Errorf("unexpected query node %v", n)
+	}
+	if v := outputs[1].(map[string]any)["value"]; v != float64(3) {
+		t.Errorf("unexpected query value %v", v)
+	}
+	serveTestRequest(t, http.MethodGet, hs.URL+"/api/query", "", http.StatusMethodNotAllowed)
+}
+
+func TestServeCheck(t *testing.T) {
+	hs := serveTestServer(t)
+	query := `{"input": 0, "query": "1"}`
+
+	testCases := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/api/tree?input=0", status: http.StatusForbidden},
+		{name: "wrong token", method: http.MethodGet, path: "/api/tree?input=0", headers: map[string]string{serveTokenHeader: "nope"}, status: http.StatusForbidden},
+		{name: "token", method: http.MethodGet, path: "/api/tree?input=0", headers: map[string]string{serveTokenHeader: "test"}, status: http.StatusOK},
+		{name: "dns rebinding host", method: http.MethodGet, path: "/api/tree?input=0", headers: map[string]string{serveTokenHeader: "test", "Host": "evil.example.com"}, status: http.StatusForbidden},
+		{name: "localhost host", method: http.MethodGet, path: "/api/tree?input=0", headers: map[string]string{serveTokenHeader: "test", "Host": "localhost:1234"}, status: http.StatusOK},
+		{name: "foreign origin", method: http.MethodGet, path: "/api/tree?input=0", headers: map[string]string{serveTokenHeader: "test", "Origin": "http://evil.example.com"}, status: http.StatusForbidden},
+		{name: "same origin", method: http.MethodGet, path: "/api/tree?input=0", headers: map[string]string{serveTokenHeader: "test", "Origin": hs.URL}, status: http.StatusOK},
+		{name: "text/plain query", method: http.MethodPost, path: "/api/query", headers: map[string]string{serveTokenHeader: "test", "Content-Type": "text/plain"}, status: http.StatusUnsupportedMediaType},
+		{name: "json query", method: http.MethodPost, path: "/api/query", headers: map[string]string{serveTokenHeader: "test", "Content-Type": "application/json; charset=utf-8"}, status: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			serveTestRequestHeaders(t, tc.method, hs.URL+tc.path, query, tc.headers, tc.status)
+		})
+	}
+
+	// page has no token but still checks host
+	req, err := http.NewRequest(http.MethodGet, hs.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "evil.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected forbidden page got %d", resp.StatusCode)
+	}
+}
+
+func TestServeAddr(t *testing.T) {
+	testCases := []struct {
+		addr     string
+		public   bool
+		expected string
+		err      bool
+	}{
+		{addr: ":8080", expected: "127.0.0.1:8080"},
+		{addr: "localhost:8080", expected: "localhost:8080"},
+		{addr: "127.0.0.2:8080", expected: "127.0.0.2:8080"},
+		{addr: "[::1]:8080", expected: "[::1]:8080"},
+		{addr: "0.0.0.0:8080", err: true},
+		{addr: "example.com:8080", err: true},
+		{addr: "8080", err: true},
+		{addr: ":8080", public: true, expected: ":8080"},
+		{addr: "0.0.0.0:8080", public: true, expected: "0.0.0.0:8080"},
+	}
+	for _, tc := range testCases {
+		actual, err := serveAddr(tc.addr, tc.public)
+		if (err != nil) != tc.err || actual != tc.expected {
+			t.Errorf("%s public=%t: expected %q err=%t got %q %v", tc.addr, tc.public, tc.expected, tc.err, actual, err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package interp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/wader/fq/internal/ctxreadseeker"
+	"github.com/wader/fq/internal/ioex"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
+
+	"github.com/wader/gojq"
+)
+
+// streaming decode of sequential formats, input is read in chunks that are
+// decoded and output one by one so that non-seekable input don't have to be
+// read into memory first. Ranges of a decoded chunk are relative to the input
+// so that the bytes last read for the chunk are at their input offset.
+//
+// flv and PostgreSQL WAL are out of scope for now, the flv decoder is not built
+// (go:build ignore) and there is no WAL decoder.
+
+func init() {
+	RegisterIter1("_stream_decode", (*Interp)._streamDecode)
+}
+
+const (
+	streamReadBufferSize = 256 * 1024
+	// max size of input prefix to probe
+	streamProbeMaxSize = 256 * 1024
+)
+
+// returned by the probe reader at the end of a prefix that is not end of input
+var errStreamProbeShort = errors.New("stream probe prefix too short")
+
+type streamDecodeOpts struct {
+	DecodeGroup string
+	Force       bool
+}
+
+type streamIter struct {
+	i        *Interp
+	f        fs.File
+	filename string
+	format   *decode.Format
+	group    *decode.Group
+	chunkFn  decode.StreamChunkFn
+	cr       *streamCountReader
+	r        *bufio.Reader
+	force    bool
+	done     bool
+}
+
+// streamCountReader counts bytes read from input
+type streamCountReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *streamCountReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// streamChunkReaderAt is a chunk at input offset bitOff, bits before the chunk
+// are not readable
+type streamChunkReaderAt struct {
+	br     bitio.ReaderAt
+	bitOff int64
+}
+
+func (r streamChunkReaderAt) ReadBitsAt(p []byte, nBits int64, bitOff int64) (int64, error) {
+	if bitOff < r.bitOff {
+		return 0, io.EOF
+	}
+	return r.br.ReadBitsAt(p, nBits, bitOff-r.bitOff)
+}
+
+// offset of chunk b in input, end of b is the bytes last read from input
+func (si *streamIter) chunkOffset(b []byte) int64 {
+	return mathex.Max(si.cr.n-int64(si.r.Buffered())-int64(len(b)), 0)
+}
+
+func (si *streamIter) decodeChunk(b []byte, offset int64) (*decode.Value, error) {
+	bitOff := offset * 8
+	bitLen := int64(len(b)) * 8
+	br := bitio.NewSectionReader(streamChunkReaderAt{br: bitio.NewBitReader(b, -1), bitOff: bitOff}, 0, bitOff+bitLen)
+	dv, _, err := decode.Decode(si.i.EvalInstance.Ctx, br, si.group,
+		decode.Options{
+			IsRoot:      true,
+			FillGaps:    true,
+			Force:       si.force,
+			Range:       ranges.Range{Start: bitOff, Len: bitLen},
+			Description: si.filename,
+		},
+	)
+	return dv, err
+}
+
+func (si *streamIter) Next() (any, bool) {
+	if si.done {
+		return nil, false
+	}
+
+	b, err := si.chunkFn()
+	if err != nil {
+		si.done = true
+		si.f.Close()
+		if errors.Is(err, io.EOF) {
+			return nil, false
+		}
+		return fmt.Errorf("%s: %w", si.format.Name, err), true
+	}
+
+	dv, err := si.decodeChunk(b, si.chunkOffset(b))
+	if dv == nil {
+		si.done = true
+		si.f.Close()
+		return valueError{err}, true
+	}
+
+	return makeDecodeValue(dv, decodeValueValue), true
+}
+
+// streamFormats returns formats in group that support streaming
+func streamFormats(group *decode.Group) []*decode.Format {
+	var fs []*decode.Format
+	for _, f := range group.Formats {
+		if f.StreamFn != nil {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// streamProbe tries first chunk of each format on the input read so far and
+// returns the first one that decodes. More input is read while some format
+// needs it so that probing does not wait for more input than the first chunk
+// of a format needs, ex: a live capture with only a few packets.
+func (si *streamIter) streamProbe(r *bufio.Reader, formats []*decode.Format) (*decode.Format, error) {
+	failed := make([]bool, len(formats))
+	for {
+		// wait for at least one more byte and use all buffered
+		_, err := r.Peek(mathex.Min(r.Buffered()+1, streamProbeMaxSize))
+		atEOF := errors.Is(err, io.EOF)
+		if err != nil && !atEOF && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+		peek, _ := r.Peek(mathex.Min(r.Buffered(), streamProbeMaxSize))
+		last := atEOF || len(peek) == streamProbeMaxSize
+
+		short := false
+		for i, f := range formats {
+			if failed[i] {
+				continue
+			}
+			var pr io.Reader = bytes.NewReader(peek)
+			if !last {
+				pr = io.MultiReader(pr, errReader{errStreamProbeShort})
+			}
+			b, err := f.StreamFn(bufio.NewReader(pr))()
+			if errors.Is(err, errStreamProbeShort) {
+				short = true
+				continue
+			}
+			if err != nil {
+				failed[i] = true
+				continue
+			}
+			group, err := si.i.Registry.Group(f.Name)
+			if err != nil {
+				return nil, err
+			}
+			si.group = group
+			if dv, err := si.decodeChunk(b, 0); dv != nil && err == nil {
+				return f, nil
+			}
+			failed[i] = true
+		}
+
+		if !short || last {
+			return nil, errors.New("no streamable format found")
+		}
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+// input is filename or null for stdin
+func (i *Interp) _streamDecode(c any, opts streamDecodeOpts) gojq.Iter {
+	group, err := i.Registry.Group(opts.DecodeGroup)
+	if err != nil {
+		return gojq.NewIter(err)
+	}
+	formats := streamFormats(group)
+	if len(formats) == 0 {
+		var names []string
+		for _, f := range streamFormats(i.Registry.MustAll()) {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		return gojq.NewIter(fmt.Errorf("%s: format does not support streaming, supported: %s", opts.DecodeGroup, strings.Join(names, ", ")))
+	}
+
+	f, filename, err := i.openInputFile(c)
+	if err != nil {
+		return gojq.NewIter(err)
+	}
+
+	// ctxreadseeker is used to make sure reads can be canceled
+	cr := &streamCountReader{r: ctxreadseeker.New(i.EvalInstance.Ctx, &ioex.ReadErrSeeker{Reader: f})}
+	r := bufio.NewReaderSize(cr, streamReadBufferSize)
+	si := &streamIter{
+		i:        i,
+		f:        f,
+		filename: filename,
+		cr:       cr,
+		r:        r,
+		force:    opts.Force,
+	}
+
+	format := formats[0]
+	if len(formats) > 1 {
+		if format, err = si.streamProbe(r, formats); err != nil {
+			f.Close()
+			return gojq.NewIter(err)
+		}
+	}
+	si.format = format
+	if si.group, err = i.Registry.Group(format.Name); err != nil {
+		f.Close()
+		return gojq.NewIter(err)
+	}
+	si.chunkFn = format.StreamFn(r)
+
+	return si
+}
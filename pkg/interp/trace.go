@@ -0,0 +1,158 @@
+package interp
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/wader/fq/internal/profile"
+	"github.com/wader/fq/pkg/decode"
+)
+
+// _trace key and trace_profile for values decoded with the trace option.
+// trace_profile sums time and allocations per format, self is without time
+// and allocations of nested formats.
+
+func init() {
+	RegisterFunc0("trace_profile", (*Interp).traceProfile)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func traceToValue(t *decode.Trace) any {
+	events := []any{}
+	for _, e := range t.Events {
+		ev := map[string]any{
+			"op":     string(e.Op),
+			"caller": e.Caller,
+			"pos":    big.NewInt(e.Pos),
+		}
+		switch e.Op {
+		case decode.TraceOpSeek:
+			ev["to"] = big.NewInt(e.To)
+		case decode.TraceOpPeek:
+			ev["len"] = big.NewInt(e.Len)
+		case decode.TraceOpFormat:
+			ev["len"] = big.NewInt(e.Len)
+			ev["format"] = e.Format
+		}
+		events = append(events, ev)
+	}
+
+	tv := map[string]any{
+		"caller":     t.Caller,
+		"func":       t.Func,
+		"pos_before": big.NewInt(t.PosBefore),
+		"pos_after":  big.NewInt(t.PosAfter),
+		"events":     events,
+	}
+	if t.Usage != nil {
+		tv["duration_ms"] = durationMs(t.Usage.Duration)
+		tv["alloc_bytes"] = new(big.Int).SetUint64(t.Usage.AllocBytes)
+		tv["allocs"] = new(big.Int).SetUint64(t.Usage.Allocs)
+	}
+
+	return tv
+}
+
+type traceFormatProfile struct {
+	calls int
+	total profile.Usage
+	self  profile.Usage
+}
+
+func usageAdd(a, b profile.Usage) profile.Usage {
+	return profile.Usage{
+		Duration:   a.Duration + b.Duration,
+		AllocBytes: a.AllocBytes + b.AllocBytes,
+		Allocs:     a.Allocs + b.Allocs,
+	}
+}
+
+func usageSub(a, b profile.Usage) profile.Usage {
+	sub := func(a, b uint64) uint64 {
+		if b > a {
+			return 0
+		}
+		return a - b
+	}
+	d := a.Duration - b.Duration
+	if d < 0 {
+		d = 0
+	}
+	return profile.Usage{
+		Duration:   d,
+		AllocBytes: sub(a.AllocBytes, b.AllocBytes),
+		Allocs:     sub(a.Allocs, b.Allocs),
+	}
+}
+
+func (i *Interp) traceProfile(c any) any {
+	dvc, ok := c.(DecodeValue)
+	if !ok {
+		return errors.New("expected a decode value")
+	}
+
+	profiles := map[string]*traceFormatProfile{}
+
+	// returns usage of v if it's a traced format root otherwise sum of nested
+	// format roots. Anonymous formats, ex: decode.FormatFn, count as part of
+	// parent. Don't resolve lazy values as that would decode them.
+	var walk func(v *decode.Value) profile.Usage
+	walk = func(v *decode.Value) profile.Usage {
+		var nested profile.Usage
+		if c, ok := v.V.(*decode.Compound); ok {
+			for _, cv := range c.Children {
+				nested = usageAdd(nested, walk(cv))
+			}
+		}
+		if v.Format == nil || v.Format.Name == "" || v.Trace == nil || v.Trace.Usage == nil {
+			return nested
+		}
+
+		u := *v.Trace.Usage
+		p, ok := profiles[v.Format.Name]
+		if !ok {
+			p = &traceFormatProfile{}
+			profiles[v.Format.Name] = p
+		}
+		p.calls++
+		p.total = usageAdd(p.total, u)
+		p.self = usageAdd(p.self, usageSub(u, nested))
+
+		return u
+	}
+	walk(dvc.DecodeValue())
+
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := profiles[names[i]], profiles[names[j]]
+		if a.self.Duration != b.self.Duration {
+			return a.self.Duration > b.self.Duration
+		}
+		return names[i] < names[j]
+	})
+
+	vs := []any{}
+	for _, name := range names {
+		p := profiles[name]
+		vs = append(vs, map[string]any{
+			"format":           name,
+			"calls":            p.calls,
+			"duration_ms":      durationMs(p.total.Duration),
+			"self_duration_ms": durationMs(p.self.Duration),
+			"alloc_bytes":      new(big.Int).SetUint64(p.total.AllocBytes),
+			"self_alloc_bytes": new(big.Int).SetUint64(p.self.AllocBytes),
+			"allocs":           new(big.Int).SetUint64(p.total.Allocs),
+			"self_allocs":      new(big.Int).SetUint64(p.self.Allocs),
+		})
+	}
+
+	return vs
+}
@@ -0,0 +1,400 @@
+// Package mutate does structural mutations of decoded input. It uses the bit
+// ranges and values of a decode value tree to change symbolic values, lengths,
+// checksums and array elements, producing input that is mostly valid but
+// adversarial. Useful for fuzzing decoders and for testing other parsers.
+package mutate
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+type Kind string
+
+const (
+	KindSym       Kind = "sym"       // change value with a symbolic value to another known value
+	KindLength    Kind = "length"    // change a length, size or count field
+	KindChecksum  Kind = "checksum"  // corrupt a checksum or digest
+	KindDuplicate Kind = "duplicate" // duplicate an array element
+	KindDrop      Kind = "drop"      // drop an array element
+)
+
+// Kinds are all mutation kinds
+var Kinds = []Kind{KindSym, KindLength, KindChecksum, KindDuplicate, KindDrop}
+
+type Options struct {
+	Seed  int64  // seed for random source, same seed and input gives same mutations
+	Count int    // number of mutations, default 1
+	Kinds []Kind // kinds of mutations to do, default all
+}
+
+// Mutation describes a done mutation
+type Mutation struct {
+	Kind        Kind
+	Value       *decode.Value // mutated value, array element for duplicate and drop
+	Range       ranges.Range  // bit range in input that was replaced
+	Description string
+}
+
+// ErrNoMutations is returned if there was nothing to mutate
+var ErrNoMutations = errors.New("no mutations possible")
+
+// edit replaces bit range with bits
+type edit struct {
+	r     ranges.Range
+	buf   []byte
+	nBits int64
+}
+
+type candidate struct {
+	kind Kind
+	v    *decode.Value
+}
+
+type mutator struct {
+	rng  *rand.Rand
+	root *decode.Value
+	br   bitio.ReaderAtSeeker
+	// observed actual values for sym fields by format and field name
+	symValues map[string][]uint64
+}
+
+// Mutate mutates the input of root and returns the mutated input and
+// mutations done. Only values decoded from the same buffer as root are
+// mutated, ex: not values in decompressed data.
+func Mutate(root *decode.Value, opts Options) ([]byte, []Mutation, error) {
+	root = root.BufferRoot()
+	if opts.Count <= 0 {
+		opts.Count = 1
+	}
+	if len(opts.Kinds) == 0 {
+		opts.Kinds = Kinds
+	}
+
+	m := &mutator{
+		rng:       rand.New(rand.NewSource(opts.Seed)), //nolint:gosec
+		root:      root,
+		br:        root.RootReader,
+		symValues: map[string][]uint64{},
+	}
+
+	candidates, err := m.candidates(opts.Kinds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var edits []edit
+	var mutations []Mutation
+	// pick kind first so that formats with lots of some kind get a mix
+	const maxTries = 100
+	for tries := 0; len(mutations) < opts.Count && tries < maxTries; tries++ {
+		var kinds []Kind
+		for _, k := range opts.Kinds {
+			if len(candidates[k]) > 0 {
+				kinds = append(kinds, k)
+			}
+		}
+		if len(kinds) == 0 {
+			break
+		}
+		k := kinds[m.rng.Intn(len(kinds))]
+		cs := candidates[k]
+		ci := m.rng.Intn(len(cs))
+		c := cs[ci]
+		// don't try same candidate again
+		candidates[k] = append(cs[0:ci:ci], cs[ci+1:]...)
+
+		e, mu, ok := m.mutate(c)
+		if !ok || overlaps(edits, e.r) {
+			continue
+		}
+		edits = append(edits, e)
+		mutations = append(mutations, mu)
+	}
+	if len(mutations) == 0 {
+		return nil, nil, ErrNoMutations
+	}
+
+	buf, err := m.apply(edits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buf, mutations, nil
+}
+
+func overlaps(edits []edit, r ranges.Range) bool {
+	for _, e := range edits {
+		// touching also counts as insertions are zero length
+		if r.Start <= e.r.Stop() && e.r.Start <= r.Stop() {
+			return true
+		}
+	}
+	return false
+}
+
+func formatName(v *decode.Value) string {
+	if f := v.FormatRoot().Format; f != nil {
+		return f.Name
+	}
+	return ""
+}
+
+func isLengthName(name string) bool {
+	for _, s := range []string{"length", "size", "len", "count", "num_", "entries"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isChecksumName(name string) bool {
+	for _, s := range []string{"crc", "checksum", "adler", "digest", "hash", "md5", "sha"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mutator) candidates(kinds []Kind) (map[Kind][]candidate, error) {
+	want := map[Kind]bool{}
+	for _, k := range kinds {
+		want[k] = true
+	}
+	cs := map[Kind][]candidate{}
+	add := func(k Kind, v *decode.Value) {
+		if want[k] {
+			cs[k] = append(cs[k], candidate{kind: k, v: v})
+		}
+	}
+
+	err := m.root.WalkPreOrder(func(v *decode.Value, _ *decode.Value, _ int, _ int) error {
+		// only values in same buffer can be mapped back to input
+		if v.RootReader != m.br || v.Range.Len == 0 {
+			return nil
+		}
+
+		switch vv := v.V.(type) {
+		case *decode.Compound:
+			if vv.IsArray && len(vv.Children) > 0 && v.Parent != nil {
+				add(KindDuplicate, v)
+				add(KindDrop, v)
+			}
+		case *scalar.Uint:
+			if isChecksumName(v.Name) {
+				add(KindChecksum, v)
+			} else if vv.Sym != nil && v.Range.Len <= 64 {
+				k := formatName(v) + "." + v.Name
+				m.symValues[k] = append(m.symValues[k], vv.Actual)
+				add(KindSym, v)
+			} else if isLengthName(v.Name) && v.Range.Len <= 64 {
+				add(KindLength, v)
+			}
+		case *scalar.BitBuf:
+			if isChecksumName(v.Name) {
+				add(KindChecksum, v)
+			}
+		}
+		return nil
+	})
+
+	return cs, err
+}
+
+// readUint reads range as unsigned integer, returns false if it's not possible
+// to figure out endian used to decode actual
+func (m *mutator) readUint(r ranges.Range, actual uint64) (uint64, bool, bool) {
+	buf := make([]byte, 8)
+	if _, err := bitio.ReadAtFull(m.br, buf, r.Len, r.Start); err != nil {
+		return 0, false, false
+	}
+	n := bitio.Read64(buf, 0, r.Len)
+	if n == actual {
+		return n, false, true
+	}
+	if r.Len%8 == 0 && bitio.ReverseBytes64(int(r.Len), n) == actual {
+		return n, true, true
+	}
+	return 0, false, false
+}
+
+// replaceUint returns edit with range replaced with n encoded same way as
+// actual was
+func (m *mutator) replaceUint(v *decode.Value, actual uint64, n uint64) (edit, bool) {
+	r := v.Range
+	if _, le, ok := m.readUint(r, actual); !ok {
+		return edit{}, false
+	} else if le {
+		n = bitio.ReverseBytes64(int(r.Len), n)
+	}
+	buf := make([]byte, bitio.BitsByteCount(r.Len))
+	bitio.Write64(n, r.Len, buf, 0)
+	return edit{r: r, buf: buf, nBits: r.Len}, true
+}
+
+func maxUint(nBits int64) uint64 {
+	if nBits >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<nBits - 1
+}
+
+// schemaSymValues returns actual values for field name from sym maps in format schema
+func schemaSymValues(f *decode.Format, name string) []uint64 {
+	if f == nil || f.Schema == nil {
+		return nil
+	}
+	var ns []uint64
+	var walk func(s *decode.Schema)
+	walk = func(s *decode.Schema) {
+		if s == nil {
+			return
+		}
+		if s.Name == name {
+			for _, sm := range s.Syms {
+				mv := reflect.ValueOf(sm)
+				if mv.Kind() != reflect.Map || mv.Type().Key().Kind() != reflect.Uint64 {
+					continue
+				}
+				for _, k := range mv.MapKeys() {
+					ns = append(ns, k.Uint())
+				}
+			}
+		}
+		for _, c := range s.Fields {
+			walk(c)
+		}
+		for _, c := range s.AnyOf {
+			walk(c)
+		}
+		walk(s.Elem)
+	}
+	walk(f.Schema)
+	return ns
+}
+
+func (m *mutator) pick(ns []uint64, current uint64, nBits int64) (uint64, bool) {
+	var alts []uint64
+	seen := map[uint64]bool{current: true}
+	for _, n := range ns {
+		if seen[n] || n > maxUint(nBits) {
+			continue
+		}
+		seen[n] = true
+		alts = append(alts, n)
+	}
+	if len(alts) == 0 {
+		return 0, false
+	}
+	sort.Slice(alts, func(i, j int) bool { return alts[i] < alts[j] })
+	return alts[m.rng.Intn(len(alts))], true
+}
+
+func (m *mutator) mutate(c candidate) (edit, Mutation, bool) {
+	v := c.v
+	mu := Mutation{Kind: c.kind, Value: v, Range: v.Range}
+
+	switch c.kind {
+	case KindSym, KindLength:
+		s, ok := v.V.(*scalar.Uint)
+		if !ok {
+			return edit{}, mu, false
+		}
+		var ns []uint64
+		if c.kind == KindSym {
+			ns = append(schemaSymValues(v.FormatRoot().Format, v.Name), m.symValues[formatName(v)+"."+v.Name]...)
+		} else {
+			a := s.Actual
+			ns = []uint64{0, 1, a - 1, a + 1, a * 2, maxUint(v.Range.Len)}
+		}
+		n, ok := m.pick(ns, s.Actual, v.Range.Len)
+		if !ok {
+			return edit{}, mu, false
+		}
+		e, ok := m.replaceUint(v, s.Actual, n)
+		if !ok {
+			return edit{}, mu, false
+		}
+		mu.Description = strconv.FormatUint(s.Actual, 10) + " -> " + strconv.FormatUint(n, 10)
+		return e, mu, true
+
+	case KindChecksum:
+		r := v.Range
+		bit := m.rng.Int63n(r.Len)
+		buf := make([]byte, 1)
+		if _, err := bitio.ReadAtFull(m.br, buf, 1, r.Start+bit); err != nil {
+			return edit{}, mu, false
+		}
+		buf[0] ^= 0x80
+		mu.Range = ranges.Range{Start: r.Start + bit, Len: 1}
+		mu.Description = "flip bit " + strconv.FormatInt(bit, 10)
+		return edit{r: mu.Range, buf: buf, nBits: 1}, mu, true
+
+	case KindDuplicate, KindDrop:
+		vc, ok := v.V.(*decode.Compound)
+		if !ok {
+			return edit{}, mu, false
+		}
+		cs := vc.Children
+		i := m.rng.Intn(len(cs))
+		ev := cs[i]
+		if ev.RootReader != m.br || ev.Range.Len == 0 {
+			return edit{}, mu, false
+		}
+		mu.Value = ev
+		mu.Range = ev.Range
+		if c.kind == KindDrop {
+			mu.Description = "drop element " + strconv.Itoa(i)
+			return edit{r: ev.Range}, mu, true
+		}
+		buf := make([]byte, bitio.BitsByteCount(ev.Range.Len))
+		if _, err := bitio.ReadAtFull(m.br, buf, ev.Range.Len, ev.Range.Start); err != nil {
+			return edit{}, mu, false
+		}
+		// insert copy after element
+		mu.Range = ranges.Range{Start: ev.Range.Stop()}
+		mu.Description = "duplicate element " + strconv.Itoa(i)
+		return edit{r: mu.Range, buf: buf, nBits: ev.Range.Len}, mu, true
+	}
+
+	return edit{}, mu, false
+}
+
+// apply copies input with edits applied
+func (m *mutator) apply(edits []edit) ([]byte, error) {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].r.Start < edits[j].r.Start })
+	l, err := bitioex.Len(m.br)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &bitio.Buffer{}
+	pos := int64(0)
+	for _, e := range edits {
+		if _, err := bitio.Copy(out, bitio.NewSectionReader(m.br, pos, e.r.Start-pos)); err != nil {
+			return nil, err
+		}
+		if _, err := out.WriteBits(e.buf, e.nBits); err != nil {
+			return nil, err
+		}
+		pos = e.r.Stop()
+	}
+	if _, err := bitio.Copy(out, bitio.NewSectionReader(m.br, pos, l-pos)); err != nil {
+		return nil, err
+	}
+
+	buf, _ := out.Bits()
+	return buf, nil
+}
@@ -0,0 +1,100 @@
+package mutate_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	_ "github.com/wader/fq/format/all"
+	"github.com/wader/fq/pkg/api"
+	"github.com/wader/fq/pkg/mutate"
+)
+
+func decodePNG(t *testing.T, b []byte) *api.Value {
+	t.Helper()
+	// mutated input might only partially decode
+	v, err := api.Decode(context.Background(), bytes.NewReader(b), "png", api.Options{})
+	if v == nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMutate(t *testing.T) {
+	b, err := os.ReadFile("../../format/png/testdata/4x4.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := decodePNG(t, b)
+	chunks, err := v.Path("chunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("deterministic", func(t *testing.T) {
+		b1, _, err := mutate.Mutate(v.Decoded(), mutate.Options{Seed: 1, Count: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2, _, err := mutate.Mutate(v.Decoded(), mutate.Options{Seed: 1, Count: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b1, b2) {
+			t.Error("expected same output for same seed")
+		}
+		if bytes.Equal(b, b1) {
+			t.Error("expected output to differ from input")
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		mb, ms, err := mutate.Mutate(v.Decoded(), mutate.Options{Kinds: []mutate.Kind{mutate.KindDrop}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 1 || ms[0].Kind != mutate.KindDrop {
+			t.Fatalf("unexpected mutations %v", ms)
+		}
+		if int64(len(mb)) != int64(len(b))-ms[0].Range.Len/8 {
+			t.Errorf("expected %d bytes to be dropped", ms[0].Range.Len/8)
+		}
+		mChunks, err := decodePNG(t, mb).Path("chunks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mChunks.Len() > chunks.Len()-1 {
+			t.Errorf("expected less than %d chunks got %d", chunks.Len(), mChunks.Len())
+		}
+	})
+
+	t.Run("sym", func(t *testing.T) {
+		mb, ms, err := mutate.Mutate(v.Decoded(), mutate.Options{Seed: 2, Kinds: []mutate.Kind{mutate.KindSym}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 1 || ms[0].Value.Name != "color_type" {
+			t.Fatalf("unexpected mutations %v", ms)
+		}
+		ct, err := decodePNG(t, mb).Path("chunks[0].color_type")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := ct.Uint(); n != 2 {
+			t.Errorf("expected color type 2 got %d", n)
+		}
+	})
+
+	t.Run("no mutations", func(t *testing.T) {
+		bv, err := api.Decode(context.Background(), bytes.NewReader(b), "bytes", api.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := mutate.Mutate(bv.Decoded(), mutate.Options{}); !errors.Is(err, mutate.ErrNoMutations) {
+			t.Errorf("expected ErrNoMutations got %v", err)
+		}
+	})
+}
+